@@ -0,0 +1,213 @@
+// Package admin provides an [net/http.Handler] for operators to inspect and
+// control the circuits of a
+// [github.com/sigmavirus24/circuitry.CircuitBreakerFactory].
+//
+// The handler serves the following JSON endpoints relative to where it is
+// mounted:
+//
+//	GET  /                list circuits, filtered by the prefix and state query parameters
+//	GET  /{name}          get one circuit
+//	POST /{name}/reset    reset the counts of a circuit
+//	PUT  /{name}/state    force a circuit into the state in the body, e.g., {"state": "open"}
+//
+// Circuit names must be path escaped. The handler is read-only unless it is
+// given an [Authorizer] with [WithAuthorizer]. To mount it under
+// /debug/circuits:
+//
+//	mux.Handle("/debug/circuits/", http.StripPrefix("/debug/circuits", admin.NewHandler(factory)))
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// Action identifies what a request to the handler is attempting to do
+type Action string
+
+const (
+	// ActionList is used when listing circuits
+	ActionList Action = "list"
+	// ActionGet is used when getting a single circuit
+	ActionGet Action = "get"
+	// ActionReset is used when resetting the counts of a circuit
+	ActionReset Action = "reset"
+	// ActionForceState is used when forcing the state of a circuit
+	ActionForceState Action = "force_state"
+)
+
+// Authorizer decides whether a request may perform an action on the named
+// circuit. The name is empty for [ActionList]. Returning an error rejects the
+// request with 403 Forbidden and the error's message.
+type Authorizer func(r *http.Request, action Action, name string) error
+
+// ReadOnly is an [Authorizer] that only allows listing and getting circuits.
+// It is used when [NewHandler] is not given an Authorizer.
+func ReadOnly(_ *http.Request, action Action, _ string) error {
+	switch action {
+	case ActionList, ActionGet:
+		return nil
+	default:
+		return errors.New("circuits are read-only")
+	}
+}
+
+// Option configures the handler returned by [NewHandler]
+type Option func(*handler)
+
+// WithAuthorizer configures the handler to check every request with the
+// [Authorizer]. Without one, only listing and getting circuits is allowed.
+func WithAuthorizer(authorize Authorizer) Option {
+	return func(h *handler) {
+		h.authorize = authorize
+	}
+}
+
+// Circuit is the JSON representation of a circuit returned by the handler
+type Circuit struct {
+	Name        string                       `json:"name"`
+	State       string                       `json:"state"`
+	Information circuitry.CircuitInformation `json:"information"`
+}
+
+type listResponse struct {
+	Circuits []Circuit `json:"circuits"`
+}
+
+type stateRequest struct {
+	State string `json:"state"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	factory   *circuitry.CircuitBreakerFactory
+	authorize Authorizer
+	mux       *http.ServeMux
+}
+
+// NewHandler creates an [net/http.Handler] serving the circuits of the
+// factory
+func NewHandler(factory *circuitry.CircuitBreakerFactory, opts ...Option) http.Handler {
+	h := &handler{
+		factory:   factory,
+		authorize: ReadOnly,
+		mux:       http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("GET /{$}", h.list)
+	h.mux.HandleFunc("GET /{name}", h.get)
+	h.mux.HandleFunc("POST /{name}/reset", h.reset)
+	h.mux.HandleFunc("PUT /{name}/state", h.forceState)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) allowed(w http.ResponseWriter, r *http.Request, action Action, name string) bool {
+	if err := h.authorize(r, action, name); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	if !h.allowed(w, r, ActionList, "") {
+		return
+	}
+	query := r.URL.Query()
+	filter := circuitry.CircuitFilter{Prefix: query.Get("prefix")}
+	for _, s := range query["state"] {
+		state, err := circuitry.ParseCircuitState(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.States = append(filter.States, state)
+	}
+	response := listResponse{Circuits: []Circuit{}}
+	for entry, err := range h.factory.Circuits(r.Context(), filter) {
+		if err != nil {
+			writeError(w, statusFor(err), err)
+			return
+		}
+		response.Circuits = append(response.Circuits, newCircuit(entry.Name, entry.Information))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !h.allowed(w, r, ActionGet, name) {
+		return
+	}
+	info, err := h.factory.Information(r.Context(), name)
+	h.writeCircuit(w, name, info, err)
+}
+
+func (h *handler) reset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !h.allowed(w, r, ActionReset, name) {
+		return
+	}
+	info, err := h.factory.ResetCounts(r.Context(), name)
+	h.writeCircuit(w, name, info, err)
+}
+
+func (h *handler) forceState(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !h.allowed(w, r, ActionForceState, name) {
+		return
+	}
+	var body stateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	state, err := circuitry.ParseCircuitState(body.State)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	info, err := h.factory.ForceState(r.Context(), name, state)
+	h.writeCircuit(w, name, info, err)
+}
+
+func (h *handler) writeCircuit(w http.ResponseWriter, name string, info circuitry.CircuitInformation, err error) {
+	if err != nil {
+		writeError(w, statusFor(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCircuit(name, info))
+}
+
+func newCircuit(name string, info circuitry.CircuitInformation) Circuit {
+	return Circuit{Name: name, State: info.State.String(), Information: info}
+}
+
+func statusFor(err error) int {
+	if errors.Is(err, circuitry.ErrListingNotSupported) {
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,310 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/admin"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+)
+
+func newFactory(opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	settings, _ := circuitry.NewFactorySettings(opts...)
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+func newServer(t *testing.T, factory *circuitry.CircuitBreakerFactory, opts ...admin.Option) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.Handle("/debug/circuits/", http.StripPrefix("/debug/circuits", admin.NewHandler(factory, opts...)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newPopulatedFactory() *circuitry.CircuitBreakerFactory {
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	ctx := context.Background()
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	neverErrorFn := func() (any, error) { return nil, nil }
+	_, _, _ = factory.BreakerFor("tenant/a", map[string]any{}).Execute(ctx, alwaysErrorFn)
+	_, _, _ = factory.BreakerFor("tenant/b", map[string]any{}).Execute(ctx, neverErrorFn)
+	_, _, _ = factory.BreakerFor("other", map[string]any{}).Execute(ctx, neverErrorFn)
+	return factory
+}
+
+func do(t *testing.T, method, url, body string, decoded any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("expected to create a request; got %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected to make a request; got %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("expected to read the response; got %v", err)
+	}
+	if decoded != nil {
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf("expected a JSON response; got %q", contentType)
+		}
+		if err := json.Unmarshal(data, decoded); err != nil {
+			t.Fatalf("expected to decode %s; got %v", data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type listResponse struct {
+	Circuits []admin.Circuit `json:"circuits"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// allowAll is an Authorizer allowing every request so circuits can be
+// changed
+func allowAll(*http.Request, admin.Action, string) error {
+	return nil
+}
+
+func TestList(t *testing.T) {
+	server := newServer(t, newPopulatedFactory())
+	testCases := map[string]struct {
+		query    string
+		expected []string
+	}{
+		"everything":        {"", []string{"other", "tenant/a", "tenant/b"}},
+		"prefix":            {"?prefix=tenant/", []string{"tenant/a", "tenant/b"}},
+		"state":             {"?state=open", []string{"tenant/a"}},
+		"multiple states":   {"?state=open&state=closed", []string{"other", "tenant/a", "tenant/b"}},
+		"prefix and states": {"?prefix=tenant/&state=closed", []string{"tenant/b"}},
+		"no matches":        {"?prefix=missing", []string{}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var response listResponse
+			if status := do(t, http.MethodGet, server.URL+"/debug/circuits/"+tc.query, "", &response); status != http.StatusOK {
+				t.Fatalf("expected status %d; got %d", http.StatusOK, status)
+			}
+			if len(response.Circuits) != len(tc.expected) {
+				t.Fatalf("expected %v; got %+v", tc.expected, response.Circuits)
+			}
+			for i, circuit := range response.Circuits {
+				if circuit.Name != tc.expected[i] {
+					t.Fatalf("expected %v; got %+v", tc.expected, response.Circuits)
+				}
+				if circuit.State != circuit.Information.State.String() {
+					t.Fatalf("expected state %q to match the information; got %+v", circuit.State, circuit)
+				}
+			}
+		})
+	}
+}
+
+func TestListErrors(t *testing.T) {
+	testCases := map[string]struct {
+		factory  *circuitry.CircuitBreakerFactory
+		query    string
+		expected int
+	}{
+		"invalid state": {newPopulatedFactory(), "?state=broken", http.StatusBadRequest},
+		"not supported": {newFactory(circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{})), "", http.StatusNotImplemented},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			server := newServer(t, tc.factory)
+			var response errorResponse
+			if status := do(t, http.MethodGet, server.URL+"/debug/circuits/"+tc.query, "", &response); status != tc.expected {
+				t.Fatalf("expected status %d; got %d", tc.expected, status)
+			}
+			if response.Error == "" {
+				t.Fatal("expected an error message")
+			}
+		})
+	}
+}
+
+func TestGet(t *testing.T) {
+	server := newServer(t, newPopulatedFactory())
+	var circuit admin.Circuit
+	if status := do(t, http.MethodGet, server.URL+"/debug/circuits/tenant%2Fa", "", &circuit); status != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, status)
+	}
+	if circuit.Name != "tenant/a" || circuit.State != "open" || circuit.Information.Generation != 1 {
+		t.Fatalf("expected tenant/a to be open in generation 1; got %+v", circuit)
+	}
+}
+
+func TestReset(t *testing.T) {
+	server := newServer(t, newPopulatedFactory(), admin.WithAuthorizer(allowAll))
+	var circuit admin.Circuit
+	if status := do(t, http.MethodPost, server.URL+"/debug/circuits/tenant%2Fb/reset", "", &circuit); status != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, status)
+	}
+	if circuit.Name != "tenant/b" || circuit.Information.Total != 0 || circuit.Information.TotalSuccesses != 0 {
+		t.Fatalf("expected tenant/b to have no counts; got %+v", circuit)
+	}
+}
+
+func TestForceState(t *testing.T) {
+	factory := newPopulatedFactory()
+	server := newServer(t, factory, admin.WithAuthorizer(allowAll))
+	var circuit admin.Circuit
+	if status := do(t, http.MethodPut, server.URL+"/debug/circuits/tenant%2Fa/state", `{"state": "closed"}`, &circuit); status != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, status)
+	}
+	if circuit.Name != "tenant/a" || circuit.State != "closed" {
+		t.Fatalf("expected tenant/a to be closed; got %+v", circuit)
+	}
+	state, err := factory.BreakerFor("tenant/a", map[string]any{}).State(context.Background())
+	if err != nil || state != circuitry.CircuitClosed {
+		t.Fatalf("expected the circuit to be stored as closed; got %s (err = %v)", state, err)
+	}
+
+	testCases := map[string]string{
+		"invalid JSON":  `{"state": `,
+		"invalid state": `{"state": "broken"}`,
+	}
+	for name, testCase := range testCases {
+		body := testCase
+		t.Run(name, func(t *testing.T) {
+			var response errorResponse
+			if status := do(t, http.MethodPut, server.URL+"/debug/circuits/tenant%2Fa/state", body, &response); status != http.StatusBadRequest {
+				t.Fatalf("expected status %d; got %d", http.StatusBadRequest, status)
+			}
+		})
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	factory := newFactory(circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{RetrieveError: errors.New("cannot retrieve")}))
+	server := newServer(t, factory, admin.WithAuthorizer(allowAll))
+	testCases := map[string]struct {
+		method, path, body string
+	}{
+		"get":         {http.MethodGet, "/debug/circuits/name", ""},
+		"reset":       {http.MethodPost, "/debug/circuits/name/reset", ""},
+		"force state": {http.MethodPut, "/debug/circuits/name/state", `{"state": "open"}`},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var response errorResponse
+			if status := do(t, tc.method, server.URL+tc.path, tc.body, &response); status != http.StatusInternalServerError {
+				t.Fatalf("expected status %d; got %d", http.StatusInternalServerError, status)
+			}
+			if response.Error != "cannot retrieve" {
+				t.Fatalf("expected the backend error; got %q", response.Error)
+			}
+		})
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	var actions []string
+	authorizer := func(r *http.Request, action admin.Action, name string) error {
+		actions = append(actions, string(action)+":"+name)
+		return admin.ReadOnly(r, action, name)
+	}
+	server := newServer(t, newPopulatedFactory(), admin.WithAuthorizer(authorizer))
+	testCases := map[string]struct {
+		method, path, body string
+		expected           int
+	}{
+		"list":        {http.MethodGet, "/debug/circuits/", "", http.StatusOK},
+		"get":         {http.MethodGet, "/debug/circuits/tenant%2Fa", "", http.StatusOK},
+		"reset":       {http.MethodPost, "/debug/circuits/tenant%2Fa/reset", "", http.StatusForbidden},
+		"force state": {http.MethodPut, "/debug/circuits/tenant%2Fa/state", `{"state": "open"}`, http.StatusForbidden},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var response map[string]any
+			if status := do(t, tc.method, server.URL+tc.path, tc.body, &response); status != tc.expected {
+				t.Fatalf("expected status %d; got %d", tc.expected, status)
+			}
+			if tc.expected == http.StatusForbidden && response["error"] != "circuits are read-only" {
+				t.Fatalf("expected the authorizer's error; got %v", response)
+			}
+		})
+	}
+	expected := map[string]bool{"list:": true, "get:tenant/a": true, "reset:tenant/a": true, "force_state:tenant/a": true}
+	for _, action := range actions {
+		if !expected[action] {
+			t.Fatalf("expected the authorizer to be called with %v; got %v", expected, actions)
+		}
+	}
+}
+
+func TestReadOnlyByDefault(t *testing.T) {
+	factory := newPopulatedFactory()
+	server := newServer(t, factory)
+	testCases := map[string]struct {
+		method, path, body string
+	}{
+		"reset":       {http.MethodPost, "/debug/circuits/tenant%2Fb/reset", ""},
+		"force state": {http.MethodPut, "/debug/circuits/tenant%2Fa/state", `{"state": "closed"}`},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var response errorResponse
+			if status := do(t, tc.method, server.URL+tc.path, tc.body, &response); status != http.StatusForbidden {
+				t.Fatalf("expected status %d; got %d", http.StatusForbidden, status)
+			}
+			if response.Error != "circuits are read-only" {
+				t.Fatalf("expected the circuits to be read-only; got %q", response.Error)
+			}
+		})
+	}
+	if state, err := factory.BreakerFor("tenant/a", map[string]any{}).State(context.Background()); err != nil || state != circuitry.CircuitOpen {
+		t.Fatalf("expected tenant/a to still be open; got %s (err = %v)", state, err)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	server := newServer(t, newPopulatedFactory())
+	if status := do(t, http.MethodDelete, server.URL+"/debug/circuits/tenant%2Fa", "", nil); status != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d; got %d", http.StatusMethodNotAllowed, status)
+	}
+}
+
+func TestAuthorizerDeniesReads(t *testing.T) {
+	denyAll := func(*http.Request, admin.Action, string) error { return errors.New("denied") }
+	server := newServer(t, newPopulatedFactory(), admin.WithAuthorizer(denyAll))
+	for _, path := range []string{"/debug/circuits/", "/debug/circuits/other"} {
+		var response errorResponse
+		if status := do(t, http.MethodGet, server.URL+path, "", &response); status != http.StatusForbidden {
+			t.Fatalf("expected status %d for %s; got %d", http.StatusForbidden, path, status)
+		}
+		if response.Error != "denied" {
+			t.Fatalf("expected the authorizer's error; got %q", response.Error)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package circuitry
 
 import (
 	"context"
+	"iter"
 	"sync"
+	"time"
 )
 
 // StorageBackender defines the contract expected of a Storage Backend for the
@@ -17,6 +19,125 @@ type StorageBackender interface {
 	Lock(context.Context, string) (sync.Locker, error)
 }
 
+// Lease is an optional interface the [sync.Locker] returned by a
+// [StorageBackender]'s Lock can implement to report errors releasing the lock
+// or when the lock expires unless it is renewed. The backend keeps renewing
+// the lease until it is released, and a [CircuitBreaker] refuses to store its
+// information once the lease is lost. Locks that only implement
+// [sync.Locker] are used as leases that never expire.
+type Lease interface {
+	sync.Locker
+	// Token returns the fencing token issued when the lock was obtained.
+	// Tokens for a circuit increase each time its lock is obtained so a
+	// holder whose lease was lost can be told apart from the current one. A
+	// token of zero means stores are not fenced.
+	Token() uint64
+	// Err returns ErrLockLost, possibly wrapping the cause, once the lease
+	// has expired or could not be renewed
+	Err() error
+	// Release gives up the lease. The context passed by a [CircuitBreaker]
+	// is never canceled so that the lease is released after the work's
+	// context has ended. Unlock is Release without the error. Releasing
+	// or unlocking a lease that has already been released returns nil.
+	Release(context.Context) error
+}
+
+// lockerLease adapts a [sync.Locker] that does not expire to a [Lease]
+type lockerLease struct {
+	sync.Locker
+}
+
+func (lockerLease) Token() uint64 { return 0 }
+func (lockerLease) Err() error    { return nil }
+
+func (l lockerLease) Release(context.Context) error {
+	l.Unlock()
+	return nil
+}
+
+// FencedStorer is an optional interface a [StorageBackender] whose locks are
+// [Lease]s can implement to reject information stored by a holder whose
+// lease was lost after a newer one was obtained
+type FencedStorer interface {
+	// StoreFenced stores the circuit information unless a lock with a
+	// greater fencing token has been obtained, in which case it returns
+	// ErrLockLost
+	StoreFenced(ctx context.Context, name string, ci CircuitInformation, token uint64) error
+}
+
+// OutcomePolicy describes how a [CircuitBreaker] using [DefaultTripFunc]
+// decides when to change state, for an [OutcomeRecorder] to apply
+type OutcomePolicy struct {
+	FailureCountThreshold uint64
+	CloseThreshold        uint64
+	AllowAfter            time.Duration
+	CyclicClearAfter      time.Duration
+}
+
+// OutcomeRecorder is an optional interface a [StorageBackender] can
+// implement to admit requests and record their outcomes in a single atomic
+// operation each, so the circuit does not need to be locked while the work
+// runs. It is only used by [CircuitBreaker]s whose WillTripCircuit is
+// [DefaultTripFunc] and whose BackendFailurePolicy is [FailClosed]; other
+// [CircuitBreaker]s and Reset and Delete keep using Lock, Retrieve and Store.
+// Both methods return the information immediately before any change of state
+// and the information stored afterwards.
+type OutcomeRecorder interface {
+	// Admit refreshes the circuit's state as of now and counts the request
+	// if the circuit allows it, returning ErrCircuitBreakerOpen or
+	// ErrTooManyRequests if it does not. Any change to the state, such as an
+	// open circuit becoming half-open, is stored either way.
+	Admit(ctx context.Context, name string, policy OutcomePolicy, now time.Time) (before, after CircuitInformation, err error)
+	// Record counts the outcome of a request admitted in the given
+	// generation and changes the circuit's state if the outcome causes it.
+	// Outcomes from other generations are discarded.
+	Record(ctx context.Context, name string, policy OutcomePolicy, generation uint64, status ExecutionStatus, now time.Time) (before, after CircuitInformation, err error)
+}
+
+// CircuitEntry pairs the name of a circuit with the [CircuitInformation]
+// stored for it
+type CircuitEntry struct {
+	Name        string
+	Information CircuitInformation
+}
+
+// Lister is an optional interface a [StorageBackender] can implement to
+// enumerate the circuits it stores. See [CircuitBreakerFactory].Circuits.
+type Lister interface {
+	// List yields each stored circuit whose name starts with the prefix. If
+	// an error is yielded, the caller may stop or keep iterating.
+	List(ctx context.Context, prefix string) iter.Seq2[CircuitEntry, error]
+}
+
+// Deleter is an optional interface a [StorageBackender] can implement to
+// remove the information stored for a circuit. See [CircuitBreaker].Delete.
+type Deleter interface {
+	// Delete removes the circuit information for the given name. Deleting a
+	// name that is not stored is not an error. Backends that fence stores
+	// keep the circuit's fencing token so tokens keep increasing if it is
+	// locked again, along with the key, item, or row holding it, which is
+	// left for the operator to remove.
+	Delete(context.Context, string) error
+}
+
+// TransitionPublisher is an optional interface a [StorageBackender] can
+// implement to share [TransitionEvent]s with other processes. Events are
+// published after the new state has been stored and the lock released.
+type TransitionPublisher interface {
+	// PublishTransition sends the event to every process watching for
+	// transitions
+	PublishTransition(context.Context, TransitionEvent) error
+}
+
+// TransitionWatcher is an optional interface a [StorageBackender] can
+// implement to deliver [TransitionEvent]s from other processes. See
+// [CircuitBreakerFactory].Watch.
+type TransitionWatcher interface {
+	// WatchTransitions calls the function for each transition the backend
+	// observes until the context is done or an error occurs
+	WatchTransitions(context.Context, func(TransitionEvent)) error
+}
+
 // WithStorageBackend allows the user to specify a [StorageBackender]
 // implementation for [CircuitBreaker]s.
 func WithStorageBackend(backend StorageBackender) SettingsOption {
@@ -1 +1,487 @@
 package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+type testLease struct {
+	sync.Locker
+	token      uint64
+	mu         sync.Mutex
+	err        error
+	releaseErr error
+	releaseCtx context.Context
+}
+
+func (l *testLease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	l.releaseCtx = ctx
+	l.mu.Unlock()
+	l.Unlock()
+	return l.releaseErr
+}
+
+func (l *testLease) Token() uint64 {
+	return l.token
+}
+
+func (l *testLease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *testLease) lose() {
+	l.mu.Lock()
+	l.err = circuitry.ErrLockLost
+	l.mu.Unlock()
+}
+
+// leasingBackend hands out leases with increasing fencing tokens and fences
+// stores when wrapped by fencedBackend
+type leasingBackend struct {
+	*flakyBackend
+	mu         sync.Mutex
+	fences     map[string]uint64
+	leases     []*testLease
+	releaseErr error
+}
+
+func newLeasingBackend() *leasingBackend {
+	return &leasingBackend{flakyBackend: newFlakyBackend(), fences: make(map[string]uint64)}
+}
+
+func (b *leasingBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	lock, err := b.flakyBackend.Lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fences[name]++
+	lease := &testLease{Locker: lock, token: b.fences[name], releaseErr: b.releaseErr}
+	b.leases = append(b.leases, lease)
+	return lease, nil
+}
+
+// takeOver issues a newer fencing token as if another process obtained the
+// lock after the current lease expired
+func (b *leasingBackend) takeOver(name string) {
+	b.mu.Lock()
+	b.fences[name]++
+	b.mu.Unlock()
+}
+
+func (b *leasingBackend) lastLease() *testLease {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.leases[len(b.leases)-1]
+}
+
+type fencedBackend struct {
+	*leasingBackend
+}
+
+func (b fencedBackend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	b.mu.Lock()
+	fence := b.fences[name]
+	b.mu.Unlock()
+	if fence > token {
+		return circuitry.ErrLockLost
+	}
+	return b.Store(ctx, name, ci)
+}
+
+func TestLeaseLostBeforeEnd(t *testing.T) {
+	ctx := context.Background()
+	backend := newLeasingBackend()
+	factory := newFactory(circuitry.WithStorageBackend(backend), circuitry.WithLogger(&log.NoOp{}), circuitry.WithFailureCountThreshold(0))
+	breaker := factory.BreakerFor("TestLeaseLostBeforeEnd", map[string]any{})
+
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start the breaker; got %v", err)
+	}
+	backend.lastLease().lose()
+	if err := breaker.End(ctx, errors.New("test")); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost; got %v", err)
+	}
+	if backend.stores.Load() != 0 {
+		t.Fatalf("expected nothing to be stored after losing the lease; got %d stores", backend.stores.Load())
+	}
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected the breaker to be usable after losing a lease; got %v", err)
+	}
+}
+
+func TestLeaseReleaseErrors(t *testing.T) {
+	releaseErr := errors.New("release failed")
+	testCases := map[string]struct {
+		run      func(context.Context, circuitry.CircuitBreaker) error
+		expected error
+	}{
+		"end": {
+			func(ctx context.Context, cb circuitry.CircuitBreaker) error {
+				_, _, err := cb.Execute(ctx, func() (any, error) { return nil, nil })
+				return err
+			},
+			nil,
+		},
+		"rejected start": {
+			func(ctx context.Context, cb circuitry.CircuitBreaker) error {
+				_, _, _ = cb.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+				return cb.Start(ctx)
+			},
+			circuitry.ErrCircuitBreakerOpen,
+		},
+		"reset": {
+			func(ctx context.Context, cb circuitry.CircuitBreaker) error { return cb.Reset(ctx) },
+			nil,
+		},
+		"delete": {
+			func(ctx context.Context, cb circuitry.CircuitBreaker) error { return cb.Delete(ctx) },
+			nil,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			backend := newLeasingBackend()
+			backend.releaseErr = releaseErr
+			breaker := newFactory(
+				circuitry.WithStorageBackend(backend),
+				circuitry.WithFailureCountThreshold(0),
+				circuitry.WithAllowAfter(time.Hour),
+			).BreakerFor(name, map[string]any{})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err := tc.run(ctx, breaker)
+			if !errors.Is(err, releaseErr) || (tc.expected != nil && !errors.Is(err, tc.expected)) {
+				t.Fatalf("expected the release error joined with %v; got %v", tc.expected, err)
+			}
+			lease := backend.lastLease()
+			lease.mu.Lock()
+			defer lease.mu.Unlock()
+			if lease.releaseCtx == nil {
+				t.Fatal("expected the lease to be released")
+			}
+			if err := breaker.Start(ctx); errors.Is(err, circuitry.ErrCircuitBreakerAlreadyStarted) {
+				t.Fatalf("expected the breaker to not be left started; got %v", err)
+			}
+		})
+	}
+}
+
+func TestLeaseReleasedAfterContextCanceled(t *testing.T) {
+	backend := newLeasingBackend()
+	breaker := newFactory(circuitry.WithStorageBackend(backend)).BreakerFor("TestLeaseReleasedAfterContextCanceled", map[string]any{})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start the breaker; got %v", err)
+	}
+	cancel()
+	if err := breaker.End(ctx, nil); err != nil {
+		t.Fatalf("expected to end the breaker; got %v", err)
+	}
+	if err := backend.lastLease().releaseCtx.Err(); err != nil {
+		t.Fatalf("expected the lease to be released with a context that is not canceled; got %v", err)
+	}
+}
+
+func TestLeaseFencing(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]struct {
+		backend  circuitry.StorageBackender
+		expected error
+		stores   int64
+	}{
+		"fenced backend":   {fencedBackend{newLeasingBackend()}, circuitry.ErrLockLost, 0},
+		"unfenced backend": {newLeasingBackend(), nil, 1},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var backend *leasingBackend
+			switch b := tc.backend.(type) {
+			case fencedBackend:
+				backend = b.leasingBackend
+			case *leasingBackend:
+				backend = b
+			}
+			breaker := newFactory(circuitry.WithStorageBackend(tc.backend)).BreakerFor(name, map[string]any{})
+			if err := breaker.Start(ctx); err != nil {
+				t.Fatalf("expected to start the breaker; got %v", err)
+			}
+			backend.takeOver(name)
+			if err := breaker.End(ctx, nil); !errors.Is(err, tc.expected) {
+				t.Fatalf("expected %v; got %v", tc.expected, err)
+			}
+			if backend.stores.Load() != tc.stores {
+				t.Fatalf("expected %d stores; got %d", tc.stores, backend.stores.Load())
+			}
+		})
+	}
+}
+
+func TestLeaseFencingWhileDegradable(t *testing.T) {
+	ctx := context.Background()
+	backend := newLeasingBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(fencedBackend{backend}, circuitry.FallbackInMemory, sink)
+	breaker := factory.BreakerFor("TestLeaseFencingWhileDegradable", map[string]any{})
+
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start the breaker; got %v", err)
+	}
+	backend.takeOver("TestLeaseFencingWhileDegradable")
+	if err := breaker.End(ctx, nil); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost; got %v", err)
+	}
+	if len(sink.degraded) != 0 {
+		t.Fatalf("expected losing a lease not to be an outage; got %v", sink.degraded)
+	}
+
+	backend.storeDown.Store(true)
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+		t.Fatalf("expected the outcome to be stored in memory; got %v", err)
+	}
+	if len(sink.degraded) != 1 || !sink.degraded[0] {
+		t.Fatalf("expected to enter degraded mode; got %v", sink.degraded)
+	}
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to be open in memory; got %v", err)
+	}
+
+	backend.storeDown.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	if err := breaker.Start(ctx); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the reconciled breaker to be open; got %v", err)
+	}
+	if len(sink.degraded) != 2 || sink.degraded[1] {
+		t.Fatalf("expected to leave degraded mode; got %v", sink.degraded)
+	}
+	if err := breaker.Reset(ctx); err != nil {
+		t.Fatalf("expected to reset the breaker with a recovered lease; got %v", err)
+	}
+	if info, _ := backend.Retrieve(ctx, "TestLeaseFencingWhileDegradable"); info.State != circuitry.CircuitClosed {
+		t.Fatalf("expected the reset to be stored; got %+v", info)
+	}
+}
+
+func TestDegradableBackendStoreFencedFallsBack(t *testing.T) {
+	ctx := context.Background()
+	backend := newLeasingBackend()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, newRecordingSink())
+	breaker := factory.BreakerFor("TestDegradableBackendStoreFencedFallsBack", map[string]any{})
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+		t.Fatalf("expected the outcome to be stored without fencing; got %v", err)
+	}
+	if info, _ := backend.Retrieve(ctx, "TestDegradableBackendStoreFencedFallsBack"); info.State != circuitry.CircuitOpen {
+		t.Fatalf("expected the trip to be stored; got %+v", info)
+	}
+}
+
+var _ circuitry.Lease = (*testLease)(nil)
+var _ circuitry.FencedStorer = fencedBackend{}
+
+// outcomeBackend records outcomes with scripted results instead of locking
+type outcomeBackend struct {
+	*flakyBackend
+	admit       func(circuitry.CircuitInformation) (circuitry.CircuitInformation, error)
+	recordErr   error
+	current     circuitry.CircuitInformation
+	generations []uint64
+	statuses    []circuitry.ExecutionStatus
+}
+
+func (b *outcomeBackend) Admit(_ context.Context, _ string, _ circuitry.OutcomePolicy, _ time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	before := b.current
+	after, err := b.admit(before)
+	if err == nil || errors.Is(err, circuitry.ErrCircuitBreakerOpen) || errors.Is(err, circuitry.ErrTooManyRequests) {
+		b.current = after
+	}
+	return before, b.current, err
+}
+
+func (b *outcomeBackend) Record(_ context.Context, _ string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	b.generations = append(b.generations, generation)
+	b.statuses = append(b.statuses, status)
+	if b.recordErr != nil {
+		return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, b.recordErr
+	}
+	before := b.current
+	if status == circuitry.ExecutionFailed {
+		before.ConsecutiveFailures++
+		b.current = circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: before.Generation + 1, ExpiresAfter: now.Add(policy.AllowAfter)}
+	}
+	return before, b.current, nil
+}
+
+func admitted(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+	ci.Total++
+	return ci, nil
+}
+
+func TestOutcomeRecorder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := &outcomeBackend{flakyBackend: newFlakyBackend(), admit: admitted, current: circuitry.CircuitInformation{Generation: 3}}
+	backend.locked.Store(true)
+	sink := newRecordingSink()
+	var changes []string
+	factory := newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithMetricsSink(sink),
+		circuitry.WithDefaultTripFunc(),
+		circuitry.WithAllowAfter(time.Hour),
+		circuitry.WithStateChangeCallback(func(_ string, _ map[string]any, from, to circuitry.CircuitState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		}),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	breaker := factory.BreakerFor("TestOutcomeRecorder", map[string]any{})
+
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start without locking; got %v", err)
+	}
+	if err := breaker.Start(ctx); !errors.Is(err, circuitry.ErrCircuitBreakerAlreadyStarted) {
+		t.Fatalf("expected ErrCircuitBreakerAlreadyStarted; got %v", err)
+	}
+	workErr := errors.New("test")
+	if err := breaker.End(ctx, workErr); err != nil {
+		t.Fatalf("expected to record the outcome; got %v", err)
+	}
+	if len(backend.generations) != 1 || backend.generations[0] != 3 || backend.statuses[0] != circuitry.ExecutionFailed {
+		t.Fatalf("expected a failure in generation 3 to be recorded; got %v %v", backend.generations, backend.statuses)
+	}
+	select {
+	case event := <-sub.C:
+		if event.From != circuitry.CircuitClosed || event.To != circuitry.CircuitOpen || event.Generation != 4 || event.Information.ConsecutiveFailures != 1 || event.Err != workErr {
+			t.Fatalf("expected the recorded trip to be delivered; got %+v", event)
+		}
+	default:
+		t.Fatal("expected the recorded trip to be delivered")
+	}
+	if len(changes) != 1 || changes[0] != "closed->open" || len(sink.transitions) != 1 {
+		t.Fatalf("expected the trip to be reported; got %v and %v", changes, sink.transitions)
+	}
+	if sink.operations[metrics.OpAdmit] != 1 || sink.operations[metrics.OpRecord] != 1 || sink.operations[metrics.OpLock] != 0 {
+		t.Fatalf("expected one admit and one record without locking; got %v", sink.operations)
+	}
+}
+
+func TestOutcomeRecorderRejections(t *testing.T) {
+	backendErr := errors.New("script failed")
+	testCases := map[string]struct {
+		admit    func(circuitry.CircuitInformation) (circuitry.CircuitInformation, error)
+		expected error
+		reason   metrics.RejectionReason
+		events   int
+	}{
+		"open": {
+			func(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				return ci, circuitry.ErrCircuitBreakerOpen
+			},
+			circuitry.ErrCircuitBreakerOpen,
+			metrics.RejectedOpen,
+			0,
+		},
+		"half-open": {
+			func(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				ci.State = circuitry.CircuitHalfOpen
+				return ci, circuitry.ErrTooManyRequests
+			},
+			circuitry.ErrTooManyRequests,
+			metrics.RejectedTooManyRequests,
+			1,
+		},
+		"backend error": {
+			func(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				return ci, backendErr
+			},
+			backendErr,
+			metrics.RejectedBackendError,
+			0,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			backend := &outcomeBackend{flakyBackend: newFlakyBackend(), admit: tc.admit, current: circuitry.CircuitInformation{State: circuitry.CircuitOpen}}
+			sink := newRecordingSink()
+			factory := newFactory(circuitry.WithStorageBackend(backend), circuitry.WithMetricsSink(sink))
+			sub := factory.Subscribe(ctx, nil)
+			breaker := factory.BreakerFor(name, map[string]any{})
+			if err := breaker.Start(ctx); !errors.Is(err, tc.expected) {
+				t.Fatalf("expected %v; got %v", tc.expected, err)
+			}
+			if sink.rejected[tc.reason] != 1 {
+				t.Fatalf("expected the rejection to be reported as %s; got %v", tc.reason, sink.rejected)
+			}
+			if len(sub.C) != tc.events {
+				t.Fatalf("expected %d transitions to be delivered; got %d", tc.events, len(sub.C))
+			}
+			if len(backend.statuses) != 0 {
+				t.Fatalf("expected no outcome to be recorded; got %v", backend.statuses)
+			}
+			if err := breaker.Start(ctx); errors.Is(err, circuitry.ErrCircuitBreakerAlreadyStarted) {
+				t.Fatal("expected the breaker to not be left started")
+			}
+		})
+	}
+}
+
+func TestOutcomeRecorderRecordError(t *testing.T) {
+	ctx := context.Background()
+	recordErr := errors.New("script failed")
+	backend := &outcomeBackend{flakyBackend: newFlakyBackend(), admit: admitted, recordErr: recordErr}
+	breaker := newFactory(circuitry.WithStorageBackend(backend)).BreakerFor("TestOutcomeRecorderRecordError", map[string]any{})
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, recordErr) {
+		t.Fatalf("expected the record error; got %v", err)
+	}
+	if backend.statuses[0] != circuitry.ExecutionSucceeded {
+		t.Fatalf("expected a success to be recorded; got %v", backend.statuses)
+	}
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected the breaker to be usable after a record error; got %v", err)
+	}
+}
+
+func TestOutcomeRecorderNotUsed(t *testing.T) {
+	testCases := map[string][]circuitry.SettingsOption{
+		"custom trip func": {
+			circuitry.WithTripFunc(func(string, uint64, circuitry.CircuitInformation) bool { return false }),
+		},
+		"degradable backend": {
+			circuitry.WithBackendFailurePolicy(circuitry.FailOpen),
+		},
+	}
+
+	for name, testCase := range testCases {
+		opts := testCase
+		t.Run(name, func(t *testing.T) {
+			backend := &outcomeBackend{flakyBackend: newFlakyBackend(), admit: admitted}
+			breaker := newFactory(append(opts, circuitry.WithStorageBackend(backend))...).BreakerFor(name, map[string]any{})
+			if _, _, err := breaker.Execute(context.Background(), func() (any, error) { return nil, nil }); err != nil {
+				t.Fatalf("expected to execute with the lock; got %v", err)
+			}
+			if backend.stores.Load() != 1 || len(backend.statuses) != 0 {
+				t.Fatalf("expected the outcome to be stored instead of recorded; got %d stores and %v", backend.stores.Load(), backend.statuses)
+			}
+		})
+	}
+}
+
+var _ circuitry.OutcomeRecorder = (*outcomeBackend)(nil)
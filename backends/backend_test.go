@@ -2,6 +2,9 @@ package backends_test
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -48,6 +51,137 @@ func TestInMemoryBackendStore(t *testing.T) {
 	}
 }
 
+func TestInMemoryBackendList(t *testing.T) {
+	b := backends.NewInMemoryBackend()
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1}
+	for _, name := range []string{"tenant-b", "tenant-a", "other"} {
+		if err := b.Store(context.TODO(), name, open); err != nil {
+			t.Fatalf("expected no error but got %+v", err)
+		}
+	}
+	lister, ok := b.(circuitry.Lister)
+	if !ok {
+		t.Fatalf("expected InMemoryBackend to implement circuitry.Lister")
+	}
+	var names []string
+	for entry, err := range lister.List(context.TODO(), "tenant-") {
+		if err != nil {
+			t.Fatalf("expected no error but got %+v", err)
+		}
+		if entry.Information != open {
+			t.Fatalf("expected %s to have %+v; got %+v", entry.Name, open, entry.Information)
+		}
+		names = append(names, entry.Name)
+	}
+	if len(names) != 2 || names[0] != "tenant-a" || names[1] != "tenant-b" {
+		t.Fatalf("expected [tenant-a tenant-b]; got %v", names)
+	}
+	for range lister.List(context.TODO(), "") {
+		break
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range lister.List(ctx, "") {
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled; got %v", err)
+		}
+	}
+}
+
+func TestInMemoryBackendDelete(t *testing.T) {
+	b := backends.NewInMemoryBackend()
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1}
+	for _, name := range []string{"tenant-a", "tenant-b"} {
+		if err := b.Store(context.TODO(), name, open); err != nil {
+			t.Fatalf("expected no error but got %+v", err)
+		}
+	}
+	deleter, ok := b.(circuitry.Deleter)
+	if !ok {
+		t.Fatalf("expected InMemoryBackend to implement circuitry.Deleter")
+	}
+	for _, name := range []string{"tenant-a", "missing"} {
+		if err := deleter.Delete(context.TODO(), name); err != nil {
+			t.Fatalf("expected no error deleting %s but got %+v", name, err)
+		}
+	}
+	var names []string
+	for entry := range b.(circuitry.Lister).List(context.TODO(), "") {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 1 || names[0] != "tenant-b" {
+		t.Fatalf("expected [tenant-b]; got %v", names)
+	}
+	if ci, _ := b.Retrieve(context.TODO(), "tenant-a"); ci != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected tenant-a to be empty; got %+v", ci)
+	}
+}
+
+func TestInMemoryBackendDeleteKeepsLock(t *testing.T) {
+	b := backends.NewInMemoryBackend()
+	deleter := b.(circuitry.Deleter)
+	var holders atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				lock, err := b.Lock(context.TODO(), "deleted-while-locked")
+				if err != nil {
+					t.Errorf("expected no error but got %+v", err)
+					return
+				}
+				lock.Lock()
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("expected 1 holder of the lock; got %d", n)
+				}
+				if err := deleter.Delete(context.TODO(), "deleted-while-locked"); err != nil {
+					t.Errorf("expected no error but got %+v", err)
+				}
+				time.Sleep(10 * time.Microsecond)
+				holders.Add(-1)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInMemoryBackendConcurrentDeleteAndExecute(t *testing.T) {
+	settings, err := circuitry.NewFactorySettings(
+		backends.WithInMemoryBackend(),
+		circuitry.WithDefaultNameFunc(),
+		circuitry.WithDefaultTripFunc(),
+		circuitry.WithDefaultFallbackErrorMatcher(),
+	)
+	if err != nil {
+		t.Fatalf("expected NewFactorySettings to not error; got %v", err)
+	}
+	factory := circuitry.NewCircuitBreakerFactory(settings)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			breaker := factory.BreakerFor("deleted-while-executing", map[string]any{})
+			for range 50 {
+				if i%2 == 0 {
+					if err := breaker.Delete(context.TODO()); err != nil {
+						t.Errorf("expected no error deleting but got %+v", err)
+					}
+					continue
+				}
+				if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, nil }); err != nil {
+					t.Errorf("expected no error executing but got %+v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestWithInMemoryBackend(t *testing.T) {
 	s, err := circuitry.NewFactorySettings(backends.WithInMemoryBackend())
 	if err != nil {
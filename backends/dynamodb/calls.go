@@ -0,0 +1,203 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+const (
+	// DefaultThrottleInitialInterval is how long to wait before retrying a
+	// throttled call the first time when ThrottleBackoff's InitialInterval is
+	// not set
+	DefaultThrottleInitialInterval = 50 * time.Millisecond
+	// DefaultThrottleMaxInterval is the longest to wait between attempts at a
+	// throttled call when ThrottleBackoff's MaxInterval is not set
+	DefaultThrottleMaxInterval = 5 * time.Second
+)
+
+// CallOptions configures the DynamoDB calls the backends make to read and
+// write circuits
+type CallOptions struct {
+	// Timeout limits each call, including the retries the client's retryer
+	// makes. Zero leaves the context's deadline as it is.
+	Timeout time.Duration
+	// Retryer, if set, replaces the client's retryer for each call
+	Retryer func() aws.Retryer
+}
+
+// ThrottleBackoff retries calls that DynamoDB throttled, once the client's
+// retryer has given up on them, waiting exponentially longer with jitter
+// between attempts. The zero value does not retry.
+type ThrottleBackoff struct {
+	// MaxAttempts is how many times a throttled call is made, including the
+	// first. Calls are not retried when it is less than 2.
+	MaxAttempts int
+	// InitialInterval defaults to DefaultThrottleInitialInterval
+	InitialInterval time.Duration
+	// MaxInterval defaults to DefaultThrottleMaxInterval
+	MaxInterval time.Duration
+}
+
+// delay returns how long to wait after the attempt, with the interval
+// doubling after each attempt and half of it chosen at random
+func (t ThrottleBackoff) delay(attempt int) time.Duration {
+	interval, maxInterval := t.InitialInterval, t.MaxInterval
+	if interval <= 0 {
+		interval = DefaultThrottleInitialInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = DefaultThrottleMaxInterval
+	}
+	for i := 1; i < attempt && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	interval = min(interval, maxInterval)
+	return interval/2 + rand.N(interval/2+1)
+}
+
+func isThrottled(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	_, ok := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]
+	return ok
+}
+
+// callOptions returns the CallOptions for the operation, overriding the
+// backend's CallOptions with the fields set in its OperationCallOptions
+func (b *Backend) callOptions(operation OperationType) CallOptions {
+	options := b.CallOptions
+	if override, ok := b.OperationCallOptions[operation]; ok {
+		if override.Timeout != 0 {
+			options.Timeout = override.Timeout
+		}
+		if override.Retryer != nil {
+			options.Retryer = override.Retryer
+		}
+	}
+	return options
+}
+
+// attemptsError carries how many attempts were made at a failed call to a
+// RemoteBackendError
+type attemptsError struct {
+	err      error
+	attempts int
+}
+
+func (e *attemptsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *attemptsError) Unwrap() error {
+	return e.err
+}
+
+// remoteError returns a RemoteBackendError for the failed operation with the
+// attempts made at it
+func remoteError(err error, operation OperationType, tableName string) *RemoteBackendError {
+	remote := &RemoteBackendError{Err: err, Operation: operation, TableName: tableName, Attempts: 1}
+	var attempts *attemptsError
+	if errors.As(err, &attempts) {
+		remote.Attempts = attempts.attempts
+	}
+	return remote
+}
+
+// attemptCounter counts the attempts the client makes at a call, which is
+// once for each try of its retryer
+type attemptCounter struct {
+	attempts *atomic.Int32
+}
+
+func (attemptCounter) ID() string {
+	return "CircuitryAttemptCounter"
+}
+
+func (c attemptCounter) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	c.attempts.Add(1)
+	return next.HandleFinalize(ctx, in)
+}
+
+// countAttempts adds the attemptCounter at the end of the finalize step,
+// after the client's retry middleware
+func countAttempts(attempts *atomic.Int32) func(*ddb.Options) {
+	return func(o *ddb.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Finalize.Add(attemptCounter{attempts: attempts}, middleware.After)
+		})
+	}
+}
+
+// call makes the call with the CallOptions of the operation, retrying it
+// with the backend's Throttling while it is throttled. Errors are wrapped so
+// remoteError can report the attempts made, counting a call the client did
+// not count as one attempt.
+func call[In, Out any](ctx context.Context, b *Backend, operation OperationType, params In, optFns []func(*ddb.Options), fn func(context.Context, In, ...func(*ddb.Options)) (Out, error)) (Out, error) {
+	options := b.callOptions(operation)
+	optFns = optFns[:len(optFns):len(optFns)]
+	if options.Retryer != nil {
+		optFns = append(optFns, func(o *ddb.Options) { o.Retryer = options.Retryer() })
+	}
+	attempts := 0
+	for attempt := 1; ; attempt++ {
+		var counted atomic.Int32
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if options.Timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, options.Timeout)
+		}
+		output, err := fn(callCtx, params, append(optFns, countAttempts(&counted))...)
+		cancel()
+		attempts += max(int(counted.Load()), 1)
+		if err == nil {
+			return output, nil
+		}
+		if attempt >= b.Throttling.MaxAttempts || !isThrottled(err) {
+			return output, &attemptsError{err: err, attempts: attempts}
+		}
+		timer := time.NewTimer(b.Throttling.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return output, &attemptsError{err: err, attempts: attempts}
+		case <-timer.C:
+		}
+	}
+}
+
+// configuredClient makes the calls of the backend's Client with call
+type configuredClient struct {
+	backend *Backend
+}
+
+func (b *Backend) client() configuredClient {
+	return configuredClient{backend: b}
+}
+
+func (c configuredClient) GetItem(ctx context.Context, params *ddb.GetItemInput, optFns ...func(*ddb.Options)) (*ddb.GetItemOutput, error) {
+	return call(ctx, c.backend, OpGetItem, params, optFns, c.backend.Client.GetItem)
+}
+
+func (c configuredClient) UpdateItem(ctx context.Context, params *ddb.UpdateItemInput, optFns ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error) {
+	return call(ctx, c.backend, OpUpdateItem, params, optFns, c.backend.Client.UpdateItem)
+}
+
+func (c configuredClient) DeleteItem(ctx context.Context, params *ddb.DeleteItemInput, optFns ...func(*ddb.Options)) (*ddb.DeleteItemOutput, error) {
+	return call(ctx, c.backend, OpDeleteItem, params, optFns, c.backend.Client.DeleteItem)
+}
+
+func (c configuredClient) Scan(ctx context.Context, params *ddb.ScanInput, optFns ...func(*ddb.Options)) (*ddb.ScanOutput, error) {
+	return call(ctx, c.backend, OpScan, params, optFns, c.backend.Client.Scan)
+}
+
+var _ ddb.ScanAPIClient = configuredClient{}
@@ -0,0 +1,210 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+var fastThrottling = ddbbackend.ThrottleBackoff{MaxAttempts: 3, InitialInterval: time.Nanosecond, MaxInterval: 4 * time.Nanosecond}
+
+func throttled() error {
+	return &ddbtypes.ProvisionedThroughputExceededException{Message: aws.String("test")}
+}
+
+// failingClient returns its errors from UpdateItem before storing items in
+// the DynamoClient
+type failingClient struct {
+	ddbbackend.DynamoClient
+	errors []error
+	calls  int
+}
+
+func (c *failingClient) UpdateItem(ctx context.Context, params *ddb.UpdateItemInput, optFns ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error) {
+	c.calls++
+	if c.calls <= len(c.errors) {
+		return nil, c.errors[c.calls-1]
+	}
+	return c.DynamoClient.UpdateItem(ctx, params, optFns...)
+}
+
+// blockingClient makes GetItem wait until its context is done
+type blockingClient struct {
+	ddbbackend.DynamoClient
+}
+
+func (blockingClient) GetItem(ctx context.Context, _ *ddb.GetItemInput, _ ...func(*ddb.Options)) (*ddb.GetItemOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestBackendConsistentRead(t *testing.T) {
+	for _, consistent := range []bool{false, true} {
+		client := newDDBMock()
+		client.AddGetItemOutput(&ddb.GetItemOutput{Item: ciToAVMap(circuitry.CircuitInformation{})})
+		client.AddScanOutput(&ddb.ScanOutput{})
+		backend := &ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_consistent", ConsistentRead: consistent}
+		if _, err := backend.Retrieve(context.TODO(), "consistent"); err != nil {
+			t.Fatalf("expected to retrieve; got %v", err)
+		}
+		for _, err := range backend.List(context.TODO(), "") {
+			t.Fatalf("expected no entries; got %v", err)
+		}
+		if actual := aws.ToBool(client.getItemInputs[0].ConsistentRead); actual != consistent {
+			t.Fatalf("expected GetItem ConsistentRead = %t; got %t", consistent, actual)
+		}
+		if actual := aws.ToBool(client.scanInputs[0].ConsistentRead); actual != consistent {
+			t.Fatalf("expected Scan ConsistentRead = %t; got %t", consistent, actual)
+		}
+	}
+}
+
+func TestBackendThrottling(t *testing.T) {
+	backendErr := errors.New("test")
+	testCases := map[string]struct {
+		errors     []error
+		throttling ddbbackend.ThrottleBackoff
+		attempts   int
+		expected   error
+	}{
+		"retried until it succeeds": {
+			errors:     []error{throttled(), throttled()},
+			throttling: fastThrottling,
+			attempts:   3,
+		},
+		"retried until the attempts run out": {
+			errors:     []error{throttled(), throttled(), throttled()},
+			throttling: fastThrottling,
+			attempts:   3,
+			expected:   throttled(),
+		},
+		"not retried by default": {
+			errors:   []error{throttled()},
+			attempts: 1,
+			expected: throttled(),
+		},
+		"other errors are not retried": {
+			errors:     []error{backendErr},
+			throttling: fastThrottling,
+			attempts:   1,
+			expected:   backendErr,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := &failingClient{DynamoClient: newFakeDynamo(), errors: tc.errors}
+			backend := &ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_throttled", Throttling: tc.throttling}
+
+			err := backend.Store(context.TODO(), "throttled", circuitry.CircuitInformation{})
+			if client.calls != tc.attempts {
+				t.Fatalf("expected %d attempts; got %d", tc.attempts, client.calls)
+			}
+			if tc.expected == nil {
+				if err != nil {
+					t.Fatalf("expected to store; got %v", err)
+				}
+				return
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if !errors.As(err, &remote) || remote.Operation != ddbbackend.OpUpdateItem || remote.Attempts != tc.attempts {
+				t.Fatalf("expected a RemoteBackendError for %s after %d attempts; got %T(%v)", ddbbackend.OpUpdateItem, tc.attempts, err, err)
+			}
+			if !strings.HasSuffix(err.Error(), ": "+tc.expected.Error()) {
+				t.Fatalf("expected the error to wrap %v; got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestBackendThrottlingCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &failingClient{DynamoClient: newFakeDynamo(), errors: []error{throttled(), throttled()}}
+	backend := &ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_throttled", Throttling: ddbbackend.ThrottleBackoff{MaxAttempts: 3, InitialInterval: time.Hour}}
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := backend.Store(ctx, "throttled", circuitry.CircuitInformation{})
+	var remote *ddbbackend.RemoteBackendError
+	if !errors.As(err, &remote) || remote.Attempts != 1 || client.calls != 1 {
+		t.Fatalf("expected to give up after 1 attempt; got %d calls and %T(%v)", client.calls, err, err)
+	}
+}
+
+func TestBackendCallTimeout(t *testing.T) {
+	backend := &ddbbackend.Backend{
+		Client:               blockingClient{DynamoClient: newFakeDynamo()},
+		CircuitTableName:     "circuit_information_timeout",
+		CallOptions:          ddbbackend.CallOptions{Timeout: time.Hour},
+		OperationCallOptions: map[ddbbackend.OperationType]ddbbackend.CallOptions{ddbbackend.OpGetItem: {Timeout: 10 * time.Millisecond}},
+	}
+	start := time.Now()
+	_, err := backend.Retrieve(context.Background(), "timeout")
+	var remote *ddbbackend.RemoteBackendError
+	if !errors.As(err, &remote) || remote.Operation != ddbbackend.OpGetItem || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a RemoteBackendError for %s wrapping %v; got %T(%v)", ddbbackend.OpGetItem, context.DeadlineExceeded, err, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Fatalf("expected the GetItem timeout to be used; took %v", elapsed)
+	}
+}
+
+func TestBackendCallRetryer(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"test"}`))
+	}))
+	defer server.Close()
+	client := ddb.New(ddb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		Retryer:      aws.NopRetryer{},
+	})
+	backend := &ddbbackend.Backend{
+		Client:           client,
+		CircuitTableName: "circuit_information_retryer",
+		OperationCallOptions: map[ddbbackend.OperationType]ddbbackend.CallOptions{ddbbackend.OpGetItem: {Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = 3
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+				o.RateLimiter = ratelimit.None
+			})
+		}}},
+		Throttling: fastThrottling,
+	}
+
+	_, err := backend.Retrieve(context.Background(), "retryer")
+	var remote *ddbbackend.RemoteBackendError
+	if !errors.As(err, &remote) || remote.Attempts != 9 || requests.Load() != 9 {
+		t.Fatalf("expected 9 attempts; got %d requests and %T(%v)", requests.Load(), err, err)
+	}
+	if !strings.Contains(err.Error(), "after 9 attempts") {
+		t.Fatalf("expected the error to report the attempts; got %v", err)
+	}
+
+	// Scan keeps the client's retryer, which does not retry
+	requests.Store(0)
+	for _, err := range backend.List(context.Background(), "") {
+		if !errors.As(err, &remote) || remote.Attempts != 3 || requests.Load() != 3 {
+			t.Fatalf("expected 3 attempts; got %d requests and %T(%v)", requests.Load(), err, err)
+		}
+	}
+}
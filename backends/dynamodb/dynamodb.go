@@ -2,12 +2,18 @@ package dynamodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	ddblock "cirello.io/dynamolock/v2"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbexp "github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 
@@ -22,6 +28,7 @@ type DynamoClient interface {
 	UpdateItem(ctx context.Context, params *ddb.UpdateItemInput, optFns ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error)
 	DeleteItem(ctx context.Context, params *ddb.DeleteItemInput, optFns ...func(*ddb.Options)) (*ddb.DeleteItemOutput, error)
 	CreateTable(ctx context.Context, params *ddb.CreateTableInput, optFns ...func(*ddb.Options)) (*ddb.CreateTableOutput, error)
+	Scan(ctx context.Context, params *ddb.ScanInput, optFns ...func(*ddb.Options)) (*ddb.ScanOutput, error)
 }
 
 // DynamoLocker defines the necessary attributes for a DynamoDB Lock
@@ -32,11 +39,21 @@ type DynamoLocker interface {
 	ReleaseLockWithContext(ctx context.Context, lockItem *ddblock.Lock, opts ...ddblock.ReleaseLockOption) (bool, error)
 }
 
+// FenceName is the attribute of the circuit information table holding the
+// last fencing token issued for a circuit
+const FenceName = "fence"
+
 // DynamoLock wraps a DynamoDB Lock from cirello.io/dynamolock/v2 in a
-// sync.Locker interface
+// [github.com/sigmavirus24/circuitry.Lease]. The lock client renews the lease
+// with its heartbeats.
 type DynamoLock struct {
-	ddbClient DynamoClient
-	lock      *ddblock.Lock
+	ddbClient   DynamoClient
+	lock        *ddblock.Lock
+	token       uint64
+	locker      DynamoLocker
+	releaseOpts []ddblock.ReleaseLockOption
+	tableName   string
+	released    atomic.Bool
 }
 
 // Lock does nothing as the lock has already been acquired at this point
@@ -44,10 +61,36 @@ func (l *DynamoLock) Lock() {}
 
 // Unlock releases the backend lock
 func (l *DynamoLock) Unlock() {
-	_ = l.lock.Close()
+	_ = l.Release(context.Background())
+}
+
+// Release releases the backend lock with the Backend's ReleaseLockOpts.
+// Releasing the lock again does nothing.
+func (l *DynamoLock) Release(ctx context.Context) error {
+	if l.released.Swap(true) {
+		return nil
+	}
+	if _, err := l.locker.ReleaseLockWithContext(ctx, l.lock, l.releaseOpts...); err != nil {
+		return remoteError(err, OpReleaseLock, l.tableName)
+	}
+	return nil
+}
+
+// Token returns the fencing token issued when the lock was acquired
+func (l *DynamoLock) Token() uint64 {
+	return l.token
 }
 
-var _ sync.Locker = (*DynamoLock)(nil)
+// Err returns [github.com/sigmavirus24/circuitry.ErrLockLost] once the lease
+// has expired because heartbeats stopped renewing it
+func (l *DynamoLock) Err() error {
+	if l.lock.IsExpired() {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+var _ circuitry.Lease = (*DynamoLock)(nil)
 
 // Backend provides the StorageBackender interface wrapper for DynamoDB
 // support
@@ -57,31 +100,75 @@ type Backend struct {
 	CircuitTableName, LockTableName string
 	AcquireLockOpts                 []ddblock.AcquireLockOption
 	ReleaseLockOpts                 []ddblock.ReleaseLockOption
+	StreamsClient                   StreamsClient                 // StreamsClient is used to watch the CircuitTableName table's stream for transitions.
+	StreamARN                       string                        // StreamARN of the CircuitTableName table's stream which must include new and old images.
+	StreamPollInterval              time.Duration                 // StreamPollInterval defaults to DefaultStreamPollInterval.
+	TTLAttributeName                string                        // TTLAttributeName defaults to DefaultTTLAttributeName.
+	Retention                       circuitry.Retention           // Retention decides the TTLAttributeName attribute written on each store. The zero value writes none. Time to Live deletes the whole item, including its FenceName and VersionName attributes.
+	SingleTable                     bool                          // SingleTable stores circuits under the StateItemType sort key so they share CircuitTableName with locks. See NewSingleTable.
+	ConsistentRead                  bool                          // ConsistentRead makes Retrieve and List read strongly consistently so they see circuits stored by other processes.
+	CallOptions                     CallOptions                   // CallOptions configures the timeout and retryer of each GetItem, UpdateItem, DeleteItem, and Scan call.
+	OperationCallOptions            map[OperationType]CallOptions // OperationCallOptions overrides the fields of CallOptions that are set for the operation.
+	Throttling                      ThrottleBackoff               // Throttling retries calls that were throttled. The zero value does not retry.
 }
 
 // Store CircuitInformation in DynamoDB in the specified CircuitTableName
 // table
 func (b *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
 	record := recordFromCircuitInformation(ci)
-	record.Name = name
-	expr, err := record.ToUpdateExpression()
+	expr, err := ddbexp.NewBuilder().WithUpdate(b.withTTL(record.update(), ci, time.Now())).Build()
+	if err != nil {
+		return err
+	}
+	key, err := b.itemKey(name)
+	if err != nil {
+		return err
+	}
+	_, err = b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              ddbtypes.ReturnValueNone,
+	})
+	if err != nil {
+		return remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	return nil
+}
+
+// StoreFenced saves the CircuitInformation like Store on the condition that
+// no lock with a greater fencing token has been acquired for the circuit.
+// Otherwise it returns [github.com/sigmavirus24/circuitry.ErrLockLost].
+func (b *Backend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	record := recordFromCircuitInformation(ci)
+	expr, err := ddbexp.NewBuilder().
+		WithUpdate(b.withTTL(record.update(), ci, time.Now())).
+		WithCondition(fenceCondition(token)).
+		Build()
 	if err != nil {
 		return err
 	}
-	key, err := attributevalue.Marshal(record.Name)
+	key, err := b.itemKey(name)
 	if err != nil {
-		return &LocalBackendError{Err: err, Message: fmt.Sprintf("could not marshal %q with AWS SDK for dynamodb hash key", name)}
+		return err
 	}
-	_, err = b.Client.UpdateItem(ctx, &ddb.UpdateItemInput{
+	_, err = b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
 		TableName:                 aws.String(b.CircuitTableName),
-		Key:                       map[string]ddbtypes.AttributeValue{KeyName: key},
+		Key:                       key,
+		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		UpdateExpression:          expr.Update(),
 		ReturnValues:              ddbtypes.ReturnValueNone,
 	})
+	var conditionFailed *ddbtypes.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		err = fmt.Errorf("%w: %w", circuitry.ErrLockLost, err)
+	}
 	if err != nil {
-		return &RemoteBackendError{Err: err, Operation: OpUpdateItem, TableName: b.CircuitTableName}
+		return remoteError(err, OpUpdateItem, b.CircuitTableName)
 	}
 	return nil
 }
@@ -90,35 +177,175 @@ func (b *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitIn
 // table
 func (b *Backend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
 	record := circuitInfoRecord{Name: name}
-	keyValue, err := attributevalue.Marshal(record.Name)
+	key, err := b.itemKey(name)
 	if err != nil {
-		return circuitry.CircuitInformation{}, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot marshal %q with AWS SDK for dynamodb hash key", name)}
+		return circuitry.CircuitInformation{}, err
 	}
 	getItem := &ddb.GetItemInput{
-		Key:       map[string]ddbtypes.AttributeValue{KeyName: keyValue},
-		TableName: aws.String(b.CircuitTableName),
+		Key:            key,
+		TableName:      aws.String(b.CircuitTableName),
+		ConsistentRead: aws.Bool(b.ConsistentRead),
 	}
-	response, err := b.Client.GetItem(ctx, getItem)
+	response, err := b.client().GetItem(ctx, getItem)
 	if err != nil {
-		return circuitry.CircuitInformation{}, &RemoteBackendError{Err: err, Operation: OpGetItem, TableName: b.CircuitTableName}
+		return circuitry.CircuitInformation{}, remoteError(err, OpGetItem, b.CircuitTableName)
 	}
 	err = attributevalue.UnmarshalMap(response.Item, &record)
 	if err != nil {
 		return circuitry.CircuitInformation{}, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot unmarshal data for %q", name)}
 	}
+	if err := record.checkSchema(name); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
 	return record.ToCircuitInformation(), nil
 }
 
-// Lock created in DynamoDB using the LockTableName table
+// Lock created in DynamoDB using the LockTableName table. Each lock is issued
+// a fencing token by incrementing the circuit's FenceName attribute in the
+// CircuitTableName table.
 func (b *Backend) Lock(ctx context.Context, name string) (sync.Locker, error) {
 	lock, err := b.LockClient.AcquireLockWithContext(ctx, name, ddblock.FailIfLocked(), ddblock.WithDeleteLockOnRelease())
 	if err != nil {
-		return nil, &RemoteBackendError{Err: err, Operation: OpAcquireLock, TableName: b.LockTableName}
+		return nil, remoteError(err, OpAcquireLock, b.LockTableName)
+	}
+	token, err := b.nextFence(ctx, name)
+	if err != nil {
+		_, _ = b.LockClient.ReleaseLockWithContext(ctx, lock, b.ReleaseLockOpts...)
+		return nil, err
+	}
+	return &DynamoLock{
+		ddbClient:   b.Client,
+		lock:        lock,
+		token:       token,
+		locker:      b.LockClient,
+		releaseOpts: b.ReleaseLockOpts,
+		tableName:   b.LockTableName,
+	}, nil
+}
+
+func (b *Backend) nextFence(ctx context.Context, name string) (uint64, error) {
+	key, err := b.itemKey(name)
+	if err != nil {
+		return 0, err
+	}
+	output, err := b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		UpdateExpression:          aws.String("ADD #fence :one"),
+		ExpressionAttributeNames:  map[string]string{"#fence": FenceName},
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{":one": &ddbtypes.AttributeValueMemberN{Value: "1"}},
+		ReturnValues:              ddbtypes.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	var token uint64
+	if err := attributevalue.Unmarshal(output.Attributes[FenceName], &token); err != nil {
+		return 0, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot unmarshal fencing token for %q", name)}
+	}
+	return token, nil
+}
+
+// Delete removes the circuit's attributes from its item in the
+// CircuitTableName table. The item's FenceName attribute is kept so tokens
+// keep increasing if the circuit is locked again, and its SchemaVersionName
+// attribute is set so Migrate does not store the deleted circuit again. The
+// item itself stays until Time to Live removes it or it is removed by hand.
+func (b *Backend) Delete(ctx context.Context, name string) error {
+	update := ddbexp.Set(ddbexp.Name(SchemaVersionName), ddbexp.Value(SchemaVersion))
+	for _, attribute := range circuitAttributeNames {
+		update = update.Remove(ddbexp.Name(attribute))
+	}
+	// Updating a missing item would create it, so circuits that were never
+	// stored or locked are left alone
+	expr, err := ddbexp.NewBuilder().
+		WithUpdate(update).
+		WithCondition(ddbexp.AttributeExists(ddbexp.Name(KeyName))).
+		Build()
+	if err != nil {
+		return err
+	}
+	key, err := b.itemKey(name)
+	if err != nil {
+		return err
+	}
+	_, err = b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              ddbtypes.ReturnValueNone,
+	})
+	if err != nil && !isConditionFailed(err) {
+		return remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	return nil
+}
+
+// List scans the CircuitTableName table for circuits whose names start with
+// the prefix, skipping the items of locks when SingleTable is set and the
+// items holding only the fencing token of a circuit that was deleted or never
+// stored. Items that cannot be unmarshaled or were written with a newer
+// SchemaVersion yield a LocalBackendError.
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		input := &ddb.ScanInput{TableName: aws.String(b.CircuitTableName), ConsistentRead: aws.Bool(b.ConsistentRead)}
+		var filters []string
+		names := map[string]string{}
+		values := map[string]ddbtypes.AttributeValue{}
+		if prefix != "" {
+			filters = append(filters, "begins_with(#name, :prefix)")
+			names["#name"] = KeyName
+			values[":prefix"] = &ddbtypes.AttributeValueMemberS{Value: prefix}
+		}
+		if b.SingleTable {
+			filters = append(filters, "#type = :type")
+			names["#type"] = SortKeyName
+			values[":type"] = &ddbtypes.AttributeValueMemberS{Value: StateItemType}
+		}
+		if len(filters) > 0 {
+			input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+			input.ExpressionAttributeNames = names
+			input.ExpressionAttributeValues = values
+		}
+		paginator := ddb.NewScanPaginator(b.client(), input)
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				yield(circuitry.CircuitEntry{}, remoteError(err, OpScan, b.CircuitTableName))
+				return
+			}
+			for _, item := range page.Items {
+				if _, ok := item[stateName]; !ok {
+					continue
+				}
+				var record circuitInfoRecord
+				if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+					if !yield(circuitry.CircuitEntry{}, &LocalBackendError{Err: err, Message: "cannot unmarshal scanned circuit information"}) {
+						return
+					}
+					continue
+				}
+				if err := record.checkSchema(record.Name); err != nil {
+					if !yield(circuitry.CircuitEntry{}, err) {
+						return
+					}
+					continue
+				}
+				if !yield(circuitry.CircuitEntry{Name: record.Name, Information: record.ToCircuitInformation()}, nil) {
+					return
+				}
+			}
+		}
 	}
-	return &DynamoLock{b.Client, lock}, nil
 }
 
 var _ circuitry.StorageBackender = (*Backend)(nil)
+var _ circuitry.Lister = (*Backend)(nil)
+var _ circuitry.Deleter = (*Backend)(nil)
+var _ circuitry.FencedStorer = (*Backend)(nil)
 
 // WithDynamoBackend can be used to configure a circuitry.FactorySettings
 // object to use DynamoDB as the backend.
@@ -131,7 +358,7 @@ func WithDynamoBackend(client DynamoClient, locker DynamoLocker, circuitInformat
 			var err error
 			locker, err = ddblock.New(client, lockTableName, lockOpts...)
 			if err != nil {
-				return circuitry.ErrProvisioningStorageBackend
+				return fmt.Errorf("%w: %w", circuitry.ErrProvisioningStorageBackend, err)
 			}
 		}
 		backend := Backend{
@@ -368,17 +368,210 @@ func TestBackendIntegrationLockWithTable(t *testing.T) {
 		CircuitTableName: circuitTable,
 		LockTableName:    locksTable,
 	}
+	_, err = ddbbackend.CreateCircuitInformationTable(context.TODO(), ddbClient, circuitTable)
+	if err != nil {
+		t.Fatalf("failed to create new test table: %v", err)
+	}
+	defer func() {
+		_, _ = ddbClient.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+			TableName: aws.String(circuitTable),
+		})
+	}()
 
-	lock, err := backend.Lock(context.TODO(), key)
+	stale, err := backend.Lock(context.TODO(), key)
 	if err != nil {
 		t.Fatalf("expected to create a lock, got err = %v", err)
 	}
-	if lock == nil {
+	if stale == nil {
 		t.Fatalf("expected to get a lock but got nil")
 	}
 	_, err = lockClient.Get(key)
 	if err != nil {
 		t.Fatalf("could not get raw lock from Dynamo, got err = %v", err)
 	}
+	stale.Unlock()
+
+	lock, err := backend.Lock(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("expected to create a lock again, got err = %v", err)
+	}
+	defer lock.Unlock()
+	staleToken, token := stale.(circuitry.Lease).Token(), lock.(circuitry.Lease).Token()
+	if staleToken != 1 || token != 2 {
+		t.Fatalf("expected fencing tokens 1 and 2; got %d and %d", staleToken, token)
+	}
+	if err := lock.(circuitry.Lease).Err(); err != nil {
+		t.Fatalf("expected the lease to be held; got err = %v", err)
+	}
+	err = backend.StoreFenced(context.TODO(), key, circuitry.CircuitInformation{Generation: 1}, staleToken)
+	if !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected the stale token to be fenced off; got err = %v", err)
+	}
+	if err := backend.StoreFenced(context.TODO(), key, circuitry.CircuitInformation{Generation: 2}, token); err != nil {
+		t.Fatalf("expected to store with the current token; got err = %v", err)
+	}
+	actual, err := backend.Retrieve(context.TODO(), key)
+	if err != nil || actual.Generation != 2 {
+		t.Fatalf("expected the information stored with the current token; got %+v (err = %v)", actual, err)
+	}
+}
+
+func TestVersionedBackendIntegrationOutcomes(t *testing.T) {
+	maybeSkip(t)
+	t.Parallel()
+
+	ddbClient := dynamodbClientFromURL()
+	testID := uuid.NewString()
+	key := fmt.Sprintf("circuit-breaker-%s", testID)
+	circuitTable := fmt.Sprintf("circuit_info_%s", testID)
+	backend := &ddbbackend.VersionedBackend{Backend: ddbbackend.Backend{Client: ddbClient, CircuitTableName: circuitTable}}
+
+	_, err := ddbbackend.CreateCircuitInformationTable(context.TODO(), ddbClient, circuitTable)
+	if err != nil {
+		t.Fatalf("failed to create new test table: %v", err)
+	}
+	defer func() {
+		_, _ = ddbClient.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+			TableName: aws.String(circuitTable),
+		})
+	}()
+
+	settings, _ := circuitry.NewFactorySettings(circuitry.WithStorageBackend(backend), circuitry.WithFailureCountThreshold(1))
+	breaker := circuitry.NewCircuitBreakerFactory(settings).BreakerFor(key, map[string]any{})
+	workErr := errors.New("test")
+	for _, outcome := range []error{nil, workErr, workErr} {
+		if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, outcome }); err != nil {
+			t.Fatalf("expected to execute, got err = %v", err)
+		}
+	}
+	if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the circuit to have tripped, got err = %v", err)
+	}
+	actual, err := backend.Retrieve(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("expected to get stored CircuitInformation, got err = %v", err)
+	}
+	if actual.State != circuitry.CircuitOpen || actual.Generation != 1 {
+		t.Fatalf("expected the circuit to be open in generation 1, got %+v", actual)
+	}
+
+	lock, err := backend.Lock(context.TODO(), key)
+	if err != nil {
+		t.Fatalf("expected to lock, got err = %v", err)
+	}
+	if err := breaker.Reset(context.TODO()); err != nil {
+		t.Fatalf("expected to reset, got err = %v", err)
+	}
+	if err := backend.StoreFenced(context.TODO(), key, actual, lock.(circuitry.Lease).Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected a stale token to be rejected, got err = %v", err)
+	}
+}
+
+func TestBackendIntegrationSingleTable(t *testing.T) {
+	maybeSkip(t)
+	t.Parallel()
+
+	ctx := context.TODO()
+	ddbClient := dynamodbClientFromURL()
+	testID := uuid.NewString()
+	key := fmt.Sprintf("circuit-breaker-%s", testID)
+	table := fmt.Sprintf("circuitry_%s", testID)
+	backend, err := ddbbackend.NewSingleTable(ddbClient, table)
+	if err != nil {
+		t.Fatalf("could not create single-table backend: %v", err)
+	}
+	if err := backend.EnsureTables(ctx); err != nil {
+		t.Fatalf("expected to create the table, got err = %v", err)
+	}
+	defer func() {
+		_, _ = ddbClient.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+			TableName: aws.String(table),
+		})
+	}()
+	if err := backend.EnsureTables(ctx); err != nil {
+		t.Fatalf("expected an existing table to be ensured, got err = %v", err)
+	}
+
+	lock, err := backend.Lock(ctx, key)
+	if err != nil {
+		t.Fatalf("expected to lock, got err = %v", err)
+	}
+	lockItem, err := ddbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			ddbbackend.KeyName:     &types.AttributeValueMemberS{Value: key},
+			ddbbackend.SortKeyName: &types.AttributeValueMemberS{Value: ddbbackend.LockItemType},
+		},
+	})
+	if err != nil || len(lockItem.Item) == 0 {
+		t.Fatalf("expected the lock to be held in the table, got %v (err = %v)", lockItem, err)
+	}
+	expected := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, ExpiresAfter: time.Now().Add(time.Minute).Truncate(time.Second)}
+	if err := backend.StoreFenced(ctx, key, expected, lock.(circuitry.Lease).Token()); err != nil {
+		t.Fatalf("expected to store, got err = %v", err)
+	}
 	lock.Unlock()
+
+	actual, err := backend.Retrieve(ctx, key)
+	if err != nil {
+		t.Fatalf("expected to retrieve, got err = %v", err)
+	}
+	deepEqCi(t, expected, actual)
+	var names []string
+	for entry, err := range backend.List(ctx, "") {
+		if err != nil {
+			t.Fatalf("expected to list, got err = %v", err)
+		}
+		names = append(names, entry.Name)
+	}
+	if len(names) != 1 || names[0] != key {
+		t.Fatalf("expected only the circuit to be listed, got %v", names)
+	}
+}
+
+func TestBackendIntegrationEnsureTables(t *testing.T) {
+	maybeSkip(t)
+	t.Parallel()
+
+	ctx := context.TODO()
+	ddbClient := dynamodbClientFromURL()
+	testID := uuid.NewString()
+	key := fmt.Sprintf("circuit-breaker-%s", testID)
+	circuitTable := fmt.Sprintf("circuit_info_%s", testID)
+	locksTable := fmt.Sprintf("circuit_breaker_locks_%s", testID)
+	lockClient, err := ddblock.New(ddbClient, locksTable)
+	if err != nil {
+		t.Fatalf("could not create dynamodb lock client: %v", err)
+	}
+	backend := ddbbackend.Backend{
+		Client:           ddbClient,
+		LockClient:       lockClient,
+		CircuitTableName: circuitTable,
+		LockTableName:    locksTable,
+	}
+	for i := 0; i < 2; i++ {
+		if err := backend.EnsureTables(ctx, ddbbackend.CreateTableWithStream()); err != nil {
+			t.Fatalf("expected to ensure the tables, got err = %v", err)
+		}
+	}
+	defer func() {
+		for _, table := range []string{circuitTable, locksTable} {
+			_, _ = ddbClient.DeleteTable(context.TODO(), &dynamodb.DeleteTableInput{
+				TableName: aws.String(table),
+			})
+		}
+	}()
+
+	lock, err := backend.Lock(ctx, key)
+	if err != nil {
+		t.Fatalf("expected to lock, got err = %v", err)
+	}
+	defer lock.Unlock()
+	if err := backend.StoreFenced(ctx, key, circuitry.CircuitInformation{Generation: 1}, lock.(circuitry.Lease).Token()); err != nil {
+		t.Fatalf("expected to store, got err = %v", err)
+	}
+	actual, err := backend.Retrieve(ctx, key)
+	if err != nil || actual.Generation != 1 {
+		t.Fatalf("expected the stored information, got %+v (err = %v)", actual, err)
+	}
 }
@@ -4,20 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
 	ddblock "cirello.io/dynamolock/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/sigmavirus24/circuitry"
 	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
 )
 
 type ddbLockerMock struct {
 	acquireLockOptions      []ddblock.AcquireLockOption
 	keys                    []string
 	acquireLockReturnErrors []error
+	released                []*ddblock.Lock
+	releaseLockError        error
 }
 
 func (l *ddbLockerMock) AcquireLockWithContext(_ context.Context, key string, opts ...ddblock.AcquireLockOption) (*ddblock.Lock, error) {
@@ -32,7 +37,8 @@ func (l *ddbLockerMock) CreateTableWithContext(_ context.Context, tableName stri
 	return nil, nil
 }
 func (l *ddbLockerMock) ReleaseLockWithContext(_ context.Context, lockItem *ddblock.Lock, opts ...ddblock.ReleaseLockOption) (bool, error) {
-	return false, nil
+	l.released = append(l.released, lockItem)
+	return l.releaseLockError == nil, l.releaseLockError
 }
 
 func newDDBLockerMock() *ddbLockerMock {
@@ -52,6 +58,10 @@ type ddbMock struct {
 	updateItemOutputs       []*ddb.UpdateItemOutput
 	updateItemErrors        []error
 	updateItemOutputCounter uint
+	scanInputs              []*ddb.ScanInput
+	scanOutputs             []*ddb.ScanOutput
+	scanErrors              []error
+	scanOutputCounter       uint
 }
 
 func newDDBMock() *ddbMock {
@@ -77,6 +87,14 @@ func (m *ddbMock) AddUpdateItemError(err error) {
 	m.updateItemErrors = append(m.updateItemErrors, err)
 }
 
+func (m *ddbMock) AddScanError(err error) {
+	m.scanErrors = append(m.scanErrors, err)
+}
+
+func (m *ddbMock) AddScanOutput(out *ddb.ScanOutput) {
+	m.scanOutputs = append(m.scanOutputs, out)
+}
+
 func (m *ddbMock) AddUpdateItemOutput(out *ddb.UpdateItemOutput) {
 	m.updateItemOutputs = append(m.updateItemOutputs, out)
 }
@@ -117,6 +135,18 @@ func (m *ddbMock) CreateTable(_ context.Context, params *ddb.CreateTableInput, o
 	return nil, nil
 }
 
+func (m *ddbMock) Scan(_ context.Context, params *ddb.ScanInput, optFns ...func(*ddb.Options)) (*ddb.ScanOutput, error) {
+	m.scanInputs = append(m.scanInputs, params)
+	if outputs := uint(len(m.scanOutputs)); m.scanOutputCounter >= outputs {
+		errorIndex := m.scanOutputCounter - outputs
+		m.scanOutputCounter++
+		return nil, m.scanErrors[errorIndex]
+	}
+	output := m.scanOutputs[m.scanOutputCounter]
+	m.scanOutputCounter++
+	return output, nil
+}
+
 var _ ddbbackend.DynamoClient = (*ddbMock)(nil)
 
 func TestWithDynamoBackend(t *testing.T) {
@@ -160,6 +190,7 @@ func ciToAVMap(ci circuitry.CircuitInformation) map[string]ddbtypes.AttributeVal
 		"total":                 intAttrValueMember(ci.Total),
 		"state":                 intAttrValueMember(uint64(ci.State)),
 		"expires_after":         strAttrValueMember(ci.ExpiresAfter.Format("2006-01-02T15:04:05Z07:00")),
+		"schema_version":        intAttrValueMember(ddbbackend.SchemaVersion),
 	}
 }
 
@@ -298,8 +329,46 @@ func TestBackendStore(t *testing.T) {
 	}
 }
 
+func TestBackendStoreRetrievesState(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	backend := ddbbackend.Backend{Client: client, LockClient: newDDBLockerMock(), CircuitTableName: "circuit_information_store_state"}
+	for _, state := range []circuitry.CircuitState{circuitry.CircuitOpen, circuitry.CircuitHalfOpen, circuitry.CircuitClosed} {
+		name := "circuit-" + state.String()
+		if err := backend.Store(ctx, name, circuitry.CircuitInformation{State: state, Generation: 2}); err != nil {
+			t.Fatalf("expected err to be nil; got err = %T(%v)", err, err)
+		}
+		if stored := client.Item(name)["state"]; !reflect.DeepEqual(stored, intAttrValueMember(uint64(state))) {
+			t.Fatalf("expected state attribute %d; got %v", state, stored)
+		}
+		ci, err := backend.Retrieve(ctx, name)
+		if err != nil {
+			t.Fatalf("expected err to be nil; got err = %T(%v)", err, err)
+		}
+		if ci.State != state {
+			t.Fatalf("expected ci.State = %s; got %s", state, ci.State)
+		}
+	}
+}
+
+func fenceOutput(token string) *ddb.UpdateItemOutput {
+	return &ddb.UpdateItemOutput{Attributes: map[string]ddbtypes.AttributeValue{
+		ddbbackend.FenceName: &ddbtypes.AttributeValueMemberN{Value: token},
+	}}
+}
+
+func TestBackendReleaseTwice(t *testing.T) {
+	lockClient := newDDBLockerMock()
+	backend := &ddbbackend.Backend{Client: newFakeDynamo(), LockClient: lockClient, CircuitTableName: "circuit_information_release_twice"}
+	circuitrytest.ReleaseTwice(t, backend)
+	if len(lockClient.released) != 2 {
+		t.Fatalf("expected each lock to be released once; got %d releases", len(lockClient.released))
+	}
+}
+
 func TestBackendLock(t *testing.T) {
 	client := newDDBMock()
+	client.AddUpdateItemOutput(fenceOutput("4"))
 	lockClient := newDDBLockerMock()
 	lockErr := errors.New("test")
 	lockClient.acquireLockReturnErrors = append(lockClient.acquireLockReturnErrors, lockErr)
@@ -321,4 +390,280 @@ func TestBackendLock(t *testing.T) {
 	}
 	lock.Lock()
 	defer lock.Unlock()
+	lease, ok := lock.(circuitry.Lease)
+	if !ok || lease.Token() != 4 {
+		t.Fatalf("expected a lease with the incremented fencing token; got %#v", lock)
+	}
+	// The mocked lock was never looked up so its lease has already expired
+	if err := lease.Err(); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected an expired lease to be lost; got err = %v", err)
+	}
+	input := client.updateItemInputs[0]
+	if aws.ToString(input.UpdateExpression) != "ADD #fence :one" || input.ReturnValues != ddbtypes.ReturnValueUpdatedNew {
+		t.Fatalf("expected the fence to be incremented; got %+v", input)
+	}
+}
+
+func TestBackendLockRelease(t *testing.T) {
+	client := newDDBMock()
+	client.AddUpdateItemOutput(fenceOutput("1"))
+	lockClient := newDDBLockerMock()
+	backend := ddbbackend.Backend{
+		Client:           client,
+		LockClient:       lockClient,
+		CircuitTableName: "circuit_information_lock_release",
+		LockTableName:    "circuit_locks_lock_release",
+	}
+	lock, err := backend.Lock(context.TODO(), "fake-key")
+	if err != nil {
+		t.Fatalf("expected to get a lock, but got err = %v", err)
+	}
+	releaseErr := errors.New("test")
+	lockClient.releaseLockError = releaseErr
+	err = lock.(circuitry.Lease).Release(context.TODO())
+	var remoteErr *ddbbackend.RemoteBackendError
+	if !errors.As(err, &remoteErr) || !errors.Is(err, releaseErr) || remoteErr.Operation != ddbbackend.OpReleaseLock || remoteErr.TableName != "circuit_locks_lock_release" {
+		t.Fatalf("expected a RemoteBackendError releasing the lock; got err = %v", err)
+	}
+	lockClient.releaseLockError = nil
+	lock.Unlock()
+	if len(lockClient.released) != 1 {
+		t.Fatalf("expected Unlock after Release to do nothing; got %d releases", len(lockClient.released))
+	}
+}
+
+func TestBackendLockFenceErrors(t *testing.T) {
+	updateErr := errors.New("test")
+	testCases := map[string]struct {
+		output *ddb.UpdateItemOutput
+		check  func(error) bool
+	}{
+		"update error": {nil, func(err error) bool {
+			var remoteErr *ddbbackend.RemoteBackendError
+			return errors.As(err, &remoteErr) && errors.Is(err, updateErr) && remoteErr.Operation == ddbbackend.OpUpdateItem
+		}},
+		"invalid token": {fenceOutput("-1"), func(err error) bool {
+			var localErr *ddbbackend.LocalBackendError
+			return errors.As(err, &localErr)
+		}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newDDBMock()
+			if tc.output != nil {
+				client.AddUpdateItemOutput(tc.output)
+			} else {
+				client.AddUpdateItemError(updateErr)
+			}
+			backend := ddbbackend.Backend{
+				Client:           client,
+				LockClient:       newDDBLockerMock(),
+				CircuitTableName: "circuit_information_lock_fence",
+				LockTableName:    "circuit_locks_lock_fence",
+			}
+			lockClient := backend.LockClient.(*ddbLockerMock)
+			if _, err := backend.Lock(context.TODO(), "fake-key"); !tc.check(err) {
+				t.Fatalf("expected a fencing error; got err = %T(%v)", err, err)
+			}
+			if len(lockClient.released) != 1 {
+				t.Fatalf("expected the lock to be released when it cannot be fenced; got %d releases", len(lockClient.released))
+			}
+		})
+	}
+}
+
+func TestBackendStoreFenced(t *testing.T) {
+	updateErr := errors.New("test")
+	testCases := map[string]struct {
+		err      error
+		expected error
+		lost     bool
+	}{
+		"stored":           {nil, nil, false},
+		"condition failed": {&ddbtypes.ConditionalCheckFailedException{}, circuitry.ErrLockLost, true},
+		"update error":     {updateErr, updateErr, false},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newDDBMock()
+			if tc.err != nil {
+				client.AddUpdateItemError(tc.err)
+			} else {
+				client.AddUpdateItemOutput(&ddb.UpdateItemOutput{})
+			}
+			backend := ddbbackend.Backend{
+				Client:           client,
+				LockClient:       newDDBLockerMock(),
+				CircuitTableName: "circuit_information_store_fenced",
+				LockTableName:    "circuit_locks_store_fenced",
+			}
+			err := backend.StoreFenced(context.TODO(), "fake-key", circuitry.CircuitInformation{Generation: 3}, 7)
+			if !errors.Is(err, tc.expected) || (tc.expected == nil) != (err == nil) {
+				t.Fatalf("expected %v; got err = %v", tc.expected, err)
+			}
+			if errors.Is(err, circuitry.ErrLockLost) != tc.lost {
+				t.Fatalf("expected ErrLockLost only when the condition fails; got err = %v", err)
+			}
+			input := client.updateItemInputs[0]
+			condition := aws.ToString(input.ConditionExpression)
+			if condition == "" {
+				t.Fatal("expected the update to be conditional on the fencing token")
+			}
+			var token *ddbtypes.AttributeValueMemberN
+			for _, value := range input.ExpressionAttributeValues {
+				if n, ok := value.(*ddbtypes.AttributeValueMemberN); ok && n.Value == "7" {
+					token = n
+				}
+			}
+			if token == nil {
+				t.Fatalf("expected the condition to compare against the token; got %+v", input.ExpressionAttributeValues)
+			}
+		})
+	}
+}
+
+func namedCiToAVMap(name string, ci circuitry.CircuitInformation) map[string]ddbtypes.AttributeValue {
+	item := ciToAVMap(ci)
+	item[ddbbackend.KeyName] = strAttrValueMember(name)
+	return item
+}
+
+func TestBackendList(t *testing.T) {
+	client := newDDBMock()
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, Total: 3}
+	closed := circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 1}
+	corrupted := namedCiToAVMap("tenant-corrupted", closed)
+	corrupted["total"] = strAttrValueMember("1")
+	client.AddScanOutput(&ddb.ScanOutput{
+		Items:            []map[string]ddbtypes.AttributeValue{namedCiToAVMap("tenant-a", open), corrupted},
+		LastEvaluatedKey: map[string]ddbtypes.AttributeValue{ddbbackend.KeyName: strAttrValueMember("tenant-corrupted")},
+	})
+	client.AddScanOutput(&ddb.ScanOutput{
+		Items: []map[string]ddbtypes.AttributeValue{namedCiToAVMap("tenant-b", closed)},
+	})
+	backend := ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information"}
+
+	var names []string
+	var errs []error
+	for entry, err := range backend.List(context.TODO(), "tenant-") {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, entry.Name)
+		if entry.Name == "tenant-a" && (entry.Information.State != open.State || entry.Information.Total != open.Total) {
+			t.Fatalf("expected tenant-a to have %+v; got %+v", open, entry.Information)
+		}
+	}
+	if len(names) != 2 || names[0] != "tenant-a" || names[1] != "tenant-b" {
+		t.Fatalf("expected [tenant-a tenant-b]; got %v", names)
+	}
+	var localErr *ddbbackend.LocalBackendError
+	if len(errs) != 1 || !errors.As(errs[0], &localErr) {
+		t.Fatalf("expected a LocalBackendError for the corrupted item; got %v", errs)
+	}
+	if len(client.scanInputs) != 2 {
+		t.Fatalf("expected 2 pages to be scanned; got %d", len(client.scanInputs))
+	}
+	input := client.scanInputs[0]
+	if aws.ToString(input.FilterExpression) != "begins_with(#name, :prefix)" || input.ExpressionAttributeNames["#name"] != ddbbackend.KeyName {
+		t.Fatalf("expected the scan to filter by prefix; got %+v", input)
+	}
+	if client.scanInputs[1].ExclusiveStartKey == nil {
+		t.Fatal("expected the second page to start after the first")
+	}
+}
+
+func TestBackendListStopsEarly(t *testing.T) {
+	client := newDDBMock()
+	closed := circuitry.CircuitInformation{State: circuitry.CircuitClosed}
+	corrupted := namedCiToAVMap("corrupted", closed)
+	corrupted["total"] = strAttrValueMember("1")
+	client.AddScanOutput(&ddb.ScanOutput{Items: []map[string]ddbtypes.AttributeValue{corrupted, namedCiToAVMap("a", closed)}})
+	client.AddScanOutput(&ddb.ScanOutput{Items: []map[string]ddbtypes.AttributeValue{namedCiToAVMap("a", closed), namedCiToAVMap("b", closed)}})
+	backend := ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information"}
+
+	for _, err := range backend.List(context.TODO(), "") {
+		if err == nil {
+			t.Fatal("expected the corrupted item to be yielded first")
+		}
+		break
+	}
+	if client.scanInputs[0].FilterExpression != nil {
+		t.Fatalf("expected no filter without a prefix; got %s", aws.ToString(client.scanInputs[0].FilterExpression))
+	}
+	count := 0
+	for range backend.List(context.TODO(), "") {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("expected iteration to stop after 1 entry; got %d", count)
+	}
+}
+
+func TestBackendDelete(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	backend := ddbbackend.Backend{Client: client, LockClient: newDDBLockerMock(), CircuitTableName: "circuit_information"}
+	lock, err := backend.Lock(ctx, "circuit-name-delete")
+	if err != nil {
+		t.Fatalf("expected to lock the circuit; got %v", err)
+	}
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2}
+	if err := backend.StoreFenced(ctx, "circuit-name-delete", open, lock.(circuitry.Lease).Token()); err != nil {
+		t.Fatalf("expected to store the circuit; got %v", err)
+	}
+	lock.Unlock()
+	for _, name := range []string{"circuit-name-delete", "missing"} {
+		if err := backend.Delete(ctx, name); err != nil {
+			t.Fatalf("expected to delete %s; got %v", name, err)
+		}
+	}
+	if client.Item("missing") != nil {
+		t.Fatalf("expected deleting a missing circuit to not create it; got %v", client.Item("missing"))
+	}
+	if ci, err := backend.Retrieve(ctx, "circuit-name-delete"); err != nil || ci != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected the deleted circuit to be empty; got %+v, %v", ci, err)
+	}
+	for entry, err := range backend.List(ctx, "") {
+		t.Fatalf("expected no circuits to be listed; got %+v, %v", entry, err)
+	}
+	lock, err = backend.Lock(ctx, "circuit-name-delete")
+	if err != nil {
+		t.Fatalf("expected to lock the circuit; got %v", err)
+	}
+	if token := lock.(circuitry.Lease).Token(); token != 2 {
+		t.Fatalf("expected the fencing token to keep increasing after the circuit was deleted; got %d", token)
+	}
+
+	mock := newDDBMock()
+	mock.AddUpdateItemError(errors.New("update error"))
+	backend.Client = mock
+	err = backend.Delete(ctx, "circuit-name-delete")
+	var remoteErr *ddbbackend.RemoteBackendError
+	if !errors.As(err, &remoteErr) || remoteErr.Operation != ddbbackend.OpUpdateItem {
+		t.Fatalf("expected an UpdateItem RemoteBackendError; got %v", err)
+	}
+	input := mock.updateItemInputs[0]
+	key, ok := input.Key[ddbbackend.KeyName].(*ddbtypes.AttributeValueMemberS)
+	if aws.ToString(input.TableName) != "circuit_information" || !ok || key.Value != "circuit-name-delete" {
+		t.Fatalf("expected to delete circuit-name-delete from circuit_information; got %+v", input)
+	}
+}
+
+func TestBackendListError(t *testing.T) {
+	client := newDDBMock()
+	client.AddScanError(errors.New("scan error"))
+	backend := ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information"}
+	for _, err := range backend.List(context.TODO(), "") {
+		var remoteErr *ddbbackend.RemoteBackendError
+		if !errors.As(err, &remoteErr) || remoteErr.Operation != ddbbackend.OpScan {
+			t.Fatalf("expected a Scan RemoteBackendError; got %v", err)
+		}
+	}
 }
@@ -1,6 +1,25 @@
 package dynamodb
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStreamNotConfigured is returned when watching for transitions without a
+// StreamsClient or StreamARN configured on the Backend
+var ErrStreamNotConfigured = errors.New("dynamodb backend has no stream configured to watch")
+
+// ErrTimeToLiveNotSupported is returned when creating a table with Time to
+// Live using a client that does not implement TimeToLiveClient
+var ErrTimeToLiveNotSupported = errors.New("dynamodb client cannot enable time to live")
+
+// ErrDescribeTableNotSupported is returned when ensuring a table exists using
+// a client that does not implement dynamodb.DescribeTableAPIClient
+var ErrDescribeTableNotSupported = errors.New("dynamodb client cannot describe tables")
+
+// ErrUnsupportedSchemaVersion is returned when reading an item written with
+// a newer SchemaVersion than this package supports
+var ErrUnsupportedSchemaVersion = errors.New("dynamodb item has an unsupported schema version")
 
 // OperationType is used to quickly identify the kind of operation the backend
 // is performing
@@ -17,6 +36,21 @@ const (
 	OpReleaseLock
 	// OpCreateTable represents the CreateTable Operation
 	OpCreateTable
+	// OpDescribeStream represents the DynamoDB Streams DescribeStream Operation
+	OpDescribeStream
+	// OpGetShardIterator represents the DynamoDB Streams GetShardIterator
+	// Operation
+	OpGetShardIterator
+	// OpGetRecords represents the DynamoDB Streams GetRecords Operation
+	OpGetRecords
+	// OpScan represents the Scan Operation
+	OpScan
+	// OpDeleteItem represents the DeleteItem Operation
+	OpDeleteItem
+	// OpDescribeTable represents the DescribeTable Operation
+	OpDescribeTable
+	// OpUpdateTimeToLive represents the UpdateTimeToLive Operation
+	OpUpdateTimeToLive
 )
 
 func (t OperationType) String() string {
@@ -31,6 +65,20 @@ func (t OperationType) String() string {
 		return "ReleaseLock"
 	case OpCreateTable:
 		return "CreateTable"
+	case OpDescribeStream:
+		return "DescribeStream"
+	case OpGetShardIterator:
+		return "GetShardIterator"
+	case OpGetRecords:
+		return "GetRecords"
+	case OpScan:
+		return "Scan"
+	case OpDeleteItem:
+		return "DeleteItem"
+	case OpDescribeTable:
+		return "DescribeTable"
+	case OpUpdateTimeToLive:
+		return "UpdateTimeToLive"
 	default:
 		return "unknown-operation"
 	}
@@ -41,6 +89,9 @@ type RemoteBackendError struct {
 	Err       error
 	TableName string
 	Operation OperationType
+	// Attempts is how many times the operation was attempted, including the
+	// retries of the client's retryer and the Backend's Throttling
+	Attempts int
 }
 
 func (e *RemoteBackendError) Unwrap() error {
@@ -48,6 +99,9 @@ func (e *RemoteBackendError) Unwrap() error {
 }
 
 func (e *RemoteBackendError) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("dynamodb backend could not perform %s on %q after %d attempts: %s", e.Operation, e.TableName, e.Attempts, e.Err)
+	}
 	return fmt.Sprintf("dynamodb backend could not perform %s on %q: %s", e.Operation, e.TableName, e.Err)
 }
 
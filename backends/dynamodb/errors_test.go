@@ -13,12 +13,19 @@ func TestOperationTypeString(t *testing.T) {
 		opType   dynamodb.OperationType
 		expected string
 	}{
-		"OpGetItem":     {dynamodb.OpGetItem, "GetItem"},
-		"OpUpdateItem":  {dynamodb.OpUpdateItem, "UpdateItem"},
-		"OpAcquireLock": {dynamodb.OpAcquireLock, "AcquireLock"},
-		"OpReleaseLock": {dynamodb.OpReleaseLock, "ReleaseLock"},
-		"OpCreateTable": {dynamodb.OpCreateTable, "CreateTable"},
-		"OpUnknown":     {dynamodb.OpCreateTable + dynamodb.OpAcquireLock, "unknown-operation"},
+		"OpGetItem":          {dynamodb.OpGetItem, "GetItem"},
+		"OpUpdateItem":       {dynamodb.OpUpdateItem, "UpdateItem"},
+		"OpAcquireLock":      {dynamodb.OpAcquireLock, "AcquireLock"},
+		"OpReleaseLock":      {dynamodb.OpReleaseLock, "ReleaseLock"},
+		"OpCreateTable":      {dynamodb.OpCreateTable, "CreateTable"},
+		"OpDescribeStream":   {dynamodb.OpDescribeStream, "DescribeStream"},
+		"OpGetShardIterator": {dynamodb.OpGetShardIterator, "GetShardIterator"},
+		"OpGetRecords":       {dynamodb.OpGetRecords, "GetRecords"},
+		"OpScan":             {dynamodb.OpScan, "Scan"},
+		"OpDeleteItem":       {dynamodb.OpDeleteItem, "DeleteItem"},
+		"OpDescribeTable":    {dynamodb.OpDescribeTable, "DescribeTable"},
+		"OpUpdateTimeToLive": {dynamodb.OpUpdateTimeToLive, "UpdateTimeToLive"},
+		"OpUnknown":          {dynamodb.OpUpdateTimeToLive + 1, "unknown-operation"},
 	}
 
 	for name, testCase := range testCases {
@@ -45,6 +52,11 @@ func TestRemoteBackendError(t *testing.T) {
 	if actual := err.Error(); actual != expected {
 		t.Fatalf("expected RemoteBackendError.Error() = %q; got %q", expected, actual)
 	}
+	err.Attempts = 3
+	expected = "dynamodb backend could not perform GetItem on \"fake-table-name\" after 3 attempts: test"
+	if actual := err.Error(); actual != expected {
+		t.Fatalf("expected RemoteBackendError.Error() = %q; got %q", expected, actual)
+	}
 }
 
 func TestLocalBackendError(t *testing.T) {
@@ -0,0 +1,358 @@
+package dynamodb_test
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+// fakeDynamo is an in-memory table keyed by KeyName, and SortKeyName when
+// items have one, that evaluates the subset of update and condition
+// expressions the backends use: SET, ADD, and REMOVE updates, and conditions
+// combining comparisons, attribute_exists, attribute_not_exists, and
+// begins_with with AND, OR, and NOT
+type fakeDynamo struct {
+	mu    sync.Mutex
+	items map[string]map[string]ddbtypes.AttributeValue
+	calls map[string]int
+	// beforeUpdate, if set, is called without the table locked before each
+	// UpdateItem so tests can write to the table in between a read and a
+	// write
+	beforeUpdate func(*fakeDynamo)
+}
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{items: map[string]map[string]ddbtypes.AttributeValue{}, calls: map[string]int{}}
+}
+
+func (f *fakeDynamo) Calls(operation string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[operation]
+}
+
+func (f *fakeDynamo) Item(name string) map[string]ddbtypes.AttributeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return copyItem(f.items[name])
+}
+
+func (f *fakeDynamo) SetItem(name string, item map[string]ddbtypes.AttributeValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item = copyItem(item)
+	item[ddbbackend.KeyName] = &ddbtypes.AttributeValueMemberS{Value: name}
+	f.items[keyOf(item)] = item
+}
+
+func copyItem(item map[string]ddbtypes.AttributeValue) map[string]ddbtypes.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	copied := make(map[string]ddbtypes.AttributeValue, len(item))
+	for name, value := range item {
+		copied[name] = value
+	}
+	return copied
+}
+
+// keyOf returns the name the item is kept under in the table: its KeyName,
+// followed by a slash and its SortKeyName if it has one
+func keyOf(key map[string]ddbtypes.AttributeValue) string {
+	name := key[ddbbackend.KeyName].(*ddbtypes.AttributeValueMemberS).Value
+	if sortKey, ok := key[ddbbackend.SortKeyName].(*ddbtypes.AttributeValueMemberS); ok {
+		return name + "/" + sortKey.Value
+	}
+	return name
+}
+
+func (f *fakeDynamo) GetItem(_ context.Context, params *ddb.GetItemInput, _ ...func(*ddb.Options)) (*ddb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["GetItem"]++
+	return &ddb.GetItemOutput{Item: copyItem(f.items[keyOf(params.Key)])}, nil
+}
+
+func (f *fakeDynamo) PutItem(_ context.Context, params *ddb.PutItemInput, _ ...func(*ddb.Options)) (*ddb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["PutItem"]++
+	f.items[keyOf(params.Item)] = copyItem(params.Item)
+	return &ddb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamo) UpdateItem(_ context.Context, params *ddb.UpdateItemInput, _ ...func(*ddb.Options)) (*ddb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	hook := f.beforeUpdate
+	f.beforeUpdate = nil
+	f.mu.Unlock()
+	if hook != nil {
+		hook(f)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateItem"]++
+	name := keyOf(params.Key)
+	old := f.items[name]
+	if condition := aws.ToString(params.ConditionExpression); condition != "" {
+		ok, err := evaluate(condition, old, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, &ddbtypes.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+		}
+	}
+	item := copyItem(old)
+	if item == nil {
+		item = copyItem(params.Key)
+	}
+	updated, err := applyUpdate(aws.ToString(params.UpdateExpression), item, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	f.items[name] = item
+	output := &ddb.UpdateItemOutput{}
+	switch params.ReturnValues {
+	case ddbtypes.ReturnValueAllNew:
+		output.Attributes = copyItem(item)
+	case ddbtypes.ReturnValueUpdatedNew:
+		output.Attributes = map[string]ddbtypes.AttributeValue{}
+		for _, attribute := range updated {
+			output.Attributes[attribute] = item[attribute]
+		}
+	}
+	return output, nil
+}
+
+func (f *fakeDynamo) DeleteItem(_ context.Context, params *ddb.DeleteItemInput, _ ...func(*ddb.Options)) (*ddb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["DeleteItem"]++
+	delete(f.items, keyOf(params.Key))
+	return &ddb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamo) CreateTable(_ context.Context, _ *ddb.CreateTableInput, _ ...func(*ddb.Options)) (*ddb.CreateTableOutput, error) {
+	return &ddb.CreateTableOutput{}, nil
+}
+
+func (f *fakeDynamo) Scan(_ context.Context, params *ddb.ScanInput, _ ...func(*ddb.Options)) (*ddb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["Scan"]++
+	output := &ddb.ScanOutput{}
+	for _, item := range f.items {
+		if filter := aws.ToString(params.FilterExpression); filter != "" {
+			ok, err := evaluate(filter, item, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		output.Items = append(output.Items, copyItem(item))
+	}
+	return output, nil
+}
+
+var _ ddbbackend.DynamoClient = (*fakeDynamo)(nil)
+
+// applyUpdate applies the update expression to the item and returns the
+// attributes it updated
+func applyUpdate(expression string, item map[string]ddbtypes.AttributeValue, names map[string]string, values map[string]ddbtypes.AttributeValue) ([]string, error) {
+	var updated []string
+	for _, clause := range strings.Split(strings.TrimSpace(expression), "\n") {
+		action, rest, _ := strings.Cut(strings.TrimSpace(clause), " ")
+		for _, operation := range strings.Split(rest, ",") {
+			fields := strings.Fields(strings.ReplaceAll(operation, "=", " "))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("fakeDynamo: empty %s in %q", action, expression)
+			}
+			attribute := names[fields[0]]
+			switch {
+			case action == "SET" && len(fields) == 2:
+				item[attribute] = values[fields[1]]
+			case action == "ADD" && len(fields) == 2:
+				current, _ := item[attribute].(*ddbtypes.AttributeValueMemberN)
+				sum := numberOf(values[fields[1]])
+				if current != nil {
+					sum.Add(sum, numberOf(current))
+				}
+				item[attribute] = &ddbtypes.AttributeValueMemberN{Value: sum.RatString()}
+			case action == "REMOVE" && len(fields) == 1:
+				delete(item, attribute)
+			default:
+				return nil, fmt.Errorf("fakeDynamo: unsupported update %q", clause)
+			}
+			updated = append(updated, attribute)
+		}
+	}
+	return updated, nil
+}
+
+func numberOf(value ddbtypes.AttributeValue) *big.Rat {
+	n, _ := value.(*ddbtypes.AttributeValueMemberN)
+	if n == nil {
+		return nil
+	}
+	r, _ := new(big.Rat).SetString(n.Value)
+	return r
+}
+
+// compare returns the sign of a - b and whether they are comparable
+func compare(a, b ddbtypes.AttributeValue) (int, bool) {
+	if x, y := numberOf(a), numberOf(b); x != nil && y != nil {
+		return x.Cmp(y), true
+	}
+	x, xok := a.(*ddbtypes.AttributeValueMemberS)
+	y, yok := b.(*ddbtypes.AttributeValueMemberS)
+	if xok && yok {
+		return strings.Compare(x.Value, y.Value), true
+	}
+	return 0, false
+}
+
+// conditionParser evaluates a condition expression by recursive descent
+type conditionParser struct {
+	tokens []string
+	item   map[string]ddbtypes.AttributeValue
+	names  map[string]string
+	values map[string]ddbtypes.AttributeValue
+	err    error
+}
+
+func evaluate(expression string, item map[string]ddbtypes.AttributeValue, names map[string]string, values map[string]ddbtypes.AttributeValue) (bool, error) {
+	replacer := strings.NewReplacer("(", " ( ", ")", " ) ", ",", " , ", "<>", " <> ", "<=", " <= ", ">=", " >= ")
+	p := &conditionParser{tokens: strings.Fields(replacer.Replace(expression)), item: item, names: names, values: values}
+	result := p.or()
+	if p.err == nil && len(p.tokens) > 0 {
+		p.err = fmt.Errorf("fakeDynamo: unexpected %q in %q", p.tokens[0], expression)
+	}
+	return result, p.err
+}
+
+func (p *conditionParser) next() string {
+	if len(p.tokens) == 0 {
+		if p.err == nil {
+			p.err = fmt.Errorf("fakeDynamo: unexpected end of condition")
+		}
+		return ""
+	}
+	token := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	return token
+}
+
+func (p *conditionParser) peek() string {
+	if len(p.tokens) == 0 {
+		return ""
+	}
+	return p.tokens[0]
+}
+
+func (p *conditionParser) expect(token string) {
+	if actual := p.next(); actual != token && p.err == nil {
+		p.err = fmt.Errorf("fakeDynamo: expected %q; got %q", token, actual)
+	}
+}
+
+func (p *conditionParser) or() bool {
+	result := p.and()
+	for p.peek() == "OR" {
+		p.next()
+		right := p.and()
+		result = result || right
+	}
+	return result
+}
+
+func (p *conditionParser) and() bool {
+	result := p.not()
+	for p.peek() == "AND" {
+		p.next()
+		right := p.not()
+		result = result && right
+	}
+	return result
+}
+
+func (p *conditionParser) not() bool {
+	if p.peek() == "NOT" {
+		p.next()
+		return !p.not()
+	}
+	return p.primary()
+}
+
+func (p *conditionParser) operand() ddbtypes.AttributeValue {
+	token := p.next()
+	switch {
+	case strings.HasPrefix(token, "#"):
+		return p.item[p.names[token]]
+	case strings.HasPrefix(token, ":"):
+		return p.values[token]
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("fakeDynamo: unexpected operand %q", token)
+	}
+	return nil
+}
+
+func (p *conditionParser) primary() bool {
+	switch token := p.peek(); token {
+	case "(":
+		p.next()
+		result := p.or()
+		p.expect(")")
+		return result
+	case "attribute_exists", "attribute_not_exists":
+		p.next()
+		p.expect("(")
+		value := p.operand()
+		p.expect(")")
+		return (value != nil) == (token == "attribute_exists")
+	case "begins_with":
+		p.next()
+		p.expect("(")
+		value, _ := p.operand().(*ddbtypes.AttributeValueMemberS)
+		p.expect(",")
+		prefix, _ := p.operand().(*ddbtypes.AttributeValueMemberS)
+		p.expect(")")
+		return value != nil && prefix != nil && strings.HasPrefix(value.Value, prefix.Value)
+	}
+	left := p.operand()
+	comparator := p.next()
+	right := p.operand()
+	sign, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+	switch comparator {
+	case "=":
+		return sign == 0
+	case "<>":
+		return sign != 0
+	case "<":
+		return sign < 0
+	case "<=":
+		return sign <= 0
+	case ">":
+		return sign > 0
+	case ">=":
+		return sign >= 0
+	}
+	if p.err == nil {
+		p.err = fmt.Errorf("fakeDynamo: unsupported comparator %q", comparator)
+	}
+	return false
+}
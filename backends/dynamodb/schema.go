@@ -0,0 +1,148 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbexp "github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// SchemaVersionName is the attribute of the circuit information table holding
+// the version of the layout an item was written with
+const SchemaVersionName = "schema_version"
+
+// SchemaVersion is the version of the layout the backends write. Items
+// without a SchemaVersionName attribute are version 1, which Backend.Store
+// wrote with a state of 0 (closed) whatever the circuit's state was. Version
+// 2 items hold every attribute of the circuit. Use Migrate to upgrade older
+// items in place.
+const SchemaVersion = 2
+
+// Attributes of the circuit information table
+const (
+	stateName                = "state"
+	generationName           = "generation"
+	consecutiveFailuresName  = "consecutive_failures"
+	consecutiveSuccessesName = "consecutive_successes"
+	totalName                = "total"
+	totalFailuresName        = "total_failures"
+	totalSuccessesName       = "total_successes"
+	expiresAfterName         = "expires_after"
+)
+
+// circuitAttributeNames are the attributes holding a circuit's information
+var circuitAttributeNames = []string{stateName, generationName, consecutiveFailuresName, consecutiveSuccessesName, totalName, totalFailuresName, totalSuccessesName, expiresAfterName}
+
+// zeroValue returns the value of the attribute of a circuit that has never
+// been stored
+func zeroValue(name string) any {
+	if name == expiresAfterName {
+		return time.Time{}
+	}
+	return uint64(0)
+}
+
+// checkSchema returns a LocalBackendError when the item was written with a
+// newer layout than this package reads
+func (r circuitInfoRecord) checkSchema(name string) error {
+	if r.SchemaVersion > SchemaVersion {
+		return &LocalBackendError{
+			Err:     ErrUnsupportedSchemaVersion,
+			Message: fmt.Sprintf("%q was stored with schema version %d but at most %d is supported", name, r.SchemaVersion, SchemaVersion),
+		}
+	}
+	return nil
+}
+
+// Migrate upgrades the items in the CircuitTableName table written with an
+// older SchemaVersion in place and returns how many it upgraded. Each item's
+// missing attributes are set to their zero values and its SchemaVersionName
+// attribute to SchemaVersion on the condition that it was not written in
+// the meantime; items written concurrently are skipped, so Migrate can run
+// while circuits are in use and be run again until it upgrades none. The
+// state of version 1 items cannot be recovered and stays as stored until the
+// circuit next changes state.
+func (b *Backend) Migrate(ctx context.Context) (int, error) {
+	schemaVersion := ddbexp.Name(SchemaVersionName)
+	filter := ddbexp.AttributeNotExists(schemaVersion).Or(ddbexp.LessThan(schemaVersion, ddbexp.Value(SchemaVersion)))
+	if b.SingleTable {
+		filter = filter.And(ddbexp.Equal(ddbexp.Name(SortKeyName), ddbexp.Value(StateItemType)))
+	}
+	expr, err := ddbexp.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return 0, err
+	}
+	paginator := ddb.NewScanPaginator(b.client(), &ddb.ScanInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	migrated := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return migrated, remoteError(err, OpScan, b.CircuitTableName)
+		}
+		for _, item := range page.Items {
+			ok, err := b.migrateItem(ctx, item)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+	}
+	return migrated, nil
+}
+
+// migrateItem upgrades a scanned item, reporting false if it was written
+// since it was scanned
+func (b *Backend) migrateItem(ctx context.Context, item map[string]ddbtypes.AttributeValue) (bool, error) {
+	schemaVersion := ddbexp.Name(SchemaVersionName)
+	update := ddbexp.Set(schemaVersion, ddbexp.Value(SchemaVersion))
+	condition := ddbexp.AttributeNotExists(schemaVersion)
+	if value, ok := item[SchemaVersionName]; ok {
+		var version uint64
+		if err := attributevalue.Unmarshal(value, &version); err != nil {
+			return false, &LocalBackendError{Err: err, Message: "cannot unmarshal schema version of scanned circuit information"}
+		}
+		condition = ddbexp.Equal(schemaVersion, ddbexp.Value(version))
+	}
+	for _, name := range circuitAttributeNames {
+		if _, ok := item[name]; !ok {
+			update = update.Set(ddbexp.Name(name), ddbexp.Value(zeroValue(name)))
+			condition = condition.And(ddbexp.AttributeNotExists(ddbexp.Name(name)))
+		}
+	}
+	expr, err := ddbexp.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return false, err
+	}
+	key := map[string]ddbtypes.AttributeValue{KeyName: item[KeyName]}
+	if b.SingleTable {
+		key[SortKeyName] = item[SortKeyName]
+	}
+	_, err = b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              ddbtypes.ReturnValueNone,
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	return true, nil
+}
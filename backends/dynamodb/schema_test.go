@@ -0,0 +1,265 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+// conformingCi returns CircuitInformation with every field set so that a
+// field the backends do not store fails the round trip
+func conformingCi(t *testing.T, state circuitry.CircuitState) circuitry.CircuitInformation {
+	t.Helper()
+	ci := circuitry.CircuitInformation{
+		State:                state,
+		Generation:           7,
+		ConsecutiveFailures:  3,
+		ConsecutiveSuccesses: 2,
+		Total:                40,
+		TotalFailures:        25,
+		TotalSuccesses:       15,
+		ExpiresAfter:         time.Date(2024, time.December, 19, 8, 30, 15, 123456789, time.UTC),
+	}
+	fields := reflect.ValueOf(ci)
+	for i := 0; i < fields.NumField(); i++ {
+		if fields.Field(i).IsZero() && fields.Type().Field(i).Name != "State" {
+			t.Fatalf("expected conformingCi to set %s", fields.Type().Field(i).Name)
+		}
+	}
+	return ci
+}
+
+// expectSameCi compares every field of the CircuitInformation, including
+// fields added after this test was written
+func expectSameCi(t *testing.T, expected, actual circuitry.CircuitInformation) {
+	t.Helper()
+	if expected.ExpiresAfter.Equal(actual.ExpiresAfter) {
+		actual.ExpiresAfter = expected.ExpiresAfter
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %+v; got\n        %+v", expected, actual)
+	}
+}
+
+func TestBackendsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, state := range []circuitry.CircuitState{circuitry.CircuitClosed, circuitry.CircuitOpen, circuitry.CircuitHalfOpen} {
+		t.Run(state.String(), func(t *testing.T) {
+			expected := conformingCi(t, state)
+			client := newFakeDynamo()
+			configured := ddbbackend.Backend{Client: client, LockClient: newDDBLockerMock(), CircuitTableName: "circuit_information_round_trip"}
+			for name, backend := range map[string]interface {
+				circuitry.StorageBackender
+				circuitry.FencedStorer
+				circuitry.Lister
+			}{
+				"backend":           &configured,
+				"versioned backend": &ddbbackend.VersionedBackend{Backend: configured},
+			} {
+				if err := backend.Store(ctx, name, expected); err != nil {
+					t.Fatalf("expected %s to store; got %v", name, err)
+				}
+				actual, err := backend.Retrieve(ctx, name)
+				if err != nil {
+					t.Fatalf("expected %s to retrieve; got %v", name, err)
+				}
+				expectSameCi(t, expected, actual)
+				for entry, err := range backend.List(ctx, name) {
+					if err != nil {
+						t.Fatalf("expected %s to list; got %v", name, err)
+					}
+					expectSameCi(t, expected, entry.Information)
+				}
+				if version := client.Item(name)[ddbbackend.SchemaVersionName]; !reflect.DeepEqual(version, intAttrValueMember(ddbbackend.SchemaVersion)) {
+					t.Fatalf("expected %s to write schema version %d; got %v", name, ddbbackend.SchemaVersion, version)
+				}
+
+				fenced := name + "-fenced"
+				lock, err := backend.Lock(ctx, fenced)
+				if err != nil {
+					t.Fatalf("expected %s to lock; got %v", name, err)
+				}
+				if err := backend.StoreFenced(ctx, fenced, expected, lock.(circuitry.Lease).Token()); err != nil {
+					t.Fatalf("expected %s to store fenced; got %v", name, err)
+				}
+				actual, err = backend.Retrieve(ctx, fenced)
+				if err != nil {
+					t.Fatalf("expected %s to retrieve; got %v", name, err)
+				}
+				expectSameCi(t, expected, actual)
+			}
+		})
+	}
+}
+
+func TestBackendsUnsupportedSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	item := ciToAVMap(circuitry.CircuitInformation{})
+	item[ddbbackend.SchemaVersionName] = intAttrValueMember(ddbbackend.SchemaVersion + 1)
+	client.SetItem("newer", item)
+	backend := newVersionedBackend(client)
+
+	_, err := backend.Retrieve(ctx, "newer")
+	expectUnsupportedSchemaVersion(t, err)
+	for _, err := range backend.List(ctx, "") {
+		expectUnsupportedSchemaVersion(t, err)
+	}
+	_, _, err = backend.Admit(ctx, "newer", versionedPolicy, time.Now())
+	expectUnsupportedSchemaVersion(t, err)
+}
+
+func expectUnsupportedSchemaVersion(t *testing.T, err error) {
+	t.Helper()
+	var local *ddbbackend.LocalBackendError
+	if !errors.As(err, &local) || !errors.Is(err, ddbbackend.ErrUnsupportedSchemaVersion) {
+		t.Fatalf("expected a LocalBackendError wrapping %v; got %T(%v)", ddbbackend.ErrUnsupportedSchemaVersion, err, err)
+	}
+}
+
+func TestBackendMigrate(t *testing.T) {
+	ctx := context.Background()
+	expiresAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 3, ConsecutiveFailures: 2, Total: 9, TotalFailures: 4, TotalSuccesses: 5, ExpiresAfter: expiresAfter}
+	legacy := ciToAVMap(open)
+	delete(legacy, ddbbackend.SchemaVersionName)
+	newer := ciToAVMap(open)
+	newer[ddbbackend.SchemaVersionName] = intAttrValueMember(ddbbackend.SchemaVersion + 1)
+
+	client := newFakeDynamo()
+	client.SetItem("legacy", legacy)
+	client.SetItem("fence-only", map[string]ddbtypes.AttributeValue{ddbbackend.FenceName: intAttrValueMember(1)})
+	client.SetItem("current", ciToAVMap(open))
+	client.SetItem("newer", newer)
+	backend := newVersionedBackend(client)
+
+	migrated, err := backend.Migrate(ctx)
+	if err != nil || migrated != 2 {
+		t.Fatalf("expected to migrate 2 items; got %d, %v", migrated, err)
+	}
+	if updates := client.Calls("UpdateItem"); updates != 2 {
+		t.Fatalf("expected 2 writes; got %d", updates)
+	}
+	for name, expected := range map[string]circuitry.CircuitInformation{"legacy": open, "fence-only": {}, "current": open} {
+		item := client.Item(name)
+		if version := item[ddbbackend.SchemaVersionName]; !reflect.DeepEqual(version, intAttrValueMember(ddbbackend.SchemaVersion)) {
+			t.Fatalf("expected %s to have schema version %d; got %v", name, ddbbackend.SchemaVersion, version)
+		}
+		for _, attribute := range []string{"state", "generation", "consecutive_failures", "consecutive_successes", "total", "total_failures", "total_successes", "expires_after"} {
+			if _, ok := item[attribute]; !ok {
+				t.Fatalf("expected %s to have %s; got %v", name, attribute, item)
+			}
+		}
+		actual, err := backend.Retrieve(ctx, name)
+		if err != nil {
+			t.Fatalf("expected to retrieve %s; got %v", name, err)
+		}
+		deepEqCi(t, expected, actual)
+	}
+	if fence := client.Item("fence-only")[ddbbackend.FenceName]; !reflect.DeepEqual(fence, intAttrValueMember(1)) {
+		t.Fatalf("expected the fencing token to be kept; got %v", fence)
+	}
+
+	// migrated items are complete so counts are incremented without
+	// rewriting them
+	if _, _, err := backend.Admit(ctx, "fence-only", versionedPolicy, time.Now()); err != nil {
+		t.Fatalf("expected to admit; got %v", err)
+	}
+	if updates := client.Calls("UpdateItem"); updates != 3 {
+		t.Fatalf("expected 3 writes; got %d", updates)
+	}
+
+	migrated, err = backend.Migrate(ctx)
+	if err != nil || migrated != 0 {
+		t.Fatalf("expected to migrate no items; got %d, %v", migrated, err)
+	}
+}
+
+func TestBackendMigrateSkipsConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	legacy := ciToAVMap(circuitry.CircuitInformation{Total: 1})
+	delete(legacy, ddbbackend.SchemaVersionName)
+	delete(legacy, "total")
+	client.SetItem("legacy", legacy)
+	backend := &ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_migrate"}
+	stored := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Total: 5}
+	client.beforeUpdate = func(*fakeDynamo) {
+		if err := backend.Store(ctx, "legacy", stored); err != nil {
+			t.Errorf("expected to store; got %v", err)
+		}
+	}
+
+	migrated, err := backend.Migrate(ctx)
+	if err != nil || migrated != 0 {
+		t.Fatalf("expected to migrate no items; got %d, %v", migrated, err)
+	}
+	actual, err := backend.Retrieve(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("expected to retrieve; got %v", err)
+	}
+	deepEqCi(t, stored, actual)
+}
+
+func TestBackendMigrateErrors(t *testing.T) {
+	backendErr := errors.New("test")
+	legacy := map[string]ddbtypes.AttributeValue{ddbbackend.KeyName: strAttrValueMember("legacy")}
+	testCases := map[string]struct {
+		setup     func(*ddbMock)
+		operation ddbbackend.OperationType
+		local     bool
+	}{
+		"scan": {
+			setup:     func(m *ddbMock) { m.AddScanError(backendErr) },
+			operation: ddbbackend.OpScan,
+		},
+		"update": {
+			setup: func(m *ddbMock) {
+				m.AddScanOutput(&ddb.ScanOutput{Items: []map[string]ddbtypes.AttributeValue{legacy}})
+				m.AddUpdateItemError(backendErr)
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"schema version": {
+			setup: func(m *ddbMock) {
+				m.AddScanOutput(&ddb.ScanOutput{Items: []map[string]ddbtypes.AttributeValue{{
+					ddbbackend.KeyName:           strAttrValueMember("legacy"),
+					ddbbackend.SchemaVersionName: strAttrValueMember("one"),
+				}}})
+			},
+			local: true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newDDBMock()
+			tc.setup(client)
+			backend := &ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_migrate"}
+			migrated, err := backend.Migrate(context.TODO())
+			if migrated != 0 {
+				t.Fatalf("expected to migrate no items; got %d", migrated)
+			}
+			if tc.local {
+				var local *ddbbackend.LocalBackendError
+				if !errors.As(err, &local) {
+					t.Fatalf("expected a LocalBackendError; got %T(%v)", err, err)
+				}
+				return
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if !errors.As(err, &remote) || remote.Operation != tc.operation || !errors.Is(err, backendErr) {
+				t.Fatalf("expected a RemoteBackendError for %s wrapping %v; got %T(%v)", tc.operation, backendErr, err, err)
+			}
+		})
+	}
+}
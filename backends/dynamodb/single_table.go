@@ -0,0 +1,143 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ddblock "cirello.io/dynamolock/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// SortKeyName is the range key of tables created with
+// CreateTableWithSingleTableLayout, telling apart the item holding a
+// circuit's information from the item holding its lock
+const SortKeyName = "item_type"
+
+// Values of the SortKeyName attribute
+const (
+	// StateItemType is the sort key of items holding a circuit's information
+	StateItemType = "state"
+	// LockItemType is the sort key of items holding a circuit's lock
+	LockItemType = "lock"
+)
+
+// itemKey returns the key of the item holding the circuit's information
+func (b *Backend) itemKey(name string) (map[string]ddbtypes.AttributeValue, error) {
+	value, err := attributevalue.Marshal(name)
+	if err != nil {
+		return nil, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot marshal %q with AWS SDK for dynamodb hash key", name)}
+	}
+	key := map[string]ddbtypes.AttributeValue{KeyName: value}
+	if b.SingleTable {
+		key[SortKeyName] = &ddbtypes.AttributeValueMemberS{Value: StateItemType}
+	}
+	return key, nil
+}
+
+// NewSingleTable returns a Backend storing circuits and their locks in one
+// table created with CreateTableWithSingleTableLayout, such as by
+// Backend.EnsureTables. Locks are items with the LockItemType sort key held
+// by a dynamolock client created with the lockOpts.
+func NewSingleTable(client DynamoClient, tableName string, lockOpts ...ddblock.ClientOption) (*Backend, error) {
+	opts := append([]ddblock.ClientOption{ddblock.WithPartitionKeyName(KeyName), ddblock.WithSortKey(SortKeyName, LockItemType)}, lockOpts...)
+	locker, err := ddblock.New(client, tableName, opts...)
+	if err != nil {
+		return nil, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot create lock client for %q", tableName)}
+	}
+	return &Backend{
+		Client:           client,
+		LockClient:       locker,
+		CircuitTableName: tableName,
+		LockTableName:    tableName,
+		SingleTable:      true,
+	}, nil
+}
+
+// WithSingleTableDynamoBackend can be used to configure a
+// circuitry.FactorySettings object to use a Backend created with
+// NewSingleTable
+func WithSingleTableDynamoBackend(client DynamoClient, tableName string, lockOpts ...ddblock.ClientOption) circuitry.SettingsOption {
+	return func(s *circuitry.FactorySettings) error {
+		if s.StorageBackend != nil {
+			return circuitry.ErrStorageBackendAlreadySet
+		}
+		backend, err := NewSingleTable(client, tableName, lockOpts...)
+		if err != nil {
+			return fmt.Errorf("%w: %w", circuitry.ErrProvisioningStorageBackend, err)
+		}
+		s.StorageBackend = backend
+		return nil
+	}
+}
+
+// EnsureTable creates the table to store Circuit Breaker Information with the
+// opts, as CreateCircuitInformationTable does, unless it already exists, and
+// waits until it is active. The client must implement
+// dynamodb.DescribeTableAPIClient. The opts are not applied to a table that
+// already exists.
+func EnsureTable(ctx context.Context, client DynamoClient, tableName string, opts ...CreateCircuitInformationTableOption) error {
+	return ensureTable(ctx, client, tableName, func(ctx context.Context) error {
+		_, err := CreateCircuitInformationTable(ctx, client, tableName, opts...)
+		return err
+	})
+}
+
+// EnsureTables ensures the CircuitTableName table exists, as EnsureTable
+// does, with the single-table layout when SingleTable is set. When the
+// LockTableName is another table and the LockClient is set, it also creates
+// the lock table with the LockClient's defaults unless it exists. It waits
+// until both tables are active.
+func (b *Backend) EnsureTables(ctx context.Context, opts ...CreateCircuitInformationTableOption) error {
+	if b.SingleTable {
+		opts = append(opts[:len(opts):len(opts)], CreateTableWithSingleTableLayout())
+	}
+	if err := EnsureTable(ctx, b.Client, b.CircuitTableName, opts...); err != nil {
+		return err
+	}
+	if b.LockClient == nil || b.LockTableName == "" || b.LockTableName == b.CircuitTableName {
+		return nil
+	}
+	return ensureTable(ctx, b.Client, b.LockTableName, func(ctx context.Context) error {
+		if _, err := b.LockClient.CreateTableWithContext(ctx, b.LockTableName); err != nil {
+			return remoteError(err, OpCreateTable, b.LockTableName)
+		}
+		return nil
+	})
+}
+
+// ensureTable creates the table unless it exists, tolerating another process
+// creating it at the same time, and waits until it is active
+func ensureTable(ctx context.Context, client DynamoClient, tableName string, create func(context.Context) error) error {
+	describer, ok := client.(ddb.DescribeTableAPIClient)
+	if !ok {
+		return &LocalBackendError{Err: ErrDescribeTableNotSupported, Message: fmt.Sprintf("cannot ensure %q exists", tableName)}
+	}
+	_, err := describer.DescribeTable(ctx, &ddb.DescribeTableInput{TableName: aws.String(tableName)})
+	var notFound *ddbtypes.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		var inUse *ddbtypes.ResourceInUseException
+		if err := create(ctx); err != nil && !errors.As(err, &inUse) {
+			return err
+		}
+	case err != nil:
+		return remoteError(err, OpDescribeTable, tableName)
+	}
+	return waitForTable(ctx, describer, tableName)
+}
+
+// waitForTable waits up to DefaultTableWaitTimeout for the table to become
+// active
+func waitForTable(ctx context.Context, client ddb.DescribeTableAPIClient, tableName string) error {
+	describe := &ddb.DescribeTableInput{TableName: aws.String(tableName)}
+	if err := ddb.NewTableExistsWaiter(client).Wait(ctx, describe, DefaultTableWaitTimeout); err != nil {
+		return remoteError(err, OpDescribeTable, tableName)
+	}
+	return nil
+}
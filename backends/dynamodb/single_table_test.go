@@ -0,0 +1,325 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	ddblock "cirello.io/dynamolock/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+func TestWithSingleTableDynamoBackend(t *testing.T) {
+	client := newDDBMock()
+	opt := ddbbackend.WithSingleTableDynamoBackend(client, "circuitry")
+	s, err := circuitry.NewFactorySettings(opt)
+	if err != nil {
+		t.Fatalf("expected successful settings creation; got %v", err)
+	}
+	backend, ok := s.StorageBackend.(*ddbbackend.Backend)
+	if !ok {
+		t.Fatal("expected configured StorageBackend to be dynamodb backend; but it wasn't")
+	}
+	if !backend.SingleTable || backend.CircuitTableName != "circuitry" || backend.LockTableName != "circuitry" || backend.LockClient == nil {
+		t.Fatalf("expected a single-table backend using circuitry; got %+v", backend)
+	}
+
+	_, err = circuitry.NewFactorySettings(opt, opt)
+	if !errors.Is(err, circuitry.ErrStorageBackendAlreadySet) {
+		t.Fatalf("expected err = circuitry.ErrStorageBackendAlreadySet; got %v", err)
+	}
+
+	opt = ddbbackend.WithSingleTableDynamoBackend(client, "circuitry", ddblock.WithHeartbeatPeriod(5*time.Second), ddblock.WithLeaseDuration(5*time.Second))
+	_, err = circuitry.NewFactorySettings(opt)
+	var local *ddbbackend.LocalBackendError
+	if !errors.Is(err, circuitry.ErrProvisioningStorageBackend) || !errors.As(err, &local) {
+		t.Fatalf("expected an ErrProvisioningStorageBackend wrapping a LocalBackendError; got %v", err)
+	}
+}
+
+func stateItem(name string, ci circuitry.CircuitInformation) map[string]ddbtypes.AttributeValue {
+	item := namedCiToAVMap(name, ci)
+	item[ddbbackend.SortKeyName] = strAttrValueMember(ddbbackend.StateItemType)
+	return item
+}
+
+func TestSingleTableBackend(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	client.SetItem("tenant-a", map[string]ddbtypes.AttributeValue{
+		ddbbackend.SortKeyName: strAttrValueMember(ddbbackend.LockItemType),
+		"ownerName":            strAttrValueMember("another process"),
+	})
+	legacy := stateItem("tenant-c", circuitry.CircuitInformation{})
+	delete(legacy, ddbbackend.SchemaVersionName)
+	client.SetItem("tenant-c", legacy)
+	backend := &ddbbackend.Backend{Client: client, LockClient: newDDBLockerMock(), CircuitTableName: "circuitry", LockTableName: "circuitry", SingleTable: true}
+
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, Total: 2}
+	for _, name := range []string{"tenant-a", "tenant-b"} {
+		if err := backend.Store(ctx, name, open); err != nil {
+			t.Fatalf("expected to store %s; got %v", name, err)
+		}
+		if itemType := client.Item(name + "/" + ddbbackend.StateItemType)[ddbbackend.SortKeyName]; itemType == nil {
+			t.Fatalf("expected %s to be stored under the %s sort key", name, ddbbackend.StateItemType)
+		}
+	}
+	actual, err := backend.Retrieve(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("expected to retrieve; got %v", err)
+	}
+	deepEqCi(t, open, actual)
+
+	var names []string
+	for entry, err := range backend.List(ctx, "tenant-") {
+		if err != nil {
+			t.Fatalf("expected to list; got %v", err)
+		}
+		names = append(names, entry.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "tenant-a" || names[1] != "tenant-b" || names[2] != "tenant-c" {
+		t.Fatalf("expected only the circuits to be listed; got %v", names)
+	}
+
+	lock, err := backend.Lock(ctx, "tenant-a")
+	if err != nil || lock.(circuitry.Lease).Token() != 1 {
+		t.Fatalf("expected a fencing token of 1 from the circuit's item; got %v, %v", lock, err)
+	}
+	if fence := client.Item("tenant-a/" + ddbbackend.StateItemType)[ddbbackend.FenceName]; fence == nil {
+		t.Fatal("expected the fencing token to be kept in the circuit's item")
+	}
+
+	migrated, err := backend.Migrate(ctx)
+	if err != nil || migrated != 1 {
+		t.Fatalf("expected to migrate tenant-c; got %d, %v", migrated, err)
+	}
+
+	versioned := &ddbbackend.VersionedBackend{Backend: *backend}
+	if _, _, err := versioned.Admit(ctx, "tenant-d", versionedPolicy, time.Now()); err != nil {
+		t.Fatalf("expected to admit; got %v", err)
+	}
+	if total := client.Item("tenant-d/" + ddbbackend.StateItemType)["total"]; total == nil {
+		t.Fatal("expected the versioned backend to count under the state sort key")
+	}
+
+	if err := backend.Delete(ctx, "tenant-a"); err != nil {
+		t.Fatalf("expected to delete; got %v", err)
+	}
+	if item := client.Item("tenant-a/" + ddbbackend.StateItemType); item["state"] != nil {
+		t.Fatalf("expected the circuit's attributes to be removed; got %v", item)
+	}
+	if lock := client.Item("tenant-a/" + ddbbackend.LockItemType); lock["ownerName"] == nil {
+		t.Fatalf("expected the lock item to be left to the lock client; got %v", lock)
+	}
+}
+
+// tableMock is a DynamoClient whose tables are active as soon as they are
+// created
+type tableMock struct {
+	*ddbMock
+	tables      map[string]bool
+	created     []*ddb.CreateTableInput
+	describeErr error
+	createErr   error
+	// concurrent creates the table in another process when CreateTable is
+	// called
+	concurrent bool
+}
+
+func newTableMock(tables ...string) *tableMock {
+	m := &tableMock{ddbMock: newDDBMock(), tables: map[string]bool{}}
+	for _, table := range tables {
+		m.tables[table] = true
+	}
+	return m
+}
+
+func (m *tableMock) DescribeTable(_ context.Context, params *ddb.DescribeTableInput, _ ...func(*ddb.Options)) (*ddb.DescribeTableOutput, error) {
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+	if !m.tables[aws.ToString(params.TableName)] {
+		return nil, &ddbtypes.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+	return &ddb.DescribeTableOutput{Table: &ddbtypes.TableDescription{TableName: params.TableName, TableStatus: ddbtypes.TableStatusActive}}, nil
+}
+
+func (m *tableMock) CreateTable(_ context.Context, params *ddb.CreateTableInput, _ ...func(*ddb.Options)) (*ddb.CreateTableOutput, error) {
+	if m.concurrent {
+		m.tables[aws.ToString(params.TableName)] = true
+		return nil, &ddbtypes.ResourceInUseException{Message: aws.String("Table already exists")}
+	}
+	if m.createErr != nil {
+		return nil, m.createErr
+	}
+	m.created = append(m.created, params)
+	m.tables[aws.ToString(params.TableName)] = true
+	return &ddb.CreateTableOutput{}, nil
+}
+
+var _ ddb.DescribeTableAPIClient = (*tableMock)(nil)
+
+// tableLockerMock creates lock tables in a tableMock
+type tableLockerMock struct {
+	*ddbLockerMock
+	client  *tableMock
+	created []string
+	err     error
+}
+
+func (l *tableLockerMock) CreateTableWithContext(_ context.Context, tableName string, _ ...ddblock.CreateTableOption) (*ddb.CreateTableOutput, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	l.created = append(l.created, tableName)
+	l.client.tables[tableName] = true
+	return &ddb.CreateTableOutput{}, nil
+}
+
+func TestEnsureTable(t *testing.T) {
+	backendErr := errors.New("test")
+	testCases := map[string]struct {
+		client      ddbbackend.DynamoClient
+		created     int
+		expectedErr error
+		operation   ddbbackend.OperationType
+	}{
+		"existing table": {
+			client: newTableMock("circuitry"),
+		},
+		"missing table": {
+			client:  newTableMock(),
+			created: 1,
+		},
+		"created concurrently": {
+			client: &tableMock{ddbMock: newDDBMock(), tables: map[string]bool{}, concurrent: true},
+		},
+		"describe error": {
+			client:      &tableMock{ddbMock: newDDBMock(), describeErr: backendErr},
+			expectedErr: backendErr,
+			operation:   ddbbackend.OpDescribeTable,
+		},
+		"create error": {
+			client:      &tableMock{ddbMock: newDDBMock(), tables: map[string]bool{}, createErr: backendErr},
+			expectedErr: backendErr,
+			operation:   ddbbackend.OpCreateTable,
+		},
+		"client without describe": {
+			client:      newDDBMock(),
+			expectedErr: ddbbackend.ErrDescribeTableNotSupported,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			err := ddbbackend.EnsureTable(context.TODO(), tc.client, "circuitry", ddbbackend.CreateTableWithStream())
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected err = %v; got %v", tc.expectedErr, err)
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if errors.Is(err, backendErr) && (!errors.As(err, &remote) || remote.Operation != tc.operation) {
+				t.Fatalf("expected a RemoteBackendError for %s; got %T(%v)", tc.operation, err, err)
+			}
+			client, ok := tc.client.(*tableMock)
+			if !ok {
+				return
+			}
+			if len(client.created) != tc.created {
+				t.Fatalf("expected %d tables to be created; got %d", tc.created, len(client.created))
+			}
+			if tc.created > 0 && client.created[0].StreamSpecification == nil {
+				t.Fatalf("expected the table to be created with the options; got %+v", client.created[0])
+			}
+		})
+	}
+}
+
+func TestBackendEnsureTables(t *testing.T) {
+	backendErr := errors.New("test")
+	testCases := map[string]struct {
+		existing      []string
+		lockTableName string
+		singleTable   bool
+		noLockClient  bool
+		describeErr   error
+		lockErr       error
+		created       []string
+		locksCreated  []string
+		rangeKey      bool
+	}{
+		"two tables": {
+			lockTableName: "locks",
+			created:       []string{"circuitry"},
+			locksCreated:  []string{"locks"},
+		},
+		"existing tables": {
+			existing:      []string{"circuitry", "locks"},
+			lockTableName: "locks",
+		},
+		"single table": {
+			lockTableName: "circuitry",
+			singleTable:   true,
+			created:       []string{"circuitry"},
+			rangeKey:      true,
+		},
+		"without a lock client": {
+			noLockClient: true,
+			created:      []string{"circuitry"},
+		},
+		"circuit table error": {
+			lockTableName: "locks",
+			describeErr:   backendErr,
+		},
+		"lock table error": {
+			lockTableName: "locks",
+			lockErr:       backendErr,
+			created:       []string{"circuitry"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newTableMock(tc.existing...)
+			client.describeErr = tc.describeErr
+			locker := &tableLockerMock{ddbLockerMock: newDDBLockerMock(), client: client, err: tc.lockErr}
+			backend := &ddbbackend.Backend{Client: client, LockClient: locker, CircuitTableName: "circuitry", LockTableName: tc.lockTableName, SingleTable: tc.singleTable}
+			if tc.noLockClient {
+				backend.LockClient = nil
+			}
+			err := backend.EnsureTables(context.TODO())
+			var remote *ddbbackend.RemoteBackendError
+			if tc.lockErr != nil && (!errors.As(err, &remote) || remote.Operation != ddbbackend.OpCreateTable || remote.TableName != "locks" || !errors.Is(err, tc.lockErr)) {
+				t.Fatalf("expected a RemoteBackendError creating locks; got %T(%v)", err, err)
+			}
+			if tc.describeErr != nil && (!errors.As(err, &remote) || remote.Operation != ddbbackend.OpDescribeTable || remote.TableName != "circuitry") {
+				t.Fatalf("expected a RemoteBackendError describing circuitry; got %T(%v)", err, err)
+			}
+			if tc.lockErr == nil && tc.describeErr == nil && err != nil {
+				t.Fatalf("expected to ensure the tables; got %v", err)
+			}
+			var created []string
+			for _, input := range client.created {
+				created = append(created, aws.ToString(input.TableName))
+				if hasRangeKey := len(input.KeySchema) == 2 && aws.ToString(input.KeySchema[1].AttributeName) == ddbbackend.SortKeyName; hasRangeKey != tc.rangeKey {
+					t.Fatalf("expected the range key to be %t; got %+v", tc.rangeKey, input.KeySchema)
+				}
+			}
+			if len(created) != len(tc.created) || (len(created) > 0 && created[0] != tc.created[0]) {
+				t.Fatalf("expected %v to be created; got %v", tc.created, created)
+			}
+			if len(locker.created) != len(tc.locksCreated) {
+				t.Fatalf("expected %v lock tables to be created; got %v", tc.locksCreated, locker.created)
+			}
+		})
+	}
+}
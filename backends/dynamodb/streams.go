@@ -0,0 +1,212 @@
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streams "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// DefaultStreamPollInterval is how often each shard of the stream is polled
+// for new records when the Backend's StreamPollInterval is not set
+const DefaultStreamPollInterval = time.Second
+
+// StreamsClient defines the necessary attributes for a DynamoDB Streams
+// client for the purpose of testing and mocks
+type StreamsClient interface {
+	DescribeStream(ctx context.Context, params *streams.DescribeStreamInput, optFns ...func(*streams.Options)) (*streams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, params *streams.GetShardIteratorInput, optFns ...func(*streams.Options)) (*streams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, params *streams.GetRecordsInput, optFns ...func(*streams.Options)) (*streams.GetRecordsOutput, error)
+}
+
+// WatchTransitions polls the StreamARN stream and calls emit whenever an
+// item's state differs between its old and new image, until the context is
+// done. Shards open when watching starts are read from their latest record;
+// shards created afterwards are read from the beginning. The stream must be
+// configured with [ddbtypes.StreamViewTypeNewAndOldImages], see
+// [CreateTableWithStream].
+func (b *Backend) WatchTransitions(ctx context.Context, emit func(circuitry.TransitionEvent)) error {
+	if b.StreamsClient == nil || b.StreamARN == "" {
+		return ErrStreamNotConfigured
+	}
+	interval := b.StreamPollInterval
+	if interval <= 0 {
+		interval = DefaultStreamPollInterval
+	}
+	w := &streamWatcher{
+		backend:   b,
+		known:     make(map[string]bool),
+		iterators: make(map[string]*string),
+	}
+	if err := w.discoverShards(ctx, streamtypes.ShardIteratorTypeLatest); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		closed, err := w.poll(ctx, emit)
+		if err != nil {
+			return err
+		}
+		if closed {
+			// A closed shard means the stream has split or rotated its
+			// shards so pick up the children
+			if err := w.discoverShards(ctx, streamtypes.ShardIteratorTypeTrimHorizon); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type streamWatcher struct {
+	backend   *Backend
+	known     map[string]bool
+	iterators map[string]*string
+}
+
+func (w *streamWatcher) discoverShards(ctx context.Context, iteratorType streamtypes.ShardIteratorType) error {
+	client, arn := w.backend.StreamsClient, w.backend.StreamARN
+	var startShardID *string
+	for {
+		out, err := client.DescribeStream(ctx, &streams.DescribeStreamInput{
+			StreamArn:             &arn,
+			ExclusiveStartShardId: startShardID,
+		})
+		if err != nil {
+			return remoteError(err, OpDescribeStream, w.backend.CircuitTableName)
+		}
+		for _, shard := range out.StreamDescription.Shards {
+			if shard.ShardId == nil || w.known[*shard.ShardId] {
+				continue
+			}
+			if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+				continue
+			}
+			iterator, err := client.GetShardIterator(ctx, &streams.GetShardIteratorInput{
+				StreamArn:         &arn,
+				ShardId:           shard.ShardId,
+				ShardIteratorType: iteratorType,
+			})
+			if err != nil {
+				return remoteError(err, OpGetShardIterator, w.backend.CircuitTableName)
+			}
+			w.known[*shard.ShardId] = true
+			w.iterators[*shard.ShardId] = iterator.ShardIterator
+		}
+		startShardID = out.StreamDescription.LastEvaluatedShardId
+		if startShardID == nil {
+			return nil
+		}
+	}
+}
+
+// poll reads the available records from every open shard and reports whether
+// any shard was closed
+func (w *streamWatcher) poll(ctx context.Context, emit func(circuitry.TransitionEvent)) (bool, error) {
+	closed := false
+	for shardID, iterator := range w.iterators {
+		out, err := w.backend.StreamsClient.GetRecords(ctx, &streams.GetRecordsInput{ShardIterator: iterator})
+		if err != nil {
+			return false, remoteError(err, OpGetRecords, w.backend.CircuitTableName)
+		}
+		for _, record := range out.Records {
+			if event, ok := transitionFromRecord(record); ok {
+				emit(event)
+			}
+		}
+		if out.NextShardIterator == nil {
+			delete(w.iterators, shardID)
+			closed = true
+			continue
+		}
+		w.iterators[shardID] = out.NextShardIterator
+	}
+	return closed, nil
+}
+
+// transitionFromRecord derives a TransitionEvent from a stream record if the
+// item's state changed. Records that cannot be decoded are skipped, as are
+// deletions, which remove the item's state.
+func transitionFromRecord(record streamtypes.Record) (circuitry.TransitionEvent, bool) {
+	change := record.Dynamodb
+	if change == nil || change.NewImage[stateName] == nil {
+		return circuitry.TransitionEvent{}, false
+	}
+	var before, after circuitInfoRecord
+	if err := attributevalue.UnmarshalMap(fromStreamImage(change.OldImage), &before); err != nil {
+		return circuitry.TransitionEvent{}, false
+	}
+	if err := attributevalue.UnmarshalMap(fromStreamImage(change.NewImage), &after); err != nil {
+		return circuitry.TransitionEvent{}, false
+	}
+	if before.State == after.State {
+		return circuitry.TransitionEvent{}, false
+	}
+	event := circuitry.TransitionEvent{
+		Name:        after.Name,
+		From:        circuitry.CircuitState(before.State),
+		To:          circuitry.CircuitState(after.State),
+		Generation:  after.Generation,
+		Information: before.ToCircuitInformation(),
+	}
+	if change.ApproximateCreationDateTime != nil {
+		event.Time = *change.ApproximateCreationDateTime
+	}
+	return event, true
+}
+
+func fromStreamImage(image map[string]streamtypes.AttributeValue) map[string]ddbtypes.AttributeValue {
+	if image == nil {
+		return nil
+	}
+	converted := make(map[string]ddbtypes.AttributeValue, len(image))
+	for name, value := range image {
+		converted[name] = fromStreamAttributeValue(value)
+	}
+	return converted
+}
+
+// fromStreamAttributeValue converts between the identical AttributeValue
+// types of the DynamoDB Streams and DynamoDB SDKs
+func fromStreamAttributeValue(value streamtypes.AttributeValue) ddbtypes.AttributeValue {
+	switch v := value.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &ddbtypes.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &ddbtypes.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &ddbtypes.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &ddbtypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &ddbtypes.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &ddbtypes.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &ddbtypes.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &ddbtypes.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]ddbtypes.AttributeValue, len(v.Value))
+		for i, item := range v.Value {
+			list[i] = fromStreamAttributeValue(item)
+		}
+		return &ddbtypes.AttributeValueMemberL{Value: list}
+	case *streamtypes.AttributeValueMemberM:
+		return &ddbtypes.AttributeValueMemberM{Value: fromStreamImage(v.Value)}
+	default:
+		return &ddbtypes.AttributeValueMemberNULL{Value: true}
+	}
+}
+
+var _ circuitry.TransitionWatcher = (*Backend)(nil)
@@ -0,0 +1,367 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streams "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+type streamsMock struct {
+	mu                  sync.Mutex
+	shards              []streamtypes.Shard
+	records             map[string]*streams.GetRecordsOutput
+	iteratorTypes       map[string]streamtypes.ShardIteratorType
+	onClose             []streamtypes.Shard
+	onCloseErr          error
+	describeStreamErr   error
+	getShardIteratorErr error
+	getRecordsErr       error
+}
+
+func newStreamsMock(shards ...streamtypes.Shard) *streamsMock {
+	return &streamsMock{
+		shards:        shards,
+		records:       make(map[string]*streams.GetRecordsOutput),
+		iteratorTypes: make(map[string]streamtypes.ShardIteratorType),
+	}
+}
+
+func (m *streamsMock) DescribeStream(_ context.Context, params *streams.DescribeStreamInput, _ ...func(*streams.Options)) (*streams.DescribeStreamOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.describeStreamErr != nil {
+		return nil, m.describeStreamErr
+	}
+	// Return one shard per page to exercise pagination
+	index := 0
+	if params.ExclusiveStartShardId != nil {
+		for i, shard := range m.shards {
+			if *shard.ShardId == *params.ExclusiveStartShardId {
+				index = i + 1
+			}
+		}
+	}
+	description := &streamtypes.StreamDescription{Shards: m.shards[index : index+1]}
+	if index+1 < len(m.shards) {
+		description.LastEvaluatedShardId = m.shards[index].ShardId
+	}
+	return &streams.DescribeStreamOutput{StreamDescription: description}, nil
+}
+
+func (m *streamsMock) GetShardIterator(_ context.Context, params *streams.GetShardIteratorInput, _ ...func(*streams.Options)) (*streams.GetShardIteratorOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getShardIteratorErr != nil {
+		return nil, m.getShardIteratorErr
+	}
+	m.iteratorTypes[*params.ShardId] = params.ShardIteratorType
+	return &streams.GetShardIteratorOutput{ShardIterator: params.ShardId}, nil
+}
+
+func (m *streamsMock) GetRecords(_ context.Context, params *streams.GetRecordsInput, _ ...func(*streams.Options)) (*streams.GetRecordsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getRecordsErr != nil {
+		return nil, m.getRecordsErr
+	}
+	out, ok := m.records[*params.ShardIterator]
+	if !ok {
+		return &streams.GetRecordsOutput{NextShardIterator: params.ShardIterator}, nil
+	}
+	delete(m.records, *params.ShardIterator)
+	if out.NextShardIterator == nil {
+		m.shards = append(m.shards, m.onClose...)
+		m.onClose = nil
+		m.describeStreamErr = m.onCloseErr
+	}
+	return out, nil
+}
+
+var _ ddbbackend.StreamsClient = (*streamsMock)(nil)
+
+func openShard(id string) streamtypes.Shard {
+	return streamtypes.Shard{ShardId: aws.String(id), SequenceNumberRange: &streamtypes.SequenceNumberRange{}}
+}
+
+func streamImage(name string, state circuitry.CircuitState, generation string) map[string]streamtypes.AttributeValue {
+	return map[string]streamtypes.AttributeValue{
+		"breaker_name":         &streamtypes.AttributeValueMemberS{Value: name},
+		"state":                &streamtypes.AttributeValueMemberN{Value: string(rune('0' + state))},
+		"generation":           &streamtypes.AttributeValueMemberN{Value: generation},
+		"consecutive_failures": &streamtypes.AttributeValueMemberN{Value: "3"},
+	}
+}
+
+func TestBackendWatchTransitions(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := newStreamsMock(
+		streamtypes.Shard{ShardId: aws.String("closed"), SequenceNumberRange: &streamtypes.SequenceNumberRange{EndingSequenceNumber: aws.String("10")}},
+		openShard("open"),
+		openShard("closing"),
+	)
+	client.onClose = []streamtypes.Shard{openShard("child")}
+	undecodable := streamImage("undecodable", circuitry.CircuitOpen, "1")
+	undecodable["state"] = &streamtypes.AttributeValueMemberS{Value: "open"}
+	client.records["open"] = &streams.GetRecordsOutput{
+		NextShardIterator: aws.String("open"),
+		Records: []streamtypes.Record{
+			{},
+			{Dynamodb: &streamtypes.StreamRecord{OldImage: streamImage("removed", circuitry.CircuitOpen, "1")}},
+			{Dynamodb: &streamtypes.StreamRecord{NewImage: undecodable}},
+			{Dynamodb: &streamtypes.StreamRecord{OldImage: undecodable, NewImage: streamImage("undecodable", circuitry.CircuitClosed, "2")}},
+			{Dynamodb: &streamtypes.StreamRecord{
+				OldImage: streamImage("deleted", circuitry.CircuitOpen, "1"),
+				NewImage: map[string]streamtypes.AttributeValue{
+					"breaker_name": &streamtypes.AttributeValueMemberS{Value: "deleted"},
+					"fence":        &streamtypes.AttributeValueMemberN{Value: "3"},
+				},
+			}},
+			{Dynamodb: &streamtypes.StreamRecord{
+				OldImage: streamImage("counts", circuitry.CircuitClosed, "1"),
+				NewImage: streamImage("counts", circuitry.CircuitClosed, "1"),
+			}},
+			{Dynamodb: &streamtypes.StreamRecord{
+				ApproximateCreationDateTime: &createdAt,
+				OldImage:                    streamImage("opened", circuitry.CircuitClosed, "1"),
+				NewImage:                    streamImage("opened", circuitry.CircuitOpen, "2"),
+			}},
+		},
+	}
+	client.records["closing"] = &streams.GetRecordsOutput{}
+	everyType := streamImage("created", circuitry.CircuitOpen, "1")
+	everyType["attributes"] = &streamtypes.AttributeValueMemberM{Value: map[string]streamtypes.AttributeValue{
+		"b":       &streamtypes.AttributeValueMemberB{Value: []byte("b")},
+		"bool":    &streamtypes.AttributeValueMemberBOOL{Value: true},
+		"null":    &streamtypes.AttributeValueMemberNULL{Value: true},
+		"ss":      &streamtypes.AttributeValueMemberSS{Value: []string{"s"}},
+		"ns":      &streamtypes.AttributeValueMemberNS{Value: []string{"1"}},
+		"bs":      &streamtypes.AttributeValueMemberBS{Value: [][]byte{[]byte("b")}},
+		"l":       &streamtypes.AttributeValueMemberL{Value: []streamtypes.AttributeValue{&streamtypes.AttributeValueMemberS{Value: "s"}}},
+		"unknown": &streamtypes.UnknownUnionMember{Tag: "unknown"},
+	}}
+	client.records["child"] = &streams.GetRecordsOutput{
+		NextShardIterator: aws.String("child"),
+		Records:           []streamtypes.Record{{Dynamodb: &streamtypes.StreamRecord{NewImage: everyType}}},
+	}
+
+	backend := ddbbackend.Backend{
+		CircuitTableName:   "circuit_info",
+		StreamsClient:      client,
+		StreamARN:          "arn:aws:dynamodb:us-east-1:123456789012:table/circuit_info/stream/label",
+		StreamPollInterval: time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan circuitry.TransitionEvent, 2)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- backend.WatchTransitions(ctx, func(event circuitry.TransitionEvent) { events <- event })
+	}()
+
+	received := map[string]circuitry.TransitionEvent{}
+	for len(received) < 2 {
+		select {
+		case event := <-events:
+			received[event.Name] = event
+		case <-time.After(time.Second):
+			t.Fatalf("expected two transitions after 1s; got %v", received)
+		}
+	}
+	cancel()
+	if err := <-watchErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled; got err = %v", err)
+	}
+
+	opened := received["opened"]
+	if opened.From != circuitry.CircuitClosed || opened.To != circuitry.CircuitOpen || opened.Generation != 2 {
+		t.Fatalf("expected opened to transition closed -> open in generation 2; got %+v", opened)
+	}
+	if !opened.Time.Equal(createdAt) {
+		t.Fatalf("expected opened.Time = %v; got %v", createdAt, opened.Time)
+	}
+	if opened.Information.ConsecutiveFailures != 3 {
+		t.Fatalf("expected opened.Information to come from the old image; got %+v", opened.Information)
+	}
+	created := received["created"]
+	if created.From != circuitry.CircuitClosed || created.To != circuitry.CircuitOpen {
+		t.Fatalf("expected created to transition closed -> open; got %+v", created)
+	}
+	if _, ok := received["counts"]; ok {
+		t.Fatal("expected records without a state change to be skipped")
+	}
+	if _, ok := received["deleted"]; ok {
+		t.Fatal("expected deleting a circuit to not be a transition")
+	}
+	if client.iteratorTypes["open"] != streamtypes.ShardIteratorTypeLatest {
+		t.Fatalf("expected shards open at start to be read from LATEST; got %s", client.iteratorTypes["open"])
+	}
+	if client.iteratorTypes["child"] != streamtypes.ShardIteratorTypeTrimHorizon {
+		t.Fatalf("expected shards discovered later to be read from TRIM_HORIZON; got %s", client.iteratorTypes["child"])
+	}
+	if _, ok := client.iteratorTypes["closed"]; ok {
+		t.Fatal("expected closed shards to not be read")
+	}
+}
+
+// toStreamImage converts an item written to the table into the image a
+// stream record carries for it
+func toStreamImage(t *testing.T, item map[string]ddbtypes.AttributeValue) map[string]streamtypes.AttributeValue {
+	t.Helper()
+	image := make(map[string]streamtypes.AttributeValue, len(item))
+	for name, value := range item {
+		switch v := value.(type) {
+		case *ddbtypes.AttributeValueMemberS:
+			image[name] = &streamtypes.AttributeValueMemberS{Value: v.Value}
+		case *ddbtypes.AttributeValueMemberN:
+			image[name] = &streamtypes.AttributeValueMemberN{Value: v.Value}
+		case *ddbtypes.AttributeValueMemberB:
+			image[name] = &streamtypes.AttributeValueMemberB{Value: v.Value}
+		case *ddbtypes.AttributeValueMemberM:
+			image[name] = &streamtypes.AttributeValueMemberM{Value: toStreamImage(t, v.Value)}
+		default:
+			t.Fatalf("expected the stored attribute %s to be convertible; got %T", name, value)
+		}
+	}
+	return image
+}
+
+func TestBackendWatchTransitionsOfStoredCircuits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	table := newFakeDynamo()
+	writer := ddbbackend.Backend{Client: table, LockClient: newDDBLockerMock(), CircuitTableName: "circuit_info"}
+	name := "TestBackendWatchTransitionsOfStoredCircuits"
+	var images []map[string]streamtypes.AttributeValue
+	for generation, state := range []circuitry.CircuitState{circuitry.CircuitClosed, circuitry.CircuitOpen, circuitry.CircuitHalfOpen} {
+		info := circuitry.CircuitInformation{State: state, Generation: uint64(generation), ConsecutiveFailures: 2}
+		if err := writer.Store(ctx, name, info); err != nil {
+			t.Fatalf("expected to store the circuit; got err = %v", err)
+		}
+		images = append(images, toStreamImage(t, table.Item(name)))
+	}
+	client := newStreamsMock(openShard("shard"))
+	client.records["shard"] = &streams.GetRecordsOutput{
+		NextShardIterator: aws.String("shard"),
+		Records: []streamtypes.Record{
+			{Dynamodb: &streamtypes.StreamRecord{OldImage: images[0], NewImage: images[1]}},
+			{Dynamodb: &streamtypes.StreamRecord{OldImage: images[1], NewImage: images[2]}},
+		},
+	}
+	watcher := ddbbackend.Backend{
+		CircuitTableName:   "circuit_info",
+		StreamsClient:      client,
+		StreamARN:          "arn:aws:dynamodb:us-east-1:123456789012:table/circuit_info/stream/label",
+		StreamPollInterval: time.Millisecond,
+	}
+	events := make(chan circuitry.TransitionEvent, 2)
+	go func() {
+		_ = watcher.WatchTransitions(ctx, func(event circuitry.TransitionEvent) { events <- event })
+	}()
+
+	expected := [][2]circuitry.CircuitState{
+		{circuitry.CircuitClosed, circuitry.CircuitOpen},
+		{circuitry.CircuitOpen, circuitry.CircuitHalfOpen},
+	}
+	for i, transition := range expected {
+		select {
+		case event := <-events:
+			if event.Name != name || event.From != transition[0] || event.To != transition[1] || event.Generation != uint64(i+1) {
+				t.Fatalf("expected %s to transition %s -> %s in generation %d; got %+v", name, transition[0], transition[1], i+1, event)
+			}
+			if event.Information.ConsecutiveFailures != 2 {
+				t.Fatalf("expected the information stored before the transition; got %+v", event.Information)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a transition %s -> %s after 1s", transition[0], transition[1])
+		}
+	}
+}
+
+func TestBackendWatchTransitionsErrors(t *testing.T) {
+	remoteErr := errors.New("remote error")
+	testCases := map[string]struct {
+		client      *streamsMock
+		streamARN   string
+		expectedErr error
+		expectedOp  ddbbackend.OperationType
+	}{
+		"no client": {
+			nil,
+			"arn",
+			ddbbackend.ErrStreamNotConfigured,
+			0,
+		},
+		"no stream ARN": {
+			newStreamsMock(),
+			"",
+			ddbbackend.ErrStreamNotConfigured,
+			0,
+		},
+		"describe stream": {
+			&streamsMock{describeStreamErr: remoteErr},
+			"arn",
+			remoteErr,
+			ddbbackend.OpDescribeStream,
+		},
+		"get shard iterator": {
+			&streamsMock{shards: []streamtypes.Shard{openShard("open")}, getShardIteratorErr: remoteErr},
+			"arn",
+			remoteErr,
+			ddbbackend.OpGetShardIterator,
+		},
+		"get records": {
+			&streamsMock{shards: []streamtypes.Shard{openShard("open")}, iteratorTypes: map[string]streamtypes.ShardIteratorType{}, getRecordsErr: remoteErr},
+			"arn",
+			remoteErr,
+			ddbbackend.OpGetRecords,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			backend := ddbbackend.Backend{StreamARN: tc.streamARN, StreamPollInterval: time.Millisecond}
+			if tc.client != nil {
+				backend.StreamsClient = tc.client
+			}
+			err := backend.WatchTransitions(context.Background(), func(circuitry.TransitionEvent) {})
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected err = %v; got %v", tc.expectedErr, err)
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if errors.As(err, &remote) && remote.Operation != tc.expectedOp {
+				t.Fatalf("expected the error for %s; got %s", tc.expectedOp, remote.Operation)
+			}
+		})
+	}
+}
+
+func TestBackendWatchTransitionsRediscoverError(t *testing.T) {
+	client := newStreamsMock(openShard("closing"))
+	client.records["closing"] = &streams.GetRecordsOutput{}
+	client.onCloseErr = errors.New("remote error")
+	backend := ddbbackend.Backend{StreamsClient: client, StreamARN: "arn", StreamPollInterval: time.Millisecond}
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- backend.WatchTransitions(context.Background(), func(circuitry.TransitionEvent) {})
+	}()
+	select {
+	case err := <-watchErr:
+		var remote *ddbbackend.RemoteBackendError
+		if !errors.As(err, &remote) || remote.Operation != ddbbackend.OpDescribeStream {
+			t.Fatalf("expected a DescribeStream RemoteBackendError; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WatchTransitions to return after 1s")
+	}
+}
@@ -2,6 +2,7 @@ package dynamodb
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -18,6 +19,9 @@ type CreateCircuitInformationTableOptions struct {
 	BillingMode           ddbtypes.BillingMode
 	ProvisionedThroughput *ddbtypes.ProvisionedThroughput
 	Tags                  []ddbtypes.Tag
+	StreamSpecification   *ddbtypes.StreamSpecification
+	TimeToLiveAttribute   string
+	SingleTableLayout     bool
 }
 
 // CreateCircuitInformationTableOption configures the
@@ -46,6 +50,38 @@ func CreateTableWithBillingMode(mode ddbtypes.BillingMode) CreateCircuitInformat
 	}
 }
 
+// CreateTableWithStream enables a DynamoDB Stream with both the new and old
+// images of each item so the Backend can watch the table for transitions
+func CreateTableWithStream() CreateCircuitInformationTableOption {
+	return func(o *CreateCircuitInformationTableOptions) {
+		o.StreamSpecification = &ddbtypes.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: ddbtypes.StreamViewTypeNewAndOldImages,
+		}
+	}
+}
+
+// CreateTableWithTimeToLive enables DynamoDB's Time to Live on the attribute
+// once the table is active, so items whose TTL has passed are deleted. An
+// empty name uses DefaultTTLAttributeName. The client must implement
+// TimeToLiveClient. Set the Backend's Retention to write the attribute.
+func CreateTableWithTimeToLive(attributeName string) CreateCircuitInformationTableOption {
+	return func(o *CreateCircuitInformationTableOptions) {
+		if attributeName == "" {
+			attributeName = DefaultTTLAttributeName
+		}
+		o.TimeToLiveAttribute = attributeName
+	}
+}
+
+// CreateTableWithSingleTableLayout adds the SortKeyName range key so the
+// table can hold both circuits and their locks. See NewSingleTable.
+func CreateTableWithSingleTableLayout() CreateCircuitInformationTableOption {
+	return func(o *CreateCircuitInformationTableOptions) {
+		o.SingleTableLayout = true
+	}
+}
+
 // KeyName is the name of the DynamoDB Hash Key Attribute
 const KeyName = "breaker_name"
 
@@ -69,6 +105,16 @@ func CreateCircuitInformationTable(ctx context.Context, client DynamoClient, tab
 			AttributeType: ddbtypes.ScalarAttributeTypeS,
 		},
 	}
+	if options.SingleTableLayout {
+		keySchema = append(keySchema, ddbtypes.KeySchemaElement{
+			AttributeName: aws.String(SortKeyName),
+			KeyType:       ddbtypes.KeyTypeRange,
+		})
+		attributes = append(attributes, ddbtypes.AttributeDefinition{
+			AttributeName: aws.String(SortKeyName),
+			AttributeType: ddbtypes.ScalarAttributeTypeS,
+		})
+	}
 	input := &ddb.CreateTableInput{
 		AttributeDefinitions: attributes,
 		KeySchema:            keySchema,
@@ -83,17 +129,46 @@ func CreateCircuitInformationTable(ctx context.Context, client DynamoClient, tab
 	if ts := options.Tags; ts != nil {
 		input.Tags = ts
 	}
+
+	if ss := options.StreamSpecification; ss != nil {
+		input.StreamSpecification = ss
+	}
 	output, err := client.CreateTable(ctx, input)
 	if err != nil {
-		return nil, &RemoteBackendError{
-			Err:       err,
-			Operation: OpCreateTable,
-			TableName: tableName,
+		return nil, remoteError(err, OpCreateTable, tableName)
+	}
+	if options.TimeToLiveAttribute != "" {
+		if err := enableTimeToLive(ctx, client, tableName, options.TimeToLiveAttribute); err != nil {
+			return output, err
 		}
 	}
 	return output, nil
 }
 
+// enableTimeToLive waits for the table to become active, as Time to Live
+// cannot be enabled while it is being created, and enables it on the
+// attribute
+func enableTimeToLive(ctx context.Context, client DynamoClient, tableName, attributeName string) error {
+	ttlClient, ok := client.(TimeToLiveClient)
+	if !ok {
+		return &LocalBackendError{Err: ErrTimeToLiveNotSupported, Message: fmt.Sprintf("cannot enable time to live on %q", tableName)}
+	}
+	if err := waitForTable(ctx, ttlClient, tableName); err != nil {
+		return err
+	}
+	_, err := ttlClient.UpdateTimeToLive(ctx, &ddb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &ddbtypes.TimeToLiveSpecification{
+			AttributeName: aws.String(attributeName),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return remoteError(err, OpUpdateTimeToLive, tableName)
+	}
+	return nil
+}
+
 type circuitInfoRecord struct {
 	Name                 string    `dynamodbav:"breaker_name"`
 	State                uint64    `dynamodbav:"state"`
@@ -104,6 +179,7 @@ type circuitInfoRecord struct {
 	TotalFailures        uint64    `dynamodbav:"total_failures"`
 	TotalSuccesses       uint64    `dynamodbav:"total_successes"`
 	ExpiresAfter         time.Time `dynamodbav:"expires_after"`
+	SchemaVersion        uint64    `dynamodbav:"schema_version"`
 }
 
 func (r circuitInfoRecord) ToCircuitInformation() circuitry.CircuitInformation {
@@ -119,24 +195,28 @@ func (r circuitInfoRecord) ToCircuitInformation() circuitry.CircuitInformation {
 	}
 }
 
-func (r circuitInfoRecord) ToUpdateExpression() (*ddbexp.Expression, error) {
-	update := ddbexp.Set(ddbexp.Name("state"), ddbexp.Value(r.State)).
+// fenceCondition is the condition that the item's fencing token is not
+// greater than the token
+func fenceCondition(token uint64) ddbexp.ConditionBuilder {
+	fence := ddbexp.Name(FenceName)
+	return ddbexp.AttributeNotExists(fence).Or(ddbexp.LessThanEqual(fence, ddbexp.Value(token)))
+}
+
+func (r circuitInfoRecord) update() ddbexp.UpdateBuilder {
+	return ddbexp.Set(ddbexp.Name("state"), ddbexp.Value(r.State)).
 		Set(ddbexp.Name("generation"), ddbexp.Value(r.Generation)).
 		Set(ddbexp.Name("consecutive_failures"), ddbexp.Value(r.ConsecutiveFailures)).
 		Set(ddbexp.Name("consecutive_successes"), ddbexp.Value(r.ConsecutiveSuccesses)).
 		Set(ddbexp.Name("expires_after"), ddbexp.Value(r.ExpiresAfter)).
 		Set(ddbexp.Name("total"), ddbexp.Value(r.Total)).
 		Set(ddbexp.Name("total_failures"), ddbexp.Value(r.TotalFailures)).
-		Set(ddbexp.Name("total_successes"), ddbexp.Value(r.TotalSuccesses))
-	exp, err := ddbexp.NewBuilder().WithUpdate(update).Build()
-	if err != nil {
-		return nil, err
-	}
-	return &exp, nil
+		Set(ddbexp.Name("total_successes"), ddbexp.Value(r.TotalSuccesses)).
+		Set(ddbexp.Name(SchemaVersionName), ddbexp.Value(r.SchemaVersion))
 }
 
 func recordFromCircuitInformation(ci circuitry.CircuitInformation) circuitInfoRecord {
 	return circuitInfoRecord{
+		State:                uint64(ci.State),
 		Generation:           ci.Generation,
 		ConsecutiveFailures:  ci.ConsecutiveFailures,
 		ConsecutiveSuccesses: ci.ConsecutiveSuccesses,
@@ -144,5 +224,6 @@ func recordFromCircuitInformation(ci circuitry.CircuitInformation) circuitInfoRe
 		TotalFailures:        ci.TotalFailures,
 		TotalSuccesses:       ci.TotalSuccesses,
 		ExpiresAfter:         ci.ExpiresAfter,
+		SchemaVersion:        SchemaVersion,
 	}
 }
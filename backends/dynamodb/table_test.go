@@ -48,6 +48,14 @@ func TestCreateCircuitInformationTable(t *testing.T) {
 			},
 			nil,
 		},
+		"with stream": {
+			newDDBMock(),
+			"create-ci-table-005",
+			[]dynamodb.CreateCircuitInformationTableOption{
+				dynamodb.CreateTableWithStream(),
+			},
+			nil,
+		},
 		"with all options": {
 			newDDBMock(),
 			"create-ci-table-004",
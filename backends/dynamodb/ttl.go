@@ -0,0 +1,49 @@
+package dynamodb
+
+import (
+	"context"
+	"time"
+
+	ddbexp "github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// DefaultTTLAttributeName is the attribute holding when an item may be
+// deleted by DynamoDB's Time to Live when the Backend's TTLAttributeName and
+// the name given to CreateTableWithTimeToLive are empty
+const DefaultTTLAttributeName = "ttl"
+
+// DefaultTableWaitTimeout is how long CreateCircuitInformationTable waits for
+// a new table to become active before enabling Time to Live on it
+const DefaultTableWaitTimeout = 5 * time.Minute
+
+// TimeToLiveClient defines the DynamoDB calls needed to enable Time to Live on
+// a new table. *dynamodb.Client implements it.
+type TimeToLiveClient interface {
+	DescribeTable(ctx context.Context, params *ddb.DescribeTableInput, optFns ...func(*ddb.Options)) (*ddb.DescribeTableOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *ddb.UpdateTimeToLiveInput, optFns ...func(*ddb.Options)) (*ddb.UpdateTimeToLiveOutput, error)
+}
+
+func (b *Backend) ttlAttributeName() string {
+	if b.TTLAttributeName == "" {
+		return DefaultTTLAttributeName
+	}
+	return b.TTLAttributeName
+}
+
+// withTTL adds setting the TTL attribute to the seconds since the epoch at
+// which the circuit may be deleted, or removing it if the circuit is kept
+// until it is deleted, to the update. The update is unchanged when no
+// Retention is configured.
+func (b *Backend) withTTL(update ddbexp.UpdateBuilder, ci circuitry.CircuitInformation, now time.Time) ddbexp.UpdateBuilder {
+	if b.Retention == (circuitry.Retention{}) {
+		return update
+	}
+	name := ddbexp.Name(b.ttlAttributeName())
+	if ttl := b.Retention.TTL(ci, now); ttl > 0 {
+		return update.Set(name, ddbexp.Value(now.Add(ttl).Unix()))
+	}
+	return update.Remove(name)
+}
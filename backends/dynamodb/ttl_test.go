@@ -0,0 +1,219 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+// ttlOf returns the TTL attribute of the item as a time, or the zero time if
+// it is not set
+func ttlOf(t *testing.T, item map[string]ddbtypes.AttributeValue, attributeName string) time.Time {
+	t.Helper()
+	value, ok := item[attributeName]
+	if !ok {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(value.(*ddbtypes.AttributeValueMemberN).Value, 10, 64)
+	if err != nil {
+		t.Fatalf("expected the TTL to be seconds since the epoch; got %v", err)
+	}
+	return time.Unix(seconds, 0)
+}
+
+func expectTTL(t *testing.T, expected, actual time.Time) {
+	t.Helper()
+	if expected.IsZero() != actual.IsZero() || actual.Sub(expected).Abs() > 5*time.Second {
+		t.Fatalf("expected a TTL of %v; got %v", expected, actual)
+	}
+}
+
+func TestBackendsRetention(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	expiring := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, ExpiresAfter: now.Add(time.Hour)}
+	testCases := map[string]struct {
+		attributeName string
+		retention     circuitry.Retention
+		stored        []circuitry.CircuitInformation
+		expected      time.Time
+	}{
+		"zero value": {
+			stored: []circuitry.CircuitInformation{expiring},
+		},
+		"keep for": {
+			retention: circuitry.RetainFor(24 * time.Hour),
+			stored:    []circuitry.CircuitInformation{{}},
+			expected:  now.Add(24 * time.Hour),
+		},
+		"until expiry": {
+			attributeName: "delete_after",
+			retention:     circuitry.RetainUntilExpiry(time.Minute),
+			stored:        []circuitry.CircuitInformation{expiring},
+			expected:      now.Add(time.Hour + time.Minute),
+		},
+		"until expiry without expiry": {
+			retention: circuitry.RetainUntilExpiry(time.Minute),
+			stored:    []circuitry.CircuitInformation{expiring, {Generation: 2}},
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			attributeName := tc.attributeName
+			if attributeName == "" {
+				attributeName = ddbbackend.DefaultTTLAttributeName
+			}
+			client := newFakeDynamo()
+			configured := ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_retention", TTLAttributeName: tc.attributeName, Retention: tc.retention}
+			for backendName, backend := range map[string]circuitry.StorageBackender{
+				"backend":           &configured,
+				"versioned backend": &ddbbackend.VersionedBackend{Backend: configured},
+			} {
+				for _, ci := range tc.stored {
+					if err := backend.Store(ctx, backendName, ci); err != nil {
+						t.Fatalf("expected %s to store; got %v", backendName, err)
+					}
+				}
+				expectTTL(t, tc.expected, ttlOf(t, client.Item(backendName), attributeName))
+			}
+		})
+	}
+}
+
+func TestBackendStoreFencedRetention(t *testing.T) {
+	client := newFakeDynamo()
+	backend := ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_retention", Retention: circuitry.RetainFor(time.Hour)}
+	if err := backend.StoreFenced(context.Background(), "fenced", circuitry.CircuitInformation{}, 1); err != nil {
+		t.Fatalf("expected to store; got %v", err)
+	}
+	expectTTL(t, time.Now().Add(time.Hour), ttlOf(t, client.Item("fenced"), ddbbackend.DefaultTTLAttributeName))
+}
+
+func TestVersionedBackendRetention(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]struct {
+		retention circuitry.Retention
+		expected  time.Duration
+	}{
+		"keep for":     {circuitry.RetainFor(24 * time.Hour), 24 * time.Hour},
+		"until expiry": {circuitry.RetainUntilExpiry(time.Minute), time.Hour + time.Minute},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newFakeDynamo()
+			backend := newVersionedBackend(client)
+			backend.Retention = tc.retention
+			breaker := newVersionedFactory(backend, circuitry.WithCyclicClearAfter(time.Hour)).BreakerFor("retention", map[string]any{})
+			for i := 0; i < 2; i++ {
+				if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+					t.Fatalf("expected to execute; got %v", err)
+				}
+			}
+			expectTTL(t, time.Now().Add(tc.expected), ttlOf(t, client.Item("retention"), ddbbackend.DefaultTTLAttributeName))
+
+			lock, err := backend.Lock(ctx, "retention")
+			if err != nil {
+				t.Fatalf("expected to lock; got %v", err)
+			}
+			ci := circuitry.CircuitInformation{ExpiresAfter: time.Now().Add(time.Hour)}
+			if err := backend.StoreFenced(ctx, "retention", ci, lock.(circuitry.Lease).Token()); err != nil {
+				t.Fatalf("expected to store; got %v", err)
+			}
+			expectTTL(t, time.Now().Add(tc.expected), ttlOf(t, client.Item("retention"), ddbbackend.DefaultTTLAttributeName))
+		})
+	}
+}
+
+// ttlClientMock enables Time to Live on tables that are immediately active
+type ttlClientMock struct {
+	*ddbMock
+	describeErr error
+	updateErr   error
+	updates     []*ddb.UpdateTimeToLiveInput
+}
+
+func (m *ttlClientMock) DescribeTable(_ context.Context, params *ddb.DescribeTableInput, _ ...func(*ddb.Options)) (*ddb.DescribeTableOutput, error) {
+	if m.describeErr != nil {
+		return nil, m.describeErr
+	}
+	return &ddb.DescribeTableOutput{Table: &ddbtypes.TableDescription{TableName: params.TableName, TableStatus: ddbtypes.TableStatusActive}}, nil
+}
+
+func (m *ttlClientMock) UpdateTimeToLive(_ context.Context, params *ddb.UpdateTimeToLiveInput, _ ...func(*ddb.Options)) (*ddb.UpdateTimeToLiveOutput, error) {
+	m.updates = append(m.updates, params)
+	if m.updateErr != nil {
+		return nil, m.updateErr
+	}
+	return &ddb.UpdateTimeToLiveOutput{TimeToLiveSpecification: params.TimeToLiveSpecification}, nil
+}
+
+var _ ddbbackend.TimeToLiveClient = (*ttlClientMock)(nil)
+
+func TestCreateCircuitInformationTableTimeToLive(t *testing.T) {
+	backendErr := errors.New("test")
+	testCases := map[string]struct {
+		client        ddbbackend.DynamoClient
+		attributeName string
+		expected      string
+		expectedErr   error
+		operation     ddbbackend.OperationType
+	}{
+		"default attribute": {
+			client:   &ttlClientMock{ddbMock: newDDBMock()},
+			expected: ddbbackend.DefaultTTLAttributeName,
+		},
+		"custom attribute": {
+			client:        &ttlClientMock{ddbMock: newDDBMock()},
+			attributeName: "delete_after",
+			expected:      "delete_after",
+		},
+		"client without time to live": {
+			client:      newDDBMock(),
+			expectedErr: ddbbackend.ErrTimeToLiveNotSupported,
+		},
+		"describe error": {
+			client:      &ttlClientMock{ddbMock: newDDBMock(), describeErr: backendErr},
+			expectedErr: backendErr,
+			operation:   ddbbackend.OpDescribeTable,
+		},
+		"update error": {
+			client:      &ttlClientMock{ddbMock: newDDBMock(), updateErr: backendErr},
+			expectedErr: backendErr,
+			operation:   ddbbackend.OpUpdateTimeToLive,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			_, err := ddbbackend.CreateCircuitInformationTable(context.TODO(), tc.client, "create-ci-table-ttl", ddbbackend.CreateTableWithTimeToLive(tc.attributeName))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected err = %v; got %v", tc.expectedErr, err)
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if errors.Is(err, backendErr) && (!errors.As(err, &remote) || remote.Operation != tc.operation) {
+				t.Fatalf("expected a RemoteBackendError for %s; got %T(%v)", tc.operation, err, err)
+			}
+			if tc.expected == "" {
+				return
+			}
+			updates := tc.client.(*ttlClientMock).updates
+			if len(updates) != 1 || aws.ToString(updates[0].TimeToLiveSpecification.AttributeName) != tc.expected || !aws.ToBool(updates[0].TimeToLiveSpecification.Enabled) {
+				t.Fatalf("expected time to live to be enabled on %q; got %+v", tc.expected, updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	ddbexp "github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// VersionName is the attribute of the circuit information table a
+// VersionedBackend increments whenever it writes a circuit or issues a lock
+const VersionName = "version"
+
+// DefaultMaxAttempts is how many times a VersionedBackend tries a
+// conditional write when its MaxAttempts is not set
+const DefaultMaxAttempts = 10
+
+var countNames = []string{consecutiveFailuresName, consecutiveSuccessesName, totalName, totalFailuresName, totalSuccessesName}
+
+func counts(ci circuitry.CircuitInformation) []uint64 {
+	return []uint64{ci.ConsecutiveFailures, ci.ConsecutiveSuccesses, ci.Total, ci.TotalFailures, ci.TotalSuccesses}
+}
+
+// VersionedBackend implements the StorageBackender interface for DynamoDB
+// without a lock table. It implements
+// [github.com/sigmavirus24/circuitry.OutcomeRecorder] by reading each circuit
+// with a consistent read and writing it back on the condition that its
+// VersionName attribute is as it was read, retrying up to MaxAttempts times
+// when another process wrote the circuit in between. Writes that only count a
+// request or an outcome increment the counts with ADD on the condition that
+// the circuit's state, generation, and expiry are as they were read, so
+// concurrent writers do not conflict, and an outcome recorded while the
+// circuit is closed is written without reading it first. Every write
+// increments the version. CircuitBreakers using the default trip function therefore make
+// two DynamoDB calls to admit a request and usually one to record its
+// outcome.
+//
+// Locks are not held: Lock increments the circuit's version and returns a
+// [github.com/sigmavirus24/circuitry.Lease] whose token is the new version,
+// and StoreFenced fails with [github.com/sigmavirus24/circuitry.ErrLockLost]
+// once the version has changed. CircuitBreakers with a custom trip function
+// should expect ErrLockLost when they share a busy circuit. The Backend's
+// LockClient and LockTableName are not used.
+type VersionedBackend struct {
+	Backend
+	MaxAttempts int
+}
+
+// versionedRecord is the item holding a circuit along with the attributes
+// that were present when it was read
+type versionedRecord struct {
+	circuitInfoRecord
+	Version    uint64 `dynamodbav:"version"`
+	attributes map[string]ddbtypes.AttributeValue
+}
+
+func (r versionedRecord) has(name string) bool {
+	_, ok := r.attributes[name]
+	return ok
+}
+
+// complete reports whether every attribute of the circuit is present so its
+// counts can be incremented. Items written with an older SchemaVersion are
+// not complete so they are rewritten whole.
+func (r versionedRecord) complete() bool {
+	for _, name := range append([]string{SchemaVersionName}, circuitAttributeNames...) {
+		if !r.has(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalOrAbsent is the condition that the attribute still has the value it
+// was read with
+func (r versionedRecord) equalOrAbsent(name string, value uint64) ddbexp.ConditionBuilder {
+	if !r.has(name) {
+		return ddbexp.AttributeNotExists(ddbexp.Name(name))
+	}
+	return ddbexp.Equal(ddbexp.Name(name), ddbexp.Value(value))
+}
+
+// sameState is the condition that the circuit's state, generation, and
+// expiry are still as they were read
+func (r versionedRecord) sameState() ddbexp.ConditionBuilder {
+	return ddbexp.Equal(ddbexp.Name(stateName), ddbexp.Value(r.State)).
+		And(ddbexp.Equal(ddbexp.Name(generationName), ddbexp.Value(r.Generation))).
+		And(ddbexp.Equal(ddbexp.Name(expiresAfterName), ddbexp.Value(r.attributes[expiresAfterName])))
+}
+
+func (b *VersionedBackend) maxAttempts() int {
+	if b.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return b.MaxAttempts
+}
+
+func isConditionFailed(err error) bool {
+	var conditionFailed *ddbtypes.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
+}
+
+func newRecord(name string, ci circuitry.CircuitInformation) circuitInfoRecord {
+	record := recordFromCircuitInformation(ci)
+	record.Name = name
+	return record
+}
+
+func (b *VersionedBackend) read(ctx context.Context, name string) (versionedRecord, error) {
+	key, err := b.itemKey(name)
+	if err != nil {
+		return versionedRecord{}, err
+	}
+	response, err := b.client().GetItem(ctx, &ddb.GetItemInput{
+		Key:            key,
+		TableName:      aws.String(b.CircuitTableName),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return versionedRecord{}, remoteError(err, OpGetItem, b.CircuitTableName)
+	}
+	return unmarshalVersioned(name, response.Item)
+}
+
+func unmarshalVersioned(name string, item map[string]ddbtypes.AttributeValue) (versionedRecord, error) {
+	record := versionedRecord{attributes: item}
+	if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+		return versionedRecord{}, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot unmarshal data for %q", name)}
+	}
+	if err := record.checkSchema(name); err != nil {
+		return versionedRecord{}, err
+	}
+	return record, nil
+}
+
+// update makes a conditional write, returning the item afterwards when
+// returnValues is ddbtypes.ReturnValueAllNew. The error is returned as is so
+// failed conditions can be retried.
+func (b *VersionedBackend) update(ctx context.Context, name string, update ddbexp.UpdateBuilder, condition ddbexp.ConditionBuilder, returnValues ddbtypes.ReturnValue) (*ddb.UpdateItemOutput, error) {
+	key, err := b.itemKey(name)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := ddbexp.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return nil, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot build update for %q", name)}
+	}
+	return b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              returnValues,
+	})
+}
+
+// apply writes the information computed from the record. Changes to the
+// state, generation, or expiry replace the circuit unless it was written
+// since it was read, while changes to the counts alone are made with ADD on
+// the condition that the state, generation, and expiry and the guard, if
+// any, hold. Either increments the circuit's version.
+func (b *VersionedBackend) apply(ctx context.Context, name string, record versionedRecord, after circuitry.CircuitInformation, guard *ddbexp.ConditionBuilder, now time.Time) (circuitry.CircuitInformation, error) {
+	current := record.ToCircuitInformation()
+	if !record.complete() || after.State != current.State || after.Generation != current.Generation || !after.ExpiresAfter.Equal(current.ExpiresAfter) {
+		condition := record.equalOrAbsent(VersionName, record.Version)
+		update := newRecord(name, after).update().Set(ddbexp.Name(VersionName), ddbexp.Value(record.Version+1))
+		if _, err := b.update(ctx, name, b.withTTL(update, after, now), condition, ddbtypes.ReturnValueNone); err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		return after, nil
+	}
+	condition := record.sameState()
+	if guard != nil {
+		condition = condition.And(*guard)
+	}
+	update := ddbexp.Add(ddbexp.Name(VersionName), ddbexp.Value(1))
+	read, written := counts(current), counts(after)
+	for i, attribute := range countNames {
+		switch {
+		case written[i] > read[i]:
+			update = update.Add(ddbexp.Name(attribute), ddbexp.Value(written[i]-read[i]))
+		case written[i] < read[i]:
+			update = update.Set(ddbexp.Name(attribute), ddbexp.Value(written[i]))
+		}
+	}
+	output, err := b.update(ctx, name, b.withTTL(update, after, now), condition, ddbtypes.ReturnValueAllNew)
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	stored, err := unmarshalVersioned(name, output.Attributes)
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	return stored.ToCircuitInformation(), nil
+}
+
+func unchanged(a, b circuitry.CircuitInformation) bool {
+	return a.State == b.State && a.Generation == b.Generation && slices.Equal(counts(a), counts(b)) && a.ExpiresAfter.Equal(b.ExpiresAfter)
+}
+
+// retry calls fn until it does not fail a condition or MaxAttempts is
+// reached
+func (b *VersionedBackend) retry(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < b.maxAttempts(); attempt++ {
+		if err = fn(); !isConditionFailed(err) {
+			break
+		}
+	}
+	return b.updateError(err)
+}
+
+// updateError wraps an error from UpdateItem in a RemoteBackendError unless
+// it is already a backend error
+func (b *VersionedBackend) updateError(err error) error {
+	var local *LocalBackendError
+	var remote *RemoteBackendError
+	if err == nil || errors.As(err, &local) || errors.As(err, &remote) {
+		return err
+	}
+	return remoteError(err, OpUpdateItem, b.CircuitTableName)
+}
+
+// Admit reads the circuit and applies the policy to it, writing the result
+// on the condition that the circuit has not been written since. Rejections
+// that do not change the circuit are not written.
+func (b *VersionedBackend) Admit(ctx context.Context, name string, policy circuitry.OutcomePolicy, now time.Time) (before, after circuitry.CircuitInformation, admitErr error) {
+	err := b.retry(func() error {
+		record, err := b.read(ctx, name)
+		if err != nil {
+			return err
+		}
+		current := record.ToCircuitInformation()
+		before, after, admitErr = policy.Admit(current, now)
+		if record.complete() && unchanged(current, after) {
+			return nil
+		}
+		var guard *ddbexp.ConditionBuilder
+		if after.State == circuitry.CircuitHalfOpen {
+			allowed := ddbexp.LessThan(ddbexp.Name(totalName), ddbexp.Value(policy.CloseThreshold))
+			guard = &allowed
+		}
+		after, err = b.apply(ctx, name, record, after, guard, now)
+		return err
+	})
+	if err != nil {
+		return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	return before, after, admitErr
+}
+
+// recordClosed counts the outcome without reading the circuit on the
+// condition that it is closed in the generation and the outcome does not
+// trip it
+func (b *VersionedBackend) recordClosed(ctx context.Context, name string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (circuitry.CircuitInformation, error) {
+	condition := ddbexp.Equal(ddbexp.Name(generationName), ddbexp.Value(generation)).
+		And(ddbexp.Equal(ddbexp.Name(stateName), ddbexp.Value(uint64(circuitry.CircuitClosed))))
+	update := ddbexp.Add(ddbexp.Name(consecutiveSuccessesName), ddbexp.Value(1)).
+		Add(ddbexp.Name(totalSuccessesName), ddbexp.Value(1)).
+		Set(ddbexp.Name(consecutiveFailuresName), ddbexp.Value(0))
+	if status != circuitry.ExecutionSucceeded {
+		condition = condition.And(ddbexp.LessThan(ddbexp.Name(consecutiveFailuresName), ddbexp.Value(policy.FailureCountThreshold)))
+		update = ddbexp.Add(ddbexp.Name(consecutiveFailuresName), ddbexp.Value(1)).
+			Add(ddbexp.Name(totalFailuresName), ddbexp.Value(1)).
+			Set(ddbexp.Name(consecutiveSuccessesName), ddbexp.Value(0))
+	}
+	update = update.Add(ddbexp.Name(VersionName), ddbexp.Value(1))
+	// The circuit's expiry is not known without reading it, and counting an
+	// outcome does not change it, so only a TTL kept from the last store is
+	// refreshed
+	if b.Retention.KeepFor > 0 {
+		update = b.withTTL(update, circuitry.CircuitInformation{}, now)
+	}
+	output, err := b.update(ctx, name, update, condition, ddbtypes.ReturnValueAllNew)
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	stored, err := unmarshalVersioned(name, output.Attributes)
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	return stored.ToCircuitInformation(), nil
+}
+
+// Record counts the outcome with a single write while the circuit is closed
+// and the outcome does not trip it. Otherwise it reads the circuit and
+// applies the policy to it, writing the result on the condition that the
+// circuit has not been written since.
+func (b *VersionedBackend) Record(ctx context.Context, name string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (before, after circuitry.CircuitInformation, err error) {
+	if status == circuitry.ExecutionSucceeded || policy.FailureCountThreshold > 0 {
+		after, err = b.recordClosed(ctx, name, policy, generation, status, now)
+		switch {
+		case err == nil:
+			return after, after, nil
+		case !isConditionFailed(err):
+			return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, b.updateError(err)
+		}
+	}
+	err = b.retry(func() error {
+		record, err := b.read(ctx, name)
+		if err != nil {
+			return err
+		}
+		current := record.ToCircuitInformation()
+		before, after = policy.Record(current, generation, status, now)
+		if record.complete() && unchanged(current, after) {
+			return nil
+		}
+		var guard *ddbexp.ConditionBuilder
+		switch {
+		case status == circuitry.ExecutionSucceeded && after.State == circuitry.CircuitHalfOpen:
+			open := ddbexp.LessThan(ddbexp.Name(consecutiveSuccessesName), ddbexp.Value(policy.CloseThreshold-1))
+			guard = &open
+		case status != circuitry.ExecutionSucceeded && after.State == circuitry.CircuitClosed:
+			closed := ddbexp.LessThan(ddbexp.Name(consecutiveFailuresName), ddbexp.Value(policy.FailureCountThreshold))
+			guard = &closed
+		}
+		after, err = b.apply(ctx, name, record, after, guard, now)
+		return err
+	})
+	if err != nil {
+		return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	return before, after, nil
+}
+
+// Store saves the CircuitInformation unconditionally and increments the
+// circuit's version
+func (b *VersionedBackend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
+	key, err := b.itemKey(name)
+	if err != nil {
+		return err
+	}
+	update := newRecord(name, ci).update().Add(ddbexp.Name(VersionName), ddbexp.Value(1))
+	expr, err := ddbexp.NewBuilder().WithUpdate(b.withTTL(update, ci, time.Now())).Build()
+	if err != nil {
+		return err
+	}
+	_, err = b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              ddbtypes.ReturnValueNone,
+	})
+	if err != nil {
+		return remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	return nil
+}
+
+// StoreFenced saves the CircuitInformation on the condition that the
+// circuit's version is still the token Lock returned. Otherwise it returns
+// [github.com/sigmavirus24/circuitry.ErrLockLost].
+func (b *VersionedBackend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	update := newRecord(name, ci).update().Set(ddbexp.Name(VersionName), ddbexp.Value(token+1))
+	condition := ddbexp.Equal(ddbexp.Name(VersionName), ddbexp.Value(token))
+	_, err := b.update(ctx, name, b.withTTL(update, ci, time.Now()), condition, ddbtypes.ReturnValueNone)
+	if isConditionFailed(err) {
+		err = fmt.Errorf("%w: %w", circuitry.ErrLockLost, err)
+	}
+	return b.updateError(err)
+}
+
+// versionLease is returned by VersionedBackend.Lock. Nothing is locked; the
+// token is the circuit's version, which any other write changes.
+type versionLease struct {
+	token uint64
+}
+
+func (versionLease) Lock()                         {}
+func (versionLease) Unlock()                       {}
+func (versionLease) Err() error                    { return nil }
+func (versionLease) Release(context.Context) error { return nil }
+func (l versionLease) Token() uint64               { return l.token }
+
+// Lock increments the circuit's version and returns a
+// [github.com/sigmavirus24/circuitry.Lease] whose token is the new version
+func (b *VersionedBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	key, err := b.itemKey(name)
+	if err != nil {
+		return nil, err
+	}
+	output, err := b.client().UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 aws.String(b.CircuitTableName),
+		Key:                       key,
+		UpdateExpression:          aws.String("ADD #version :one"),
+		ExpressionAttributeNames:  map[string]string{"#version": VersionName},
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{":one": &ddbtypes.AttributeValueMemberN{Value: "1"}},
+		ReturnValues:              ddbtypes.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return nil, remoteError(err, OpUpdateItem, b.CircuitTableName)
+	}
+	var token uint64
+	if err := attributevalue.Unmarshal(output.Attributes[VersionName], &token); err != nil {
+		return nil, &LocalBackendError{Err: err, Message: fmt.Sprintf("cannot unmarshal version for %q", name)}
+	}
+	return versionLease{token}, nil
+}
+
+var _ circuitry.StorageBackender = (*VersionedBackend)(nil)
+var _ circuitry.OutcomeRecorder = (*VersionedBackend)(nil)
+var _ circuitry.FencedStorer = (*VersionedBackend)(nil)
+var _ circuitry.Lister = (*VersionedBackend)(nil)
+var _ circuitry.Deleter = (*VersionedBackend)(nil)
+var _ circuitry.TransitionWatcher = (*VersionedBackend)(nil)
+var _ circuitry.Lease = versionLease{}
+
+// WithVersionedDynamoBackend can be used to configure a
+// circuitry.FactorySettings object to use DynamoDB without a lock table as
+// the backend. See [VersionedBackend].
+func WithVersionedDynamoBackend(client DynamoClient, circuitInformationTableName string) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(&VersionedBackend{
+		Backend: Backend{
+			Client:           client,
+			CircuitTableName: circuitInformationTableName,
+		},
+	})
+}
@@ -0,0 +1,624 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+)
+
+func newVersionedBackend(client ddbbackend.DynamoClient) *ddbbackend.VersionedBackend {
+	return &ddbbackend.VersionedBackend{Backend: ddbbackend.Backend{Client: client, CircuitTableName: "circuit_information_versioned"}}
+}
+
+func newVersionedFactory(backend circuitry.StorageBackender, opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	settings, _ := circuitry.NewFactorySettings(append(opts, circuitry.WithStorageBackend(backend))...)
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+var versionedPolicy = circuitry.OutcomePolicy{FailureCountThreshold: 2, CloseThreshold: 2, AllowAfter: time.Minute}
+
+func TestWithVersionedDynamoBackend(t *testing.T) {
+	client := newFakeDynamo()
+	opt := ddbbackend.WithVersionedDynamoBackend(client, "circuit_info")
+	s, err := circuitry.NewFactorySettings(opt)
+	if err != nil {
+		t.Fatalf("expected successful settings creation; got %v", err)
+	}
+	backend, ok := s.StorageBackend.(*ddbbackend.VersionedBackend)
+	if !ok {
+		t.Fatalf("expected configured StorageBackend to be a VersionedBackend; got %T", s.StorageBackend)
+	}
+	if backend.Client != client || backend.CircuitTableName != "circuit_info" || backend.LockClient != nil {
+		t.Fatalf("expected a backend without a lock client for circuit_info; got %+v", backend)
+	}
+	if _, err := circuitry.NewFactorySettings(opt, opt); !errors.Is(err, circuitry.ErrStorageBackendAlreadySet) {
+		t.Fatalf("expected err = circuitry.ErrStorageBackendAlreadySet; got %v", err)
+	}
+}
+
+func TestVersionedBackendCalls(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	breaker := newVersionedFactory(newVersionedBackend(client)).BreakerFor("calls", map[string]any{})
+	succeed := func() (any, error) { return nil, nil }
+
+	if _, _, err := breaker.Execute(ctx, succeed); err != nil {
+		t.Fatalf("expected to execute; got %v", err)
+	}
+	// The first request creates the circuit, then each request reads it,
+	// counts the request, and counts the outcome
+	for i := 0; i < 3; i++ {
+		if _, _, err := breaker.Execute(ctx, succeed); err != nil {
+			t.Fatalf("expected to execute; got %v", err)
+		}
+	}
+	if gets, updates := client.Calls("GetItem"), client.Calls("UpdateItem"); gets != 4 || updates != 8 {
+		t.Fatalf("expected 4 reads and 8 writes; got %d and %d", gets, updates)
+	}
+	item := client.Item("calls")
+	for attribute, expected := range map[string]string{"total": "4", "total_successes": "4", "consecutive_successes": "4", "consecutive_failures": "0", ddbbackend.VersionName: "8"} {
+		if actual := item[attribute].(*ddbtypes.AttributeValueMemberN).Value; actual != expected {
+			t.Fatalf("expected %s = %s; got %s", attribute, expected, actual)
+		}
+	}
+}
+
+func TestVersionedBackendMatchesCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	opts := []circuitry.SettingsOption{
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(2),
+		circuitry.WithAllowAfter(time.Millisecond),
+		circuitry.WithCyclicClearAfter(time.Hour),
+	}
+	locked := backends.NewInMemoryBackend()
+	versioned := newVersionedBackend(newFakeDynamo())
+	lockedBreaker := newVersionedFactory(locked, opts...).BreakerFor("parity", map[string]any{})
+	versionedBreaker := newVersionedFactory(versioned, opts...).BreakerFor("parity", map[string]any{})
+	workErr := errors.New("test")
+	outcomes := []error{nil, workErr, workErr, workErr, nil, nil, nil, workErr, nil, workErr, workErr, nil}
+
+	for i, outcome := range outcomes {
+		// Let open circuits become half-open
+		time.Sleep(2 * time.Millisecond)
+		_, _, lockedErr := lockedBreaker.Execute(ctx, func() (any, error) { return nil, outcome })
+		_, _, versionedErr := versionedBreaker.Execute(ctx, func() (any, error) { return nil, outcome })
+		if !errors.Is(versionedErr, lockedErr) {
+			t.Fatalf("expected step %d to end with %v; got %v", i, lockedErr, versionedErr)
+		}
+		expected, _ := locked.Retrieve(ctx, "parity")
+		actual, err := versioned.Retrieve(ctx, "parity")
+		if err != nil {
+			t.Fatalf("expected to retrieve step %d; got %v", i, err)
+		}
+		expected.ExpiresAfter, actual.ExpiresAfter = time.Time{}, time.Time{}
+		if actual != expected {
+			t.Fatalf("expected step %d to store %+v; got %+v", i, expected, actual)
+		}
+	}
+}
+
+func TestVersionedBackendConcurrentBreakers(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	backend := newVersionedBackend(client)
+	factory := newVersionedFactory(backend, circuitry.WithFailureCountThreshold(1000))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(failed bool) {
+			defer wg.Done()
+			breaker := factory.BreakerFor("concurrent", map[string]any{})
+			for j := 0; j < 10; j++ {
+				_, _, err := breaker.Execute(ctx, func() (any, error) {
+					if failed {
+						return nil, errors.New("test")
+					}
+					return nil, nil
+				})
+				if err != nil {
+					t.Errorf("expected to execute; got %v", err)
+				}
+			}
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	ci, err := backend.Retrieve(ctx, "concurrent")
+	if err != nil {
+		t.Fatalf("expected to retrieve the circuit; got %v", err)
+	}
+	if ci.State != circuitry.CircuitClosed || ci.Generation != 0 || ci.Total != 200 || ci.TotalFailures != 100 || ci.TotalSuccesses != 100 {
+		t.Fatalf("expected every request and outcome to be counted; got %+v", ci)
+	}
+}
+
+func TestVersionedBackendAdmitRetries(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	testCases := map[string]struct {
+		item     circuitry.CircuitInformation
+		conflict func(*fakeDynamo)
+		expected error
+		total    uint64
+	}{
+		"half-open admitted elsewhere": {
+			item: circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, Total: 1},
+			conflict: func(f *fakeDynamo) {
+				item := f.Item("retries")
+				item["total"] = intAttrValueMember(2)
+				f.SetItem("retries", item)
+			},
+			expected: circuitry.ErrTooManyRequests,
+			total:    2,
+		},
+		"tripped elsewhere": {
+			item: circuitry.CircuitInformation{Generation: 1, Total: 1},
+			conflict: func(f *fakeDynamo) {
+				item := ciToAVMap(circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: now.Add(time.Hour)})
+				item[ddbbackend.VersionName] = intAttrValueMember(1)
+				f.SetItem("retries", item)
+			},
+			expected: circuitry.ErrCircuitBreakerOpen,
+		},
+		"counted elsewhere": {
+			item: circuitry.CircuitInformation{Generation: 1, Total: 1},
+			conflict: func(f *fakeDynamo) {
+				item := f.Item("retries")
+				item["total"] = intAttrValueMember(5)
+				f.SetItem("retries", item)
+			},
+			total: 6,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newFakeDynamo()
+			item := ciToAVMap(tc.item)
+			item["expires_after"] = strAttrValueMember(tc.item.ExpiresAfter.Format(time.RFC3339Nano))
+			client.SetItem("retries", item)
+			client.beforeUpdate = tc.conflict
+			backend := newVersionedBackend(client)
+			_, after, err := backend.Admit(ctx, "retries", versionedPolicy, now)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected err = %v; got %v", tc.expected, err)
+			}
+			if after.Total != tc.total {
+				t.Fatalf("expected total = %d; got %+v", tc.total, after)
+			}
+			stored, _ := backend.Retrieve(ctx, "retries")
+			if stored.Total != tc.total {
+				t.Fatalf("expected %d requests to be stored; got %+v", tc.total, stored)
+			}
+		})
+	}
+}
+
+func TestVersionedBackendRecord(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	testCases := map[string]struct {
+		item       circuitry.CircuitInformation
+		generation uint64
+		status     circuitry.ExecutionStatus
+		conflict   func(*fakeDynamo)
+		before     circuitry.CircuitInformation
+		after      circuitry.CircuitInformation
+		updates    int
+	}{
+		"closed success": {
+			item:       circuitry.CircuitInformation{Generation: 1, ConsecutiveFailures: 1, Total: 2, TotalFailures: 1},
+			generation: 1,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{Generation: 1, ConsecutiveSuccesses: 1, Total: 2, TotalFailures: 1, TotalSuccesses: 1},
+			after:      circuitry.CircuitInformation{Generation: 1, ConsecutiveSuccesses: 1, Total: 2, TotalFailures: 1, TotalSuccesses: 1},
+			updates:    1,
+		},
+		"closed failure trips": {
+			item:       circuitry.CircuitInformation{Generation: 1, ConsecutiveFailures: 2, Total: 3, TotalFailures: 2},
+			generation: 1,
+			status:     circuitry.ExecutionFailed,
+			before:     circuitry.CircuitInformation{Generation: 1, ConsecutiveFailures: 3, Total: 3, TotalFailures: 3},
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: now.Add(time.Minute)},
+			updates:    2,
+		},
+		"closed failure tripped by a failure elsewhere": {
+			item:       circuitry.CircuitInformation{Generation: 1, ConsecutiveFailures: 1, Total: 3, TotalFailures: 1},
+			generation: 1,
+			status:     circuitry.ExecutionFailed,
+			conflict: func(f *fakeDynamo) {
+				item := f.Item("record")
+				item["consecutive_failures"] = intAttrValueMember(2)
+				f.SetItem("record", item)
+			},
+			before:  circuitry.CircuitInformation{Generation: 1, ConsecutiveFailures: 3, Total: 3, TotalFailures: 2},
+			after:   circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: now.Add(time.Minute)},
+			updates: 2,
+		},
+		"half-open success": {
+			item:       circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, Total: 2},
+			generation: 1,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+			updates:    2,
+		},
+		"half-open success closes": {
+			item:       circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+			generation: 1,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, ConsecutiveSuccesses: 2, Total: 2, TotalSuccesses: 2},
+			after:      circuitry.CircuitInformation{Generation: 2},
+			updates:    2,
+		},
+		"half-open failure": {
+			item:       circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, Total: 2},
+			generation: 1,
+			status:     circuitry.ExecutionFailed,
+			before:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 1, Total: 2},
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, Total: 2, ExpiresAfter: now.Add(time.Minute)},
+			updates:    2,
+		},
+		"other generation": {
+			item:       circuitry.CircuitInformation{Generation: 2, Total: 1},
+			generation: 1,
+			status:     circuitry.ExecutionFailed,
+			before:     circuitry.CircuitInformation{Generation: 2, Total: 1},
+			after:      circuitry.CircuitInformation{Generation: 2, Total: 1},
+			updates:    1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newFakeDynamo()
+			item := ciToAVMap(tc.item)
+			item["expires_after"] = strAttrValueMember(tc.item.ExpiresAfter.Format(time.RFC3339Nano))
+			client.SetItem("record", item)
+			client.beforeUpdate = tc.conflict
+			backend := newVersionedBackend(client)
+			before, after, err := backend.Record(ctx, "record", versionedPolicy, tc.generation, tc.status, now)
+			if err != nil {
+				t.Fatalf("expected to record the outcome; got %v", err)
+			}
+			deepEqCi(t, tc.before, before)
+			deepEqCi(t, tc.after, after)
+			stored, _ := backend.Retrieve(ctx, "record")
+			deepEqCi(t, tc.after, stored)
+			if updates := client.Calls("UpdateItem"); updates != tc.updates {
+				t.Fatalf("expected %d writes; got %d", tc.updates, updates)
+			}
+		})
+	}
+}
+
+func TestVersionedBackendRecordClosedExpressions(t *testing.T) {
+	testCases := map[string]struct {
+		status    circuitry.ExecutionStatus
+		condition string
+		update    string
+	}{
+		"success": {
+			status:    circuitry.ExecutionSucceeded,
+			condition: "generation = 3 AND state = 0",
+			update:    "ADD consecutive_successes 1, total_successes 1, version 1\nSET consecutive_failures = 0",
+		},
+		"failure": {
+			status:    circuitry.ExecutionFailed,
+			condition: "generation = 3 AND state = 0 AND consecutive_failures < 2",
+			update:    "ADD consecutive_failures 1, total_failures 1, version 1\nSET consecutive_successes = 0",
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newDDBMock()
+			client.AddUpdateItemOutput(&ddb.UpdateItemOutput{Attributes: ciToAVMap(circuitry.CircuitInformation{Generation: 3})})
+			backend := newVersionedBackend(client)
+			if _, _, err := backend.Record(context.TODO(), "fake-key", versionedPolicy, 3, tc.status, time.Now()); err != nil {
+				t.Fatalf("expected to record the outcome; got %v", err)
+			}
+			if len(client.getItemInputs) != 0 {
+				t.Fatalf("expected the outcome to be recorded without reading the circuit; got %d reads", len(client.getItemInputs))
+			}
+			input := client.updateItemInputs[0]
+			if condition := resolve(input, aws.ToString(input.ConditionExpression)); condition != tc.condition {
+				t.Fatalf("expected condition %q; got %q", tc.condition, condition)
+			}
+			if update := resolve(input, aws.ToString(input.UpdateExpression)); update != tc.update {
+				t.Fatalf("expected update %q; got %q", tc.update, update)
+			}
+			if input.ReturnValues != ddbtypes.ReturnValueAllNew {
+				t.Fatalf("expected the new item to be returned; got %q", input.ReturnValues)
+			}
+		})
+	}
+}
+
+// resolve replaces the placeholders in an expression with the names and
+// numbers they stand for and drops its parentheses
+func resolve(input *ddb.UpdateItemInput, expression string) string {
+	placeholders := make([]string, 0, len(input.ExpressionAttributeNames)+len(input.ExpressionAttributeValues))
+	replacements := map[string]string{}
+	for placeholder, name := range input.ExpressionAttributeNames {
+		placeholders = append(placeholders, placeholder)
+		replacements[placeholder] = name
+	}
+	for placeholder, value := range input.ExpressionAttributeValues {
+		if n, ok := value.(*ddbtypes.AttributeValueMemberN); ok {
+			placeholders = append(placeholders, placeholder)
+			replacements[placeholder] = n.Value
+		}
+	}
+	// Longer placeholders first so #1 does not replace part of #10
+	sort.Slice(placeholders, func(i, j int) bool { return len(placeholders[i]) > len(placeholders[j]) })
+	for _, placeholder := range placeholders {
+		expression = strings.ReplaceAll(expression, placeholder, replacements[placeholder])
+	}
+	return strings.TrimSpace(strings.NewReplacer("(", "", ")", "").Replace(expression))
+}
+
+func TestVersionedBackendRetriesExhausted(t *testing.T) {
+	client := newDDBMock()
+	for i := 0; i < 3; i++ {
+		client.AddGetItemOutput(&ddb.GetItemOutput{Item: ciToAVMap(circuitry.CircuitInformation{})})
+		client.AddUpdateItemError(&ddbtypes.ConditionalCheckFailedException{})
+	}
+	backend := newVersionedBackend(client)
+	backend.MaxAttempts = 3
+	_, _, err := backend.Admit(context.TODO(), "fake-key", versionedPolicy, time.Now())
+	var remote *ddbbackend.RemoteBackendError
+	var conditionFailed *ddbtypes.ConditionalCheckFailedException
+	if !errors.As(err, &remote) || remote.Operation != ddbbackend.OpUpdateItem || !errors.As(err, &conditionFailed) {
+		t.Fatalf("expected the failed condition wrapped in a RemoteBackendError; got %T(%v)", err, err)
+	}
+	if len(client.getItemInputs) != 3 || len(client.updateItemInputs) != 3 {
+		t.Fatalf("expected 3 attempts; got %d reads and %d writes", len(client.getItemInputs), len(client.updateItemInputs))
+	}
+	if !aws.ToBool(client.getItemInputs[0].ConsistentRead) {
+		t.Fatal("expected the circuit to be read consistently")
+	}
+}
+
+func TestVersionedBackendErrors(t *testing.T) {
+	backendErr := errors.New("test")
+	testCases := map[string]struct {
+		setup     func(*ddbMock)
+		call      func(*ddbbackend.VersionedBackend) error
+		operation ddbbackend.OperationType
+		local     bool
+	}{
+		"admit read": {
+			setup: func(m *ddbMock) { m.AddGetItemError(backendErr) },
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Admit(context.TODO(), "fake-key", versionedPolicy, time.Now())
+				return err
+			},
+			operation: ddbbackend.OpGetItem,
+		},
+		"admit unmarshal": {
+			setup: func(m *ddbMock) {
+				m.AddGetItemOutput(&ddb.GetItemOutput{Item: map[string]ddbtypes.AttributeValue{"total": strAttrValueMember("many")}})
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Admit(context.TODO(), "fake-key", versionedPolicy, time.Now())
+				return err
+			},
+			local: true,
+		},
+		"admit write": {
+			setup: func(m *ddbMock) {
+				m.AddGetItemOutput(&ddb.GetItemOutput{})
+				m.AddUpdateItemError(backendErr)
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Admit(context.TODO(), "fake-key", versionedPolicy, time.Now())
+				return err
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"admit returned item": {
+			setup: func(m *ddbMock) {
+				m.AddGetItemOutput(&ddb.GetItemOutput{Item: ciToAVMap(circuitry.CircuitInformation{})})
+				m.AddUpdateItemOutput(&ddb.UpdateItemOutput{Attributes: map[string]ddbtypes.AttributeValue{"total": strAttrValueMember("many")}})
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Admit(context.TODO(), "fake-key", versionedPolicy, time.Now())
+				return err
+			},
+			local: true,
+		},
+		"record write": {
+			setup: func(m *ddbMock) { m.AddUpdateItemError(backendErr) },
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Record(context.TODO(), "fake-key", versionedPolicy, 0, circuitry.ExecutionSucceeded, time.Now())
+				return err
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"record returned item": {
+			setup: func(m *ddbMock) {
+				m.AddUpdateItemOutput(&ddb.UpdateItemOutput{Attributes: map[string]ddbtypes.AttributeValue{"total": strAttrValueMember("many")}})
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Record(context.TODO(), "fake-key", versionedPolicy, 0, circuitry.ExecutionSucceeded, time.Now())
+				return err
+			},
+			local: true,
+		},
+		"record read": {
+			setup: func(m *ddbMock) {
+				m.AddUpdateItemError(&ddbtypes.ConditionalCheckFailedException{})
+				m.AddGetItemError(backendErr)
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, _, err := b.Record(context.TODO(), "fake-key", versionedPolicy, 0, circuitry.ExecutionSucceeded, time.Now())
+				return err
+			},
+			operation: ddbbackend.OpGetItem,
+		},
+		"store": {
+			setup: func(m *ddbMock) { m.AddUpdateItemError(backendErr) },
+			call: func(b *ddbbackend.VersionedBackend) error {
+				return b.Store(context.TODO(), "fake-key", circuitry.CircuitInformation{})
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"store fenced": {
+			setup: func(m *ddbMock) { m.AddUpdateItemError(backendErr) },
+			call: func(b *ddbbackend.VersionedBackend) error {
+				return b.StoreFenced(context.TODO(), "fake-key", circuitry.CircuitInformation{}, 1)
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"lock": {
+			setup: func(m *ddbMock) { m.AddUpdateItemError(backendErr) },
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, err := b.Lock(context.TODO(), "fake-key")
+				return err
+			},
+			operation: ddbbackend.OpUpdateItem,
+		},
+		"lock version": {
+			setup: func(m *ddbMock) {
+				m.AddUpdateItemOutput(&ddb.UpdateItemOutput{Attributes: map[string]ddbtypes.AttributeValue{ddbbackend.VersionName: strAttrValueMember("one")}})
+			},
+			call: func(b *ddbbackend.VersionedBackend) error {
+				_, err := b.Lock(context.TODO(), "fake-key")
+				return err
+			},
+			local: true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			client := newDDBMock()
+			tc.setup(client)
+			err := tc.call(newVersionedBackend(client))
+			if tc.local {
+				var local *ddbbackend.LocalBackendError
+				if !errors.As(err, &local) {
+					t.Fatalf("expected a LocalBackendError; got %T(%v)", err, err)
+				}
+				return
+			}
+			var remote *ddbbackend.RemoteBackendError
+			if !errors.As(err, &remote) || remote.Operation != tc.operation || !errors.Is(err, backendErr) {
+				t.Fatalf("expected a RemoteBackendError for %s wrapping %v; got %T(%v)", tc.operation, backendErr, err, err)
+			}
+		})
+	}
+}
+
+func TestVersionedBackendLock(t *testing.T) {
+	ctx := context.Background()
+	client := newFakeDynamo()
+	backend := newVersionedBackend(client)
+	ci := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 4, ExpiresAfter: time.Now().Add(time.Hour)}
+
+	lock, err := backend.Lock(ctx, "lock")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	lease.Lock()
+	if lease.Token() != 1 || lease.Err() != nil {
+		t.Fatalf("expected a lease with token 1; got %d and %v", lease.Token(), lease.Err())
+	}
+	if err := backend.StoreFenced(ctx, "lock", ci, lease.Token()); err != nil {
+		t.Fatalf("expected to store with the lease's token; got %v", err)
+	}
+	stored, _ := backend.Retrieve(ctx, "lock")
+	deepEqCi(t, ci, stored)
+	lease.Unlock()
+	if err := lease.Release(ctx); err != nil {
+		t.Fatalf("expected releasing to do nothing; got %v", err)
+	}
+
+	next, _ := backend.Lock(ctx, "lock")
+	if token := next.(circuitry.Lease).Token(); token != 3 {
+		t.Fatalf("expected the stored circuit's version to be incremented; got token %d", token)
+	}
+	if err := backend.StoreFenced(ctx, "lock", ci, lease.Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost with a stale token; got %v", err)
+	}
+	if err := backend.Store(ctx, "lock", circuitry.CircuitInformation{Generation: 5}); err != nil {
+		t.Fatalf("expected to store; got %v", err)
+	}
+	if version := client.Item("lock")[ddbbackend.VersionName].(*ddbtypes.AttributeValueMemberN).Value; version != "4" {
+		t.Fatalf("expected Store to increment the version; got %s", version)
+	}
+	if err := backend.StoreFenced(ctx, "lock", ci, 3); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost after another store; got %v", err)
+	}
+}
+
+func TestVersionedBackendStoreFencedAfterCounting(t *testing.T) {
+	testCases := map[string]func(*ddbbackend.VersionedBackend) error{
+		"admit": func(b *ddbbackend.VersionedBackend) error {
+			_, _, err := b.Admit(context.Background(), "fenced", versionedPolicy, time.Now())
+			return err
+		},
+		"record": func(b *ddbbackend.VersionedBackend) error {
+			_, _, err := b.Record(context.Background(), "fenced", versionedPolicy, 1, circuitry.ExecutionSucceeded, time.Now())
+			return err
+		},
+	}
+
+	for name, testCase := range testCases {
+		count := testCase
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			backend := newVersionedBackend(newFakeDynamo())
+			if err := backend.Store(ctx, "fenced", circuitry.CircuitInformation{Generation: 1}); err != nil {
+				t.Fatalf("expected to store; got %v", err)
+			}
+			lock, err := backend.Lock(ctx, "fenced")
+			if err != nil {
+				t.Fatalf("expected to lock; got %v", err)
+			}
+			if err := count(backend); err != nil {
+				t.Fatalf("expected to count; got %v", err)
+			}
+			if err := backend.StoreFenced(ctx, "fenced", circuitry.CircuitInformation{Generation: 1}, lock.(circuitry.Lease).Token()); !errors.Is(err, circuitry.ErrLockLost) {
+				t.Fatalf("expected ErrLockLost after counting; got %v", err)
+			}
+			if ci, _ := backend.Retrieve(ctx, "fenced"); ci.Total+ci.TotalSuccesses != 1 {
+				t.Fatalf("expected the count to be kept; got %+v", ci)
+			}
+		})
+	}
+}
+
+func TestVersionedBackendReset(t *testing.T) {
+	ctx := context.Background()
+	backend := newVersionedBackend(newFakeDynamo())
+	breaker := newVersionedFactory(backend).BreakerFor("reset", map[string]any{})
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected to execute; got %v", err)
+	}
+	if err := breaker.Reset(ctx); err != nil {
+		t.Fatalf("expected to reset the circuit; got %v", err)
+	}
+	ci, _ := backend.Retrieve(ctx, "reset")
+	if ci.Generation != 1 || ci.Total != 0 {
+		t.Fatalf("expected a new generation; got %+v", ci)
+	}
+}
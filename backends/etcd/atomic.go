@@ -0,0 +1,141 @@
+package etcd
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// DefaultMaxAttempts is how many times Admit and Record try their
+// transactions when the Backend's MaxAttempts is not set
+const DefaultMaxAttempts = 10
+
+// DefaultRetryInterval is how long Admit and Record wait, at most, after
+// their first conflicting transaction when the Backend's RetryInterval is not
+// set
+const DefaultRetryInterval = 10 * time.Millisecond
+
+// DefaultMaxRetryInterval is the longest Admit and Record wait between
+// attempts when the Backend's MaxRetryInterval is not set
+const DefaultMaxRetryInterval = time.Second
+
+func (b *Backend) maxAttempts() int {
+	if b.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return b.MaxAttempts
+}
+
+func unchanged(a, b circuitry.CircuitInformation) bool {
+	expired := a.ExpiresAfter.Equal(b.ExpiresAfter)
+	a.ExpiresAfter, b.ExpiresAfter = time.Time{}, time.Time{}
+	return expired && a == b
+}
+
+// wait sleeps for a random duration of up to RetryInterval, doubled for each
+// attempt after the first until it reaches MaxRetryInterval, so processes
+// that conflicted spread their next attempts out, or until the context is
+// done
+func (b *Backend) wait(ctx context.Context, attempt int) error {
+	interval, maxInterval := b.RetryInterval, b.MaxRetryInterval
+	if interval <= 0 {
+		interval = DefaultRetryInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = DefaultMaxRetryInterval
+	}
+	for i := 1; i < attempt && interval < maxInterval; i++ {
+		// Doubling an interval past half of the maximum could overflow
+		if interval > maxInterval/2 {
+			interval = maxInterval
+			break
+		}
+		interval *= 2
+	}
+	timer := time.NewTimer(rand.N(min(interval, maxInterval)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// update reads the circuit, applies fn to it, and writes the result in a
+// transaction on the condition that the circuit's ModRevision is the one it
+// was read with. A missing circuit has a ModRevision of 0. When another
+// process wrote the circuit in between, it waits and tries again, up to
+// MaxAttempts times. Results that do not change the circuit are not
+// written.
+func (b *Backend) update(ctx context.Context, name string, fn func(circuitry.CircuitInformation) circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+	key := b.circuitKey(name)
+	for attempt := 0; attempt < b.maxAttempts(); attempt++ {
+		if attempt > 0 {
+			if err := b.wait(ctx, attempt); err != nil {
+				return circuitry.CircuitInformation{}, err
+			}
+		}
+		response, err := b.Client.Get(ctx, key)
+		if err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		current, revision, err := b.decode(response.Kvs)
+		if err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		after := fn(current)
+		if unchanged(current, after) {
+			return after, nil
+		}
+		data, err := b.codec().Marshal(after)
+		if err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		written, err := b.Client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+			Then(clientv3.OpPut(key, string(data))).
+			Commit()
+		if err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		if written.Succeeded {
+			return after, nil
+		}
+	}
+	return circuitry.CircuitInformation{}, ErrConflict
+}
+
+// Admit reads the circuit and applies the policy to it, writing the result
+// unless the circuit has been written since. Rejections that do not change
+// the circuit are not written.
+func (b *Backend) Admit(ctx context.Context, name string, policy circuitry.OutcomePolicy, now time.Time) (before, after circuitry.CircuitInformation, admitErr error) {
+	after, err := b.update(ctx, name, func(ci circuitry.CircuitInformation) circuitry.CircuitInformation {
+		var admitted circuitry.CircuitInformation
+		before, admitted, admitErr = policy.Admit(ci, now)
+		return admitted
+	})
+	if err != nil {
+		return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	return before, after, admitErr
+}
+
+// Record reads the circuit and counts the outcome, changing its state if the
+// policy says so, writing the result unless the circuit has been written
+// since. Outcomes from other generations are not written.
+func (b *Backend) Record(ctx context.Context, name string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (before, after circuitry.CircuitInformation, err error) {
+	after, err = b.update(ctx, name, func(ci circuitry.CircuitInformation) circuitry.CircuitInformation {
+		var recorded circuitry.CircuitInformation
+		before, recorded = policy.Record(ci, generation, status, now)
+		return recorded
+	})
+	if err != nil {
+		return circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	return before, after, nil
+}
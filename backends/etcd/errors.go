@@ -0,0 +1,11 @@
+package etcd
+
+import "errors"
+
+// ErrConflict is returned by Admit and Record when other processes wrote the
+// circuit between each of MaxAttempts reads and the writes that followed
+var ErrConflict = errors.New("etcd backend could not update circuit without conflicting writes")
+
+// ErrWatchClosed is returned by WatchTransitions when its watch ends before
+// the context is done, e.g., because the client was closed
+var ErrWatchClosed = errors.New("etcd backend watch was closed")
@@ -0,0 +1,306 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"math"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+// DefaultKeyPrefix is prepended to every key when the Backend's KeyPrefix is
+// empty
+const DefaultKeyPrefix = "circuitry/"
+
+// DefaultLockTTL is the TTL of the lease behind each lock when the Backend's
+// LockTTL is not set
+const DefaultLockTTL = 60 * time.Second
+
+// listPageSize is the number of circuits requested from each range when
+// listing circuits
+const listPageSize = 100
+
+// Backend implements the StorageBackender interface for etcd using
+// [go.etcd.io/etcd/client/v3]. Each circuit is stored in its own key, which
+// Admit and Record update in transactions conditioned on its ModRevision.
+type Backend struct {
+	Client           *clientv3.Client
+	KeyPrefix        string        // KeyPrefix is prepended to every key so that applications sharing an etcd cluster do not collide. It defaults to DefaultKeyPrefix.
+	LockTTL          time.Duration // LockTTL is the TTL of the lease behind each lock, rounded up to whole seconds. It defaults to DefaultLockTTL.
+	MaxAttempts      int           // MaxAttempts is how many times Admit and Record try their transactions when other processes write the circuit. It defaults to DefaultMaxAttempts.
+	RetryInterval    time.Duration // RetryInterval is how long Admit and Record wait, at most, after their first conflicting transaction, doubling with each attempt up to MaxRetryInterval. It defaults to DefaultRetryInterval.
+	MaxRetryInterval time.Duration // MaxRetryInterval caps how long Admit and Record wait between attempts. It defaults to DefaultMaxRetryInterval.
+	Codec            codec.Codec   // Codec serializes circuits' information. It defaults to codec.JSON, and circuits stored with any of the codec package's codecs are read.
+}
+
+func (b *Backend) codec() codec.Codec {
+	if b.Codec == nil {
+		return codec.JSON
+	}
+	return b.Codec
+}
+
+func (b *Backend) keyPrefix() string {
+	if b.KeyPrefix == "" {
+		return DefaultKeyPrefix
+	}
+	return b.KeyPrefix
+}
+
+// circuitPrefix is the prefix of every key holding a circuit's information
+func (b *Backend) circuitPrefix() string {
+	return b.keyPrefix() + "circuits/"
+}
+
+func (b *Backend) circuitKey(name string) string {
+	return b.circuitPrefix() + name
+}
+
+// lockPrefix is the prefix of the keys a circuit's mutex is held with. The
+// name is escaped because a mutex waits on every key under its prefix, so
+// the lock for "a" must not wait on the lock for "a/b".
+func (b *Backend) lockPrefix(name string) string {
+	return b.keyPrefix() + "locks/" + url.PathEscape(name)
+}
+
+func (b *Backend) fenceKey(name string) string {
+	return b.keyPrefix() + "fences/" + name
+}
+
+// fence formats a fencing token so that tokens compare as they would as
+// numbers when etcd compares them as bytes
+func fence(token uint64) string {
+	return fmt.Sprintf("%020d", token)
+}
+
+func (b *Backend) lockTTL() int {
+	ttl := b.LockTTL
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	return int(math.Ceil(ttl.Seconds()))
+}
+
+// decode returns the information in the first of the key-values from a range
+// and its ModRevision, or empty information and 0 if there were none
+func (b *Backend) decode(kvs []*mvccpb.KeyValue) (circuitry.CircuitInformation, int64, error) {
+	if len(kvs) == 0 {
+		return circuitry.CircuitInformation{}, 0, nil
+	}
+	ci, err := codec.Unmarshal(kvs[0].Value, b.Codec)
+	if err != nil {
+		return circuitry.CircuitInformation{}, 0, err
+	}
+	return ci, kvs[0].ModRevision, nil
+}
+
+// Store saves the CircuitInformation in etcd under the circuit's key after
+// serializing it with the Codec
+func (b *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
+	data, err := b.codec().Marshal(ci)
+	if err != nil {
+		return err
+	}
+	_, err = b.Client.Put(ctx, b.circuitKey(name), string(data))
+	return err
+}
+
+// StoreFenced saves the CircuitInformation like Store in a transaction that
+// only writes it if no lock with a greater fencing token has been obtained
+// for the circuit, and otherwise returns
+// [github.com/sigmavirus24/circuitry.ErrLockLost]
+func (b *Backend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	data, err := b.codec().Marshal(ci)
+	if err != nil {
+		return err
+	}
+	// Comparing the value of a missing key fails, so circuits that were
+	// never locked are stored
+	response, err := b.Client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(b.fenceKey(name)), ">", fence(token))).
+		Else(clientv3.OpPut(b.circuitKey(name), string(data))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if response.Succeeded {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+// Retrieve gets the circuit's key and returns its value after deserializing
+// it with the codec it was stored with. If the key is not present in etcd,
+// this will return an empty
+// [github.com/sigmavirus24/circuitry.CircuitInformation].
+func (b *Backend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
+	response, err := b.Client.Get(ctx, b.circuitKey(name))
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	ci, _, err := b.decode(response.Kvs)
+	return ci, err
+}
+
+// Delete removes the circuit's key from etcd. Its fencing token is kept so
+// tokens keep increasing if the circuit is locked again.
+func (b *Backend) Delete(ctx context.Context, name string) error {
+	_, err := b.Client.Delete(ctx, b.circuitKey(name))
+	return err
+}
+
+// List ranges over the keys of circuits whose names start with the prefix,
+// a page at a time in order of name, and yields those whose values
+// deserialize to CircuitInformation
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		key := b.circuitKey(prefix)
+		end := clientv3.GetPrefixRangeEnd(key)
+		for {
+			response, err := b.Client.Get(ctx, key, clientv3.WithRange(end), clientv3.WithLimit(listPageSize))
+			if err != nil {
+				yield(circuitry.CircuitEntry{}, err)
+				return
+			}
+			for _, kv := range response.Kvs {
+				ci, err := codec.Unmarshal(kv.Value, b.Codec)
+				if err != nil {
+					continue
+				}
+				name := strings.TrimPrefix(string(kv.Key), b.circuitPrefix())
+				if !yield(circuitry.CircuitEntry{Name: name, Information: ci}, nil) {
+					return
+				}
+			}
+			if !response.More {
+				return
+			}
+			key = string(response.Kvs[len(response.Kvs)-1].Key) + "\x00"
+		}
+	}
+}
+
+// etcdLock is a lease on a [concurrency.Mutex] held with a session whose
+// etcd lease is kept alive until it is released
+type etcdLock struct {
+	ctx      context.Context
+	session  *concurrency.Session
+	mutex    *concurrency.Mutex
+	token    uint64
+	released atomic.Bool
+}
+
+func (l *etcdLock) Lock() {}
+
+// Unlock releases the lock with the context it was obtained with, even if
+// that context has since been canceled
+func (l *etcdLock) Unlock() {
+	_ = l.Release(context.WithoutCancel(l.ctx))
+}
+
+// Release unlocks the mutex and closes the session, revoking its etcd lease.
+// It returns [github.com/sigmavirus24/circuitry.ErrLockLost] if the lease
+// had already expired. Releasing the lock again does nothing.
+func (l *etcdLock) Release(ctx context.Context) error {
+	if l.released.Swap(true) {
+		return nil
+	}
+	lost := l.Err()
+	err := l.mutex.Unlock(ctx)
+	if closeErr := l.session.Close(); err == nil {
+		err = closeErr
+	}
+	if lost != nil {
+		return lost
+	}
+	return err
+}
+
+func (l *etcdLock) Token() uint64 {
+	return l.token
+}
+
+// Err returns [github.com/sigmavirus24/circuitry.ErrLockLost] once the
+// session's etcd lease can no longer be kept alive, e.g., because it expired
+// while etcd was unreachable
+func (l *etcdLock) Err() error {
+	select {
+	case <-l.session.Done():
+		return circuitry.ErrLockLost
+	default:
+		return nil
+	}
+}
+
+var _ circuitry.Lease = (*etcdLock)(nil)
+
+// Lock obtains the circuit's [concurrency.Mutex] with a session whose etcd
+// lease has the LockTTL and is kept alive until the lock is released, waiting
+// until the context is done if another process holds it. The fencing token
+// is the revision etcd was at when the mutex was obtained, which increases
+// from one holder to the next, and is recorded in the circuit's fence key for
+// StoreFenced.
+func (b *Backend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	// The caller's context ending does not release the lock so it must not
+	// stop the lease from being kept alive either
+	session, err := concurrency.NewSession(b.Client, concurrency.WithTTL(b.lockTTL()), concurrency.WithContext(context.WithoutCancel(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	mutex := concurrency.NewMutex(session, b.lockPrefix(name))
+	if err := mutex.Lock(ctx); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	lock := &etcdLock{ctx: ctx, session: session, mutex: mutex, token: uint64(mutex.Header().Revision)}
+	if err := b.issueToken(ctx, name, lock); err != nil {
+		_ = lock.Release(ctx)
+		return nil, err
+	}
+	return lock, nil
+}
+
+// issueToken writes the lock's token to the circuit's fence key on the
+// condition that the lock is still held
+func (b *Backend) issueToken(ctx context.Context, name string, lock *etcdLock) error {
+	response, err := b.Client.Txn(ctx).
+		If(lock.mutex.IsOwner()).
+		Then(clientv3.OpPut(b.fenceKey(name), fence(lock.token))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !response.Succeeded {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+var _ circuitry.StorageBackender = (*Backend)(nil)
+var _ circuitry.Lister = (*Backend)(nil)
+var _ circuitry.Deleter = (*Backend)(nil)
+var _ circuitry.FencedStorer = (*Backend)(nil)
+var _ circuitry.OutcomeRecorder = (*Backend)(nil)
+var _ circuitry.TransitionWatcher = (*Backend)(nil)
+
+// New builds a new Backend for circuitry that uses an existing client
+func New(client *clientv3.Client) *Backend {
+	return &Backend{Client: client}
+}
+
+// WithEtcdBackend provides a way to configure the StorageBackend for a
+// Circuit Breaker Factory's settings with an existing client
+func WithEtcdBackend(client *clientv3.Client) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(New(client))
+}
@@ -0,0 +1,532 @@
+package etcd_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+
+	"github.com/sigmavirus24/circuitry"
+	etcdbackend "github.com/sigmavirus24/circuitry/backends/etcd"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+// startEtcd starts an embedded etcd server listening on random ports and
+// returns its client URL
+func startEtcd(t *testing.T) string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "panic"
+	cfg.UnsafeNoFsync = true
+	local := url.URL{Scheme: "http", Host: "127.0.0.1:0"}
+	cfg.ListenClientUrls = []url.URL{local}
+	cfg.AdvertiseClientUrls = []url.URL{local}
+	cfg.ListenPeerUrls = []url.URL{local}
+	cfg.AdvertisePeerUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:2380"}}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("expected to start etcd; got %v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected etcd to be ready")
+	}
+	return "http://" + server.Clients[0].Addr().String()
+}
+
+func newClient(t *testing.T, endpoint string) *clientv3.Client {
+	t.Helper()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second, Logger: zap.NewNop()})
+	if err != nil {
+		t.Fatalf("expected to connect to etcd; got %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func newBackend(t *testing.T) *etcdbackend.Backend {
+	t.Helper()
+	return etcdbackend.New(newClient(t, startEtcd(t)))
+}
+
+func TestBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	expected := circuitry.CircuitInformation{
+		State:                circuitry.CircuitHalfOpen,
+		Generation:           7,
+		ConsecutiveFailures:  3,
+		ConsecutiveSuccesses: 2,
+		Total:                40,
+		TotalFailures:        25,
+		TotalSuccesses:       15,
+		ExpiresAfter:         time.Date(2024, time.December, 19, 8, 30, 15, 123456789, time.UTC),
+	}
+
+	actual, err := backend.Retrieve(ctx, "circuit")
+	if err != nil || actual != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected empty information for a circuit that was never stored; got %+v, %v", actual, err)
+	}
+	for _, c := range []codec.Codec{nil, codec.Binary} {
+		backend.Codec = c
+		for _, ci := range []circuitry.CircuitInformation{expected, {Generation: 8}} {
+			if err := backend.Store(ctx, "circuit", ci); err != nil {
+				t.Fatalf("expected to store; got %v", err)
+			}
+			actual, err := backend.Retrieve(ctx, "circuit")
+			if err != nil {
+				t.Fatalf("expected to retrieve; got %v", err)
+			}
+			if !actual.ExpiresAfter.Equal(ci.ExpiresAfter) {
+				t.Fatalf("expected ExpiresAfter %v; got %v", ci.ExpiresAfter, actual.ExpiresAfter)
+			}
+			actual.ExpiresAfter = ci.ExpiresAfter
+			if actual != ci {
+				t.Fatalf("expected %+v; got %+v", ci, actual)
+			}
+		}
+	}
+
+	stored, err := backend.Client.Get(ctx, etcdbackend.DefaultKeyPrefix+"circuits/circuit")
+	if err != nil || len(stored.Kvs) != 1 {
+		t.Fatalf("expected the circuit under the default key prefix; got %v, %v", stored, err)
+	}
+	if _, err := backend.Client.Put(ctx, etcdbackend.DefaultKeyPrefix+"circuits/circuit", "not a circuit"); err != nil {
+		t.Fatalf("expected to put; got %v", err)
+	}
+	if _, err := backend.Retrieve(ctx, "circuit"); err == nil {
+		t.Fatalf("expected an error retrieving a value that is not a circuit")
+	}
+}
+
+func TestBackendListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	backend.KeyPrefix = "app/"
+	for i, name := range []string{"tenant-a", "tenant-b", "Tenant-c", "tenant/d", "other"} {
+		if err := backend.Store(ctx, name, circuitry.CircuitInformation{Total: uint64(i)}); err != nil {
+			t.Fatalf("expected to store %s; got %v", name, err)
+		}
+	}
+	for i := range 150 {
+		if err := backend.Store(ctx, fmt.Sprintf("page/%03d", i), circuitry.CircuitInformation{}); err != nil {
+			t.Fatalf("expected to store; got %v", err)
+		}
+	}
+	if _, err := backend.Client.Put(ctx, "app/circuits/tenant-x", "not a circuit"); err != nil {
+		t.Fatalf("expected to put; got %v", err)
+	}
+	list := func(prefix string) []string {
+		var names []string
+		for entry, err := range backend.List(ctx, prefix) {
+			if err != nil {
+				t.Fatalf("expected to list; got %v", err)
+			}
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	testCases := map[string]struct {
+		prefix   string
+		expected []string
+	}{
+		"case sensitive":   {prefix: "tenant", expected: []string{"tenant-a", "tenant-b", "tenant/d"}},
+		"slashes":          {prefix: "tenant/", expected: []string{"tenant/d"}},
+		"no matching keys": {prefix: "missing", expected: nil},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if actual := list(tc.prefix); fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v; got %v", tc.expected, actual)
+			}
+		})
+	}
+	if pages := list("page/"); len(pages) != 150 || pages[149] != "page/149" {
+		t.Fatalf("expected every page of circuits; got %d ending with %v", len(pages), pages[len(pages)-1])
+	}
+	if everything := list(""); len(everything) != 155 {
+		t.Fatalf("expected 155 circuits; got %d", len(everything))
+	}
+
+	for range backend.List(ctx, "") {
+		break
+	}
+	lock, err := backend.Lock(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	defer lock.Unlock()
+	if err := backend.Delete(ctx, "tenant-a"); err != nil {
+		t.Fatalf("expected to delete; got %v", err)
+	}
+	if err := backend.Delete(ctx, "tenant-a"); err != nil {
+		t.Fatalf("expected deleting a missing circuit to succeed; got %v", err)
+	}
+	if names := list("tenant-"); fmt.Sprint(names) != "[tenant-b]" {
+		t.Fatalf("expected tenant-a to be deleted; got %v", names)
+	}
+	// the fencing token outlives the circuit's information
+	if err := backend.StoreFenced(ctx, "tenant-a", circuitry.CircuitInformation{}, lock.(circuitry.Lease).Token()-1); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+}
+
+func TestBackendLock(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	lease.Lock()
+	if lease.Token() == 0 || lease.Err() != nil {
+		t.Fatalf("expected a token and no error; got %d, %v", lease.Token(), lease.Err())
+	}
+	for _, name := range []string{"other", "circuit/nested"} {
+		other, err := backend.Lock(ctx, name)
+		if err != nil {
+			t.Fatalf("expected to lock %s while circuit is locked; got %v", name, err)
+		}
+		other.Unlock()
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := backend.Lock(timeout, "circuit"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	released := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		if err := lease.Release(ctx); err != nil {
+			t.Errorf("expected to release; got %v", err)
+		}
+		close(released)
+	})
+	next, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock once the lock is released; got %v", err)
+	}
+	<-released
+	current := next.(circuitry.Lease)
+	if current.Token() <= lease.Token() {
+		t.Fatalf("expected a token greater than %d; got %d", lease.Token(), current.Token())
+	}
+
+	stored := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Total: 1}
+	if err := backend.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{Total: 99}, lease.Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+	if err := backend.StoreFenced(ctx, "circuit", stored, current.Token()); err != nil {
+		t.Fatalf("expected to store fenced; got %v", err)
+	}
+	if err := backend.StoreFenced(ctx, "never-locked", stored, 0); err != nil {
+		t.Fatalf("expected to store a circuit that was never locked; got %v", err)
+	}
+	if actual, err := backend.Retrieve(ctx, "circuit"); err != nil || actual != stored {
+		t.Fatalf("expected %+v; got %+v, %v", stored, actual, err)
+	}
+	next.Unlock()
+}
+
+func TestBackendLockLost(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	backend.LockTTL = time.Second
+
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	stale := lock.(circuitry.Lease)
+	held, err := backend.Client.Get(ctx, etcdbackend.DefaultKeyPrefix+"locks/", clientv3.WithPrefix())
+	if err != nil || len(held.Kvs) != 1 {
+		t.Fatalf("expected one lock key; got %v, %v", held, err)
+	}
+	if _, err := backend.Client.Revoke(ctx, clientv3.LeaseID(held.Kvs[0].Lease)); err != nil {
+		t.Fatalf("expected to revoke the lock's lease; got %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for stale.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(stale.Err(), circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, stale.Err())
+	}
+
+	next, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock once the lease is revoked; got %v", err)
+	}
+	defer next.Unlock()
+	if err := backend.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{Total: 99}, stale.Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+	if err := stale.Release(ctx); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v releasing the stale lock; got %v", circuitry.ErrLockLost, err)
+	}
+}
+
+func TestBackendConcurrentBreakers(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	circuitrytest.ConcurrentBreakers(t, backend)
+	held, err := backend.Client.Get(ctx, etcdbackend.DefaultKeyPrefix+"locks/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil || held.Count != 0 {
+		t.Fatalf("expected no locks to be taken; got %v, %v", held, err)
+	}
+}
+
+func TestBackendMatchesInMemoryBackend(t *testing.T) {
+	circuitrytest.MatchesInMemoryBackend(t, newBackend(t))
+}
+
+func TestBackendReleaseTwice(t *testing.T) {
+	circuitrytest.ReleaseTwice(t, newBackend(t))
+}
+
+// conflictingCodec stores the circuit with another backend each time it
+// marshals information, so every transaction that follows conflicts
+type conflictingCodec struct {
+	codec.Codec
+	other    *etcdbackend.Backend
+	marshals int
+}
+
+func (c *conflictingCodec) Marshal(ci circuitry.CircuitInformation) ([]byte, error) {
+	c.marshals++
+	if err := c.other.Store(context.Background(), "circuit", circuitry.CircuitInformation{Total: 100 + uint64(c.marshals)}); err != nil {
+		return nil, err
+	}
+	return c.Codec.Marshal(ci)
+}
+
+func TestBackendConflicts(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	conflicting := &conflictingCodec{Codec: codec.JSON, other: etcdbackend.New(backend.Client)}
+	backend.Codec = conflicting
+	backend.MaxAttempts = 3
+	policy := circuitry.OutcomePolicy{FailureCountThreshold: 5, CloseThreshold: 1}
+
+	if _, _, err := backend.Admit(ctx, "circuit", policy, time.Now()); !errors.Is(err, etcdbackend.ErrConflict) {
+		t.Fatalf("expected %v; got %v", etcdbackend.ErrConflict, err)
+	}
+	if conflicting.marshals != 3 {
+		t.Fatalf("expected 3 attempts; got %d", conflicting.marshals)
+	}
+	if _, _, err := backend.Record(ctx, "circuit", policy, 0, circuitry.ExecutionFailed, time.Now()); !errors.Is(err, etcdbackend.ErrConflict) {
+		t.Fatalf("expected %v; got %v", etcdbackend.ErrConflict, err)
+	}
+	if conflicting.marshals != 6 {
+		t.Fatalf("expected 3 more attempts; got %d", conflicting.marshals)
+	}
+	backend.RetryInterval = time.Hour
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := backend.Admit(timeout, "circuit", policy, time.Now()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to stop waiting to try again with %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	// the wait stops doubling at MaxRetryInterval however many attempts are
+	// made
+	backend.RetryInterval, backend.MaxRetryInterval, backend.MaxAttempts = time.Microsecond, 3*time.Microsecond, 70
+	conflicting.marshals = 0
+	if _, _, err := backend.Admit(ctx, "circuit", policy, time.Now()); !errors.Is(err, etcdbackend.ErrConflict) {
+		t.Fatalf("expected %v; got %v", etcdbackend.ErrConflict, err)
+	}
+	if conflicting.marshals != 70 {
+		t.Fatalf("expected 70 attempts; got %d", conflicting.marshals)
+	}
+
+	backend.Codec = nil
+	if _, _, err := backend.Record(ctx, "circuit", policy, 1, circuitry.ExecutionFailed, time.Now()); err != nil {
+		t.Fatalf("expected an outcome from another generation to be discarded; got %v", err)
+	}
+	before, after, err := backend.Record(ctx, "circuit", policy, 0, circuitry.ExecutionFailed, time.Now())
+	if err != nil || before.TotalFailures != 1 || after.TotalFailures != 1 {
+		t.Fatalf("expected the failure to be recorded; got %+v, %+v, %v", before, after, err)
+	}
+	if _, err := backend.Client.Put(ctx, etcdbackend.DefaultKeyPrefix+"circuits/circuit", "not a circuit"); err != nil {
+		t.Fatalf("expected to put; got %v", err)
+	}
+	if _, _, err := backend.Admit(ctx, "circuit", policy, time.Now()); err == nil {
+		t.Fatalf("expected an error admitting to a value that is not a circuit")
+	}
+}
+
+func TestWatchTransitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := newBackend(t)
+	if err := backend.Store(ctx, "before", circuitry.CircuitInformation{State: circuitry.CircuitOpen}); err != nil {
+		t.Fatalf("expected to store; got %v", err)
+	}
+
+	events := make(chan circuitry.TransitionEvent, 10)
+	watched := make(chan error, 1)
+	go func() {
+		watched <- backend.WatchTransitions(ctx, func(event circuitry.TransitionEvent) { events <- event })
+	}()
+
+	opened := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, ConsecutiveFailures: 2}
+	writes := []func() error{
+		func() error { return backend.Store(ctx, "circuit", circuitry.CircuitInformation{Total: 1}) },
+		func() error { return backend.Store(ctx, "circuit", opened) },
+		func() error { return backend.Store(ctx, "circuit", opened) },
+		func() error {
+			_, err := backend.Client.Put(ctx, etcdbackend.DefaultKeyPrefix+"circuits/circuit", "not a circuit")
+			return err
+		},
+		func() error {
+			return backend.Store(ctx, "circuit", circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen})
+		},
+		func() error { return backend.Delete(ctx, "circuit") },
+		func() error {
+			return backend.Store(ctx, "new", circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1})
+		},
+	}
+	for _, write := range writes {
+		if err := write(); err != nil {
+			t.Fatalf("expected to write; got %v", err)
+		}
+	}
+
+	expected := []circuitry.TransitionEvent{
+		{Name: "circuit", From: circuitry.CircuitClosed, To: circuitry.CircuitOpen, Generation: 1, Information: circuitry.CircuitInformation{Total: 1}},
+		{Name: "new", From: circuitry.CircuitClosed, To: circuitry.CircuitOpen, Generation: 1},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Time.IsZero() {
+				t.Fatalf("expected the event to have a time")
+			}
+			event.Time = time.Time{}
+			if fmt.Sprintf("%+v", event) != fmt.Sprintf("%+v", want) {
+				t.Fatalf("expected %+v; got %+v", want, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %+v; got nothing", want)
+		}
+	}
+	cancel()
+	if err := <-watched; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v; got %v", context.Canceled, err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected no more events; got %+v", event)
+	default:
+	}
+}
+
+func TestWatchTransitionsClosed(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+
+	watched := make(chan error, 1)
+	go func() {
+		watched <- backend.WatchTransitions(ctx, func(circuitry.TransitionEvent) {})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_ = backend.Client.Close()
+	select {
+	case err := <-watched:
+		if !errors.Is(err, etcdbackend.ErrWatchClosed) {
+			t.Fatalf("expected %v; got %v", etcdbackend.ErrWatchClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the watch to end")
+	}
+
+}
+
+// failingCodec returns errTest from Marshal
+type failingCodec struct{ codec.Codec }
+
+var errTest = errors.New("test")
+
+func (failingCodec) Marshal(circuitry.CircuitInformation) ([]byte, error) { return nil, errTest }
+
+func TestBackendErrors(t *testing.T) {
+	operations := map[string]func(context.Context, *etcdbackend.Backend) error{
+		"store": func(ctx context.Context, b *etcdbackend.Backend) error {
+			return b.Store(ctx, "circuit", circuitry.CircuitInformation{})
+		},
+		"store fenced": func(ctx context.Context, b *etcdbackend.Backend) error {
+			return b.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 1)
+		},
+		"retrieve": func(ctx context.Context, b *etcdbackend.Backend) error {
+			_, err := b.Retrieve(ctx, "circuit")
+			return err
+		},
+		"list": func(ctx context.Context, b *etcdbackend.Backend) error {
+			for _, err := range b.List(ctx, "") {
+				return err
+			}
+			return nil
+		},
+		"delete": func(ctx context.Context, b *etcdbackend.Backend) error {
+			return b.Delete(ctx, "circuit")
+		},
+		"lock": func(ctx context.Context, b *etcdbackend.Backend) error {
+			_, err := b.Lock(ctx, "circuit")
+			return err
+		},
+		"admit": func(ctx context.Context, b *etcdbackend.Backend) error {
+			_, _, err := b.Admit(ctx, "circuit", circuitry.OutcomePolicy{}, time.Now())
+			return err
+		},
+		"watch": func(ctx context.Context, b *etcdbackend.Backend) error {
+			return b.WatchTransitions(ctx, func(circuitry.TransitionEvent) {})
+		},
+	}
+	endpoint := startEtcd(t)
+
+	for name, operation := range operations {
+		t.Run(name+" closed client", func(t *testing.T) {
+			backend := etcdbackend.New(newClient(t, endpoint))
+			_ = backend.Client.Close()
+			if err := operation(context.Background(), backend); err == nil {
+				t.Fatalf("expected an error; got nil")
+			}
+		})
+	}
+	for _, name := range []string{"store", "store fenced", "admit"} {
+		t.Run(name+" codec", func(t *testing.T) {
+			backend := etcdbackend.New(newClient(t, endpoint))
+			backend.Codec = failingCodec{codec.JSON}
+			if err := operations[name](context.Background(), backend); !errors.Is(err, errTest) {
+				t.Fatalf("expected %v; got %v", errTest, err)
+			}
+		})
+	}
+}
+
+func TestWithEtcdBackend(t *testing.T) {
+	client := newClient(t, startEtcd(t))
+	settings, err := circuitry.NewFactorySettings(etcdbackend.WithEtcdBackend(client))
+	if err != nil {
+		t.Fatalf("expected to create settings; got %v", err)
+	}
+	backend, ok := settings.StorageBackend.(*etcdbackend.Backend)
+	if !ok || backend.Client != client {
+		t.Fatalf("expected a Backend using the client; got %#v", settings.StorageBackend)
+	}
+}
@@ -0,0 +1,72 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+// WatchTransitions watches the keys of every circuit from the revision etcd
+// is at when it is called and calls emit with a TransitionEvent for each
+// write that changes a circuit's state, until the context is done. Events
+// carry the time they were observed. Values that cannot be deserialized are
+// skipped, and deleting a circuit is not a transition. It returns the watch's
+// error if etcd cancels it, e.g., because the revision was compacted.
+func (b *Backend) WatchTransitions(ctx context.Context, emit func(circuitry.TransitionEvent)) error {
+	prefix := b.circuitPrefix()
+	current, err := b.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return err
+	}
+	watch := b.Client.Watch(
+		clientv3.WithRequireLeader(ctx), prefix,
+		clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(current.Header.Revision+1),
+	)
+	for response := range watch {
+		if err := response.Err(); err != nil {
+			return err
+		}
+		for _, event := range response.Events {
+			if transition, ok := b.transitionFromEvent(event); ok {
+				emit(transition)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrWatchClosed
+}
+
+// transitionFromEvent derives a TransitionEvent from a write to a circuit's
+// key if its state changed. A circuit written for the first time changed
+// from the empty, closed, information.
+func (b *Backend) transitionFromEvent(event *clientv3.Event) (circuitry.TransitionEvent, bool) {
+	if event.Type != clientv3.EventTypePut {
+		return circuitry.TransitionEvent{}, false
+	}
+	var before circuitry.CircuitInformation
+	if event.PrevKv != nil {
+		var err error
+		if before, err = codec.Unmarshal(event.PrevKv.Value, b.Codec); err != nil {
+			return circuitry.TransitionEvent{}, false
+		}
+	}
+	after, err := codec.Unmarshal(event.Kv.Value, b.Codec)
+	if err != nil || before.State == after.State {
+		return circuitry.TransitionEvent{}, false
+	}
+	return circuitry.TransitionEvent{
+		Name:        strings.TrimPrefix(string(event.Kv.Key), b.circuitPrefix()),
+		From:        before.State,
+		To:          after.State,
+		Generation:  after.Generation,
+		Information: before,
+		Time:        time.Now(),
+	}, true
+}
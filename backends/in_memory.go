@@ -2,6 +2,9 @@ package backends
 
 import (
 	"context"
+	"iter"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/sigmavirus24/circuitry"
@@ -10,6 +13,7 @@ import (
 type infoWithLock struct {
 	information circuitry.CircuitInformation
 	lock        *sync.Mutex
+	deleted     bool
 }
 
 // InMemoryBackend defines an in
@@ -28,7 +32,7 @@ func NewInMemoryBackend() circuitry.StorageBackender {
 }
 
 func (b *InMemoryBackend) setDefault(name string) infoWithLock {
-	i := infoWithLock{circuitry.CircuitInformation{}, &sync.Mutex{}}
+	i := infoWithLock{lock: &sync.Mutex{}}
 	b.information[name] = i
 	return i
 }
@@ -68,7 +72,46 @@ func (b *InMemoryBackend) Lock(_ context.Context, name string) (sync.Locker, err
 	return info.lock, nil
 }
 
+// Delete removes the circuit information from memory. The circuit's lock is
+// kept so that a breaker holding it while the circuit is deleted still
+// excludes the breakers that lock the circuit afterwards.
+func (b *InMemoryBackend) Delete(_ context.Context, name string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if info, ok := b.information[name]; ok {
+		b.information[name] = infoWithLock{lock: info.lock, deleted: true}
+	}
+	return nil
+}
+
+// List yields the circuits stored in memory whose names start with the
+// prefix in order of their names
+func (b *InMemoryBackend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		b.lock.Lock()
+		entries := make([]circuitry.CircuitEntry, 0, len(b.information))
+		for name, info := range b.information {
+			if !info.deleted && strings.HasPrefix(name, prefix) {
+				entries = append(entries, circuitry.CircuitEntry{Name: name, Information: info.information})
+			}
+		}
+		b.lock.Unlock()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				yield(circuitry.CircuitEntry{}, err)
+				return
+			}
+			if !yield(entry, nil) {
+				return
+			}
+		}
+	}
+}
+
 var _ circuitry.StorageBackender = (*InMemoryBackend)(nil)
+var _ circuitry.Lister = (*InMemoryBackend)(nil)
+var _ circuitry.Deleter = (*InMemoryBackend)(nil)
 
 // WithInMemoryBackend creates an in memory backend storage for a circuit
 // breaker
@@ -0,0 +1,435 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strconv"
+	"time"
+
+	"github.com/bsm/redislock"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// hashFields are the fields of the hash AtomicBackend stores each circuit in,
+// in the order the scripts take and return them. expires_after is in
+// milliseconds since the epoch, or 0 if the circuit does not expire.
+var hashFields = []string{
+	"state",
+	"generation",
+	"consecutive_failures",
+	"consecutive_successes",
+	"total",
+	"total_failures",
+	"total_successes",
+	"expires_after",
+}
+
+// atomicPrelude is shared by the scripts that apply the circuit breaker's
+// state machine to the hash in KEYS[1]. ARGV[1] is the current time in
+// milliseconds since the epoch, ARGV[2] through ARGV[5] are the
+// circuitry.OutcomePolicy, and ARGV[6] and ARGV[7] are the Retention, with
+// durations in milliseconds. Each script returns a status followed by the
+// hash before any change of state and the hash it stored.
+const atomicPrelude = `
+local fields = {'state', 'generation', 'consecutive_failures', 'consecutive_successes', 'total', 'total_failures', 'total_successes', 'expires_after'}
+local counts = {'consecutive_failures', 'consecutive_successes', 'total', 'total_failures', 'total_successes'}
+local CLOSED, OPEN, HALF_OPEN = 0, 1, 2
+local now = tonumber(ARGV[1])
+local failureThreshold = tonumber(ARGV[2])
+local closeThreshold = tonumber(ARGV[3])
+local allowAfter = tonumber(ARGV[4])
+local resetCycle = tonumber(ARGV[5])
+local keepFor = tonumber(ARGV[6])
+local grace = tonumber(ARGV[7])
+
+local function load()
+	local values = redis.call('HMGET', KEYS[1], unpack(fields))
+	local c = {}
+	for i, field in ipairs(fields) do
+		c[field] = tonumber(values[i]) or 0
+	end
+	return c
+end
+
+local function snapshot(c)
+	local values = {}
+	for i, field in ipairs(fields) do
+		values[i] = c[field]
+	end
+	return values
+end
+
+local function save(c)
+	local args = {}
+	for _, field in ipairs(fields) do
+		table.insert(args, field)
+		table.insert(args, string.format('%d', c[field]))
+	end
+	redis.call('HSET', KEYS[1], unpack(args))
+	local ttl = 0
+	if keepFor > 0 then
+		ttl = keepFor
+	elseif grace > 0 and c.expires_after ~= 0 then
+		ttl = math.max(c.expires_after - now + grace, grace)
+	end
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[1], string.format('%d', ttl))
+	else
+		redis.call('PERSIST', KEYS[1])
+	end
+end
+
+local function result(status, before, c)
+	local values = {status}
+	for _, value in ipairs(before) do
+		table.insert(values, value)
+	end
+	for _, value in ipairs(snapshot(c)) do
+		table.insert(values, value)
+	end
+	return values
+end
+
+local function updateExpiry(c)
+	if c.state == CLOSED then
+		if resetCycle == 0 then
+			c.expires_after = 0
+		else
+			c.expires_after = now + resetCycle
+		end
+	elseif c.state == OPEN then
+		c.expires_after = now + allowAfter
+	else
+		c.expires_after = 0
+	end
+end
+
+local function newGeneration(c)
+	c.generation = c.generation + 1
+	for _, field in ipairs(counts) do
+		c[field] = 0
+	end
+	updateExpiry(c)
+end
+
+local function setState(c, state)
+	local prev = c.state
+	c.state = state
+	if (prev == HALF_OPEN and state ~= CLOSED) or state == HALF_OPEN then
+		updateExpiry(c)
+	else
+		newGeneration(c)
+	end
+end
+`
+
+// admitScript refreshes the circuit and counts the request if it is
+// allowed. The status is 0 if the request is allowed, 1 if the circuit is
+// open, and 2 if it is half-open and allowing as many requests as it will.
+var admitScript = redis.NewScript(atomicPrelude + `
+local c = load()
+if c.expires_after == 0 and c.generation == 0 and c.total == 0 and resetCycle ~= 0 then
+	c.expires_after = now + resetCycle
+end
+local before = snapshot(c)
+if c.state == CLOSED and c.expires_after ~= 0 and c.expires_after < now then
+	newGeneration(c)
+elseif c.state == OPEN and c.expires_after < now then
+	setState(c, HALF_OPEN)
+end
+local status = 0
+if c.state == OPEN then
+	status = 1
+elseif c.state == HALF_OPEN and c.total >= closeThreshold then
+	status = 2
+else
+	c.total = c.total + 1
+end
+save(c)
+return result(status, before, c)
+`)
+
+// recordScript counts the outcome in ARGV[9], 0 for a success and 1 for a
+// failure, of a request admitted in the generation in ARGV[8] and trips or
+// closes the circuit as the default trip function would
+var recordScript = redis.NewScript(atomicPrelude + `
+local c = load()
+local before = snapshot(c)
+if c.generation ~= tonumber(ARGV[8]) then
+	return result(0, before, c)
+end
+if ARGV[9] == '0' then
+	if c.state ~= OPEN then
+		c.consecutive_failures = 0
+		c.consecutive_successes = c.consecutive_successes + 1
+		c.total_successes = c.total_successes + 1
+		before = snapshot(c)
+		if c.state == HALF_OPEN and c.consecutive_successes >= closeThreshold then
+			setState(c, CLOSED)
+		end
+	end
+elseif c.state == CLOSED then
+	c.consecutive_successes = 0
+	c.consecutive_failures = c.consecutive_failures + 1
+	c.total_failures = c.total_failures + 1
+	before = snapshot(c)
+	if c.consecutive_failures > failureThreshold then
+		setState(c, OPEN)
+	end
+elseif c.state == HALF_OPEN then
+	setState(c, OPEN)
+end
+save(c)
+return result(0, before, c)
+`)
+
+// storeHashScript stores the fields in ARGV[3] onwards in the hash in
+// KEYS[1] unless ARGV[1] is a fencing token and the token in KEYS[2] is
+// greater than it. ARGV[2] is how long the key is kept in milliseconds, or 0
+// if it is kept until it is deleted.
+var storeHashScript = redis.NewScript(`
+if ARGV[1] ~= '0' and tonumber(redis.call('GET', KEYS[2]) or '0') > tonumber(ARGV[1]) then
+	return 0
+end
+local fields = {'state', 'generation', 'consecutive_failures', 'consecutive_successes', 'total', 'total_failures', 'total_successes', 'expires_after'}
+local args = {}
+for i, field in ipairs(fields) do
+	table.insert(args, field)
+	table.insert(args, ARGV[i + 2])
+end
+redis.call('HSET', KEYS[1], unpack(args))
+if ARGV[2] == '0' then
+	redis.call('PERSIST', KEYS[1])
+else
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// AtomicBackend implements the StorageBackender interface for Redis like
+// Backend but stores each circuit in a hash and implements
+// [github.com/sigmavirus24/circuitry.OutcomeRecorder]. CircuitBreakers using
+// the default trip function admit each request and record each outcome with a
+// single EVALSHA, without obtaining a lock. Locks are only obtained to reset,
+// delete, or otherwise operate on a circuit directly and by CircuitBreakers
+// with a custom trip function. The hash's fields are Redis strings, so the
+// Backend's Codec is not used.
+type AtomicBackend struct {
+	Backend
+}
+
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func hashValues(ci circuitry.CircuitInformation) []any {
+	return []any{
+		uint32(ci.State),
+		ci.Generation,
+		ci.ConsecutiveFailures,
+		ci.ConsecutiveSuccesses,
+		ci.Total,
+		ci.TotalFailures,
+		ci.TotalSuccesses,
+		millis(ci.ExpiresAfter),
+	}
+}
+
+func infoFromValues(values []int64) circuitry.CircuitInformation {
+	//nolint:gosec // The scripts only store values converted from these types
+	return circuitry.CircuitInformation{
+		State:                circuitry.CircuitState(values[0]),
+		Generation:           uint64(values[1]),
+		ConsecutiveFailures:  uint64(values[2]),
+		ConsecutiveSuccesses: uint64(values[3]),
+		Total:                uint64(values[4]),
+		TotalFailures:        uint64(values[5]),
+		TotalSuccesses:       uint64(values[6]),
+		ExpiresAfter:         fromMillis(values[7]),
+	}
+}
+
+func infoFromHash(hash map[string]string) (circuitry.CircuitInformation, error) {
+	values := make([]int64, len(hashFields))
+	for i, field := range hashFields {
+		value, err := strconv.ParseInt(hash[field], 10, 64)
+		if err != nil {
+			return circuitry.CircuitInformation{}, fmt.Errorf("cannot parse %s: %w", field, err)
+		}
+		values[i] = value
+	}
+	return infoFromValues(values), nil
+}
+
+func (c *AtomicBackend) store(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	if err := c.checkKeys(); err != nil {
+		return err
+	}
+	args := append([]any{token, c.Retention.TTL(ci, time.Now()).Milliseconds()}, hashValues(ci)...)
+	stored, err := storeHashScript.Run(ctx, c.Client, []string{c.key(name), c.fenceKey(name)}, args...).Int()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+// Store saves the CircuitInformation in the hash under the named key
+func (c *AtomicBackend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
+	return c.store(ctx, name, ci, 0)
+}
+
+// StoreFenced saves the CircuitInformation like Store unless a lock with a
+// greater fencing token has been obtained for the circuit, in which case it
+// returns [github.com/sigmavirus24/circuitry.ErrLockLost]
+func (c *AtomicBackend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	return c.store(ctx, name, ci, token)
+}
+
+// Retrieve reads the hash under the named key. If the key is not present in
+// Redis, this will return an empty
+// [github.com/sigmavirus24/circuitry.CircuitInformation].
+func (c *AtomicBackend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
+	if err := c.checkKeys(); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	hash, err := c.Client.HGetAll(ctx, c.key(name)).Result()
+	if err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	if len(hash) == 0 {
+		return circuitry.CircuitInformation{}, nil
+	}
+	return infoFromHash(hash)
+}
+
+func (c *AtomicBackend) scriptArgs(policy circuitry.OutcomePolicy, now time.Time) []any {
+	return []any{
+		now.UnixMilli(),
+		policy.FailureCountThreshold,
+		policy.CloseThreshold,
+		policy.AllowAfter.Milliseconds(),
+		policy.CyclicClearAfter.Milliseconds(),
+		c.Retention.KeepFor.Milliseconds(),
+		c.Retention.Grace.Milliseconds(),
+	}
+}
+
+func (c *AtomicBackend) runOutcomeScript(ctx context.Context, script *redis.Script, name string, args []any) (int64, circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	if err := c.checkKeys(); err != nil {
+		return 0, circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	values, err := script.Run(ctx, c.Client, []string{c.key(name)}, args...).Int64Slice()
+	if err != nil {
+		return 0, circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, err
+	}
+	if len(values) != 1+2*len(hashFields) {
+		return 0, circuitry.CircuitInformation{}, circuitry.CircuitInformation{}, fmt.Errorf("unexpected reply from script: %v", values)
+	}
+	return values[0], infoFromValues(values[1 : 1+len(hashFields)]), infoFromValues(values[1+len(hashFields):]), nil
+}
+
+// Admit refreshes the circuit and counts the request with a single script
+func (c *AtomicBackend) Admit(ctx context.Context, name string, policy circuitry.OutcomePolicy, now time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	status, before, after, err := c.runOutcomeScript(ctx, admitScript, name, c.scriptArgs(policy, now))
+	switch {
+	case err != nil:
+		return before, after, err
+	case status == 1:
+		return before, after, circuitry.ErrCircuitBreakerOpen
+	case status == 2:
+		return before, after, circuitry.ErrTooManyRequests
+	}
+	return before, after, nil
+}
+
+// Record counts the outcome and changes the circuit's state with a single
+// script
+func (c *AtomicBackend) Record(ctx context.Context, name string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	failed := 0
+	if status != circuitry.ExecutionSucceeded {
+		failed = 1
+	}
+	args := append(c.scriptArgs(policy, now), generation, failed)
+	_, before, after, err := c.runOutcomeScript(ctx, recordScript, name, args)
+	return before, after, err
+}
+
+// List scans Redis like [Backend].List and yields the keys holding a
+// circuit's hash. Keys holding anything else, such as locks, are skipped.
+func (c *AtomicBackend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		err := c.scan(ctx, prefix, func(keys []string) bool {
+			for _, key := range keys {
+				name, ok := c.nameOf(key)
+				if !ok {
+					continue
+				}
+				hash, err := c.Client.HGetAll(ctx, key).Result()
+				if err != nil && !isWrongType(err) {
+					yield(circuitry.CircuitEntry{}, err)
+					return false
+				}
+				// Keys deleted since the SCAN are empty
+				if len(hash) == 0 {
+					continue
+				}
+				info, err := infoFromHash(hash)
+				if err == nil && !yield(circuitry.CircuitEntry{Name: name, Information: info}, nil) {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			yield(circuitry.CircuitEntry{}, err)
+		}
+	}
+}
+
+var _ circuitry.StorageBackender = (*AtomicBackend)(nil)
+var _ circuitry.OutcomeRecorder = (*AtomicBackend)(nil)
+var _ circuitry.Lister = (*AtomicBackend)(nil)
+var _ circuitry.Deleter = (*AtomicBackend)(nil)
+var _ circuitry.FencedStorer = (*AtomicBackend)(nil)
+var _ circuitry.TransitionPublisher = (*AtomicBackend)(nil)
+var _ circuitry.TransitionWatcher = (*AtomicBackend)(nil)
+
+// NewAtomic builds a new AtomicBackend for circuitry.
+func NewAtomic(clientOpts *redis.Options, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.StorageBackender {
+	return NewAtomicWithClient(redis.NewClient(clientOpts), lockOpts, defaultLockTTL)
+}
+
+// NewAtomicWithClient builds a new AtomicBackend for circuitry that uses an
+// existing client like [NewWithClient]
+func NewAtomicWithClient(client Client, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.StorageBackender {
+	return &AtomicBackend{*newBackend(client, lockOpts, defaultLockTTL)}
+}
+
+// WithAtomicRedisBackend provides a way to configure an AtomicBackend as the
+// StorageBackend for a Circuit Breaker Factory's settings.
+func WithAtomicRedisBackend(clientOpts *redis.Options, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(NewAtomic(clientOpts, lockOpts, defaultLockTTL))
+}
+
+// WithAtomicRedisClient provides a way to configure an AtomicBackend that
+// uses an existing client as the StorageBackend for a Circuit Breaker
+// Factory's settings. See [NewAtomicWithClient].
+func WithAtomicRedisClient(client Client, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(NewAtomicWithClient(client, lockOpts, defaultLockTTL))
+}
@@ -0,0 +1,323 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bsm/redislock"
+	redismock "github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+)
+
+func newAtomicBackend(t *testing.T) (*miniredis.Miniredis, *redisbackend.AtomicBackend) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return server, &redisbackend.AtomicBackend{Backend: redisbackend.Backend{
+		Client:         client,
+		Locker:         redislock.New(client),
+		LockOpts:       &redislock.Options{},
+		DefaultLockTTL: time.Minute,
+	}}
+}
+
+func newAtomicFactory(t *testing.T, backend circuitry.StorageBackender, opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	t.Helper()
+	settings, err := circuitry.NewFactorySettings(append(opts, circuitry.WithStorageBackend(backend))...)
+	if err != nil {
+		t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+	}
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+func TestAtomicBackendMatchesLockingBreaker(t *testing.T) {
+	ctx := context.Background()
+	server, atomic := newAtomicBackend(t)
+	opts := []circuitry.SettingsOption{
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(2),
+		circuitry.WithAllowAfter(20 * time.Millisecond),
+	}
+	atomicBreaker := newAtomicFactory(t, atomic, opts...).BreakerFor("circuit", map[string]any{})
+	lockingBreaker := newAtomicFactory(t, backends.NewInMemoryBackend(), opts...).BreakerFor("circuit", map[string]any{})
+
+	outcomes := map[string]circuitry.WorkFn{
+		"succeed": func() (any, error) { return nil, nil },
+		"fail":    func() (any, error) { return nil, errors.New("test") },
+	}
+	steps := []string{"succeed", "fail", "succeed", "fail", "fail", "succeed", "wait", "fail", "wait", "succeed", "succeed", "succeed", "fail"}
+	for i, step := range steps {
+		if step == "wait" {
+			time.Sleep(30 * time.Millisecond)
+			continue
+		}
+		_, _, atomicErr := atomicBreaker.Execute(ctx, outcomes[step])
+		_, _, lockingErr := lockingBreaker.Execute(ctx, outcomes[step])
+		if !errors.Is(atomicErr, lockingErr) {
+			t.Fatalf("step %d: expected %v; got %v", i, lockingErr, atomicErr)
+		}
+		expected, _ := lockingBreaker.Information(ctx)
+		actual, err := atomicBreaker.Information(ctx)
+		if err != nil {
+			t.Fatalf("step %d: expected to retrieve the information; got err = %v", i, err)
+		}
+		if expected.ExpiresAfter.IsZero() != actual.ExpiresAfter.IsZero() {
+			t.Fatalf("step %d: expected expiry %v; got %v", i, expected.ExpiresAfter, actual.ExpiresAfter)
+		}
+		expected.ExpiresAfter, actual.ExpiresAfter = time.Time{}, time.Time{}
+		if expected != actual {
+			t.Fatalf("step %d: expected %+v; got %+v", i, expected, actual)
+		}
+	}
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "circuit" {
+		t.Fatalf("expected only the circuit's hash to be written; got %v", keys)
+	}
+}
+
+func TestAtomicBackendTransitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, b := newAtomicBackend(t)
+	factory := newAtomicFactory(t, b, circuitry.WithFailureCountThreshold(0), circuitry.WithAllowAfter(time.Millisecond))
+	sub := factory.Subscribe(ctx, nil)
+	breaker := factory.BreakerFor("circuit", map[string]any{})
+
+	workErr := errors.New("test")
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, workErr }); err != nil {
+		t.Fatalf("expected to record the failure; got err = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	// The default CloseThreshold of 0 rejects every half-open request
+	if err := breaker.Start(ctx); !errors.Is(err, circuitry.ErrTooManyRequests) {
+		t.Fatalf("expected ErrTooManyRequests; got %v", err)
+	}
+	expected := []struct {
+		from, to   circuitry.CircuitState
+		generation uint64
+		failures   uint64
+	}{
+		{circuitry.CircuitClosed, circuitry.CircuitOpen, 1, 1},
+		{circuitry.CircuitOpen, circuitry.CircuitHalfOpen, 1, 0},
+	}
+	for _, e := range expected {
+		event := <-sub.C
+		if event.From != e.from || event.To != e.to || event.Generation != e.generation || event.Information.ConsecutiveFailures != e.failures {
+			t.Fatalf("expected %v -> %v in generation %d; got %+v", e.from, e.to, e.generation, event)
+		}
+	}
+}
+
+func TestAtomicBackendDiscardsOutcomesFromOtherGenerations(t *testing.T) {
+	ctx := context.Background()
+	_, b := newAtomicBackend(t)
+	factory := newAtomicFactory(t, b, circuitry.WithFailureCountThreshold(0), circuitry.WithAllowAfter(time.Hour))
+	first, second := factory.BreakerFor("circuit", map[string]any{}), factory.BreakerFor("circuit", map[string]any{})
+	for _, breaker := range []circuitry.CircuitBreaker{first, second} {
+		if err := breaker.Start(ctx); err != nil {
+			t.Fatalf("expected both breakers to start concurrently; got err = %v", err)
+		}
+	}
+	if err := first.End(ctx, errors.New("test")); err != nil {
+		t.Fatalf("expected to record the failure; got err = %v", err)
+	}
+	if err := second.End(ctx, nil); err != nil {
+		t.Fatalf("expected to discard the success; got err = %v", err)
+	}
+	info, err := b.Retrieve(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to retrieve the circuit; got err = %v", err)
+	}
+	if info.State != circuitry.CircuitOpen || info.Generation != 1 || info.TotalSuccesses != 0 {
+		t.Fatalf("expected the circuit to stay open; got %+v", info)
+	}
+}
+
+func TestAtomicBackendStoreAndRetrieve(t *testing.T) {
+	ctx := context.Background()
+	server, b := newAtomicBackend(t)
+	info := circuitry.CircuitInformation{
+		State:                circuitry.CircuitOpen,
+		Generation:           3,
+		ConsecutiveFailures:  2,
+		ConsecutiveSuccesses: 1,
+		Total:                9,
+		TotalFailures:        5,
+		TotalSuccesses:       4,
+		ExpiresAfter:         time.UnixMilli(time.Now().Add(time.Hour).UnixMilli()),
+	}
+	if err := b.Store(ctx, "circuit", info); err != nil {
+		t.Fatalf("expected to store the circuit; got err = %v", err)
+	}
+	if actual, err := b.Retrieve(ctx, "circuit"); err != nil || !actual.ExpiresAfter.Equal(info.ExpiresAfter) {
+		t.Fatalf("expected %+v; got %+v, %v", info, actual, err)
+	} else if actual.ExpiresAfter = info.ExpiresAfter; actual != info {
+		t.Fatalf("expected %+v; got %+v", info, actual)
+	}
+	if ttl := server.TTL("circuit"); ttl != 0 {
+		t.Fatalf("expected the circuit's key to not expire; got %v", ttl)
+	}
+	if actual, err := b.Retrieve(ctx, "missing"); err != nil || actual != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected an empty CircuitInformation; got %+v, %v", actual, err)
+	}
+	server.HSet("corrupt", "state", "open")
+	if _, err := b.Retrieve(ctx, "corrupt"); err == nil {
+		t.Fatal("expected an error retrieving a corrupt hash")
+	}
+}
+
+func TestAtomicBackendLockAndStoreFenced(t *testing.T) {
+	ctx := context.Background()
+	server, b := newAtomicBackend(t)
+	lock, err := b.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock the circuit; got err = %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	defer func() { _ = lease.Release(ctx) }()
+	if !server.Exists("circuitry:lock:circuit") || server.Exists("circuit") {
+		t.Fatalf("expected the lock to not use the circuit's key; got %v", server.Keys())
+	}
+	info := circuitry.CircuitInformation{Generation: 1}
+	if err := b.StoreFenced(ctx, "circuit", info, lease.Token()); err != nil {
+		t.Fatalf("expected to store with the current token; got err = %v", err)
+	}
+	server.Incr("circuitry:lock:circuit:fence", 1)
+	if err := b.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{Generation: 2}, lease.Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost; got %v", err)
+	}
+	if actual, _ := b.Retrieve(ctx, "circuit"); actual != info {
+		t.Fatalf("expected %+v to be kept; got %+v", info, actual)
+	}
+
+	// Operating on the circuit directly still locks it
+	factory := newAtomicFactory(t, b)
+	if err := factory.BreakerFor("other", map[string]any{}).Reset(ctx); err != nil {
+		t.Fatalf("expected to reset a circuit; got err = %v", err)
+	}
+	if actual, _ := b.Retrieve(ctx, "other"); actual.Generation != 1 {
+		t.Fatalf("expected the reset to be stored; got %+v", actual)
+	}
+}
+
+func TestAtomicBackendList(t *testing.T) {
+	ctx := context.Background()
+	server, b := newAtomicBackend(t)
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, Total: 3}
+	for _, name := range []string{"tenant-a", "tenant-b", "other"} {
+		if err := b.Store(ctx, name, open); err != nil {
+			t.Fatalf("expected to store %s; got err = %v", name, err)
+		}
+	}
+	if err := server.Set("circuitry:lock:tenant-a", "token"); err != nil {
+		t.Fatalf("expected to lock tenant-a; got err = %v", err)
+	}
+	server.HSet("tenant-hash", "field", "value")
+
+	names := map[string]circuitry.CircuitInformation{}
+	for entry, err := range b.List(ctx, "tenant-") {
+		if err != nil {
+			t.Fatalf("expected to list circuits; got err = %v", err)
+		}
+		names[entry.Name] = entry.Information
+	}
+	if len(names) != 2 || names["tenant-a"] != open || names["tenant-b"] != open {
+		t.Fatalf("expected tenant-a and tenant-b to be listed; got %v", names)
+	}
+
+	count := 0
+	for range b.List(ctx, "") {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("expected iteration to stop after 1 entry; got %d", count)
+	}
+}
+
+func TestAtomicBackendListPagesAndErrors(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectScan(0, "*", 100).SetVal([]string{"a"}, 7)
+	mock.ExpectHGetAll("a").SetVal(map[string]string{})
+	mock.ExpectScan(7, "*", 100).SetVal([]string{"b"}, 0)
+	mock.ExpectHGetAll("b").SetErr(redis.ErrClosed)
+
+	b := redisbackend.AtomicBackend{Backend: redisbackend.Backend{Client: db}}
+	var errs []error
+	for entry, err := range b.List(context.TODO(), "") {
+		if err == nil {
+			t.Fatalf("expected only an error; got %+v", entry)
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], redis.ErrClosed) {
+		t.Fatalf("expected redis.ErrClosed; got %v", errs)
+	}
+	requireExpectations(t, mock)
+
+	db, mock = redismock.NewClientMock()
+	mock.ExpectScan(0, "*", 100).SetErr(redis.ErrClosed)
+	b = redisbackend.AtomicBackend{Backend: redisbackend.Backend{Client: db}}
+	for _, err := range b.List(context.TODO(), "") {
+		if !errors.Is(err, redis.ErrClosed) {
+			t.Fatalf("expected redis.ErrClosed; got %v", err)
+		}
+	}
+	requireExpectations(t, mock)
+}
+
+func TestAtomicBackendErrors(t *testing.T) {
+	ctx := context.Background()
+	server, b := newAtomicBackend(t)
+	server.SetError("server unavailable")
+	policy := circuitry.OutcomePolicy{}
+	if _, _, err := b.Admit(ctx, "circuit", policy, time.Now()); err == nil || errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the script's error; got %v", err)
+	}
+	if _, _, err := b.Record(ctx, "circuit", policy, 0, circuitry.ExecutionFailed, time.Now()); err == nil {
+		t.Fatal("expected the script's error")
+	}
+	if err := b.Store(ctx, "circuit", circuitry.CircuitInformation{}); err == nil {
+		t.Fatal("expected the script's error")
+	}
+	if _, err := b.Retrieve(ctx, "circuit"); err == nil {
+		t.Fatal("expected the server's error")
+	}
+	breaker := newAtomicFactory(t, b).BreakerFor("circuit", map[string]any{})
+	if err := breaker.Start(ctx); err == nil || errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to fail to start; got %v", err)
+	}
+}
+
+func TestAtomicBackendUnexpectedReply(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	anyArgs := func([]any, []any) error { return nil }
+	mock.CustomMatch(anyArgs).ExpectEvalSha("", []string{"circuit"}, 0, 0, 0, 0, 0, 0, 0).SetVal([]any{int64(0)})
+	b := redisbackend.AtomicBackend{Backend: redisbackend.Backend{Client: db}}
+	if _, _, err := b.Admit(context.TODO(), "circuit", circuitry.OutcomePolicy{}, time.Now()); err == nil || !strings.Contains(err.Error(), "unexpected reply") {
+		t.Fatalf("expected an error for a reply of the wrong length; got %v", err)
+	}
+	requireExpectations(t, mock)
+}
+
+func TestNewAtomic(t *testing.T) {
+	backend := redisbackend.NewAtomic(&redis.Options{}, &redislock.Options{}, 0)
+	if _, ok := backend.(circuitry.OutcomeRecorder); !ok {
+		t.Fatalf("expected an OutcomeRecorder; got %T", backend)
+	}
+	settings, err := circuitry.NewFactorySettings(redisbackend.WithAtomicRedisBackend(&redis.Options{}, &redislock.Options{}, 0))
+	if err != nil {
+		t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+	}
+	if _, ok := settings.StorageBackend.(*redisbackend.AtomicBackend); !ok {
+		t.Fatalf("expected an AtomicBackend; got %T", settings.StorageBackend)
+	}
+}
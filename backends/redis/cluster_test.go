@@ -0,0 +1,337 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bsm/redislock"
+	redismock "github.com/go-redis/redismock/v9"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sigmavirus24/circuitry"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+)
+
+// hashSlot computes the Redis Cluster hash slot of the key
+func hashSlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := range len(key) {
+		crc ^= uint16(key[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc % 16384
+}
+
+// commandKeys returns the keys of the multi-key commands the backends use
+func commandKeys(args []any) []string {
+	var keys []any
+	switch strings.ToLower(fmt.Sprint(args[0])) {
+	case "mget", "del":
+		keys = args[1:]
+	case "eval", "evalsha", "eval_ro", "evalsha_ro":
+		count, _ := strconv.Atoi(fmt.Sprint(args[2]))
+		keys = args[3 : 3+count]
+	}
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = fmt.Sprint(key)
+	}
+	return names
+}
+
+// crossSlotHook rejects commands whose keys are in different hash slots like
+// a Redis Cluster node would. miniredis serves every slot from one node but
+// does not enforce this.
+type crossSlotHook struct{}
+
+func (crossSlotHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (crossSlotHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		keys := commandKeys(cmd.Args())
+		for _, key := range keys {
+			if hashSlot(key) != hashSlot(keys[0]) {
+				err := errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+				cmd.SetErr(err)
+				return err
+			}
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (crossSlotHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func newClusterClient(t *testing.T) (*miniredis.Miniredis, *redis.ClusterClient) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	client.AddHook(crossSlotHook{})
+	client.OnNewNode(func(node *redis.Client) { node.AddHook(crossSlotHook{}) })
+	t.Cleanup(func() { _ = client.Close() })
+	return server, client
+}
+
+func TestHashSlot(t *testing.T) {
+	// Slots from https://redis.io/docs/latest/operate/oss_and_stack/reference/cluster-spec/
+	testCases := map[string]uint16{
+		"123456789":        12739,
+		"{123456789}:lock": 12739,
+		"a{123456789}{b}":  12739,
+	}
+	for key, slot := range testCases {
+		if actual := hashSlot(key); actual != slot {
+			t.Fatalf("expected %q to be in slot %d; got %d", key, slot, actual)
+		}
+	}
+}
+
+func TestBackendsWithClusterClient(t *testing.T) {
+	ctx := context.Background()
+	lockOpts := &redislock.Options{}
+	testCases := map[string]func(redisbackend.Client) circuitry.StorageBackender{
+		"backend": func(client redisbackend.Client) circuitry.StorageBackender {
+			return redisbackend.NewWithClient(client, lockOpts, time.Minute)
+		},
+		"atomic backend": func(client redisbackend.Client) circuitry.StorageBackender {
+			return redisbackend.NewAtomicWithClient(client, lockOpts, time.Minute)
+		},
+	}
+
+	for name, testCase := range testCases {
+		newBackend := testCase
+		t.Run(name, func(t *testing.T) {
+			server, client := newClusterClient(t)
+			backend := newBackend(client)
+			factory := newAtomicFactory(t, backend, circuitry.WithFailureCountThreshold(0), circuitry.WithAllowAfter(time.Hour))
+			breaker := factory.BreakerFor("circuit", map[string]any{})
+			if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+				t.Fatalf("expected to record the failure; got err = %v", err)
+			}
+			if state, err := breaker.State(ctx); err != nil || state != circuitry.CircuitOpen {
+				t.Fatalf("expected the circuit to be open; got %v, %v", state, err)
+			}
+			if err := breaker.Reset(ctx); err != nil {
+				t.Fatalf("expected to reset the circuit; got err = %v", err)
+			}
+			for _, key := range server.Keys() {
+				if hashSlot(key) != hashSlot("circuit") {
+					t.Fatalf("expected every key to be in the circuit's slot; got %v", server.Keys())
+				}
+			}
+
+			var names []string
+			for entry, err := range factory.Circuits(ctx, circuitry.CircuitFilter{Prefix: "circ"}) {
+				if err != nil {
+					t.Fatalf("expected to list circuits; got err = %v", err)
+				}
+				names = append(names, entry.Name)
+			}
+			if len(names) != 1 || names[0] != "circuit" {
+				t.Fatalf("expected [circuit]; got %v", names)
+			}
+			if err := breaker.Delete(ctx); err != nil || server.Exists("{circuit}") {
+				t.Fatalf("expected to delete the circuit; got err = %v", err)
+			}
+		})
+	}
+}
+
+func TestBackendWithoutHashTagsCrossesSlots(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClusterClient(t)
+	b := redisbackend.NewWithClient(client, &redislock.Options{}, time.Minute).(*redisbackend.Backend)
+	b.HashTags = false
+	if err := b.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 1); err == nil || !strings.HasPrefix(err.Error(), "CROSSSLOT") {
+		t.Fatalf("expected the cluster to reject keys in different slots; got %v", err)
+	}
+}
+
+func TestBackendListClusterErrors(t *testing.T) {
+	// Nothing is listening so the cluster's nodes cannot be found
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected to find a free port; got err = %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{addr}, MaxRetries: -1})
+	defer func() { _ = client.Close() }()
+	b := redisbackend.NewWithClient(client, &redislock.Options{}, time.Minute).(*redisbackend.Backend)
+	for _, err := range b.List(context.Background(), "") {
+		if err == nil {
+			t.Fatal("expected an error finding the cluster's nodes")
+		}
+	}
+}
+
+func TestWithRedisClient(t *testing.T) {
+	client := redis.NewClient(&redis.Options{})
+	testCases := map[string]circuitry.SettingsOption{
+		"backend":        redisbackend.WithRedisClient(client, &redislock.Options{}, 0),
+		"atomic backend": redisbackend.WithAtomicRedisClient(client, &redislock.Options{}, 0),
+	}
+
+	for name, testCase := range testCases {
+		opt := testCase
+		t.Run(name, func(t *testing.T) {
+			settings, err := circuitry.NewFactorySettings(opt)
+			if err != nil {
+				t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+			}
+			var b *redisbackend.Backend
+			switch backend := settings.StorageBackend.(type) {
+			case *redisbackend.Backend:
+				b = backend
+			case *redisbackend.AtomicBackend:
+				b = &backend.Backend
+			}
+			if b == nil || b.Client != client || b.HashTags {
+				t.Fatalf("expected a backend using the client without hash tags; got %+v", settings.StorageBackend)
+			}
+		})
+	}
+}
+
+func TestBackendListHashTagged(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectScan(0, "{tenant-*", 100).SetVal([]string{"{tenant-a}", "{tenant-a}:lock", "{tenant-b}", "{tenant-c}"}, 0)
+	mock.ExpectGet("{tenant-a}").SetVal(`{"generation": 1}`)
+	mock.ExpectGet("{tenant-b}").RedisNil()
+	mock.ExpectGet("{tenant-c}").SetErr(redis.ErrClosed)
+
+	b := redisbackend.Backend{Client: db, HashTags: true}
+	var names []string
+	var errs []error
+	for entry, err := range b.List(context.TODO(), "tenant-") {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, entry.Name)
+	}
+	if len(names) != 0 || len(errs) != 1 || !errors.Is(errs[0], redis.ErrClosed) {
+		t.Fatalf("expected only redis.ErrClosed; got %v and %v", names, errs)
+	}
+	requireExpectations(t, mock)
+}
+
+// instrumentedClient embeds a cluster client like wrappers adding tracing or
+// metrics do
+type instrumentedClient struct {
+	*redis.ClusterClient
+}
+
+func TestNewWithClientHashTagsClusterClients(t *testing.T) {
+	_, cluster := newClusterClient(t)
+	testCases := map[string]struct {
+		client   redisbackend.Client
+		hashTags bool
+	}{
+		"cluster client":      {cluster, true},
+		"instrumented client": {instrumentedClient{cluster}, true},
+		"client":              {redis.NewClient(&redis.Options{}), false},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			b := redisbackend.NewWithClient(tc.client, &redislock.Options{}, time.Minute).(*redisbackend.Backend)
+			if b.HashTags != tc.hashTags {
+				t.Fatalf("expected HashTags to be %v; got %v", tc.hashTags, b.HashTags)
+			}
+		})
+	}
+}
+
+func TestBackendsRejectBracedKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]func(*redisbackend.Backend){
+		"key prefix":      func(b *redisbackend.Backend) { b.KeyPrefix = "{app}:" },
+		"state namespace": func(b *redisbackend.Backend) { b.StateNamespace = "state{" },
+		"lock namespace":  func(b *redisbackend.Backend) { b.LockNamespace = "}locks:" },
+	}
+
+	for name, testCase := range testCases {
+		configure := testCase
+		t.Run(name, func(t *testing.T) {
+			server, backends := newBackends(t, func(b *redisbackend.Backend) {
+				configure(b)
+				b.HashTags = true
+			})
+			for kind, backend := range backends {
+				operations := map[string]func() error{
+					"store": func() error { return backend.Store(ctx, "circuit", circuitry.CircuitInformation{}) },
+					"retrieve": func() error {
+						_, err := backend.Retrieve(ctx, "circuit")
+						return err
+					},
+					"lock": func() error {
+						_, err := backend.Lock(ctx, "circuit")
+						return err
+					},
+					"store fenced": func() error {
+						return backend.(circuitry.FencedStorer).StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 1)
+					},
+					"delete": func() error { return backend.(circuitry.Deleter).Delete(ctx, "circuit") },
+					"list": func() error {
+						for _, err := range backend.(circuitry.Lister).List(ctx, "") {
+							return err
+						}
+						return nil
+					},
+				}
+				if recorder, ok := backend.(circuitry.OutcomeRecorder); ok {
+					operations["admit"] = func() error {
+						_, _, err := recorder.Admit(ctx, "circuit", circuitry.OutcomePolicy{}, time.Now())
+						return err
+					}
+				}
+				for operation, fn := range operations {
+					if err := fn(); !errors.Is(err, redisbackend.ErrBracedKeyPrefix) {
+						t.Fatalf("%s: expected %s to return ErrBracedKeyPrefix; got %v", kind, operation, err)
+					}
+				}
+				if keys := server.Keys(); len(keys) != 0 {
+					t.Fatalf("%s: expected no keys to be written; got %v", kind, keys)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendsAllowBracedKeyPrefixWithoutHashTags(t *testing.T) {
+	ctx := context.Background()
+	server, backends := newBackends(t, func(b *redisbackend.Backend) { b.KeyPrefix = "{app}:" })
+	for kind, backend := range backends {
+		server.FlushAll()
+		if err := backend.Store(ctx, "circuit", circuitry.CircuitInformation{Generation: 1}); err != nil {
+			t.Fatalf("%s: expected to store the circuit; got err = %v", kind, err)
+		}
+		if !server.Exists("{app}:circuit") {
+			t.Fatalf("%s: expected the circuit to be stored at {app}:circuit; got %v", kind, server.Keys())
+		}
+	}
+}
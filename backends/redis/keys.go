@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultLockNamespace follows the Backend's KeyPrefix in the keys locked for
+// circuits when its LockNamespace is empty. Versions before it was added
+// locked circuits at their own key, so they do not exclude processes using
+// it; see the package's README for upgrading.
+const DefaultLockNamespace = "circuitry:lock:"
+
+// ErrBracedKeyPrefix is returned when HashTags is set and the KeyPrefix or a
+// namespace contains a brace. Redis would hash the braces in the prefix
+// instead of the circuit's name, so a circuit's keys could land in different
+// hash slots.
+var ErrBracedKeyPrefix = errors.New("redis backend: key prefix and namespaces cannot contain braces when HashTags is set")
+
+// checkKeys returns ErrBracedKeyPrefix if the circuits' keys cannot be hash
+// tagged
+func (c *Backend) checkKeys() error {
+	if c.HashTags && strings.ContainsAny(c.KeyPrefix+c.StateNamespace+c.LockNamespace, "{}") {
+		return ErrBracedKeyPrefix
+	}
+	return nil
+}
+
+func (c *Backend) tag(name string) string {
+	if c.HashTags {
+		return "{" + name + "}"
+	}
+	return name
+}
+
+func (c *Backend) statePrefix() string {
+	return c.KeyPrefix + c.StateNamespace
+}
+
+func (c *Backend) lockPrefix() string {
+	if c.LockNamespace == "" {
+		return c.KeyPrefix + DefaultLockNamespace
+	}
+	return c.KeyPrefix + c.LockNamespace
+}
+
+// key returns the key the named circuit's information is stored in
+func (c *Backend) key(name string) string {
+	return c.statePrefix() + c.tag(name)
+}
+
+// lockKey returns the key locked for the named circuit
+func (c *Backend) lockKey(name string) string {
+	return c.lockPrefix() + c.tag(name)
+}
+
+func (c *Backend) fenceKey(name string) string {
+	return c.lockKey(name) + fenceSuffix
+}
+
+// nameOf returns the name of the circuit whose information is stored in the
+// key, or false if the key cannot hold a circuit's information
+func (c *Backend) nameOf(key string) (string, bool) {
+	statePrefix, lockPrefix := c.statePrefix(), c.lockPrefix()
+	// Lock keys share the state prefix when the StateNamespace is empty
+	if strings.HasPrefix(key, lockPrefix) && !strings.HasPrefix(statePrefix, lockPrefix) {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(key, statePrefix)
+	if !ok || !c.HashTags {
+		return name, ok
+	}
+	if len(name) < 2 || name[0] != '{' || name[len(name)-1] != '}' {
+		return "", false
+	}
+	return name[1 : len(name)-1], true
+}
@@ -0,0 +1,174 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/sigmavirus24/circuitry"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+)
+
+// newBackends returns a Backend and an AtomicBackend sharing a miniredis
+// server, with the function applied to both
+func newBackends(t *testing.T, configure func(*redisbackend.Backend)) (*miniredis.Miniredis, map[string]circuitry.StorageBackender) {
+	t.Helper()
+	server, b := newMiniredisBackend(t)
+	configure(b)
+	atomic := &redisbackend.AtomicBackend{Backend: *b}
+	return server, map[string]circuitry.StorageBackender{"backend": b, "atomic backend": atomic}
+}
+
+func TestBackendsKeyNamespaces(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]struct {
+		stateNamespace string
+		lockNamespace  string
+		stateKey       string
+		lockKey        string
+	}{
+		"prefix only":     {"", "", "app:circuit", "app:circuitry:lock:circuit"},
+		"state namespace": {"state:", "", "app:state:circuit", "app:circuitry:lock:circuit"},
+		"both namespaces": {"state:", "locks:", "app:state:circuit", "app:locks:circuit"},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			server, backends := newBackends(t, func(b *redisbackend.Backend) {
+				b.KeyPrefix = "app:"
+				b.StateNamespace = tc.stateNamespace
+				b.LockNamespace = tc.lockNamespace
+			})
+			for kind, backend := range backends {
+				server.FlushAll()
+				if err := server.Set("circuit", "another application's value"); err != nil {
+					t.Fatalf("expected to set circuit; got err = %v", err)
+				}
+				lock, err := backend.Lock(ctx, "circuit")
+				if err != nil {
+					t.Fatalf("%s: expected to lock the circuit; got err = %v", kind, err)
+				}
+				if !server.Exists(tc.lockKey) || !server.Exists(tc.lockKey+":fence") {
+					t.Fatalf("%s: expected %s to be locked; got %v", kind, tc.lockKey, server.Keys())
+				}
+				lock.Unlock()
+
+				factory := newAtomicFactory(t, backend, circuitry.WithFailureCountThreshold(0), circuitry.WithAllowAfter(time.Hour))
+				breaker := factory.BreakerFor("circuit", map[string]any{})
+				if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+					t.Fatalf("%s: expected to record the failure; got err = %v", kind, err)
+				}
+				if !server.Exists(tc.stateKey) {
+					t.Fatalf("%s: expected the circuit to be stored in %s; got %v", kind, tc.stateKey, server.Keys())
+				}
+				if value, _ := server.Get("circuit"); value != "another application's value" {
+					t.Fatalf("%s: expected the unprefixed key to be untouched; got %q", kind, value)
+				}
+				// Hold the lock so the lock key exists while listing
+				lock, err = backend.Lock(ctx, "circuit")
+				if err != nil {
+					t.Fatalf("%s: expected to lock the circuit; got err = %v", kind, err)
+				}
+				var names []string
+				for entry, err := range factory.Circuits(ctx, circuitry.CircuitFilter{}) {
+					if err != nil {
+						t.Fatalf("%s: expected to list circuits; got err = %v", kind, err)
+					}
+					if entry.Information.State != circuitry.CircuitOpen {
+						t.Fatalf("%s: expected %s to be open; got %+v", kind, entry.Name, entry.Information)
+					}
+					names = append(names, entry.Name)
+				}
+				lock.Unlock()
+				if !slices.Equal(names, []string{"circuit"}) {
+					t.Fatalf("%s: expected [circuit]; got %v", kind, names)
+				}
+				if err := breaker.Delete(ctx); err != nil || server.Exists(tc.stateKey) {
+					t.Fatalf("%s: expected to delete %s; got err = %v", kind, tc.stateKey, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendsRetention(t *testing.T) {
+	ctx := context.Background()
+	expiresAfter := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, ExpiresAfter: expiresAfter}
+	testCases := map[string]struct {
+		retention circuitry.Retention
+		info      circuitry.CircuitInformation
+		min, max  time.Duration
+	}{
+		"kept until deleted":          {circuitry.Retention{}, open, 0, 0},
+		"kept for":                    {circuitry.RetainFor(time.Minute), open, time.Minute, time.Minute},
+		"kept until expiry":           {circuitry.RetainUntilExpiry(time.Minute), open, time.Hour, time.Hour + time.Minute},
+		"closed kept until deleted":   {circuitry.RetainUntilExpiry(time.Minute), circuitry.CircuitInformation{Generation: 1}, 0, 0},
+		"closed kept for":             {circuitry.RetainFor(time.Minute), circuitry.CircuitInformation{Generation: 1}, time.Minute, time.Minute},
+		"expired kept for grace only": {circuitry.RetainUntilExpiry(time.Minute), circuitry.CircuitInformation{ExpiresAfter: time.UnixMilli(1)}, time.Minute, time.Minute},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			server, backends := newBackends(t, func(b *redisbackend.Backend) { b.Retention = tc.retention })
+			for kind, backend := range backends {
+				store := map[string]func() error{
+					"Store": func() error { return backend.Store(ctx, "circuit", tc.info) },
+					"StoreFenced": func() error {
+						return backend.(circuitry.FencedStorer).StoreFenced(ctx, "circuit", tc.info, 0)
+					},
+				}
+				for method, fn := range store {
+					// Storing replaces the TTL the key had before
+					server.FlushAll()
+					if err := backend.Store(ctx, "circuit", circuitry.CircuitInformation{}); err != nil {
+						t.Fatalf("%s: expected to store the circuit; got err = %v", kind, err)
+					}
+					server.SetTTL("circuit", time.Second)
+					if err := fn(); err != nil {
+						t.Fatalf("%s %s: expected to store the circuit; got err = %v", kind, method, err)
+					}
+					if ttl := server.TTL("circuit"); ttl < tc.min || ttl > tc.max {
+						t.Fatalf("%s %s: expected a TTL between %v and %v; got %v", kind, method, tc.min, tc.max, ttl)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAtomicBackendRetention(t *testing.T) {
+	ctx := context.Background()
+	testCases := map[string]struct {
+		retention circuitry.Retention
+		min, max  time.Duration
+	}{
+		"kept until deleted": {circuitry.Retention{}, 0, 0},
+		"kept for":           {circuitry.RetainFor(time.Minute), time.Minute, time.Minute},
+		"kept until expiry":  {circuitry.RetainUntilExpiry(time.Minute), time.Hour, time.Hour + time.Minute},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			server, b := newAtomicBackend(t)
+			b.Retention = tc.retention
+			server.HSet("circuit", "generation", "0")
+			server.SetTTL("circuit", time.Second)
+			factory := newAtomicFactory(t, b, circuitry.WithFailureCountThreshold(0), circuitry.WithAllowAfter(time.Hour))
+			breaker := factory.BreakerFor("circuit", map[string]any{})
+			if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+				t.Fatalf("expected to record the failure; got err = %v", err)
+			}
+			if ttl := server.TTL("circuit"); ttl < tc.min || ttl > tc.max {
+				t.Fatalf("expected a TTL between %v and %v; got %v", tc.min, tc.max, ttl)
+			}
+		})
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,45 +15,196 @@ import (
 	redis "github.com/redis/go-redis/v9"
 
 	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/codec"
 )
 
 // Client describes the interface expected for this backend to function
 // appropriately
 type Client interface {
 	Get(context.Context, string) *redis.StringCmd
+	MGet(context.Context, ...string) *redis.SliceCmd
+	Scan(context.Context, uint64, string, int64) *redis.ScanCmd
 	Set(context.Context, string, any, time.Duration) *redis.StatusCmd
+	Del(context.Context, ...string) *redis.IntCmd
+	Incr(context.Context, string) *redis.IntCmd
+	HGetAll(context.Context, string) *redis.MapStringStringCmd
 	SetArgs(context.Context, string, any, redis.SetArgs) *redis.StatusCmd
+	Publish(context.Context, string, any) *redis.IntCmd
+	Subscribe(context.Context, ...string) *redis.PubSub
 	redis.Scripter // Need this interface for redislock
 }
 
+// scanCount is the number of keys requested from each SCAN when listing
+// circuits
+const scanCount = 100
+
+// DefaultTransitionChannel is the pub/sub channel state transitions are
+// published on when the Backend's TransitionChannel is empty
+const DefaultTransitionChannel = "circuitry:transitions"
+
 // Locker describes the interface expected for this backend to function
 // appropriately
 type Locker interface {
 	Obtain(context.Context, string, time.Duration, *redislock.Options) (*redislock.Lock, error)
 }
 
+// fenceSuffix is appended to a circuit's lock key for the key holding the
+// last fencing token issued for it
+const fenceSuffix = ":fence"
+
+// storeFencedScript stores the circuit information in KEYS[1] unless the
+// fencing token in KEYS[2] is greater than ARGV[2]. ARGV[3] is how long the
+// key is kept in milliseconds, or 0 if it is kept until it is deleted.
+var storeFencedScript = redis.NewScript(`
+local fence = tonumber(redis.call('GET', KEYS[2]) or '0')
+if fence > tonumber(ARGV[2]) then
+	return 0
+end
+if ARGV[3] == '0' then
+	redis.call('SET', KEYS[1], ARGV[1])
+else
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+end
+return 1
+`)
+
+// redLock is a lease on a redislock.Lock that is refreshed in the background
+// until it is unlocked
 type redLock struct {
-	ctx  context.Context
-	lock *redislock.Lock
+	ctx   context.Context
+	lock  *redislock.Lock
+	token uint64
+	ttl   time.Duration
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu       sync.Mutex
+	expires  time.Time
+	err      error
+	released bool
+}
+
+func newRedLock(ctx context.Context, lock *redislock.Lock, token uint64, ttl, heartbeat time.Duration) *redLock {
+	l := &redLock{ctx: ctx, lock: lock, token: token, ttl: ttl}
+	if ttl <= 0 {
+		return l
+	}
+	l.expires = time.Now().Add(ttl)
+	if heartbeat > 0 {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.heartbeat(heartbeat)
+	}
+	return l
+}
+
+func (l *redLock) heartbeat(interval time.Duration) {
+	defer close(l.done)
+	// The caller's context ending does not release the lock so it must not
+	// stop the lease from being renewed either
+	ctx := context.WithoutCancel(l.ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			refreshed := time.Now()
+			err := l.lock.Refresh(ctx, l.ttl, nil)
+			l.mu.Lock()
+			if err != nil {
+				l.err = fmt.Errorf("%w: %w", circuitry.ErrLockLost, err)
+			} else {
+				l.expires = refreshed.Add(l.ttl)
+			}
+			l.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
 }
 
-func (l *redLock) Lock()   {}
-func (l *redLock) Unlock() { _ = l.lock.Release(l.ctx) }
+func (l *redLock) Lock() {}
+
+// Unlock releases the lock with the context it was obtained with, even if
+// that context has since been canceled
+func (l *redLock) Unlock() {
+	_ = l.Release(context.WithoutCancel(l.ctx))
+}
+
+// Release stops refreshing the lock and releases it. Releasing the lock
+// again does nothing.
+func (l *redLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	released := l.released
+	l.released = true
+	l.mu.Unlock()
+	if released {
+		return nil
+	}
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	return l.lock.Release(ctx)
+}
+
+func (l *redLock) Token() uint64 {
+	return l.token
+}
+
+func (l *redLock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if !l.expires.IsZero() && time.Now().After(l.expires) {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+var _ circuitry.Lease = (*redLock)(nil)
 
 // Backend implements the StorageBackender interface for Redis using
 // [github.com/redis/go-redis/v9] and [github.com/bsm/redislock]
 type Backend struct {
-	Client         Client
-	Locker         Locker
-	LockOpts       *redislock.Options
-	DefaultLockTTL time.Duration
+	Client            Client
+	Locker            Locker
+	LockOpts          *redislock.Options
+	DefaultLockTTL    time.Duration
+	HeartbeatInterval time.Duration // HeartbeatInterval is how often held locks are refreshed. It defaults to a third of DefaultLockTTL and a negative value disables refreshing.
+	TransitionChannel string
+	HashTags          bool                // HashTags wraps circuits' names in hash tags so that each circuit's keys land in the same Redis Cluster hash slot. The KeyPrefix and namespaces cannot contain braces when it is set.
+	KeyPrefix         string              // KeyPrefix is prepended to every key so that applications sharing a Redis do not collide.
+	StateNamespace    string              // StateNamespace follows the KeyPrefix in the keys holding circuits' information. It is empty by default.
+	LockNamespace     string              // LockNamespace follows the KeyPrefix in the keys locked for circuits and holding their fencing tokens. It defaults to DefaultLockNamespace and must differ from StateNamespace.
+	Retention         circuitry.Retention // Retention decides how long circuits' keys are kept after they are stored. The zero value keeps them until they are deleted.
+	Codec             codec.Codec         // Codec serializes circuits' information. It defaults to codec.JSON, and circuits stored with any of the codec package's codecs are read.
+}
+
+func (c *Backend) codec() codec.Codec {
+	if c.Codec == nil {
+		return codec.JSON
+	}
+	return c.Codec
 }
 
 // Store saves the CircuitInformation in Redis under the named key after
-// seriailizing it to JSON.
+// serializing it with the Codec.
 func (c *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
-	bytes, _ := json.Marshal(ci) // We know CircuitInformation is Marshal-able
-	cmd := c.Client.SetArgs(ctx, name, string(bytes), redis.SetArgs{ExpireAt: ci.ExpiresAfter})
+	if err := c.checkKeys(); err != nil {
+		return err
+	}
+	bytes, err := c.codec().Marshal(ci)
+	if err != nil {
+		return err
+	}
+	cmd := c.Client.SetArgs(ctx, c.key(name), string(bytes), redis.SetArgs{TTL: c.Retention.TTL(ci, time.Now())})
 	if err := cmd.Err(); err != nil {
 		return err
 	}
@@ -57,41 +212,297 @@ func (c *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitIn
 }
 
 // Retrieve looks up the key in Redis and returns the value after
-// deserializing it from JSON. If the key is not present in Redis, this will
+// deserializing it with the codec it was stored with. If the key is not present in Redis, this will
 // return an empty [github.com/sigmavirus24/circuitry.CircuitInformation].
 func (c *Backend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
-	jsonCI, err := c.Client.Get(ctx, name).Result()
-	ci := circuitry.CircuitInformation{}
+	if err := c.checkKeys(); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	stored, err := c.Client.Get(ctx, c.key(name)).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			// If the name is not in Redis, we should not return that error,
 			// but instead an empty CircuitInformation
-			return ci, nil
+			return circuitry.CircuitInformation{}, nil
 		}
-		return ci, err
-	}
-	err = json.Unmarshal([]byte(jsonCI), &ci)
-	if err != nil {
 		return circuitry.CircuitInformation{}, err
 	}
-	return ci, nil
+	return codec.Unmarshal([]byte(stored), c.Codec)
 }
 
-// Lock builds a lock in Redis with the DefaultTTL and returns an interface
-// that matches sync.Locker
+// Lock builds a lock in Redis with the DefaultLockTTL and returns a
+// [github.com/sigmavirus24/circuitry.Lease] that is refreshed every
+// HeartbeatInterval until it is unlocked. Each lock is issued a fencing token
+// by incrementing the circuit's fence key.
 func (c *Backend) Lock(ctx context.Context, name string) (sync.Locker, error) {
-	lock, err := c.Locker.Obtain(ctx, name, c.DefaultLockTTL, c.LockOpts)
+	if err := c.checkKeys(); err != nil {
+		return nil, err
+	}
+	return c.obtain(ctx, c.lockKey(name), name)
+}
+
+// obtain locks the key and issues a fencing token for the named circuit
+func (c *Backend) obtain(ctx context.Context, key, name string) (sync.Locker, error) {
+	lock, err := c.Locker.Obtain(ctx, key, c.DefaultLockTTL, c.LockOpts)
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.Client.Incr(ctx, c.fenceKey(name)).Uint64()
 	if err != nil {
+		_ = lock.Release(ctx)
 		return nil, err
 	}
-	return &redLock{ctx, lock}, nil
+	return newRedLock(ctx, lock, token, c.DefaultLockTTL, c.heartbeatInterval()), nil
+}
+
+func (c *Backend) heartbeatInterval() time.Duration {
+	if c.HeartbeatInterval == 0 {
+		return c.DefaultLockTTL / 3
+	}
+	return c.HeartbeatInterval
+}
+
+// StoreFenced saves the CircuitInformation like Store unless a lock with a
+// greater fencing token has been obtained for the circuit, in which case it
+// returns [github.com/sigmavirus24/circuitry.ErrLockLost]
+func (c *Backend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	if err := c.checkKeys(); err != nil {
+		return err
+	}
+	bytes, err := c.codec().Marshal(ci)
+	if err != nil {
+		return err
+	}
+	ttl := c.Retention.TTL(ci, time.Now()).Milliseconds()
+	stored, err := storeFencedScript.Run(ctx, c.Client, []string{c.key(name), c.fenceKey(name)}, string(bytes), token, ttl).Int()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return circuitry.ErrLockLost
+	}
+	return nil
 }
 
+// Delete removes the named key from Redis. The circuit's fence key is kept
+// so fencing tokens keep increasing if the circuit is locked again. Fence
+// keys do not expire, even with a Retention.
+func (c *Backend) Delete(ctx context.Context, name string) error {
+	if err := c.checkKeys(); err != nil {
+		return err
+	}
+	return c.Client.Del(ctx, c.key(name)).Err()
+}
+
+// List scans Redis, every master node of a [redis.ClusterClient], for keys
+// starting with the prefix and yields those whose values deserialize to
+// CircuitInformation. Keys holding anything else, such as locks, are skipped.
+func (c *Backend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		err := c.scan(ctx, prefix, func(keys []string) bool {
+			var names []string
+			keys = slices.DeleteFunc(keys, func(key string) bool {
+				name, ok := c.nameOf(key)
+				if ok {
+					names = append(names, name)
+				}
+				return !ok
+			})
+			if len(keys) == 0 {
+				return true
+			}
+			values, err := c.values(ctx, keys)
+			if err != nil {
+				yield(circuitry.CircuitEntry{}, err)
+				return false
+			}
+			for i, value := range values {
+				entry, ok := c.decodeEntry(names[i], value)
+				if ok && !yield(entry, nil) {
+					return false
+				}
+			}
+			return true
+		})
+		if err != nil {
+			yield(circuitry.CircuitEntry{}, err)
+		}
+	}
+}
+
+// values gets the values of the keys. Hash tagged keys are in different hash
+// slots so they are fetched one at a time.
+func (c *Backend) values(ctx context.Context, keys []string) ([]any, error) {
+	if !c.HashTags {
+		return c.Client.MGet(ctx, keys...).Result()
+	}
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		value, err := c.Client.Get(ctx, key).Result()
+		if err != nil && !errors.Is(err, redis.Nil) && !isWrongType(err) {
+			return nil, err
+		}
+		if err == nil {
+			values[i] = value
+		}
+	}
+	return values, nil
+}
+
+func isWrongType(err error) bool {
+	return strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// clusterClient is implemented by [redis.ClusterClient], whose Scan only
+// scans a single node
+type clusterClient interface {
+	ForEachMaster(context.Context, func(context.Context, *redis.Client) error) error
+}
+
+// nodes returns the clients to scan for keys
+func (c *Backend) nodes(ctx context.Context) ([]Client, error) {
+	cluster, ok := c.Client.(clusterClient)
+	if !ok {
+		return []Client{c.Client}, nil
+	}
+	var mu sync.Mutex
+	var nodes []Client
+	err := cluster.ForEachMaster(ctx, func(_ context.Context, node *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes, err
+}
+
+// scan calls fn with each page of keys starting with the prefix until it
+// returns false
+func (c *Backend) scan(ctx context.Context, prefix string, fn func([]string) bool) error {
+	if err := c.checkKeys(); err != nil {
+		return err
+	}
+	match := globEscaper.Replace(c.statePrefix())
+	if c.HashTags {
+		match += "{"
+	}
+	match += globEscaper.Replace(prefix) + "*"
+	nodes, err := c.nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(ctx, cursor, match, scanCount).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 && !fn(keys) {
+				return nil
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	return nil
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (c *Backend) decodeEntry(name string, value any) (circuitry.CircuitEntry, bool) {
+	// Keys deleted since the SCAN are nil and other keys under the prefix
+	// may not hold circuits
+	s, ok := value.(string)
+	if !ok {
+		return circuitry.CircuitEntry{}, false
+	}
+	ci, err := codec.Unmarshal([]byte(s), c.Codec)
+	if err != nil {
+		return circuitry.CircuitEntry{}, false
+	}
+	return circuitry.CircuitEntry{Name: name, Information: ci}, true
+}
+
+func (c *Backend) transitionChannel() string {
+	if c.TransitionChannel == "" {
+		return DefaultTransitionChannel
+	}
+	return c.TransitionChannel
+}
+
+// PublishTransition serializes the event to JSON and publishes it on the
+// TransitionChannel. The event's CircuitContext must be serializable to JSON.
+func (c *Backend) PublishTransition(ctx context.Context, event circuitry.TransitionEvent) error {
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return c.Client.Publish(ctx, c.transitionChannel(), string(bytes)).Err()
+}
+
+// WatchTransitions subscribes to the TransitionChannel and calls emit with
+// each event published there until the context is done. Messages that cannot
+// be deserialized are skipped.
+func (c *Backend) WatchTransitions(ctx context.Context, emit func(circuitry.TransitionEvent)) error {
+	pubsub := c.Client.Subscribe(ctx, c.transitionChannel())
+	defer func() { _ = pubsub.Close() }()
+	// Wait for the subscription to be confirmed so no events published after
+	// this point are missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return redis.ErrClosed
+			}
+			var event circuitry.TransitionEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			emit(event)
+		}
+	}
+}
+
+var _ circuitry.StorageBackender = (*Backend)(nil)
+var _ circuitry.Lister = (*Backend)(nil)
+var _ circuitry.Deleter = (*Backend)(nil)
+var _ circuitry.FencedStorer = (*Backend)(nil)
+var _ circuitry.TransitionPublisher = (*Backend)(nil)
+var _ circuitry.TransitionWatcher = (*Backend)(nil)
+
 // New builds a new StorageBackender for circuitry.
 func New(clientOpts *redis.Options, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.StorageBackender {
-	redClient := redis.NewClient(clientOpts)
-	locker := redislock.New(redClient)
-	return &Backend{redClient, locker, lockOpts, defaultLockTTL}
+	return NewWithClient(redis.NewClient(clientOpts), lockOpts, defaultLockTTL)
+}
+
+// NewWithClient builds a new StorageBackender for circuitry that uses an
+// existing client, e.g., a [redis.UniversalClient], a [redis.ClusterClient],
+// a Sentinel failover client, or a client that has been instrumented. Keys are
+// wrapped in hash tags if the client has a ForEachMaster method, as
+// [redis.ClusterClient] and clients embedding it do. Set the Backend's
+// HashTags for other clients talking to a Redis Cluster.
+func NewWithClient(client Client, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.StorageBackender {
+	return newBackend(client, lockOpts, defaultLockTTL)
+}
+
+func newBackend(client Client, lockOpts *redislock.Options, defaultLockTTL time.Duration) *Backend {
+	_, cluster := client.(clusterClient)
+	return &Backend{
+		Client:         client,
+		Locker:         redislock.New(client),
+		LockOpts:       lockOpts,
+		DefaultLockTTL: defaultLockTTL,
+		HashTags:       cluster,
+	}
 }
 
 // WithRedisBackend provides a way to configure the StorageBackend for a
@@ -100,3 +511,10 @@ func WithRedisBackend(clientOpts *redis.Options, lockOpts *redislock.Options, de
 	backend := New(clientOpts, lockOpts, defaultLockTTL)
 	return circuitry.WithStorageBackend(backend)
 }
+
+// WithRedisClient provides a way to configure the StorageBackend for a
+// Circuit Breaker Factory's settings with an existing client. See
+// [NewWithClient].
+func WithRedisClient(client Client, lockOpts *redislock.Options, defaultLockTTL time.Duration) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(NewWithClient(client, lockOpts, defaultLockTTL))
+}
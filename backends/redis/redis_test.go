@@ -7,12 +7,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/bsm/redislock"
 	redismock "github.com/go-redis/redismock/v9"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/sigmavirus24/circuitry"
 	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/codec"
 )
 
 func requireExpectations(t *testing.T, mock redismock.ClientMock) {
@@ -129,8 +132,7 @@ func TestBackendStore(t *testing.T) {
 		t.Fatalf("serializing CircuitInformation to JSON shouldn't fail but it did, %v", err)
 	}
 	key := "store-circuit-breaker-info-1234"
-	var zero time.Time
-	mock.ExpectSetArgs(key, string(jsonBytes), redis.SetArgs{ExpireAt: zero}).SetVal("")
+	mock.ExpectSetArgs(key, string(jsonBytes), redis.SetArgs{}).SetVal("")
 
 	b := redisbackend.Backend{Client: db, Locker: redislock.New(db), LockOpts: &redislock.Options{}, DefaultLockTTL: 0}
 	err = b.Store(context.TODO(), key, expectedInfo)
@@ -158,13 +160,41 @@ func TestBackendStoreError(t *testing.T) {
 	requireExpectations(t, mock)
 }
 
+func TestBackendDelete(t *testing.T) {
+	testCases := map[string]struct {
+		err error
+	}{
+		"deleted": {nil},
+		"error":   {redis.ErrClosed},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			key := "delete-circuit-breaker-1234"
+			if tc.err != nil {
+				mock.ExpectDel(key).SetErr(tc.err)
+			} else {
+				mock.ExpectDel(key).SetVal(1)
+			}
+			b := redisbackend.Backend{Client: db, Locker: redislock.New(db), LockOpts: &redislock.Options{}, DefaultLockTTL: 0}
+			if err := b.Delete(context.TODO(), key); !errors.Is(err, tc.err) {
+				t.Fatalf("expected err = %v; got %v", tc.err, err)
+			}
+			requireExpectations(t, mock)
+		})
+	}
+}
+
 func TestBackendLock(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 
 	key := "lock-circuit-breaker-1234"
 	lockOpts := &redislock.Options{}
 
-	mock.Regexp().ExpectEvalSha(`.*`, []string{key}, `.*`, `[0-9]+`, `0.*`).SetVal("")
+	mock.Regexp().ExpectEvalSha(`.*`, []string{"circuitry:lock:" + key}, `.*`, `[0-9]+`, `0.*`).SetVal("")
+	mock.ExpectIncr("circuitry:lock:" + key + ":fence").SetVal(3)
 
 	b := redisbackend.Backend{
 		Client:         db,
@@ -176,6 +206,10 @@ func TestBackendLock(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected to retrieve a lock successfully, but didn't due to %v", err)
 	}
+	lease, ok := lock.(circuitry.Lease)
+	if !ok || lease.Token() != 3 || lease.Err() != nil {
+		t.Fatalf("expected a lease with the incremented fencing token; got %#v", lock)
+	}
 	lock.Lock() // Technically a no-op because redislock creates the lock so no point in calling Lock, but we implemented it for sync.Locker
 	defer lock.Unlock()
 
@@ -188,7 +222,7 @@ func TestBackendLockError(t *testing.T) {
 	key := "lock-circuit-breaker-1234"
 	lockOpts := &redislock.Options{}
 
-	mock.Regexp().ExpectEvalSha(`.*`, []string{key}, `.*`, `[0-9]+`, `0.*`).SetErr(redis.ErrClosed)
+	mock.Regexp().ExpectEvalSha(`.*`, []string{"circuitry:lock:" + key}, `.*`, `[0-9]+`, `0.*`).SetErr(redis.ErrClosed)
 
 	b := redisbackend.Backend{
 		Client:         db,
@@ -204,6 +238,24 @@ func TestBackendLockError(t *testing.T) {
 	requireExpectations(t, mock)
 }
 
+func TestBackendLockFenceError(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+
+	key := "lock-circuit-breaker-1234"
+	mock.Regexp().ExpectEvalSha(`.*`, []string{"circuitry:lock:" + key}, `.*`, `[0-9]+`, `0.*`).SetVal("")
+	mock.ExpectIncr("circuitry:lock:" + key + ":fence").SetErr(redis.ErrClosed)
+
+	b := redisbackend.Backend{
+		Client:         db,
+		Locker:         redislock.New(db),
+		LockOpts:       &redislock.Options{},
+		DefaultLockTTL: 0,
+	}
+	if _, err := b.Lock(context.TODO(), key); !errors.Is(err, redis.ErrClosed) {
+		t.Fatalf("expected to get redis.ErrClosed; got err = %v", err)
+	}
+}
+
 func TestNewBackend(t *testing.T) {
 	b := redisbackend.New(&redis.Options{
 		Addr:     "localhost:6379",
@@ -238,3 +290,534 @@ func TestWithRedisBackend(t *testing.T) {
 		t.Fatalf("expected to get an ErrSettingConflict; got err = %v", err)
 	}
 }
+
+func TestBackendPublishTransition(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	event := circuitry.TransitionEvent{
+		Name:       "publish-circuit-breaker-1234",
+		From:       circuitry.CircuitClosed,
+		To:         circuitry.CircuitOpen,
+		Generation: 2,
+		Time:       time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Err:        errors.New("test"),
+	}
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("serializing TransitionEvent to JSON shouldn't fail but it did, %v", err)
+	}
+	mock.ExpectPublish("transitions", string(jsonBytes)).SetVal(1)
+
+	b := redisbackend.Backend{Client: db, Locker: redislock.New(db), TransitionChannel: "transitions"}
+	if err := b.PublishTransition(context.TODO(), event); err != nil {
+		t.Fatalf("expected to publish the transition; got err = %v", err)
+	}
+	requireExpectations(t, mock)
+}
+
+func TestBackendPublishTransitionErrors(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	event := circuitry.TransitionEvent{Name: "publish-circuit-breaker-1234"}
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("serializing TransitionEvent to JSON shouldn't fail but it did, %v", err)
+	}
+	mock.ExpectPublish(redisbackend.DefaultTransitionChannel, string(jsonBytes)).SetErr(redis.ErrClosed)
+
+	b := redisbackend.Backend{Client: db, Locker: redislock.New(db)}
+	if err := b.PublishTransition(context.TODO(), event); !errors.Is(err, redis.ErrClosed) {
+		t.Fatalf("expected to get redis.ErrClosed; got err = %v", err)
+	}
+	event.CircuitContext = map[string]any{"unserializable": make(chan int)}
+	if err := b.PublishTransition(context.TODO(), event); err == nil {
+		t.Fatal("expected an error serializing the CircuitContext; got nil")
+	}
+	requireExpectations(t, mock)
+}
+
+func newMiniredisBackend(t *testing.T) (*miniredis.Miniredis, *redisbackend.Backend) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return server, &redisbackend.Backend{
+		Client:         client,
+		Locker:         redislock.New(client),
+		LockOpts:       &redislock.Options{},
+		DefaultLockTTL: time.Minute,
+	}
+}
+
+func waitForSubscribers(t *testing.T, server *miniredis.Miniredis, channel string, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for server.PubSubNumSub(channel)[channel] < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers to %s after 1s", count, channel)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackendWatchTransitions(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan circuitry.TransitionEvent, 1)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- b.WatchTransitions(ctx, func(event circuitry.TransitionEvent) { events <- event })
+	}()
+	waitForSubscribers(t, server, redisbackend.DefaultTransitionChannel, 1)
+
+	server.Publish(redisbackend.DefaultTransitionChannel, "not json")
+	if err := b.PublishTransition(ctx, circuitry.TransitionEvent{
+		Name: "watched",
+		From: circuitry.CircuitOpen,
+		To:   circuitry.CircuitHalfOpen,
+		Err:  errors.New("test"),
+	}); err != nil {
+		t.Fatalf("expected to publish the transition; got err = %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Name != "watched" || event.From != circuitry.CircuitOpen || event.To != circuitry.CircuitHalfOpen {
+			t.Fatalf("expected watched open -> half-open; got %+v", event)
+		}
+		if event.Err == nil || event.Err.Error() != "test" {
+			t.Fatalf("expected event.Err to have the message test; got %v", event.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected to receive an event after 1s")
+	}
+
+	cancel()
+	if err := <-watchErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled; got err = %v", err)
+	}
+}
+
+func TestBackendWatchTransitionsErrors(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	server.Close()
+	if err := b.WatchTransitions(context.Background(), func(circuitry.TransitionEvent) {}); err == nil {
+		t.Fatal("expected an error subscribing to a closed server; got nil")
+	}
+
+	server, b = newMiniredisBackend(t)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- b.WatchTransitions(context.Background(), func(circuitry.TransitionEvent) {})
+	}()
+	waitForSubscribers(t, server, redisbackend.DefaultTransitionChannel, 1)
+	_ = b.Client.(*redis.Client).Close()
+	select {
+	case err := <-watchErr:
+		if !errors.Is(err, redis.ErrClosed) {
+			t.Fatalf("expected redis.ErrClosed; got err = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WatchTransitions to return after the client was closed")
+	}
+}
+
+func TestFactoryWatchDeliversTransitionsFromOtherInstances(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	newFactory := func() *circuitry.CircuitBreakerFactory {
+		settings, err := circuitry.NewFactorySettings(
+			circuitry.WithStorageBackend(b),
+			circuitry.WithFailureCountThreshold(0),
+			circuitry.WithAllowAfter(time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+		}
+		return circuitry.NewCircuitBreakerFactory(settings)
+	}
+	tripping, watching := newFactory(), newFactory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trippingSub := tripping.Subscribe(ctx, nil)
+	watchingSub := watching.Subscribe(ctx, nil)
+	for _, factory := range []*circuitry.CircuitBreakerFactory{tripping, watching} {
+		go func() { _ = factory.Watch(ctx) }()
+	}
+	waitForSubscribers(t, server, redisbackend.DefaultTransitionChannel, 2)
+
+	breaker := tripping.BreakerFor("shared", map[string]any{"tenant": "a"})
+	_, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	if err != nil {
+		t.Fatalf("expected to store the circuit state; got err = %v", err)
+	}
+
+	select {
+	case event := <-watchingSub.C:
+		if !event.Remote {
+			t.Fatal("expected the event to be marked as remote")
+		}
+		if event.Name != "shared" || event.To != circuitry.CircuitOpen || event.CircuitContext["tenant"] != "a" {
+			t.Fatalf("expected shared to open with its context; got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the watching factory to receive the transition after 1s")
+	}
+	select {
+	case event := <-trippingSub.C:
+		if event.Remote {
+			t.Fatal("expected the tripping factory's event to be local")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the tripping factory to receive the transition after 1s")
+	}
+	select {
+	case event := <-trippingSub.C:
+		t.Fatalf("expected the tripping factory to not receive its own transition twice; got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBackendList(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	ctx := context.Background()
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, Total: 3}
+	for _, name := range []string{"tenant-a", "tenant-b", "other", "tenant*[literal]"} {
+		if err := b.Store(ctx, name, open); err != nil {
+			t.Fatalf("expected to store %s; got err = %v", name, err)
+		}
+	}
+	if err := server.Set("tenant-not-json", "token"); err != nil {
+		t.Fatalf("expected to set tenant-not-json; got err = %v", err)
+	}
+	server.HSet("tenant-hash", "field", "value")
+
+	names := map[string]circuitry.CircuitInformation{}
+	for entry, err := range b.List(ctx, "tenant-") {
+		if err != nil {
+			t.Fatalf("expected to list circuits; got err = %v", err)
+		}
+		names[entry.Name] = entry.Information
+	}
+	if len(names) != 2 || names["tenant-a"] != open || names["tenant-b"] != open {
+		t.Fatalf("expected tenant-a and tenant-b to be listed; got %v", names)
+	}
+
+	names = map[string]circuitry.CircuitInformation{}
+	for entry, err := range b.List(ctx, "tenant*[") {
+		if err != nil {
+			t.Fatalf("expected to list circuits; got err = %v", err)
+		}
+		names[entry.Name] = entry.Information
+	}
+	if _, ok := names["tenant*[literal]"]; !ok || len(names) != 1 {
+		t.Fatalf("expected the prefix to be matched literally; got %v", names)
+	}
+
+	count := 0
+	for range b.List(ctx, "") {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("expected iteration to stop after 1 entry; got %d", count)
+	}
+}
+
+func TestBackendListOnlyLocks(t *testing.T) {
+	_, b := newMiniredisBackend(t)
+	ctx := context.Background()
+	lock, err := b.Lock(ctx, "locked")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock; got err = %v", err)
+	}
+	lock.Unlock()
+
+	for entry, err := range b.List(ctx, "") {
+		t.Fatalf("expected no circuits to be listed; got %+v, err = %v", entry, err)
+	}
+}
+
+func TestBackendListPages(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	info, err := json.Marshal(circuitry.CircuitInformation{Generation: 1})
+	if err != nil {
+		t.Fatalf("serializing CircuitInformation to JSON shouldn't fail but it did, %v", err)
+	}
+	mock.ExpectScan(0, "*", 100).SetVal([]string{"a"}, 7)
+	mock.ExpectMGet("a").SetVal([]any{string(info)})
+	mock.ExpectScan(7, "*", 100).SetVal([]string{}, 9)
+	mock.ExpectScan(9, "*", 100).SetVal([]string{"b", "deleted"}, 0)
+	mock.ExpectMGet("b", "deleted").SetVal([]any{string(info), nil})
+
+	b := redisbackend.Backend{Client: db, Locker: redislock.New(db)}
+	var names []string
+	for entry, err := range b.List(context.TODO(), "") {
+		if err != nil {
+			t.Fatalf("expected to list circuits; got err = %v", err)
+		}
+		names = append(names, entry.Name)
+	}
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Fatalf("expected [a b]; got %v", names)
+	}
+	requireExpectations(t, mock)
+}
+
+func TestBackendListErrors(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	mock.ExpectScan(0, "*", 100).SetErr(redis.ErrClosed)
+	mock.ExpectScan(0, "*", 100).SetVal([]string{"a"}, 0)
+	mock.ExpectMGet("a").SetErr(redis.ErrClosed)
+
+	b := redisbackend.Backend{Client: db, Locker: redislock.New(db)}
+	for range 2 {
+		errs := 0
+		for _, err := range b.List(context.TODO(), "") {
+			if !errors.Is(err, redis.ErrClosed) {
+				t.Fatalf("expected to get redis.ErrClosed; got err = %v", err)
+			}
+			errs++
+		}
+		if errs != 1 {
+			t.Fatalf("expected 1 error; got %d", errs)
+		}
+	}
+	requireExpectations(t, mock)
+}
+
+func TestBackendLockHeartbeat(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	b.DefaultLockTTL = 50 * time.Millisecond
+	b.HeartbeatInterval = 5 * time.Millisecond
+	ctx := context.Background()
+
+	lock, err := b.Lock(ctx, "heartbeat")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock; got err = %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	time.Sleep(100 * time.Millisecond)
+	if err := lease.Err(); err != nil {
+		t.Fatalf("expected the lease to be renewed past its TTL; got err = %v", err)
+	}
+	if ttl := server.TTL("circuitry:lock:heartbeat"); ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Fatalf("expected the lock to be refreshed with its TTL; got %v", ttl)
+	}
+
+	server.Del("circuitry:lock:heartbeat")
+	deadline := time.Now().Add(time.Second)
+	for lease.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := lease.Err(); !errors.Is(err, circuitry.ErrLockLost) || !errors.Is(err, redislock.ErrNotObtained) {
+		t.Fatalf("expected the lease to be lost when it cannot be refreshed; got err = %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestBackendLockReleaseTwice(t *testing.T) {
+	_, b := newMiniredisBackend(t)
+	b.DefaultLockTTL = 50 * time.Millisecond
+	b.HeartbeatInterval = 5 * time.Millisecond
+	circuitrytest.ReleaseTwice(t, b)
+}
+
+func TestBackendLockExpires(t *testing.T) {
+	_, b := newMiniredisBackend(t)
+	b.DefaultLockTTL = 10 * time.Millisecond
+	b.HeartbeatInterval = -1
+
+	lock, err := b.Lock(context.Background(), "expires")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock; got err = %v", err)
+	}
+	defer lock.Unlock()
+	lease := lock.(circuitry.Lease)
+	if err := lease.Err(); err != nil {
+		t.Fatalf("expected the lease to be held; got err = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := lease.Err(); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected the lease to expire without a heartbeat; got err = %v", err)
+	}
+}
+
+func TestBackendStoreFenced(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	b.Retention = circuitry.RetainUntilExpiry(time.Minute)
+	ctx := context.Background()
+	expiresAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	info := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: expiresAfter}
+
+	stale, err := b.Lock(ctx, "fenced")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock; got err = %v", err)
+	}
+	stale.Unlock()
+	current, err := b.Lock(ctx, "fenced")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock again; got err = %v", err)
+	}
+	defer current.Unlock()
+
+	if err := b.StoreFenced(ctx, "fenced", info, stale.(circuitry.Lease).Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected the stale token to be fenced off; got err = %v", err)
+	}
+	if server.Exists("fenced") {
+		t.Fatal("expected nothing to be stored with the stale token")
+	}
+	if err := b.StoreFenced(ctx, "fenced", info, current.(circuitry.Lease).Token()); err != nil {
+		t.Fatalf("expected to store with the current token; got err = %v", err)
+	}
+	stored, err := b.Retrieve(ctx, "fenced")
+	if err != nil || stored.State != circuitry.CircuitOpen || stored.Generation != 2 {
+		t.Fatalf("expected the information to be stored; got %+v (err = %v)", stored, err)
+	}
+	if ttl := server.TTL("fenced"); ttl <= time.Hour {
+		t.Fatalf("expected the key to expire with the circuit; got %v", ttl)
+	}
+	if err := b.StoreFenced(ctx, "unexpiring", circuitry.CircuitInformation{}, 0); err != nil {
+		t.Fatalf("expected to store without a lock ever being obtained; got err = %v", err)
+	}
+	if ttl := server.TTL("unexpiring"); ttl != 0 {
+		t.Fatalf("expected the key to not expire; got %v", ttl)
+	}
+
+	server.SetError("ERR unavailable")
+	if err := b.StoreFenced(ctx, "fenced", info, 2); err == nil {
+		t.Fatal("expected an error when Redis is unavailable; got nil")
+	}
+}
+
+func TestBreakerEndAfterLockTakenOver(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	b.HeartbeatInterval = -1
+	settings, err := circuitry.NewFactorySettings(circuitry.WithStorageBackend(b))
+	if err != nil {
+		t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+	}
+	breaker := circuitry.NewCircuitBreakerFactory(settings).BreakerFor("taken-over", map[string]any{})
+	ctx := context.Background()
+
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start the breaker; got err = %v", err)
+	}
+	// Another process obtains the lock once this one's has expired
+	server.Del("circuitry:lock:taken-over")
+	other, err := b.Lock(ctx, "taken-over")
+	if err != nil {
+		t.Fatalf("expected the other process to obtain the lock; got err = %v", err)
+	}
+	defer other.Unlock()
+	if err := breaker.End(ctx, nil); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected ErrLockLost; got err = %v", err)
+	}
+	if server.Exists("taken-over") {
+		t.Fatal("expected nothing to be stored by the breaker whose lock was taken over")
+	}
+}
+
+func TestBackendLockRelease(t *testing.T) {
+	server, b := newMiniredisBackend(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	lock, err := b.Lock(ctx, "release")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock; got err = %v", err)
+	}
+	cancel()
+	lock.Unlock()
+	if server.Exists("circuitry:lock:release") {
+		t.Fatal("expected Unlock to release the lock after its context was canceled")
+	}
+
+	lock, err = b.Lock(context.Background(), "release")
+	if err != nil {
+		t.Fatalf("expected to obtain the lock again; got err = %v", err)
+	}
+	server.Del("circuitry:lock:release")
+	if err := lock.(circuitry.Lease).Release(context.Background()); !errors.Is(err, redislock.ErrLockNotHeld) {
+		t.Fatalf("expected releasing an expired lock to fail; got err = %v", err)
+	}
+}
+
+func TestBackendCodecs(t *testing.T) {
+	ctx := context.Background()
+	info := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 3, Total: 4, TotalFailures: 4, ConsecutiveFailures: 4, ExpiresAfter: time.Unix(1729296000, 0)}
+	testCases := map[string]codec.Codec{
+		"default":     nil,
+		"json":        codec.JSON,
+		"messagepack": codec.MessagePack,
+		"protobuf":    codec.Protobuf,
+		"binary":      codec.Binary,
+	}
+
+	for name, testCase := range testCases {
+		c := testCase
+		t.Run(name, func(t *testing.T) {
+			server, b := newMiniredisBackend(t)
+			b.Codec = c
+			if err := b.Store(ctx, "stored", info); err != nil {
+				t.Fatalf("expected to store the circuit; got err = %v", err)
+			}
+			if err := b.StoreFenced(ctx, "fenced", info, 0); err != nil {
+				t.Fatalf("expected to store the circuit; got err = %v", err)
+			}
+			version := codec.VersionJSON
+			if c != nil {
+				version = c.Version()
+			}
+			for _, key := range []string{"stored", "fenced"} {
+				if value, _ := server.Get(key); len(value) == 0 || value[0] != version {
+					t.Fatalf("expected %s to start with the version byte %#02x; got %q", key, version, value)
+				}
+			}
+
+			// Another process configured with a different codec reads it
+			other := *b
+			other.Codec = codec.Binary
+			for _, backend := range []*redisbackend.Backend{b, &other} {
+				actual, err := backend.Retrieve(ctx, "stored")
+				if err != nil || !actual.ExpiresAfter.Equal(info.ExpiresAfter) {
+					t.Fatalf("expected %+v; got %+v, %v", info, actual, err)
+				}
+				count := 0
+				for entry, err := range backend.List(ctx, "") {
+					if err != nil || entry.Information.Generation != info.Generation {
+						t.Fatalf("expected %+v; got %+v, %v", info, entry.Information, err)
+					}
+					count++
+				}
+				if count != 2 {
+					t.Fatalf("expected 2 circuits; got %d", count)
+				}
+			}
+		})
+	}
+}
+
+// failingCodec fails to marshal circuit information
+type failingCodec struct{ codec.Codec }
+
+func (failingCodec) Marshal(circuitry.CircuitInformation) ([]byte, error) {
+	return nil, errors.New("marshal failed")
+}
+
+func TestBackendStoreMarshalError(t *testing.T) {
+	ctx := context.Background()
+	server, b := newMiniredisBackend(t)
+	b.Codec = failingCodec{codec.JSON}
+	if err := b.Store(ctx, "circuit", circuitry.CircuitInformation{}); err == nil || err.Error() != "marshal failed" {
+		t.Fatalf("expected the codec's error; got %v", err)
+	}
+	if err := b.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 0); err == nil || err.Error() != "marshal failed" {
+		t.Fatalf("expected the codec's error; got %v", err)
+	}
+	if len(server.Keys()) != 0 {
+		t.Fatalf("expected nothing to be stored; got %v", server.Keys())
+	}
+
+	// Times JSON cannot represent were previously stored as an empty value
+	b.Codec = nil
+	unrepresentable := circuitry.CircuitInformation{ExpiresAfter: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := b.Store(ctx, "circuit", unrepresentable); err == nil {
+		t.Fatal("expected an error marshaling the circuit to JSON")
+	}
+}
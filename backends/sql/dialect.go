@@ -0,0 +1,99 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect identifies the database the Backend's statements are written for
+type Dialect int
+
+const (
+	// PostgreSQL uses numbered placeholders and INSERT ... ON CONFLICT
+	PostgreSQL Dialect = iota
+	// MySQL uses INSERT ... ON DUPLICATE KEY UPDATE and compares names with
+	// a binary collation. MariaDB uses the same dialect.
+	MySQL
+	// SQLite requires version 3.24.0 or later for INSERT ... ON CONFLICT
+	SQLite
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case PostgreSQL:
+		return "PostgreSQL"
+	case MySQL:
+		return "MySQL"
+	case SQLite:
+		return "SQLite"
+	default:
+		return "unknown-dialect"
+	}
+}
+
+// nameType is the column type of circuits' names, which are compared byte
+// for byte
+func (d Dialect) nameType() string {
+	switch d {
+	case MySQL:
+		return "VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin"
+	case SQLite:
+		return "TEXT"
+	default:
+		return "VARCHAR(255)"
+	}
+}
+
+// upsert returns the clause following an INSERT into the table keyed by name
+// that updates the columns of the existing row instead
+func (d Dialect) upsert(columns []string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		if d == MySQL {
+			assignments[i] = fmt.Sprintf("%s = VALUES(%s)", column, column)
+		} else {
+			assignments[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+		}
+	}
+	if d == MySQL {
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+	return " ON CONFLICT (name) DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+// ignoreDuplicate returns the clause following an INSERT into the table keyed
+// by name that leaves an existing row as it is
+func (d Dialect) ignoreDuplicate() string {
+	if d == MySQL {
+		return " ON DUPLICATE KEY UPDATE name = name"
+	}
+	return " ON CONFLICT (name) DO NOTHING"
+}
+
+// forUpdate returns the clause following a SELECT that locks the rows it
+// reads until the transaction ends. SQLite has no row locks.
+func (d Dialect) forUpdate() string {
+	if d == SQLite {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// rebind replaces the ? placeholders in the query with the dialect's
+func (d Dialect) rebind(query string) string {
+	if d != PostgreSQL {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	sqlbackend "github.com/sigmavirus24/circuitry/backends/sql"
+)
+
+func TestDialectString(t *testing.T) {
+	for dialect, expected := range map[sqlbackend.Dialect]string{
+		sqlbackend.PostgreSQL:  "PostgreSQL",
+		sqlbackend.MySQL:       "MySQL",
+		sqlbackend.SQLite:      "SQLite",
+		sqlbackend.Dialect(42): "unknown-dialect",
+	} {
+		if actual := dialect.String(); actual != expected {
+			t.Fatalf("expected %q; got %q", expected, actual)
+		}
+	}
+}
+
+func TestDialectStatements(t *testing.T) {
+	testCases := map[string]struct {
+		dialect  sqlbackend.Dialect
+		expected []string
+	}{
+		"PostgreSQL": {
+			dialect: sqlbackend.PostgreSQL,
+			expected: []string{
+				"CREATE TABLE IF NOT EXISTS circuitry_circuits (name VARCHAR(255) NOT NULL PRIMARY KEY, state BIGINT NOT NULL DEFAULT 0,",
+				"INSERT INTO circuitry_schema_migrations (version, description, applied_at) VALUES ($1, $2, $3)",
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (name) DO UPDATE SET state = excluded.state, generation = excluded.generation,",
+				"SELECT fence FROM circuitry_locks WHERE name = $1 FOR UPDATE",
+				"SELECT name, state, generation, consecutive_failures, consecutive_successes, total, total_failures, total_successes, expires_after FROM circuitry_circuits WHERE SUBSTR(name, 1, $1) = $2 ORDER BY name",
+				"INSERT INTO circuitry_locks (name, owner, expires_at, fence) VALUES ($1, '', 0, 0) ON CONFLICT (name) DO NOTHING",
+				"UPDATE circuitry_locks SET owner = $1, expires_at = $2, fence = fence + 1 WHERE name = $3 AND (owner = '' OR expires_at <= $4)",
+				"UPDATE circuitry_locks SET owner = '', expires_at = 0 WHERE name = $1 AND owner = $2",
+			},
+		},
+		"MySQL": {
+			dialect: sqlbackend.MySQL,
+			expected: []string{
+				"CREATE TABLE IF NOT EXISTS circuitry_circuits (name VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL PRIMARY KEY,",
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE state = VALUES(state), generation = VALUES(generation),",
+				"SELECT fence FROM circuitry_locks WHERE name = ? FOR UPDATE",
+				"WHERE SUBSTR(name, 1, ?) = ? ORDER BY name",
+				"INSERT INTO circuitry_locks (name, owner, expires_at, fence) VALUES (?, '', 0, 0) ON DUPLICATE KEY UPDATE name = name",
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := &fakeDriver{rowsAffected: 1, fence: []driver.Value{int64(3)}}
+			backend := &sqlbackend.Backend{DB: newFakeDB(t, fake), Dialect: tc.dialect, HeartbeatInterval: -1}
+			if _, err := backend.Migrate(ctx); err != nil {
+				t.Fatalf("expected to migrate; got %v", err)
+			}
+			if err := backend.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 3); err != nil {
+				t.Fatalf("expected to store fenced; got %v", err)
+			}
+			for _, err := range backend.List(ctx, "c") {
+				t.Fatalf("expected no circuits; got %v", err)
+			}
+			lock, err := backend.Lock(ctx, "circuit")
+			if err != nil {
+				t.Fatalf("expected to lock; got %v", err)
+			}
+			if token := lock.(circuitry.Lease).Token(); token != 3 {
+				t.Fatalf("expected token 3; got %d", token)
+			}
+			lock.Unlock()
+
+			statements := strings.Join(fake.Statements(), "\n")
+			for _, expected := range tc.expected {
+				if !strings.Contains(statements, expected) {
+					t.Fatalf("expected a statement containing\n%s\ngot\n%s", expected, statements)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	operations := map[string]func(context.Context, *sqlbackend.Backend) error{
+		"store": func(ctx context.Context, b *sqlbackend.Backend) error {
+			return b.Store(ctx, "circuit", circuitry.CircuitInformation{})
+		},
+		"store fenced": func(ctx context.Context, b *sqlbackend.Backend) error {
+			return b.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{}, 1)
+		},
+		"retrieve": func(ctx context.Context, b *sqlbackend.Backend) error {
+			_, err := b.Retrieve(ctx, "circuit")
+			return err
+		},
+		"list": func(ctx context.Context, b *sqlbackend.Backend) error {
+			for _, err := range b.List(ctx, "") {
+				return err
+			}
+			return nil
+		},
+		"delete": func(ctx context.Context, b *sqlbackend.Backend) error {
+			return b.Delete(ctx, "circuit")
+		},
+		"lock": func(ctx context.Context, b *sqlbackend.Backend) error {
+			_, err := b.Lock(ctx, "circuit")
+			return err
+		},
+		"release": func(ctx context.Context, b *sqlbackend.Backend) error {
+			lock, err := b.Lock(ctx, "circuit")
+			if err != nil {
+				return err
+			}
+			return lock.(circuitry.Lease).Release(ctx)
+		},
+		"migrate": func(ctx context.Context, b *sqlbackend.Backend) error {
+			_, err := b.Migrate(ctx)
+			return err
+		},
+	}
+	testCases := map[string]struct {
+		operation string
+		fake      *fakeDriver
+	}{
+		"store":                      {operation: "store", fake: &fakeDriver{failOn: "INSERT"}},
+		"store fenced begin":         {operation: "store fenced", fake: &fakeDriver{failOn: "BEGIN"}},
+		"store fenced select":        {operation: "store fenced", fake: &fakeDriver{failOn: "SELECT fence"}},
+		"store fenced insert":        {operation: "store fenced", fake: &fakeDriver{failOn: "INSERT"}},
+		"store fenced commit":        {operation: "store fenced", fake: &fakeDriver{failOn: "COMMIT"}},
+		"retrieve":                   {operation: "retrieve", fake: &fakeDriver{failOn: "SELECT"}},
+		"retrieve scan":              {operation: "retrieve", fake: &fakeDriver{circuits: [][]driver.Value{{"circuit", "open"}}}},
+		"list query":                 {operation: "list", fake: &fakeDriver{failOn: "SELECT"}},
+		"list scan":                  {operation: "list", fake: &fakeDriver{circuits: [][]driver.Value{{"circuit", "open"}}}},
+		"list rows":                  {operation: "list", fake: &fakeDriver{circuits: [][]driver.Value{nil}}},
+		"delete":                     {operation: "delete", fake: &fakeDriver{failOn: "DELETE"}},
+		"lock insert":                {operation: "lock", fake: &fakeDriver{failOn: "INSERT"}},
+		"lock update":                {operation: "lock", fake: &fakeDriver{failOn: "UPDATE"}},
+		"lock select":                {operation: "lock", fake: &fakeDriver{failOn: "SELECT fence", rowsAffected: 1}},
+		"release":                    {operation: "release", fake: &fakeDriver{failOn: "SET owner = ''", rowsAffected: 1, fence: []driver.Value{int64(1)}}},
+		"migrate create":             {operation: "migrate", fake: &fakeDriver{failOn: "CREATE TABLE IF NOT EXISTS circuitry_schema_migrations"}},
+		"migrate versions":           {operation: "migrate", fake: &fakeDriver{failOn: "SELECT version"}},
+		"migrate versions scan":      {operation: "migrate", fake: &fakeDriver{versions: [][]driver.Value{{"one"}}}},
+		"migrate begin":              {operation: "migrate", fake: &fakeDriver{failOn: "BEGIN"}},
+		"migrate up":                 {operation: "migrate", fake: &fakeDriver{failOn: "CREATE TABLE IF NOT EXISTS circuitry_circuits"}},
+		"migrate record":             {operation: "migrate", fake: &fakeDriver{failOn: "INSERT INTO circuitry_schema_migrations"}},
+		"migrate commit":             {operation: "migrate", fake: &fakeDriver{failOn: "COMMIT"}},
+		"migrate versions iteration": {operation: "migrate", fake: &fakeDriver{versions: [][]driver.Value{nil}}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			backend := &sqlbackend.Backend{DB: newFakeDB(t, tc.fake), Dialect: sqlbackend.SQLite, HeartbeatInterval: -1}
+			err := operations[tc.operation](context.Background(), backend)
+			if err == nil {
+				t.Fatalf("expected an error; got nil")
+			}
+			if tc.fake.failOn != "" && !errors.Is(err, errTest) {
+				t.Fatalf("expected %v; got %v", errTest, err)
+			}
+		})
+	}
+}
+
+func TestLeaseRefreshError(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDriver{rowsAffected: 1, fence: []driver.Value{int64(1)}}
+	backend := &sqlbackend.Backend{DB: newFakeDB(t, fake), Dialect: sqlbackend.SQLite, LockTTL: time.Minute, HeartbeatInterval: 10 * time.Millisecond}
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	fake.mu.Lock()
+	fake.failOn = "SET expires_at"
+	fake.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if err := lease.Err(); !errors.Is(err, circuitry.ErrLockLost) || !errors.Is(err, errTest) {
+		t.Fatalf("expected %v wrapping %v; got %v", circuitry.ErrLockLost, errTest, err)
+	}
+}
@@ -0,0 +1,12 @@
+package sql
+
+import "errors"
+
+// ErrLockNotObtained is returned by Lock when another process holds the
+// circuit's lock and the Backend does not retry, or stops retrying because
+// the context is done
+var ErrLockNotObtained = errors.New("sql backend could not obtain lock")
+
+// ErrUnsupportedSchemaVersion is returned by Migrate when the database's
+// schema was migrated by a newer version of this package
+var ErrUnsupportedSchemaVersion = errors.New("sql backend schema has an unsupported version")
@@ -0,0 +1,124 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var errTest = errors.New("test")
+
+// fakeDriver records the statements it runs and returns errTest from those
+// containing failOn. Queries return the fence, versions, or circuits.
+type fakeDriver struct {
+	mu           sync.Mutex
+	statements   []string
+	failOn       string
+	rowsAffected int64
+	fence        []driver.Value
+	versions     [][]driver.Value
+	circuits     [][]driver.Value
+}
+
+func newFakeDB(t *testing.T, d *fakeDriver) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(fakeConnector{d})
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func (d *fakeDriver) Statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.statements...)
+}
+
+func (d *fakeDriver) run(statement string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, statement)
+	if d.failOn != "" && strings.Contains(statement, d.failOn) {
+		return errTest
+	}
+	return nil
+}
+
+type fakeConnector struct{ driver *fakeDriver }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ driver *fakeDriver }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if err := c.driver.run("BEGIN"); err != nil {
+		return nil, err
+	}
+	return fakeTx(c), nil
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	if err := c.driver.run(query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(c.driver.rowsAffected), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if err := c.driver.run(query); err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(query, "SELECT fence"):
+		if c.driver.fence == nil {
+			return &fakeRows{columns: []string{"fence"}}, nil
+		}
+		return &fakeRows{columns: []string{"fence"}, values: [][]driver.Value{c.driver.fence}}, nil
+	case strings.HasPrefix(query, "SELECT version"):
+		return &fakeRows{columns: []string{"version"}, values: c.driver.versions}, nil
+	case strings.HasPrefix(query, "SELECT name"):
+		return &fakeRows{columns: make([]string, 9), values: c.driver.circuits}, nil
+	default:
+		values := make([][]driver.Value, len(c.driver.circuits))
+		for i, circuit := range c.driver.circuits {
+			values[i] = circuit[1:]
+		}
+		return &fakeRows{columns: make([]string, 8), values: values}, nil
+	}
+}
+
+type fakeTx struct{ driver *fakeDriver }
+
+func (t fakeTx) Commit() error   { return t.driver.run("COMMIT") }
+func (t fakeTx) Rollback() error { return nil }
+
+// fakeRows returns the values, then errTest if a row is nil
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	row := r.values[0]
+	r.values = r.values[1:]
+	if row == nil {
+		return errTest
+	}
+	copy(dest, row)
+	return nil
+}
@@ -0,0 +1,131 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultMigrationTableName is the table recording the migrations applied
+// when the Backend's MigrationTableName is empty
+const DefaultMigrationTableName = "circuitry_schema_migrations"
+
+// Migration changes the schema of the Backend's tables from the previous
+// Version to its own
+type Migration struct {
+	Version     int
+	Description string
+	// Up applies the migration in the transaction. Statements should be
+	// written with the Backend's Dialect and table names. MySQL commits
+	// each CREATE and ALTER statement as it runs.
+	Up func(ctx context.Context, tx *sql.Tx, b *Backend) error
+}
+
+// Migrations returns the migrations Migrate applies, in order of Version
+func Migrations() []Migration {
+	return []Migration{
+		{Version: 1, Description: "create circuit and lock tables", Up: createTables},
+	}
+}
+
+func createTables(ctx context.Context, tx *sql.Tx, b *Backend) error {
+	columns := make([]string, len(circuitColumns))
+	for i, column := range circuitColumns {
+		columns[i] = column + " BIGINT NOT NULL DEFAULT 0"
+	}
+	statements := []string{
+		fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (name %s NOT NULL PRIMARY KEY, %s)",
+			b.circuitTable(), b.Dialect.nameType(), strings.Join(columns, ", "),
+		),
+		fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (name %s NOT NULL PRIMARY KEY, owner VARCHAR(64) NOT NULL DEFAULT '', expires_at BIGINT NOT NULL DEFAULT 0, fence BIGINT NOT NULL DEFAULT 0)",
+			b.lockTable(), b.Dialect.nameType(),
+		),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backend) migrationTable() string {
+	if b.MigrationTableName == "" {
+		return DefaultMigrationTableName
+	}
+	return b.MigrationTableName
+}
+
+// Migrate applies the Migrations that have not been applied yet, each in its
+// own transaction, recording them in the MigrationTableName, and returns how
+// many were applied. It returns ErrUnsupportedSchemaVersion if a migration
+// newer than this package's was applied. Run it before using the Backend,
+// from one process at a time.
+func (b *Backend) Migrate(ctx context.Context) (int, error) {
+	create := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL PRIMARY KEY, description VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)",
+		b.migrationTable(),
+	)
+	if _, err := b.exec(ctx, create); err != nil {
+		return 0, err
+	}
+	applied, err := b.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	migrations := Migrations()
+	latest := migrations[len(migrations)-1].Version
+	for version := range applied {
+		if version > latest {
+			return 0, fmt.Errorf("%w: %d is newer than %d", ErrUnsupportedSchemaVersion, version, latest)
+		}
+	}
+	migrated := 0
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+		if err := b.apply(ctx, migration); err != nil {
+			return migrated, fmt.Errorf("cannot apply migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+func (b *Backend) appliedVersions(ctx context.Context) (map[int]bool, error) {
+	rows, err := b.DB.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", b.migrationTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (b *Backend) apply(ctx context.Context, migration Migration) error {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := migration.Up(ctx, tx, b); err != nil {
+		return err
+	}
+	record := fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (?, ?, ?)", b.migrationTable())
+	if _, err := tx.ExecContext(ctx, b.Dialect.rebind(record), migration.Version, migration.Description, time.Now().UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,471 @@
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+const (
+	// DefaultCircuitTableName is the table circuits are stored in when the
+	// Backend's CircuitTableName is empty
+	DefaultCircuitTableName = "circuitry_circuits"
+	// DefaultLockTableName is the table circuits' locks are held in when the
+	// Backend's LockTableName is empty
+	DefaultLockTableName = "circuitry_locks"
+	// DefaultLockTTL is how long a lock is held without being refreshed when
+	// the Backend's LockTTL is not set
+	DefaultLockTTL = 30 * time.Second
+	// DefaultLockRetryInterval is how often Lock tries to obtain a lock held
+	// by another process when the Backend's LockRetryInterval is not set
+	DefaultLockRetryInterval = 50 * time.Millisecond
+)
+
+// circuitColumns are the columns of the CircuitTableName holding a circuit's
+// information, in the order circuitValues returns them
+var circuitColumns = []string{
+	"state",
+	"generation",
+	"consecutive_failures",
+	"consecutive_successes",
+	"total",
+	"total_failures",
+	"total_successes",
+	"expires_after",
+}
+
+// DB describes the interface expected for this backend to function
+// appropriately. It is implemented by [*sql.DB] and [*sql.Conn].
+type DB interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+	BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Backend implements the StorageBackender interface over [database/sql].
+// Its tables are created by Migrate. Table names are written into statements
+// as they are and must not come from untrusted input.
+type Backend struct {
+	DB                 DB
+	Dialect            Dialect
+	CircuitTableName   string        // CircuitTableName defaults to DefaultCircuitTableName.
+	LockTableName      string        // LockTableName defaults to DefaultLockTableName.
+	MigrationTableName string        // MigrationTableName defaults to DefaultMigrationTableName.
+	LockTTL            time.Duration // LockTTL defaults to DefaultLockTTL.
+	HeartbeatInterval  time.Duration // HeartbeatInterval is how often held locks are refreshed. It defaults to a third of the LockTTL and a negative value disables refreshing.
+	LockRetryInterval  time.Duration // LockRetryInterval defaults to DefaultLockRetryInterval and a negative value returns ErrLockNotObtained instead of waiting for a lock held by another process.
+}
+
+func (b *Backend) circuitTable() string {
+	if b.CircuitTableName == "" {
+		return DefaultCircuitTableName
+	}
+	return b.CircuitTableName
+}
+
+func (b *Backend) lockTable() string {
+	if b.LockTableName == "" {
+		return DefaultLockTableName
+	}
+	return b.LockTableName
+}
+
+func (b *Backend) lockTTL() time.Duration {
+	if b.LockTTL <= 0 {
+		return DefaultLockTTL
+	}
+	return b.LockTTL
+}
+
+func (b *Backend) heartbeatInterval() time.Duration {
+	if b.HeartbeatInterval == 0 {
+		return b.lockTTL() / 3
+	}
+	return b.HeartbeatInterval
+}
+
+func (b *Backend) lockRetryInterval() time.Duration {
+	if b.LockRetryInterval == 0 {
+		return DefaultLockRetryInterval
+	}
+	return b.LockRetryInterval
+}
+
+func (b *Backend) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return b.DB.ExecContext(ctx, b.Dialect.rebind(query), args...)
+}
+
+func (b *Backend) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return b.DB.QueryRowContext(ctx, b.Dialect.rebind(query), args...)
+}
+
+// circuitValues returns the values of the circuitColumns for the
+// CircuitInformation. ExpiresAfter is stored in nanoseconds since the epoch,
+// or 0 if it is not set.
+func circuitValues(ci circuitry.CircuitInformation) []any {
+	var expiresAfter int64
+	if !ci.ExpiresAfter.IsZero() {
+		expiresAfter = ci.ExpiresAfter.UnixNano()
+	}
+	return []any{
+		int64(ci.State),
+		int64(ci.Generation),
+		int64(ci.ConsecutiveFailures),
+		int64(ci.ConsecutiveSuccesses),
+		int64(ci.Total),
+		int64(ci.TotalFailures),
+		int64(ci.TotalSuccesses),
+		expiresAfter,
+	}
+}
+
+// scanCircuit scans the circuitColumns following the dest from the row
+func scanCircuit(row interface{ Scan(...any) error }, dest ...any) (circuitry.CircuitInformation, error) {
+	var state, generation, consecutiveFailures, consecutiveSuccesses, total, totalFailures, totalSuccesses, expiresAfter int64
+	dest = append(dest, &state, &generation, &consecutiveFailures, &consecutiveSuccesses, &total, &totalFailures, &totalSuccesses, &expiresAfter)
+	if err := row.Scan(dest...); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	ci := circuitry.CircuitInformation{
+		State:                circuitry.CircuitState(state),
+		Generation:           uint64(generation),
+		ConsecutiveFailures:  uint64(consecutiveFailures),
+		ConsecutiveSuccesses: uint64(consecutiveSuccesses),
+		Total:                uint64(total),
+		TotalFailures:        uint64(totalFailures),
+		TotalSuccesses:       uint64(totalSuccesses),
+	}
+	if expiresAfter != 0 {
+		ci.ExpiresAfter = time.Unix(0, expiresAfter).UTC()
+	}
+	return ci, nil
+}
+
+// storeQuery inserts or updates the named circuit's row
+func (b *Backend) storeQuery() string {
+	return fmt.Sprintf(
+		"INSERT INTO %s (name, %s) VALUES (?%s)%s",
+		b.circuitTable(),
+		strings.Join(circuitColumns, ", "),
+		strings.Repeat(", ?", len(circuitColumns)),
+		b.Dialect.upsert(circuitColumns),
+	)
+}
+
+// Store saves the CircuitInformation in the named circuit's row of the
+// CircuitTableName
+func (b *Backend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
+	_, err := b.exec(ctx, b.storeQuery(), append([]any{name}, circuitValues(ci)...)...)
+	return err
+}
+
+// StoreFenced saves the CircuitInformation like Store in a transaction that
+// first locks the circuit's row of the LockTableName with SELECT ... FOR
+// UPDATE, unless a lock with a greater fencing token has been obtained for
+// the circuit, in which case it returns
+// [github.com/sigmavirus24/circuitry.ErrLockLost]. SQLite has no row locks
+// and fails the transaction instead if the lock changes before it commits.
+func (b *Backend) StoreFenced(ctx context.Context, name string, ci circuitry.CircuitInformation, token uint64) error {
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var fence int64
+	query := fmt.Sprintf("SELECT fence FROM %s WHERE name = ?%s", b.lockTable(), b.Dialect.forUpdate())
+	err = tx.QueryRowContext(ctx, b.Dialect.rebind(query), name).Scan(&fence)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if uint64(fence) > token {
+		return circuitry.ErrLockLost
+	}
+	if _, err := tx.ExecContext(ctx, b.Dialect.rebind(b.storeQuery()), append([]any{name}, circuitValues(ci)...)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Retrieve reads the named circuit's row of the CircuitTableName. If the
+// circuit is not stored, this will return an empty
+// [github.com/sigmavirus24/circuitry.CircuitInformation].
+func (b *Backend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE name = ?", strings.Join(circuitColumns, ", "), b.circuitTable())
+	ci, err := scanCircuit(b.queryRow(ctx, query, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return circuitry.CircuitInformation{}, nil
+	}
+	return ci, err
+}
+
+// Delete removes the named circuit's row from the CircuitTableName. Its lock
+// and fencing token are kept.
+func (b *Backend) Delete(ctx context.Context, name string) error {
+	_, err := b.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE name = ?", b.circuitTable()), name)
+	return err
+}
+
+// List yields the circuits in the CircuitTableName whose names start with
+// the prefix, ordered by name
+func (b *Backend) List(ctx context.Context, prefix string) iter.Seq2[circuitry.CircuitEntry, error] {
+	return func(yield func(circuitry.CircuitEntry, error) bool) {
+		query := fmt.Sprintf("SELECT name, %s FROM %s", strings.Join(circuitColumns, ", "), b.circuitTable())
+		var args []any
+		if prefix != "" {
+			// LIKE ignores case in SQLite and some MySQL collations
+			query += " WHERE SUBSTR(name, 1, ?) = ?"
+			args = append(args, utf8.RuneCountInString(prefix), prefix)
+		}
+		rows, err := b.DB.QueryContext(ctx, b.Dialect.rebind(query+" ORDER BY name"), args...)
+		if err != nil {
+			yield(circuitry.CircuitEntry{}, err)
+			return
+		}
+		defer func() { _ = rows.Close() }()
+		for rows.Next() {
+			var name string
+			ci, err := scanCircuit(rows, &name)
+			if err != nil {
+				yield(circuitry.CircuitEntry{}, err)
+				return
+			}
+			if !yield(circuitry.CircuitEntry{Name: name, Information: ci}, nil) {
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			yield(circuitry.CircuitEntry{}, err)
+		}
+	}
+}
+
+// Lock obtains the named circuit's lock in the LockTableName for the LockTTL
+// and returns a [github.com/sigmavirus24/circuitry.Lease] that is refreshed
+// every HeartbeatInterval until it is unlocked. A lock held by another
+// process is tried again every LockRetryInterval until it is released or
+// expires, or the context is done. Each lock is issued a fencing token by
+// incrementing the row's fence. Expiry is decided with each process's
+// clock, so their clocks must agree to well within the LockTTL.
+func (b *Backend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	insert := fmt.Sprintf("INSERT INTO %s (name, owner, expires_at, fence) VALUES (?, '', 0, 0)%s", b.lockTable(), b.Dialect.ignoreDuplicate())
+	if _, err := b.exec(ctx, insert, name); err != nil {
+		return nil, notObtained(ctx, err)
+	}
+	owner := rand.Text()
+	for {
+		token, ok, err := b.acquire(ctx, name, owner)
+		if err != nil {
+			return nil, notObtained(ctx, err)
+		}
+		if ok {
+			return newSQLLock(ctx, b, name, owner, token), nil
+		}
+		interval := b.lockRetryInterval()
+		if interval < 0 {
+			return nil, ErrLockNotObtained
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, notObtained(ctx, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// notObtained wraps the context's error in ErrLockNotObtained once the
+// context is done, whether it ended while waiting for the lock or during a
+// query
+func notObtained(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ErrLockNotObtained, ctxErr)
+	}
+	return err
+}
+
+// acquire takes the named circuit's lock for the owner if it is not held or
+// has expired, returning its new fencing token
+func (b *Backend) acquire(ctx context.Context, name, owner string) (uint64, bool, error) {
+	now := time.Now()
+	update := fmt.Sprintf("UPDATE %s SET owner = ?, expires_at = ?, fence = fence + 1 WHERE name = ? AND (owner = '' OR expires_at <= ?)", b.lockTable())
+	result, err := b.exec(ctx, update, owner, now.Add(b.lockTTL()).UnixNano(), name, now.UnixNano())
+	if err != nil {
+		return 0, false, err
+	}
+	if acquired, err := result.RowsAffected(); err != nil || acquired == 0 {
+		return 0, false, err
+	}
+	var fence int64
+	err = b.queryRow(ctx, fmt.Sprintf("SELECT fence FROM %s WHERE name = ? AND owner = ?", b.lockTable()), name, owner).Scan(&fence)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The lock expired and was taken by another process already
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return uint64(fence), true, nil
+}
+
+// sqlLock is a lease on a row of the LockTableName that is refreshed in the
+// background until it is unlocked
+type sqlLock struct {
+	ctx     context.Context
+	backend *Backend
+	name    string
+	owner   string
+	token   uint64
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	expires  time.Time
+	err      error
+	released bool
+}
+
+func newSQLLock(ctx context.Context, backend *Backend, name, owner string, token uint64) *sqlLock {
+	l := &sqlLock{
+		ctx:     ctx,
+		backend: backend,
+		name:    name,
+		owner:   owner,
+		token:   token,
+		expires: time.Now().Add(backend.lockTTL()),
+	}
+	if interval := backend.heartbeatInterval(); interval > 0 {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.heartbeat(interval)
+	}
+	return l
+}
+
+func (l *sqlLock) heartbeat(interval time.Duration) {
+	defer close(l.done)
+	// The caller's context ending does not release the lock so it must not
+	// stop the lease from being renewed either
+	ctx := context.WithoutCancel(l.ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			refreshed := time.Now()
+			err := l.refresh(ctx, refreshed.Add(l.backend.lockTTL()))
+			l.mu.Lock()
+			switch {
+			case errors.Is(err, circuitry.ErrLockLost):
+				l.err = err
+			case err != nil:
+				l.err = fmt.Errorf("%w: %w", circuitry.ErrLockLost, err)
+			default:
+				l.expires = refreshed.Add(l.backend.lockTTL())
+			}
+			l.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// refresh extends the lock until expires if the owner still holds it
+func (l *sqlLock) refresh(ctx context.Context, expires time.Time) error {
+	update := fmt.Sprintf("UPDATE %s SET expires_at = ? WHERE name = ? AND owner = ?", l.backend.lockTable())
+	return l.update(ctx, update, expires.UnixNano(), l.name, l.owner)
+}
+
+// update runs the statement and returns
+// [github.com/sigmavirus24/circuitry.ErrLockLost] if it did not change the
+// owner's row because the lock expired and was taken by another process
+func (l *sqlLock) update(ctx context.Context, query string, args ...any) error {
+	result, err := l.backend.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+func (l *sqlLock) Lock() {}
+
+// Unlock releases the lock with the context it was obtained with, even if
+// that context has since been canceled
+func (l *sqlLock) Unlock() {
+	_ = l.Release(context.WithoutCancel(l.ctx))
+}
+
+// Release stops refreshing the lock and releases it. It returns
+// [github.com/sigmavirus24/circuitry.ErrLockLost] if the lock had expired
+// and was taken by another process. Releasing the lock again does nothing.
+func (l *sqlLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	released := l.released
+	l.released = true
+	l.mu.Unlock()
+	if released {
+		return nil
+	}
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	update := fmt.Sprintf("UPDATE %s SET owner = '', expires_at = 0 WHERE name = ? AND owner = ?", l.backend.lockTable())
+	return l.update(ctx, update, l.name, l.owner)
+}
+
+func (l *sqlLock) Token() uint64 {
+	return l.token
+}
+
+func (l *sqlLock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	if time.Now().After(l.expires) {
+		return circuitry.ErrLockLost
+	}
+	return nil
+}
+
+var _ circuitry.Lease = (*sqlLock)(nil)
+
+var _ circuitry.StorageBackender = (*Backend)(nil)
+var _ circuitry.Lister = (*Backend)(nil)
+var _ circuitry.Deleter = (*Backend)(nil)
+var _ circuitry.FencedStorer = (*Backend)(nil)
+
+// New builds a Backend storing circuits in the database with the dialect's
+// statements. Call Migrate to create its tables before using it.
+func New(db DB, dialect Dialect) *Backend {
+	return &Backend{DB: db, Dialect: dialect}
+}
+
+// WithSQLBackend provides a way to configure the StorageBackend for a
+// Circuit Breaker Factory's settings. See [New].
+func WithSQLBackend(db DB, dialect Dialect) circuitry.SettingsOption {
+	return circuitry.WithStorageBackend(New(db, dialect))
+}
@@ -0,0 +1,336 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/sigmavirus24/circuitry"
+	sqlbackend "github.com/sigmavirus24/circuitry/backends/sql"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "circuitry.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatalf("expected to open SQLite; got %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func newBackend(t *testing.T) *sqlbackend.Backend {
+	t.Helper()
+	backend := sqlbackend.New(openSQLite(t), sqlbackend.SQLite)
+	if _, err := backend.Migrate(context.Background()); err != nil {
+		t.Fatalf("expected to migrate; got %v", err)
+	}
+	return backend
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	backend := sqlbackend.New(db, sqlbackend.SQLite)
+
+	migrated, err := backend.Migrate(ctx)
+	if err != nil || migrated != len(sqlbackend.Migrations()) {
+		t.Fatalf("expected to apply %d migrations; got %d, %v", len(sqlbackend.Migrations()), migrated, err)
+	}
+	for _, table := range []string{sqlbackend.DefaultCircuitTableName, sqlbackend.DefaultLockTableName} {
+		var count int
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil {
+			t.Fatalf("expected %s to be created; got %v", table, err)
+		}
+	}
+	migrated, err = backend.Migrate(ctx)
+	if err != nil || migrated != 0 {
+		t.Fatalf("expected to apply no migrations; got %d, %v", migrated, err)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (999, 'newer', 0)", sqlbackend.DefaultMigrationTableName)); err != nil {
+		t.Fatalf("expected to record a newer migration; got %v", err)
+	}
+	if _, err := backend.Migrate(ctx); !errors.Is(err, sqlbackend.ErrUnsupportedSchemaVersion) {
+		t.Fatalf("expected %v; got %v", sqlbackend.ErrUnsupportedSchemaVersion, err)
+	}
+}
+
+func TestMigrateTableNames(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	backend := &sqlbackend.Backend{
+		DB:                 db,
+		Dialect:            sqlbackend.SQLite,
+		CircuitTableName:   "app_circuits",
+		LockTableName:      "app_locks",
+		MigrationTableName: "app_migrations",
+	}
+	if _, err := backend.Migrate(ctx); err != nil {
+		t.Fatalf("expected to migrate; got %v", err)
+	}
+	if err := backend.Store(ctx, "circuit", circuitry.CircuitInformation{Total: 1}); err != nil {
+		t.Fatalf("expected to store; got %v", err)
+	}
+	if _, err := backend.Lock(ctx, "circuit"); err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	for _, table := range []string{"app_circuits", "app_locks", "app_migrations"} {
+		var count int
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", table)).Scan(&count); err != nil || count != 1 {
+			t.Fatalf("expected one row in %s; got %d, %v", table, count, err)
+		}
+	}
+}
+
+func TestBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	expected := circuitry.CircuitInformation{
+		State:                circuitry.CircuitHalfOpen,
+		Generation:           7,
+		ConsecutiveFailures:  3,
+		ConsecutiveSuccesses: 2,
+		Total:                40,
+		TotalFailures:        25,
+		TotalSuccesses:       15,
+		ExpiresAfter:         time.Date(2024, time.December, 19, 8, 30, 15, 123456789, time.UTC),
+	}
+
+	actual, err := backend.Retrieve(ctx, "circuit")
+	if err != nil || actual != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected empty information for a circuit that was never stored; got %+v, %v", actual, err)
+	}
+	for _, ci := range []circuitry.CircuitInformation{expected, {Generation: 8}} {
+		if err := backend.Store(ctx, "circuit", ci); err != nil {
+			t.Fatalf("expected to store; got %v", err)
+		}
+		actual, err := backend.Retrieve(ctx, "circuit")
+		if err != nil {
+			t.Fatalf("expected to retrieve; got %v", err)
+		}
+		if !actual.ExpiresAfter.Equal(ci.ExpiresAfter) {
+			t.Fatalf("expected ExpiresAfter %v; got %v", ci.ExpiresAfter, actual.ExpiresAfter)
+		}
+		actual.ExpiresAfter = ci.ExpiresAfter
+		if actual != ci {
+			t.Fatalf("expected %+v; got %+v", ci, actual)
+		}
+	}
+}
+
+func TestBackendListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	for i, name := range []string{"tenant-a", "tenant-b", "Tenant-c", "tenant_%", "other"} {
+		if err := backend.Store(ctx, name, circuitry.CircuitInformation{Total: uint64(i)}); err != nil {
+			t.Fatalf("expected to store %s; got %v", name, err)
+		}
+	}
+	list := func(prefix string) []string {
+		var names []string
+		for entry, err := range backend.List(ctx, prefix) {
+			if err != nil {
+				t.Fatalf("expected to list; got %v", err)
+			}
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	testCases := map[string]struct {
+		prefix   string
+		expected []string
+	}{
+		"everything":       {prefix: "", expected: []string{"Tenant-c", "other", "tenant-a", "tenant-b", "tenant_%"}},
+		"case sensitive":   {prefix: "tenant-", expected: []string{"tenant-a", "tenant-b"}},
+		"no wildcards":     {prefix: "tenant_", expected: []string{"tenant_%"}},
+		"no matching rows": {prefix: "missing", expected: nil},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if actual := list(tc.prefix); fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v; got %v", tc.expected, actual)
+			}
+		})
+	}
+
+	for range backend.List(ctx, "") {
+		break
+	}
+	lock, err := backend.Lock(ctx, "tenant-a")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	defer lock.Unlock()
+	if err := backend.Delete(ctx, "tenant-a"); err != nil {
+		t.Fatalf("expected to delete; got %v", err)
+	}
+	if err := backend.Delete(ctx, "tenant-a"); err != nil {
+		t.Fatalf("expected deleting a missing circuit to succeed; got %v", err)
+	}
+	if names := list("tenant-"); fmt.Sprint(names) != "[tenant-b]" {
+		t.Fatalf("expected tenant-a to be deleted; got %v", names)
+	}
+	// the fencing token outlives the circuit's information
+	if err := backend.StoreFenced(ctx, "tenant-a", circuitry.CircuitInformation{}, lock.(circuitry.Lease).Token()-1); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+}
+
+func TestBackendLock(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	backend.LockRetryInterval = -1
+
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	lease.Lock()
+	if lease.Token() != 1 || lease.Err() != nil {
+		t.Fatalf("expected token 1 and no error; got %d, %v", lease.Token(), lease.Err())
+	}
+	if _, err := backend.Lock(ctx, "circuit"); !errors.Is(err, sqlbackend.ErrLockNotObtained) {
+		t.Fatalf("expected %v; got %v", sqlbackend.ErrLockNotObtained, err)
+	}
+	other, err := backend.Lock(ctx, "other")
+	if err != nil || other.(circuitry.Lease).Token() != 1 {
+		t.Fatalf("expected to lock another circuit with its own token; got %v", err)
+	}
+	other.Unlock()
+
+	backend.LockRetryInterval = 0
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := backend.Lock(timeout, "circuit"); !errors.Is(err, sqlbackend.ErrLockNotObtained) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v wrapping %v; got %v", sqlbackend.ErrLockNotObtained, context.DeadlineExceeded, err)
+	}
+
+	canceled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	if _, err := backend.Lock(canceled, "circuit"); !errors.Is(err, sqlbackend.ErrLockNotObtained) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v wrapping %v; got %v", sqlbackend.ErrLockNotObtained, context.Canceled, err)
+	}
+
+	released := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		if err := lease.Release(ctx); err != nil {
+			t.Errorf("expected to release; got %v", err)
+		}
+		close(released)
+	})
+	next, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock once the lock is released; got %v", err)
+	}
+	<-released
+	if token := next.(circuitry.Lease).Token(); token != 2 {
+		t.Fatalf("expected token 2; got %d", token)
+	}
+	next.Unlock()
+}
+
+func TestBackendLockExpires(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	backend.LockTTL = 50 * time.Millisecond
+	backend.HeartbeatInterval = -1
+
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	stale := lock.(circuitry.Lease)
+	next, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock once the lock expires; got %v", err)
+	}
+	current := next.(circuitry.Lease)
+	if !errors.Is(stale.Err(), circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, stale.Err())
+	}
+
+	stored := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Total: 1}
+	if err := backend.StoreFenced(ctx, "circuit", circuitry.CircuitInformation{Total: 99}, stale.Token()); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+	if err := backend.StoreFenced(ctx, "circuit", stored, current.Token()); err != nil {
+		t.Fatalf("expected to store fenced; got %v", err)
+	}
+	if actual, err := backend.Retrieve(ctx, "circuit"); err != nil || actual != stored {
+		t.Fatalf("expected %+v; got %+v, %v", stored, actual, err)
+	}
+	if err := stale.Release(ctx); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v releasing the stale lock; got %v", circuitry.ErrLockLost, err)
+	}
+	if err := current.Release(ctx); err != nil {
+		t.Fatalf("expected to release; got %v", err)
+	}
+}
+
+func TestBackendLockHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	backend := newBackend(t)
+	backend.LockTTL = 60 * time.Millisecond
+	backend.LockRetryInterval = -1
+
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock; got %v", err)
+	}
+	lease := lock.(circuitry.Lease)
+	time.Sleep(200 * time.Millisecond)
+	if err := lease.Err(); err != nil {
+		t.Fatalf("expected the lease to be refreshed; got %v", err)
+	}
+	if _, err := backend.Lock(ctx, "circuit"); !errors.Is(err, sqlbackend.ErrLockNotObtained) {
+		t.Fatalf("expected the lock to still be held; got %v", err)
+	}
+
+	// another process taking the lock is noticed by the next refresh
+	if _, err := backend.DB.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET owner = 'other'", sqlbackend.DefaultLockTableName)); err != nil {
+		t.Fatalf("expected to take the lock; got %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := lease.Err(); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+	if err := lease.Release(ctx); !errors.Is(err, circuitry.ErrLockLost) {
+		t.Fatalf("expected %v; got %v", circuitry.ErrLockLost, err)
+	}
+}
+
+func TestBackendConcurrentBreakers(t *testing.T) {
+	circuitrytest.ConcurrentBreakers(t, newBackend(t))
+}
+
+func TestBackendMatchesInMemoryBackend(t *testing.T) {
+	circuitrytest.MatchesInMemoryBackend(t, newBackend(t))
+}
+
+func TestBackendReleaseTwice(t *testing.T) {
+	circuitrytest.ReleaseTwice(t, newBackend(t))
+}
+
+func TestWithSQLBackend(t *testing.T) {
+	db := openSQLite(t)
+	settings, err := circuitry.NewFactorySettings(sqlbackend.WithSQLBackend(db, sqlbackend.SQLite))
+	if err != nil {
+		t.Fatalf("expected to create settings; got %v", err)
+	}
+	backend, ok := settings.StorageBackend.(*sqlbackend.Backend)
+	if !ok || backend.DB != db || backend.Dialect != sqlbackend.SQLite {
+		t.Fatalf("expected a SQLite Backend using the database; got %#v", settings.StorageBackend)
+	}
+}
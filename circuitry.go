@@ -2,11 +2,15 @@ package circuitry
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
 )
 
 // WorkFn defines the allowed interface of a function that can be passed to
@@ -35,6 +39,13 @@ type CircuitBreaker interface {
 	// Information returns the current CircuitInformation representing the
 	// state of the CircuitBreaker
 	Information(context.Context) (CircuitInformation, error)
+	// Reset closes the CircuitBreaker and starts a new generation with zeroed
+	// counts
+	Reset(context.Context) error
+	// Delete removes the CircuitBreaker's information from the storage
+	// backend. It returns ErrDeleteNotSupported if the backend does not
+	// implement Deleter.
+	Delete(context.Context) error
 }
 
 type circuitBreaker struct {
@@ -44,33 +55,160 @@ type circuitBreaker struct {
 	errMatcher            ExpectedErrorMatcherFunc
 	failureCountThreshold uint64
 	closeThreshold        uint64
-	lock                  sync.Locker
+	lock                  Lease
 	allowAfter            time.Duration
 	resetCycle            time.Duration
 	tripperFn             WillTripFunc
 	stateChangeFn         StateChangeFunc
 	logger                log.Logger
+	metrics               metrics.Sink
+	tracer                trace.Tracer
+	transitions           *transitionHub
+	publisher             TransitionPublisher
+	recorder              OutcomeRecorder
 
 	counts     *circuitCounts
 	state      CircuitState
 	generation uint64
 	expiry     time.Time
+	pending    []TransitionEvent
 }
 
 func (cb *circuitBreaker) Information(ctx context.Context) (CircuitInformation, error) {
-	if err := cb.refreshFromRemoteState(ctx); err != nil {
+	if err := cb.readRemoteState(ctx); err != nil {
 		return CircuitInformation{}, err
 	}
 	return cb.toCircuitInformation(), nil
 }
 
-func (cb *circuitBreaker) Start(ctx context.Context) error {
+func (cb *circuitBreaker) Start(ctx context.Context) (err error) {
 	if cb.lock != nil {
 		return ErrCircuitBreakerAlreadyStarted
 	}
+	ctx, span := cb.startSpan(ctx, "circuitry.Start")
+	defer func() { endSpan(span, err) }()
+	if cb.recorder != nil {
+		return cb.admit(ctx, span)
+	}
 	if err := cb.lockRemoteState(ctx); err != nil {
+		cb.metrics.CallRejected(cb.name, metrics.RejectedBackendError)
+		span.SetAttributes(AttributeRejectionReason.String(string(metrics.RejectedBackendError)))
+		return err
+	}
+	cb.pending = nil
+	if err := cb.allowRequest(ctx); err != nil {
+		reason := rejectionReason(err)
+		cb.metrics.CallRejected(cb.name, reason)
+		span.SetAttributes(AttributeRejectionReason.String(string(reason)))
+		// The work will not run so End will never be called to release the
+		// lock
+		if releaseErr := cb.unlockRemoteState(ctx); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+		return err
+	}
+	cb.counts.AddRequest()
+	cb.started(span)
+	return nil
+}
+
+func (cb *circuitBreaker) started(span trace.Span) {
+	span.SetAttributes(
+		AttributeStateAfter.String(cb.state.String()),
+		generationAttribute(cb.generation),
+	)
+	cb.logger.WithField("circuit_name", cb.name).Info("starting circuit breaker")
+	cb.metrics.CallStarted(cb.name)
+}
+
+// admit starts work with the storage backend's OutcomeRecorder instead of
+// holding the lock until End
+func (cb *circuitBreaker) admit(ctx context.Context, span trace.Span) error {
+	cb.pending = nil
+	now := time.Now()
+	spanCtx, backendSpan := cb.startSpan(ctx, "circuitry.backend.Admit")
+	start := time.Now()
+	before, after, err := cb.recorder.Admit(spanCtx, cb.name, cb.outcomePolicy(), now)
+	rejected := errors.Is(err, ErrCircuitBreakerOpen) || errors.Is(err, ErrTooManyRequests)
+	backendErr := err
+	if rejected {
+		backendErr = nil
+	}
+	cb.metrics.BackendOperation(cb.name, metrics.OpAdmit, time.Since(start), backendErr)
+	endSpan(backendSpan, backendErr)
+	if backendErr != nil {
+		cb.metrics.CallRejected(cb.name, metrics.RejectedBackendError)
+		span.SetAttributes(AttributeRejectionReason.String(string(metrics.RejectedBackendError)))
+		return fmt.Errorf("cannot start circuit breaker for %s due to: %w", cb.name, err)
+	}
+	cb.applyRecorded(ctx, before, after, now, nil)
+	if rejected {
+		reason := rejectionReason(err)
+		cb.metrics.CallRejected(cb.name, reason)
+		span.SetAttributes(AttributeRejectionReason.String(string(reason)))
+		// The transition has been stored but End will never be called to
+		// publish it
+		cb.publishTransitions(ctx, cb.pending)
+		cb.pending = nil
+		return err
+	}
+	// Nothing is locked but Start and End still need to be paired
+	cb.lock = lockerLease{noLock{}}
+	cb.started(span)
+	return nil
+}
+
+// record stores the outcome with the storage backend's OutcomeRecorder
+func (cb *circuitBreaker) record(ctx context.Context, now time.Time, status ExecutionStatus, cause error) error {
+	spanCtx, span := cb.startSpan(ctx, "circuitry.backend.Record")
+	start := time.Now()
+	before, after, err := cb.recorder.Record(spanCtx, cb.name, cb.outcomePolicy(), cb.generation, status, now)
+	cb.metrics.BackendOperation(cb.name, metrics.OpRecord, time.Since(start), err)
+	endSpan(span, err)
+	if err != nil {
 		return err
 	}
+	cb.applyRecorded(ctx, before, after, now, cause)
+	return nil
+}
+
+// applyRecorded takes on the information an OutcomeRecorder stored and
+// reports the transition if its state changed
+func (cb *circuitBreaker) applyRecorded(ctx context.Context, before, after CircuitInformation, now time.Time, cause error) {
+	cb.counts = fromCircuitInformation(after)
+	cb.state = after.State
+	cb.generation = after.Generation
+	cb.expiry = after.ExpiresAfter
+	if before.State != after.State {
+		cb.transitioned(ctx, before.State, after.State, before, now, cause)
+	}
+}
+
+func (cb *circuitBreaker) outcomePolicy() OutcomePolicy {
+	return OutcomePolicy{
+		FailureCountThreshold: cb.failureCountThreshold,
+		CloseThreshold:        cb.closeThreshold,
+		AllowAfter:            cb.allowAfter,
+		CyclicClearAfter:      cb.resetCycle,
+	}
+}
+
+func generationAttribute(generation uint64) attribute.KeyValue {
+	return AttributeGeneration.Int64(int64(generation)) //nolint:gosec // Generations will not overflow an int64
+}
+
+func rejectionReason(err error) metrics.RejectionReason {
+	switch {
+	case errors.Is(err, ErrCircuitBreakerOpen):
+		return metrics.RejectedOpen
+	case errors.Is(err, ErrTooManyRequests):
+		return metrics.RejectedTooManyRequests
+	default:
+		return metrics.RejectedBackendError
+	}
+}
+
+func (cb *circuitBreaker) allowRequest(ctx context.Context) error {
 	if err := cb.refreshFromRemoteState(ctx); err != nil {
 		return err
 	}
@@ -82,13 +220,33 @@ func (cb *circuitBreaker) Start(ctx context.Context) error {
 			return ErrTooManyRequests
 		}
 	}
-	cb.counts.AddRequest()
-	cb.logger.WithField("circuit_name", cb.name).Info("starting circuit breaker")
 	return nil
 }
 
+// refreshFromRemoteState retrieves the circuit and moves it to the state it
+// is in now, reporting any transition, for callers that store it afterwards
 func (cb *circuitBreaker) refreshFromRemoteState(ctx context.Context) error {
-	info, err := cb.storage.Retrieve(ctx, cb.name)
+	return cb.retrieveRemoteState(ctx, func(state CircuitState, now time.Time) {
+		cb.setState(ctx, state, now, nil)
+	})
+}
+
+// readRemoteState retrieves the circuit and moves it to the state it is in
+// now without reporting the transition, which is only reported by the caller
+// that stores it, so that reading a circuit repeatedly does not report the
+// same transition each time
+func (cb *circuitBreaker) readRemoteState(ctx context.Context) error {
+	return cb.retrieveRemoteState(ctx, func(state CircuitState, now time.Time) {
+		cb.changeState(state, now)
+	})
+}
+
+func (cb *circuitBreaker) retrieveRemoteState(ctx context.Context, setState func(CircuitState, time.Time)) error {
+	spanCtx, span := cb.startSpan(ctx, "circuitry.backend.Retrieve")
+	start := time.Now()
+	info, err := cb.storage.Retrieve(spanCtx, cb.name)
+	cb.metrics.BackendOperation(cb.name, metrics.OpRetrieve, time.Since(start), err)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
@@ -101,7 +259,7 @@ func (cb *circuitBreaker) refreshFromRemoteState(ctx context.Context) error {
 		}
 	case CircuitOpen:
 		if info.ExpiresAfter.Before(now) {
-			cb.setState(CircuitHalfOpen, now)
+			setState(CircuitHalfOpen, now)
 		}
 	}
 	return nil
@@ -109,26 +267,81 @@ func (cb *circuitBreaker) refreshFromRemoteState(ctx context.Context) error {
 
 func (cb *circuitBreaker) updateRemoteState(ctx context.Context, _ time.Time) error {
 	info := cb.toCircuitInformation()
-	err := cb.storage.Store(ctx, cb.name, info)
+	ctx, span := cb.startSpan(ctx, "circuitry.backend.Store")
+	start := time.Now()
+	err := cb.store(ctx, info)
+	cb.metrics.BackendOperation(cb.name, metrics.OpStore, time.Since(start), err)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// store saves the information unless the lease held was lost, fencing the
+// write when the lease has a token and the backend supports it
+func (cb *circuitBreaker) store(ctx context.Context, info CircuitInformation) error {
+	if err := cb.lock.Err(); err != nil {
+		return err
+	}
+	if fenced, ok := cb.storage.(FencedStorer); ok && cb.lock.Token() > 0 {
+		return fenced.StoreFenced(ctx, cb.name, info, cb.lock.Token())
+	}
+	return cb.storage.Store(ctx, cb.name, info)
+}
+
+// publishTransitions delivers transitions that have been stored to the
+// metrics sink, the StateChangeCallback, subscribers, and the backend's
+// TransitionPublisher
+func (cb *circuitBreaker) publishTransitions(ctx context.Context, events []TransitionEvent) {
+	for _, event := range events {
+		cb.metrics.StateTransition(cb.name, event.From.String(), event.To.String())
+		if cb.stateChangeFn != nil {
+			cb.stateChangeFn(cb.name, cb.circuitContext, event.From, event.To)
+		}
+		if cb.transitions != nil {
+			cb.transitions.publish(event)
+		}
+		if cb.publisher == nil {
+			continue
+		}
+		spanCtx, span := cb.startSpan(ctx, "circuitry.backend.Publish")
+		start := time.Now()
+		err := cb.publisher.PublishTransition(spanCtx, event)
+		cb.metrics.BackendOperation(cb.name, metrics.OpPublish, time.Since(start), err)
+		endSpan(span, err)
+		if err != nil {
+			cb.logger.WithError(err).WithField("circuit_name", cb.name).Warn("could not publish state transition")
+		}
+	}
+}
+
 func (cb *circuitBreaker) lockRemoteState(ctx context.Context) error {
+	ctx, span := cb.startSpan(ctx, "circuitry.backend.Lock")
+	start := time.Now()
 	lock, err := cb.storage.Lock(ctx, cb.name)
+	cb.metrics.BackendOperation(cb.name, metrics.OpLock, time.Since(start), err)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("cannot start circuit breaker for %s due to: %w", cb.name, err)
 	}
-	cb.lock = lock
-	cb.lock.Lock()
+	lease, ok := lock.(Lease)
+	if !ok {
+		lease = lockerLease{lock}
+	}
+	lease.Lock()
+	cb.lock = lease
 	return nil
 }
 
-func (cb *circuitBreaker) unlockRemoteState() {
-	cb.lock.Unlock()
+// unlockRemoteState releases the lock even if the context has been canceled
+func (cb *circuitBreaker) unlockRemoteState(ctx context.Context) error {
+	err := cb.lock.Release(context.WithoutCancel(ctx))
 	cb.lock = nil
+	if err != nil {
+		return fmt.Errorf("cannot release lock for %s due to: %w", cb.name, err)
+	}
+	return nil
 }
 
 func (cb *circuitBreaker) fromCircuitInformation(info CircuitInformation) {
@@ -155,9 +368,21 @@ func (cb *circuitBreaker) Name() string {
 	return cb.name
 }
 
-func (cb *circuitBreaker) End(ctx context.Context, err error) error {
+func (cb *circuitBreaker) End(ctx context.Context, err error) (storageErr error) {
 	now := time.Now()
-	defer cb.unlockRemoteState()
+	// Transitions are delivered once they have been stored and the lock has
+	// been released so that other processes are not kept waiting on them
+	var published []TransitionEvent
+	publishCtx := ctx
+	defer func() { cb.publishTransitions(publishCtx, published) }()
+	ctx, span := cb.startSpan(ctx, "circuitry.End")
+	defer func() { endSpan(span, storageErr) }()
+	defer func() {
+		if releaseErr := cb.unlockRemoteState(ctx); releaseErr != nil {
+			storageErr = errors.Join(storageErr, releaseErr)
+		}
+	}()
+	before := cb.state
 	status := cb.errMatcher(err)
 	cb.logger.WithFields(log.Fields{
 		"work_err":             err,
@@ -166,48 +391,87 @@ func (cb *circuitBreaker) End(ctx context.Context, err error) error {
 	}).Info("circuit breaker ended")
 	switch status {
 	case ExecutionSucceeded:
-		cb.endSuccess(ctx, now)
+		cb.metrics.CallSucceeded(cb.name)
 	default:
-		cb.endFailure(ctx, now)
+		cb.metrics.CallFailed(cb.name)
 	}
-	return cb.updateRemoteState(ctx, now)
+	if cb.recorder != nil {
+		storageErr = cb.record(ctx, now, status, err)
+	} else {
+		if status == ExecutionSucceeded {
+			cb.endSuccess(ctx, now)
+		} else {
+			cb.endFailure(ctx, now, err)
+		}
+		storageErr = cb.updateRemoteState(ctx, now)
+	}
+	span.SetAttributes(
+		AttributeExecutionStatus.String(status.String()),
+		AttributeStateBefore.String(before.String()),
+		AttributeStateAfter.String(cb.state.String()),
+		generationAttribute(cb.generation),
+	)
+	switch {
+	case storageErr == nil:
+		published = cb.pending
+	case errors.Is(storageErr, ErrLockLost):
+		cb.logger.WithError(storageErr).WithField("circuit_name", cb.name).Warn("lock lost during execution, discarding outcome")
+	}
+	cb.pending = nil
+	return storageErr
 }
 
 func (cb *circuitBreaker) Execute(ctx context.Context, work WorkFn) (any, error, error) {
+	ctx, span := cb.startSpan(ctx, "circuitry.Execute")
 	err := cb.Start(ctx)
 	if err != nil {
+		endSpan(span, err)
 		return nil, nil, err
 	}
+	_, workSpan := cb.startSpan(ctx, "circuitry.Work")
 	retVal, retErr := work()
+	endSpan(workSpan, retErr)
 	storageErr := cb.End(ctx, retErr)
+	endSpan(span, storageErr)
 	return retVal, retErr, storageErr
 }
 
-func (cb *circuitBreaker) endSuccess(_ context.Context, now time.Time) {
+func (cb *circuitBreaker) endSuccess(ctx context.Context, now time.Time) {
 	switch cb.state {
 	case CircuitClosed:
 		cb.counts.AddSuccess()
 	case CircuitHalfOpen:
 		cb.counts.AddSuccess()
 		if cb.counts.ConsecutiveSuccesses >= cb.closeThreshold {
-			cb.setState(CircuitClosed, now)
+			cb.setState(ctx, CircuitClosed, now, nil)
 		}
 	}
 }
 
-func (cb *circuitBreaker) endFailure(_ context.Context, now time.Time) {
+func (cb *circuitBreaker) endFailure(ctx context.Context, now time.Time, err error) {
 	switch cb.state {
 	case CircuitClosed:
 		cb.counts.AddFailure()
 		if cb.tripperFn(cb.name, cb.failureCountThreshold, cb.toCircuitInformation()) {
-			cb.setState(CircuitOpen, now)
+			cb.setState(ctx, CircuitOpen, now, err)
 		}
 	case CircuitHalfOpen:
-		cb.setState(CircuitOpen, now)
+		cb.setState(ctx, CircuitOpen, now, err)
 	}
 }
 
-func (cb *circuitBreaker) setState(state CircuitState, now time.Time) {
+func (cb *circuitBreaker) setState(ctx context.Context, state CircuitState, now time.Time, cause error) {
+	if cb.state == state {
+		return
+	}
+	prev, info := cb.state, cb.toCircuitInformation()
+	cb.changeState(state, now)
+	cb.transitioned(ctx, prev, state, info, now, cause)
+}
+
+// changeState moves the circuit to the state without reporting the
+// transition
+func (cb *circuitBreaker) changeState(state CircuitState, now time.Time) {
 	if cb.state == state {
 		return
 	}
@@ -219,9 +483,22 @@ func (cb *circuitBreaker) setState(state CircuitState, now time.Time) {
 	} else {
 		cb.newGeneration(now)
 	}
-	if cb.stateChangeFn != nil {
-		cb.stateChangeFn(cb.name, cb.circuitContext, prev, state)
-	}
+}
+
+// transitioned reports that the circuit changed state, with the information
+// immediately before the transition
+func (cb *circuitBreaker) transitioned(ctx context.Context, prev, state CircuitState, info CircuitInformation, now time.Time, cause error) {
+	recordTransition(ctx, prev, state, cb.generation)
+	cb.pending = append(cb.pending, TransitionEvent{
+		Name:           cb.name,
+		CircuitContext: cb.circuitContext,
+		From:           prev,
+		To:             state,
+		Generation:     cb.generation,
+		Information:    info,
+		Time:           now,
+		Err:            cause,
+	})
 }
 
 func (cb *circuitBreaker) updateExpiry(now time.Time) {
@@ -248,7 +525,7 @@ func (cb *circuitBreaker) newGeneration(now time.Time) {
 }
 
 func (cb *circuitBreaker) State(ctx context.Context) (CircuitState, error) {
-	if err := cb.refreshFromRemoteState(ctx); err != nil {
+	if err := cb.readRemoteState(ctx); err != nil {
 		return CircuitOpen, err
 	}
 	return cb.state, nil
@@ -258,13 +535,25 @@ var _ CircuitBreaker = (*circuitBreaker)(nil)
 
 // CircuitBreakerFactory creates [CircuitBreaker]s for a given named circuit
 type CircuitBreakerFactory struct {
-	settings *FactorySettings
+	settings    *FactorySettings
+	transitions *transitionHub
+	storage     StorageBackender
 }
 
 // NewCircuitBreakerFactory builds a new [CircuitBreakerFactory] from
 // the [FactorySettings] supplied
 func NewCircuitBreakerFactory(s *FactorySettings) *CircuitBreakerFactory {
-	return &CircuitBreakerFactory{s}
+	storage := s.StorageBackend
+	if s.BackendFailurePolicy != FailClosed {
+		// Every CircuitBreaker shares the wrapper so they degrade and recover
+		// together
+		storage = newDegradableBackend(s)
+	}
+	return &CircuitBreakerFactory{
+		settings:    s,
+		transitions: newTransitionHub(s.SubscriptionBufferSize),
+		storage:     storage,
+	}
 }
 
 // BreakerFor builds a new [CircuitBreaker] for the given named circuit and
@@ -272,5 +561,22 @@ func NewCircuitBreakerFactory(s *FactorySettings) *CircuitBreakerFactory {
 // the naming function and can be used by custom naming functions to produce
 // names based off of a template
 func (cbf *CircuitBreakerFactory) BreakerFor(name string, circuitContext map[string]any) CircuitBreaker {
-	return cbf.settings.circuitBreakerFor(name, circuitContext)
+	return cbf.attach(cbf.settings.circuitBreakerFor(name, circuitContext))
+}
+
+// breakerNamed builds a circuitBreaker for a name as it is stored, i.e.,
+// without applying the NameFn
+func (cbf *CircuitBreakerFactory) breakerNamed(name string) *circuitBreaker {
+	return cbf.attach(cbf.settings.namedCircuitBreaker(name, name, map[string]any{}))
+}
+
+// attach shares the factory's state with a circuitBreaker built from its
+// settings
+func (cbf *CircuitBreakerFactory) attach(cb *circuitBreaker) *circuitBreaker {
+	cb.transitions = cbf.transitions
+	cb.storage = cbf.storage
+	if recorder, ok := cbf.storage.(OutcomeRecorder); ok && isDefaultTripFunc(cb.tripperFn) {
+		cb.recorder = recorder
+	}
+	return cb
 }
@@ -2,11 +2,14 @@ package circuitry
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
 )
 
 func newFactory(opts ...SettingsOption) *CircuitBreakerFactory {
@@ -37,7 +40,7 @@ func TestSetState(t *testing.T) {
 	if cb.state != CircuitClosed {
 		t.Fatalf("expected cb.state = %s; got %s", CircuitClosed, cb.state)
 	}
-	cb.setState(CircuitClosed, time.Now())
+	cb.setState(context.TODO(), CircuitClosed, time.Now(), nil)
 	if cb.state != CircuitClosed {
 		t.Fatalf("expected cb.state = %s; got %s", CircuitClosed, cb.state)
 	}
@@ -55,3 +58,61 @@ func TestDefaultNoOpLogger(t *testing.T) {
 		t.Fatalf("expected logger to default to log.NoOp; got %T", actualBreaker.logger)
 	}
 }
+
+func TestDefaultNoOpMetricsSink(t *testing.T) {
+	s, err := NewFactorySettings()
+	if err != nil {
+		t.Fatalf("expected to not receive an error but got %v", err)
+	}
+	factory := NewCircuitBreakerFactory(s)
+	breaker := factory.BreakerFor("breaker", map[string]any{})
+	actualBreaker, _ := breaker.(*circuitBreaker)
+	if _, ok := actualBreaker.metrics.(*metrics.NoOp); !ok {
+		t.Fatalf("expected metrics sink to default to metrics.NoOp; got %T", actualBreaker.metrics)
+	}
+}
+
+func TestRejectionReason(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected metrics.RejectionReason
+	}{
+		"open":              {ErrCircuitBreakerOpen, metrics.RejectedOpen},
+		"too many requests": {ErrTooManyRequests, metrics.RejectedTooManyRequests},
+		"wrapped open":      {fmt.Errorf("admit: %w", ErrCircuitBreakerOpen), metrics.RejectedOpen},
+		"wrapped too many":  {fmt.Errorf("admit: %w", ErrTooManyRequests), metrics.RejectedTooManyRequests},
+		"backend":           {errors.New("cannot retrieve"), metrics.RejectedBackendError},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if reason := rejectionReason(tc.err); reason != tc.expected {
+				t.Fatalf("expected rejectionReason(%v) = %s; got %s", tc.err, tc.expected, reason)
+			}
+		})
+	}
+}
+
+func TestTransitionHubForgetsExpiredTransitions(t *testing.T) {
+	hub := newTransitionHub(0)
+	if hub.bufferSize != DefaultSubscriptionBufferSize {
+		t.Fatalf("expected hub.bufferSize = %d; got %d", DefaultSubscriptionBufferSize, hub.bufferSize)
+	}
+	expired := time.Now().Add(-transitionDedupeWindow)
+	for i := range maxRememberedTransitions {
+		hub.seen[transitionKey{name: "expired", generation: uint64(i)}] = expired
+	}
+	event := TransitionEvent{Name: "expired", Generation: 0}
+	if !hub.firstSighting(event) {
+		t.Fatalf("expected an expired transition to be seen again")
+	}
+	if !hub.firstSighting(TransitionEvent{Name: "new"}) {
+		t.Fatalf("expected a new transition to be seen for the first time")
+	}
+	if len(hub.seen) != 2 {
+		t.Fatalf("expected expired transitions to be pruned; got %d remembered", len(hub.seen))
+	}
+	if hub.firstSighting(event) {
+		t.Fatalf("expected a recent transition to not be seen for the first time")
+	}
+}
@@ -468,3 +468,30 @@ func TestTooManyRequestsInHalfOpen(t *testing.T) {
 		t.Fatalf("expected breaker to error on too many requests; got err = %v", err)
 	}
 }
+
+func TestRejectedStartReleasesLock(t *testing.T) {
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithDefaultTripFunc(),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	breaker := factory.BreakerFor("TestRejectedStartReleasesLock", map[string]any{})
+	if _, _, err := breaker.Execute(context.TODO(), alwaysErrorFn); err != nil {
+		t.Fatalf("couldn't execute work function; got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			done <- factory.BreakerFor("TestRejectedStartReleasesLock", map[string]any{}).Start(context.TODO())
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+				t.Fatalf("expected breaker.Start() = ErrCircuitBreakerOpen; got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected rejected breaker.Start() to release the backend lock")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package circuitrytest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+)
+
+// NewFactory creates a CircuitBreakerFactory storing circuits in the backend
+// and configured with the options, failing the test if the options are
+// invalid
+func NewFactory(t testing.TB, backend circuitry.StorageBackender, opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	t.Helper()
+	settings, err := circuitry.NewFactorySettings(append(opts, circuitry.WithStorageBackend(backend))...)
+	if err != nil {
+		t.Fatalf("expected to successfully create FactorySettings; got err = %v", err)
+	}
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+// ConcurrentBreakers executes 50 requests from 10 goroutines, each with its
+// own breaker for the same circuit in the backend, and fails the test unless
+// the backend counted every one of them
+func ConcurrentBreakers(t testing.TB, backend circuitry.StorageBackender) {
+	t.Helper()
+	ctx := context.Background()
+	factory := NewFactory(t, backend, circuitry.WithFailureCountThreshold(1000))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				if _, _, err := factory.BreakerFor("circuit", map[string]any{}).Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+					t.Errorf("expected to execute; got %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	actual, err := backend.Retrieve(ctx, "circuit")
+	if err != nil || actual.Total != 50 || actual.TotalSuccesses != 50 {
+		t.Fatalf("expected every execution to be counted; got %+v, %v", actual, err)
+	}
+}
+
+// MatchesInMemoryBackend executes the same successes and failures with a
+// breaker storing its circuit in the backend and one storing it in a
+// [backends.InMemoryBackend], waiting for the circuits to become half-open in
+// between, and fails the test as soon as the breakers' results or
+// information differ
+func MatchesInMemoryBackend(t testing.TB, backend circuitry.StorageBackender) {
+	t.Helper()
+	ctx := context.Background()
+	opts := []circuitry.SettingsOption{
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(2),
+		circuitry.WithAllowAfter(20 * time.Millisecond),
+	}
+	breaker := NewFactory(t, backend, opts...).BreakerFor("circuit", map[string]any{})
+	memoryBreaker := NewFactory(t, backends.NewInMemoryBackend(), opts...).BreakerFor("circuit", map[string]any{})
+
+	outcomes := map[string]circuitry.WorkFn{
+		"succeed": func() (any, error) { return nil, nil },
+		"fail":    func() (any, error) { return nil, errors.New("test") },
+	}
+	steps := []string{"succeed", "fail", "succeed", "fail", "fail", "succeed", "wait", "fail", "wait", "succeed", "succeed", "succeed", "fail"}
+	for i, step := range steps {
+		if step == "wait" {
+			time.Sleep(30 * time.Millisecond)
+			continue
+		}
+		_, _, err := breaker.Execute(ctx, outcomes[step])
+		_, _, memoryErr := memoryBreaker.Execute(ctx, outcomes[step])
+		if !errors.Is(err, memoryErr) {
+			t.Fatalf("step %d: expected %v; got %v", i, memoryErr, err)
+		}
+		expected, _ := memoryBreaker.Information(ctx)
+		actual, err := breaker.Information(ctx)
+		if err != nil {
+			t.Fatalf("step %d: expected to retrieve the information; got err = %v", i, err)
+		}
+		if expected.ExpiresAfter.IsZero() != actual.ExpiresAfter.IsZero() {
+			t.Fatalf("step %d: expected expiry %v; got %v", i, expected.ExpiresAfter, actual.ExpiresAfter)
+		}
+		expected.ExpiresAfter, actual.ExpiresAfter = time.Time{}, time.Time{}
+		if expected != actual {
+			t.Fatalf("step %d: expected %+v; got %+v", i, expected, actual)
+		}
+	}
+}
+
+// ReleaseTwice locks a circuit in the backend and releases its
+// [circuitry.Lease] twice, failing the test unless both releases return nil
+// and the circuit can then be locked again
+func ReleaseTwice(t testing.TB, backend circuitry.StorageBackender) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lock, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock the circuit; got err = %v", err)
+	}
+	lease, ok := lock.(circuitry.Lease)
+	if !ok {
+		t.Fatalf("expected the lock to be a circuitry.Lease; got %T", lock)
+	}
+	lease.Lock()
+	for i := range 2 {
+		if err := lease.Release(ctx); err != nil {
+			t.Fatalf("release %d: expected to release the lock; got err = %v", i+1, err)
+		}
+	}
+	relocked, err := backend.Lock(ctx, "circuit")
+	if err != nil {
+		t.Fatalf("expected to lock the released circuit again; got err = %v", err)
+	}
+	relocked.Lock()
+	relocked.Unlock()
+}
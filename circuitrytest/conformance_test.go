@@ -0,0 +1,230 @@
+package circuitrytest
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+)
+
+// recordingT records failures instead of failing the test, ending the
+// goroutine on Fatalf as testing.T does
+type recordingT struct {
+	testing.TB
+	mu       sync.Mutex
+	failures []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, _ ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, format)
+}
+
+func (r *recordingT) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// failures runs the conformance test against the backend and returns the
+// failures it reported
+func failures(conformance func(testing.TB, circuitry.StorageBackender), backend circuitry.StorageBackender) []string {
+	r := &recordingT{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conformance(r, backend)
+	}()
+	<-done
+	return r.failures
+}
+
+// readingBackend changes the information read while the circuit is not
+// locked, as it is when a breaker's information is read
+type readingBackend struct {
+	circuitry.StorageBackender
+	mu     sync.Mutex
+	locked bool
+	read   func(circuitry.CircuitInformation) (circuitry.CircuitInformation, error)
+}
+
+func (b *readingBackend) setLocked(locked bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.locked = locked
+}
+
+func (b *readingBackend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
+	ci, err := b.StorageBackender.Retrieve(ctx, name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil || b.locked {
+		return ci, err
+	}
+	return b.read(ci)
+}
+
+func (b *readingBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	lock, err := b.StorageBackender.Lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &readingLock{lock, b}, nil
+}
+
+type readingLock struct {
+	sync.Locker
+	backend *readingBackend
+}
+
+func (l *readingLock) Lock() {
+	l.Locker.Lock()
+	l.backend.setLocked(true)
+}
+
+func (l *readingLock) Unlock() {
+	l.backend.setLocked(false)
+	l.Locker.Unlock()
+}
+
+// leasingBackend returns locks that are a circuitry.Lease whose Release
+// returns the errors in turn
+type leasingBackend struct {
+	circuitry.StorageBackender
+	releaseErrors []error
+}
+
+func (b *leasingBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	lock, err := b.StorageBackender.Lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &leasingLock{Locker: lock, backend: b}, nil
+}
+
+type leasingLock struct {
+	sync.Locker
+	backend  *leasingBackend
+	released bool
+}
+
+func (l *leasingLock) Release(context.Context) error {
+	var err error
+	if len(l.backend.releaseErrors) > 0 {
+		err, l.backend.releaseErrors = l.backend.releaseErrors[0], l.backend.releaseErrors[1:]
+	}
+	if !l.released {
+		l.released = true
+		l.Unlock()
+	}
+	return err
+}
+
+func (l *leasingLock) Err() error    { return nil }
+func (l *leasingLock) Token() uint64 { return 0 }
+
+func TestConformanceInMemoryBackend(t *testing.T) {
+	ConcurrentBreakers(t, backends.NewInMemoryBackend())
+	MatchesInMemoryBackend(t, backends.NewInMemoryBackend())
+	ReleaseTwice(t, &leasingBackend{StorageBackender: backends.NewInMemoryBackend()})
+}
+
+// relockingBackend fails to lock a circuit more than once
+type relockingBackend struct {
+	circuitry.StorageBackender
+	locked bool
+}
+
+func (b *relockingBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	if b.locked {
+		return nil, errors.New("cannot lock again")
+	}
+	b.locked = true
+	return b.StorageBackender.Lock(ctx, name)
+}
+
+func TestConformanceFailures(t *testing.T) {
+	storeErr := ErroringInMemoryBackend{StoreError: errors.New("cannot store")}
+	testCases := map[string]struct {
+		conformance func(testing.TB, circuitry.StorageBackender)
+		backend     circuitry.StorageBackender
+		failures    int
+	}{
+		"invalid settings": {
+			conformance: func(t testing.TB, backend circuitry.StorageBackender) {
+				NewFactory(t, backend, circuitry.WithStorageBackend(backend))
+			},
+			backend:  backends.NewInMemoryBackend(),
+			failures: 1,
+		},
+		"executions not counted": {
+			conformance: ConcurrentBreakers,
+			backend:     storeErr,
+			failures:    51,
+		},
+		"different errors": {
+			conformance: MatchesInMemoryBackend,
+			backend:     storeErr,
+			failures:    1,
+		},
+		"information not retrieved": {
+			conformance: MatchesInMemoryBackend,
+			backend: &readingBackend{StorageBackender: backends.NewInMemoryBackend(), read: func(circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				return circuitry.CircuitInformation{}, errors.New("cannot retrieve")
+			}},
+			failures: 1,
+		},
+		"different expiry": {
+			conformance: MatchesInMemoryBackend,
+			backend: &readingBackend{StorageBackender: backends.NewInMemoryBackend(), read: func(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				ci.ExpiresAfter = time.Now().Add(time.Hour)
+				return ci, nil
+			}},
+			failures: 1,
+		},
+		"lock not a lease": {
+			conformance: ReleaseTwice,
+			backend:     backends.NewInMemoryBackend(),
+			failures:    1,
+		},
+		"lock not obtained": {
+			conformance: ReleaseTwice,
+			backend:     &leasingBackend{StorageBackender: ErroringInMemoryBackend{LockError: errors.New("cannot lock")}},
+			failures:    1,
+		},
+		"released once": {
+			conformance: ReleaseTwice,
+			backend:     &leasingBackend{StorageBackender: backends.NewInMemoryBackend(), releaseErrors: []error{nil, circuitry.ErrLockLost}},
+			failures:    1,
+		},
+		"not locked again": {
+			conformance: ReleaseTwice,
+			backend:     &leasingBackend{StorageBackender: &relockingBackend{StorageBackender: backends.NewInMemoryBackend()}},
+			failures:    1,
+		},
+		"different information": {
+			conformance: MatchesInMemoryBackend,
+			backend: &readingBackend{StorageBackender: backends.NewInMemoryBackend(), read: func(ci circuitry.CircuitInformation) (circuitry.CircuitInformation, error) {
+				ci.Total++
+				return ci, nil
+			}},
+			failures: 1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if actual := failures(tc.conformance, tc.backend); len(actual) != tc.failures {
+				t.Fatalf("expected %d failures; got %v", tc.failures, actual)
+			}
+		})
+	}
+}
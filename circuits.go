@@ -0,0 +1,41 @@
+package circuitry
+
+import (
+	"context"
+	"iter"
+	"slices"
+)
+
+// CircuitFilter narrows the circuits yielded by
+// [CircuitBreakerFactory].Circuits
+type CircuitFilter struct {
+	Prefix string         // Prefix the names of the circuits must start with.
+	States []CircuitState // States the circuits must be in. If empty, circuits in any state are yielded.
+}
+
+func (f CircuitFilter) matches(entry CircuitEntry) bool {
+	return len(f.States) == 0 || slices.Contains(f.States, entry.Information.State)
+}
+
+// Circuits yields each circuit stored in the storage backend that matches
+// the filter along with the [CircuitInformation] as it is stored, e.g., an
+// open circuit whose AllowAfter has passed is still reported as open until a
+// [CircuitBreaker] next uses it. If the backend does not implement [Lister],
+// [ErrListingNotSupported] is yielded.
+func (cbf *CircuitBreakerFactory) Circuits(ctx context.Context, filter CircuitFilter) iter.Seq2[CircuitEntry, error] {
+	return func(yield func(CircuitEntry, error) bool) {
+		lister, ok := cbf.settings.StorageBackend.(Lister)
+		if !ok {
+			yield(CircuitEntry{}, ErrListingNotSupported)
+			return
+		}
+		for entry, err := range lister.List(ctx, filter.Prefix) {
+			if err == nil && !filter.matches(entry) {
+				continue
+			}
+			if !yield(entry, err) {
+				return
+			}
+		}
+	}
+}
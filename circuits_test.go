@@ -0,0 +1,83 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+)
+
+func TestCircuits(t *testing.T) {
+	ctx := context.Background()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	neverErrorFn := func() (any, error) { return nil, nil }
+	_, _, _ = factory.BreakerFor("tenant-a", map[string]any{}).Execute(ctx, alwaysErrorFn)
+	_, _, _ = factory.BreakerFor("tenant-b", map[string]any{}).Execute(ctx, neverErrorFn)
+	_, _, _ = factory.BreakerFor("other", map[string]any{}).Execute(ctx, alwaysErrorFn)
+
+	testCases := map[string]struct {
+		filter   circuitry.CircuitFilter
+		expected []string
+	}{
+		"everything": {circuitry.CircuitFilter{}, []string{"other", "tenant-a", "tenant-b"}},
+		"prefix":     {circuitry.CircuitFilter{Prefix: "tenant-"}, []string{"tenant-a", "tenant-b"}},
+		"open":       {circuitry.CircuitFilter{States: []circuitry.CircuitState{circuitry.CircuitOpen}}, []string{"other", "tenant-a"}},
+		"prefix and states": {
+			circuitry.CircuitFilter{Prefix: "tenant-", States: []circuitry.CircuitState{circuitry.CircuitClosed, circuitry.CircuitHalfOpen}},
+			[]string{"tenant-b"},
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			var actual []string
+			for entry, err := range factory.Circuits(ctx, tc.filter) {
+				if err != nil {
+					t.Fatalf("expected no error; got %v", err)
+				}
+				actual = append(actual, entry.Name)
+			}
+			if len(actual) != len(tc.expected) {
+				t.Fatalf("expected %v; got %v", tc.expected, actual)
+			}
+			for i := range tc.expected {
+				if actual[i] != tc.expected[i] {
+					t.Fatalf("expected %v; got %v", tc.expected, actual)
+				}
+			}
+		})
+	}
+
+	count := 0
+	for range factory.Circuits(ctx, circuitry.CircuitFilter{}) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Fatalf("expected iteration to stop after 1 entry; got %d", count)
+	}
+}
+
+func TestCircuitsListingNotSupported(t *testing.T) {
+	factory := newFactory(circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{}))
+	errs := 0
+	for _, err := range factory.Circuits(context.Background(), circuitry.CircuitFilter{}) {
+		if !errors.Is(err, circuitry.ErrListingNotSupported) {
+			t.Fatalf("expected err = %v; got %v", circuitry.ErrListingNotSupported, err)
+		}
+		errs++
+	}
+	if errs != 1 {
+		t.Fatalf("expected 1 error; got %d", errs)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	ddblock "cirello.io/dynamolock/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	streams "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/bsm/redislock"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/sigmavirus24/circuitry"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+// codecs are the codecs -redis-codec selects
+var codecs = map[string]codec.Codec{
+	"json":     codec.JSON,
+	"msgpack":  codec.MessagePack,
+	"protobuf": codec.Protobuf,
+	"binary":   codec.Binary,
+}
+
+func newBackend(ctx context.Context, cfg config) (circuitry.StorageBackender, error) {
+	switch cfg.backend {
+	case "redis":
+		return newRedisBackend(cfg)
+	case "dynamodb":
+		return newDynamoBackend(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.backend)
+	}
+}
+
+func newRedisBackend(cfg config) (circuitry.StorageBackender, error) {
+	encoding, ok := codecs[cfg.redisCodec]
+	if !ok {
+		return nil, fmt.Errorf("unknown Redis codec %q", cfg.redisCodec)
+	}
+	rb := redisbackend.New(
+		&redis.Options{Addr: cfg.redisAddr, Password: cfg.redisPassword, DB: cfg.redisDB},
+		&redislock.Options{RetryStrategy: redislock.NoRetry()},
+		cfg.lockTTL,
+	).(*redisbackend.Backend)
+	rb.TransitionChannel = cfg.redisChannel
+	rb.KeyPrefix = cfg.redisKeyPrefix
+	rb.StateNamespace = cfg.redisStateNamespace
+	rb.LockNamespace = cfg.redisLockNamespace
+	rb.HashTags = cfg.redisHashTags
+	rb.Codec = encoding
+	rb.Retention = cfg.retention()
+	if cfg.redisAtomic {
+		return &redisbackend.AtomicBackend{Backend: *rb}, nil
+	}
+	return rb, nil
+}
+
+func newDynamoBackend(ctx context.Context, cfg config) (circuitry.StorageBackender, error) {
+	if cfg.dynamoTable == "" || (cfg.dynamoLockTable == "" && !cfg.dynamoSingleTable) {
+		return nil, fmt.Errorf("the dynamodb backend requires -dynamodb-table and -dynamodb-lock-table or -dynamodb-single-table")
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load AWS configuration: %w", err)
+	}
+	client := ddb.NewFromConfig(awsCfg, func(o *ddb.Options) {
+		if cfg.dynamoEndpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.dynamoEndpoint)
+		}
+	})
+	backend, err := newDynamoTables(client, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create DynamoDB lock client: %w", err)
+	}
+	backend.StreamARN = cfg.dynamoStreamARN
+	backend.StreamPollInterval = cfg.dynamoPollInterval
+	backend.Retention = cfg.retention()
+	if cfg.dynamoStreamARN != "" {
+		backend.StreamsClient = streams.NewFromConfig(awsCfg, func(o *streams.Options) {
+			if cfg.dynamoEndpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.dynamoEndpoint)
+			}
+		})
+	}
+	return backend, nil
+}
+
+// newDynamoTables returns a Backend using the circuit and lock tables, or
+// only the circuit table with -dynamodb-single-table
+func newDynamoTables(client *ddb.Client, cfg config) (*ddbbackend.Backend, error) {
+	if cfg.dynamoSingleTable {
+		return ddbbackend.NewSingleTable(client, cfg.dynamoTable)
+	}
+	locker, err := ddblock.New(client, cfg.dynamoLockTable)
+	if err != nil {
+		return nil, err
+	}
+	return &ddbbackend.Backend{
+		Client:           client,
+		LockClient:       locker,
+		CircuitTableName: cfg.dynamoTable,
+		LockTableName:    cfg.dynamoLockTable,
+	}, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/admin"
+)
+
+type environment struct {
+	cli
+	output  string
+	backend circuitry.StorageBackender
+	factory *circuitry.CircuitBreakerFactory
+}
+
+type command func(ctx context.Context, env *environment, args []string) error
+
+var commands = map[string]command{
+	"list":   list,
+	"get":    get,
+	"reset":  reset,
+	"open":   forceState(circuitry.CircuitOpen),
+	"close":  forceState(circuitry.CircuitClosed),
+	"watch":  watch,
+	"export": export,
+	"import": importCircuits,
+}
+
+func list(ctx context.Context, env *environment, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w: list takes at most one prefix", errUsage)
+	}
+	filter := circuitry.CircuitFilter{}
+	if len(args) == 1 {
+		filter.Prefix = args[0]
+	}
+	circuits, err := collect(ctx, env.factory, filter)
+	if err != nil {
+		return err
+	}
+	return env.printCircuits(circuits)
+}
+
+func get(ctx context.Context, env *environment, args []string) error {
+	name, err := nameArg("get", args)
+	if err != nil {
+		return err
+	}
+	info, err := env.factory.Information(ctx, name)
+	if err != nil {
+		return err
+	}
+	return env.printCircuit(newCircuit(name, info))
+}
+
+func reset(ctx context.Context, env *environment, args []string) error {
+	name, err := nameArg("reset", args)
+	if err != nil {
+		return err
+	}
+	info, err := env.factory.ResetCounts(ctx, name)
+	if err != nil {
+		return err
+	}
+	return env.printCircuit(newCircuit(name, info))
+}
+
+func forceState(state circuitry.CircuitState) command {
+	return func(ctx context.Context, env *environment, args []string) error {
+		name, err := nameArg(state.String(), args)
+		if err != nil {
+			return err
+		}
+		info, err := env.factory.ForceState(ctx, name, state)
+		if err != nil {
+			return err
+		}
+		return env.printCircuit(newCircuit(name, info))
+	}
+}
+
+func watch(ctx context.Context, env *environment, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: watch takes no arguments", errUsage)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := env.factory.Subscribe(ctx, nil)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- env.factory.Watch(ctx)
+		cancel()
+	}()
+	encoder := json.NewEncoder(env.stdout)
+	for event := range sub.C {
+		if env.output == "json" {
+			if err := encoder.Encode(event); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(env.stdout, "%s\t%s\t%s -> %s\tgeneration %d\n",
+			event.Time.Format(time.RFC3339), event.Name, event.From, event.To, event.Generation)
+	}
+	// Watch returns once the context is done, which is how watch is meant to
+	// end, or when the backend cannot be watched
+	if err := <-watchErr; !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func export(ctx context.Context, env *environment, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: export takes no arguments", errUsage)
+	}
+	circuits, err := collect(ctx, env.factory, circuitry.CircuitFilter{})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(env.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(circuits)
+}
+
+func importCircuits(ctx context.Context, env *environment, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: import takes no arguments", errUsage)
+	}
+	var circuits []admin.Circuit
+	if err := json.NewDecoder(env.stdin).Decode(&circuits); err != nil {
+		return fmt.Errorf("cannot decode circuits: %w", err)
+	}
+	for _, circuit := range circuits {
+		if err := store(ctx, env.backend, circuit); err != nil {
+			return fmt.Errorf("cannot import %q: %w", circuit.Name, err)
+		}
+	}
+	fmt.Fprintf(env.stderr, "imported %d circuits\n", len(circuits))
+	return nil
+}
+
+func store(ctx context.Context, backend circuitry.StorageBackender, circuit admin.Circuit) error {
+	lock, err := backend.Lock(ctx, circuit.Name)
+	if err != nil {
+		return err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return backend.Store(ctx, circuit.Name, circuit.Information)
+}
+
+func nameArg(cmd string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: %s takes exactly one circuit name", errUsage, cmd)
+	}
+	return args[0], nil
+}
+
+func collect(ctx context.Context, factory *circuitry.CircuitBreakerFactory, filter circuitry.CircuitFilter) ([]admin.Circuit, error) {
+	circuits := []admin.Circuit{}
+	for entry, err := range factory.Circuits(ctx, filter) {
+		if err != nil {
+			return nil, err
+		}
+		circuits = append(circuits, newCircuit(entry.Name, entry.Information))
+	}
+	return circuits, nil
+}
+
+func newCircuit(name string, info circuitry.CircuitInformation) admin.Circuit {
+	return admin.Circuit{Name: name, State: info.State.String(), Information: info}
+}
+
+func (env *environment) printCircuit(circuit admin.Circuit) error {
+	if env.output == "json" {
+		return json.NewEncoder(env.stdout).Encode(circuit)
+	}
+	return env.printTable([]admin.Circuit{circuit})
+}
+
+func (env *environment) printCircuits(circuits []admin.Circuit) error {
+	if env.output == "json" {
+		return json.NewEncoder(env.stdout).Encode(circuits)
+	}
+	return env.printTable(circuits)
+}
+
+func (env *environment) printTable(circuits []admin.Circuit) error {
+	w := tabwriter.NewWriter(env.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tGENERATION\tTOTAL\tFAILURES\tSUCCESSES\tCONSECUTIVE FAILURES\tEXPIRES AFTER")
+	for _, c := range circuits {
+		expires := "-"
+		if !c.Information.ExpiresAfter.IsZero() {
+			expires = c.Information.ExpiresAfter.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			c.Name, c.State, c.Information.Generation, c.Information.Total,
+			c.Information.TotalFailures, c.Information.TotalSuccesses,
+			c.Information.ConsecutiveFailures, expires)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,280 @@
+// Command circuitry inspects and controls the circuits stored in a circuitry
+// Redis or DynamoDB backend.
+//
+// Usage:
+//
+//	circuitry [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	list [prefix]   list circuits, optionally only those whose names start with prefix
+//	get <name>      show one circuit
+//	reset <name>    reset the counts of a circuit
+//	open <name>     force a circuit open
+//	close <name>    force a circuit closed
+//	watch           print state transitions as they happen
+//	export          write every circuit to stdout as JSON
+//	import          read circuits written by export from stdin and store them
+//
+// Every flag can also be set with the environment variable shown in
+// circuitry -help.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: circuitry [flags] <command> [arguments]
+
+Commands:
+  list [prefix]   list circuits, optionally only those whose names start with prefix
+  get <name>      show one circuit
+  reset <name>    reset the counts of a circuit
+  open <name>     force a circuit open
+  close <name>    force a circuit closed
+  watch           print state transitions as they happen
+  export          write every circuit to stdout as JSON
+  import          read circuits written by export from stdin and store them
+
+Flags:
+`
+
+var errUsage = errors.New("invalid usage")
+
+type config struct {
+	backend             string
+	output              string
+	allowAfter          time.Duration
+	cyclicClearAfter    time.Duration
+	lockTTL             time.Duration
+	retainFor           time.Duration
+	retentionGrace      time.Duration
+	redisAddr           string
+	redisPassword       string
+	redisDB             int
+	redisChannel        string
+	redisKeyPrefix      string
+	redisStateNamespace string
+	redisLockNamespace  string
+	redisHashTags       bool
+	redisAtomic         bool
+	redisCodec          string
+	dynamoTable         string
+	dynamoLockTable     string
+	dynamoSingleTable   bool
+	dynamoEndpoint      string
+	dynamoStreamARN     string
+	dynamoPollInterval  time.Duration
+}
+
+// retention returns the Retention of the circuits stored by the backend
+func (cfg config) retention() circuitry.Retention {
+	if cfg.retainFor > 0 {
+		return circuitry.RetainFor(cfg.retainFor)
+	}
+	return circuitry.RetainUntilExpiry(cfg.retentionGrace)
+}
+
+type backendFunc func(context.Context, config) (circuitry.StorageBackender, error)
+
+type cli struct {
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	getenv     func(string) string
+	newBackend backendFunc
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	c := cli{
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		getenv:     os.Getenv,
+		newBackend: newBackend,
+	}
+	code := c.run(ctx, os.Args[1:])
+	stop()
+	os.Exit(code)
+}
+
+func (c cli) run(ctx context.Context, args []string) int {
+	cfg, rest, err := c.parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintf(c.stderr, "circuitry: %v\n", err)
+		return exitUsage
+	}
+	if len(rest) == 0 {
+		fmt.Fprintln(c.stderr, "circuitry: no command given")
+		fmt.Fprint(c.stderr, usage)
+		return exitUsage
+	}
+	cmd, ok := commands[rest[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "circuitry: unknown command %q\n", rest[0])
+		return exitUsage
+	}
+	if cfg.output != "table" && cfg.output != "json" {
+		fmt.Fprintf(c.stderr, "circuitry: unknown output %q\n", cfg.output)
+		return exitUsage
+	}
+	backend, err := c.newBackend(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "circuitry: %v\n", err)
+		return exitError
+	}
+	settings, _ := circuitry.NewFactorySettings( // We know these options cannot conflict
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithAllowAfter(cfg.allowAfter),
+		circuitry.WithCyclicClearAfter(cfg.cyclicClearAfter),
+	)
+	env := &environment{
+		cli:     c,
+		output:  cfg.output,
+		backend: backend,
+		factory: circuitry.NewCircuitBreakerFactory(settings),
+	}
+	if err := cmd(ctx, env, rest[1:]); err != nil {
+		fmt.Fprintf(c.stderr, "circuitry %s: %v\n", rest[0], err)
+		if errors.Is(err, errUsage) {
+			return exitUsage
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+func (c cli) parse(args []string) (config, []string, error) {
+	var cfg config
+	fs := flag.NewFlagSet("circuitry", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprint(c.stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.backend, "backend", c.env("CIRCUITRY_BACKEND", "redis"), "storage backend, redis or dynamodb ($CIRCUITRY_BACKEND)")
+	fs.StringVar(&cfg.output, "output", c.env("CIRCUITRY_OUTPUT", "table"), "output format, table or json ($CIRCUITRY_OUTPUT)")
+	fs.StringVar(&cfg.redisAddr, "redis-addr", c.env("CIRCUITRY_REDIS_ADDR", "localhost:6379"), "Redis address ($CIRCUITRY_REDIS_ADDR)")
+	fs.StringVar(&cfg.redisPassword, "redis-password", c.env("CIRCUITRY_REDIS_PASSWORD", ""), "Redis password ($CIRCUITRY_REDIS_PASSWORD)")
+	fs.StringVar(&cfg.redisChannel, "redis-channel", c.env("CIRCUITRY_REDIS_CHANNEL", ""), "Redis channel transitions are published on ($CIRCUITRY_REDIS_CHANNEL)")
+	fs.StringVar(&cfg.redisKeyPrefix, "redis-key-prefix", c.env("CIRCUITRY_REDIS_KEY_PREFIX", ""), "prefix of the Redis keys circuits are stored in ($CIRCUITRY_REDIS_KEY_PREFIX)")
+	fs.StringVar(&cfg.redisStateNamespace, "redis-state-namespace", c.env("CIRCUITRY_REDIS_STATE_NAMESPACE", ""), "namespace following the key prefix in the Redis keys circuits are stored in ($CIRCUITRY_REDIS_STATE_NAMESPACE)")
+	fs.StringVar(&cfg.redisLockNamespace, "redis-lock-namespace", c.env("CIRCUITRY_REDIS_LOCK_NAMESPACE", ""), "namespace following the key prefix in the Redis keys circuits are locked at, "+redisbackend.DefaultLockNamespace+" if empty ($CIRCUITRY_REDIS_LOCK_NAMESPACE)")
+	fs.StringVar(&cfg.redisCodec, "redis-codec", c.env("CIRCUITRY_REDIS_CODEC", "json"), "codec circuits are stored in Redis with, json, msgpack, protobuf, or binary ($CIRCUITRY_REDIS_CODEC)")
+	fs.StringVar(&cfg.dynamoTable, "dynamodb-table", c.env("CIRCUITRY_DYNAMODB_TABLE", ""), "DynamoDB circuit information table ($CIRCUITRY_DYNAMODB_TABLE)")
+	fs.StringVar(&cfg.dynamoLockTable, "dynamodb-lock-table", c.env("CIRCUITRY_DYNAMODB_LOCK_TABLE", ""), "DynamoDB lock table ($CIRCUITRY_DYNAMODB_LOCK_TABLE)")
+	fs.StringVar(&cfg.dynamoEndpoint, "dynamodb-endpoint", c.env("CIRCUITRY_DYNAMODB_ENDPOINT", ""), "DynamoDB endpoint, e.g., for DynamoDB Local ($CIRCUITRY_DYNAMODB_ENDPOINT)")
+	fs.StringVar(&cfg.dynamoStreamARN, "dynamodb-stream-arn", c.env("CIRCUITRY_DYNAMODB_STREAM_ARN", ""), "DynamoDB Stream ARN of the circuit information table ($CIRCUITRY_DYNAMODB_STREAM_ARN)")
+	durations := []struct {
+		value    *time.Duration
+		name     string
+		env      string
+		fallback time.Duration
+		help     string
+	}{
+		{&cfg.allowAfter, "allow-after", "CIRCUITRY_ALLOW_AFTER", time.Minute, "how long circuits forced open wait before allowing requests"},
+		{&cfg.cyclicClearAfter, "cyclic-clear-after", "CIRCUITRY_CYCLIC_CLEAR_AFTER", 0, "how long circuits forced closed wait before clearing their counts, which should match the applications' CyclicClearAfter"},
+		{&cfg.retainFor, "retain-for", "CIRCUITRY_RETAIN_FOR", 0, "how long circuits are kept after they are stored, forever if zero"},
+		{&cfg.retentionGrace, "retention-grace", "CIRCUITRY_RETENTION_GRACE", 0, "how long circuits are kept after they expire when -retain-for is zero, forever if zero"},
+		{&cfg.lockTTL, "lock-ttl", "CIRCUITRY_LOCK_TTL", 10 * time.Second, "how long Redis locks are held"},
+		{&cfg.dynamoPollInterval, "dynamodb-poll-interval", "CIRCUITRY_DYNAMODB_POLL_INTERVAL", time.Second, "how often the DynamoDB Stream is polled"},
+	}
+	for _, d := range durations {
+		fallback, err := c.durationEnv(d.env, d.fallback)
+		if err != nil {
+			return cfg, nil, err
+		}
+		fs.DurationVar(d.value, d.name, fallback, fmt.Sprintf("%s ($%s)", d.help, d.env))
+	}
+	db, err := c.intEnv("CIRCUITRY_REDIS_DB", 0)
+	if err != nil {
+		return cfg, nil, err
+	}
+	fs.IntVar(&cfg.redisDB, "redis-db", db, "Redis database ($CIRCUITRY_REDIS_DB)")
+	bools := []struct {
+		value *bool
+		name  string
+		env   string
+		help  string
+	}{
+		{&cfg.redisAtomic, "redis-atomic", "CIRCUITRY_REDIS_ATOMIC", "use the Redis AtomicBackend, which stores circuits as hashes"},
+		{&cfg.redisHashTags, "redis-hash-tags", "CIRCUITRY_REDIS_HASH_TAGS", "wrap circuits' names in hash tags in their Redis keys"},
+		{&cfg.dynamoSingleTable, "dynamodb-single-table", "CIRCUITRY_DYNAMODB_SINGLE_TABLE", "store circuits and their locks in -dynamodb-table"},
+	}
+	for _, b := range bools {
+		fallback, err := c.boolEnv(b.env)
+		if err != nil {
+			return cfg, nil, err
+		}
+		fs.BoolVar(b.value, b.name, fallback, fmt.Sprintf("%s ($%s)", b.help, b.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (c cli) env(name, fallback string) string {
+	if value := strings.TrimSpace(c.getenv(name)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (c cli) durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := c.env(name, "")
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid $%s: %w", name, err)
+	}
+	return d, nil
+}
+
+func (c cli) boolEnv(name string) (bool, error) {
+	value := c.env(name, "")
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid $%s: %w", name, err)
+	}
+	return b, nil
+}
+
+func (c cli) intEnv(name string, fallback int) (int, error) {
+	value := c.env(name, "")
+	if value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid $%s: %w", name, err)
+	}
+	return i, nil
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/admin"
+	"github.com/sigmavirus24/circuitry/backends"
+	ddbbackend "github.com/sigmavirus24/circuitry/backends/dynamodb"
+	redisbackend "github.com/sigmavirus24/circuitry/backends/redis"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+// syncBuffer lets a test read output written by a command still running in
+// another goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type fakeCLI struct {
+	cli
+	stdin  *strings.Reader
+	stdout *syncBuffer
+	stderr *syncBuffer
+	env    map[string]string
+}
+
+func newCLI(backend circuitry.StorageBackender) *fakeCLI {
+	f := &fakeCLI{
+		stdin:  strings.NewReader(""),
+		stdout: &syncBuffer{},
+		stderr: &syncBuffer{},
+		env:    map[string]string{},
+	}
+	f.cli = cli{
+		stdin:  f.stdin,
+		stdout: f.stdout,
+		stderr: f.stderr,
+		getenv: func(name string) string { return f.env[name] },
+		newBackend: func(context.Context, config) (circuitry.StorageBackender, error) {
+			return backend, nil
+		},
+	}
+	return f
+}
+
+func newPopulatedBackend(t *testing.T) circuitry.StorageBackender {
+	t.Helper()
+	backend := backends.NewInMemoryBackend()
+	ctx := context.Background()
+	circuits := map[string]circuitry.CircuitInformation{
+		"tenant/a": {State: circuitry.CircuitOpen, Generation: 2, Total: 5, TotalFailures: 5, ConsecutiveFailures: 5, ExpiresAfter: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"tenant/b": {State: circuitry.CircuitClosed, Generation: 1, Total: 3, TotalSuccesses: 3, ConsecutiveSuccesses: 3},
+		"other":    {State: circuitry.CircuitClosed, Generation: 1, Total: 1, TotalSuccesses: 1, ConsecutiveSuccesses: 1},
+	}
+	for name, info := range circuits {
+		if err := backend.Store(ctx, name, info); err != nil {
+			t.Fatalf("expected to store %s; got %v", name, err)
+		}
+	}
+	return backend
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	testCases := map[string]struct {
+		args     []string
+		env      map[string]string
+		expected int
+		message  string
+	}{
+		"no command":         {[]string{}, nil, exitUsage, "no command given"},
+		"unknown command":    {[]string{"frobnicate"}, nil, exitUsage, `unknown command "frobnicate"`},
+		"unknown flag":       {[]string{"-frobnicate", "list"}, nil, exitUsage, "flag provided but not defined"},
+		"unknown output":     {[]string{"-output", "yaml", "list"}, nil, exitUsage, `unknown output "yaml"`},
+		"invalid duration":   {[]string{"list"}, map[string]string{"CIRCUITRY_LOCK_TTL": "soon"}, exitUsage, "invalid $CIRCUITRY_LOCK_TTL"},
+		"invalid int":        {[]string{"list"}, map[string]string{"CIRCUITRY_REDIS_DB": "zero"}, exitUsage, "invalid $CIRCUITRY_REDIS_DB"},
+		"invalid bool":       {[]string{"list"}, map[string]string{"CIRCUITRY_REDIS_ATOMIC": "maybe"}, exitUsage, "invalid $CIRCUITRY_REDIS_ATOMIC"},
+		"invalid env output": {[]string{"list"}, map[string]string{"CIRCUITRY_OUTPUT": "yaml"}, exitUsage, `unknown output "yaml"`},
+		"help":               {[]string{"-help"}, nil, exitOK, "Commands:"},
+		"list arguments":     {[]string{"list", "a", "b"}, nil, exitUsage, "list takes at most one prefix"},
+		"get arguments":      {[]string{"get"}, nil, exitUsage, "get takes exactly one circuit name"},
+		"reset arguments":    {[]string{"reset"}, nil, exitUsage, "reset takes exactly one circuit name"},
+		"open arguments":     {[]string{"open", "a", "b"}, nil, exitUsage, "open takes exactly one circuit name"},
+		"watch arguments":    {[]string{"watch", "a"}, nil, exitUsage, "watch takes no arguments"},
+		"export arguments":   {[]string{"export", "a"}, nil, exitUsage, "export takes no arguments"},
+		"import arguments":   {[]string{"import", "a"}, nil, exitUsage, "import takes no arguments"},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			c := newCLI(backends.NewInMemoryBackend())
+			for k, v := range tc.env {
+				c.env[k] = v
+			}
+			if code := c.run(context.Background(), tc.args); code != tc.expected {
+				t.Fatalf("expected exit code %d; got %d (stderr: %s)", tc.expected, code, c.stderr)
+			}
+			if !strings.Contains(c.stderr.String(), tc.message) {
+				t.Fatalf("expected stderr to contain %q; got %q", tc.message, c.stderr)
+			}
+		})
+	}
+}
+
+func TestRunBackendError(t *testing.T) {
+	c := newCLI(nil)
+	c.newBackend = func(context.Context, config) (circuitry.StorageBackender, error) {
+		return nil, errors.New("no route to host")
+	}
+	if code := c.run(context.Background(), []string{"list"}); code != exitError {
+		t.Fatalf("expected exit code %d; got %d", exitError, code)
+	}
+	if !strings.Contains(c.stderr.String(), "no route to host") {
+		t.Fatalf("expected the backend error on stderr; got %q", c.stderr)
+	}
+}
+
+func TestParseUsesEnvironment(t *testing.T) {
+	c := newCLI(nil)
+	c.env = map[string]string{
+		"CIRCUITRY_BACKEND":                "dynamodb",
+		"CIRCUITRY_OUTPUT":                 "json",
+		"CIRCUITRY_REDIS_DB":               "3",
+		"CIRCUITRY_DYNAMODB_TABLE":         "circuits",
+		"CIRCUITRY_DYNAMODB_POLL_INTERVAL": "5s",
+		"CIRCUITRY_DYNAMODB_SINGLE_TABLE":  "true",
+		"CIRCUITRY_CYCLIC_CLEAR_AFTER":     "1h",
+	}
+	cfg, rest, err := c.parse([]string{"-output", "table", "get", "a"})
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if cfg.backend != "dynamodb" || cfg.dynamoTable != "circuits" || cfg.redisDB != 3 || cfg.dynamoPollInterval != 5*time.Second || !cfg.dynamoSingleTable || cfg.cyclicClearAfter != time.Hour {
+		t.Fatalf("expected settings from the environment; got %+v", cfg)
+	}
+	if cfg.output != "table" {
+		t.Fatalf("expected flags to override the environment; got output %q", cfg.output)
+	}
+	if cfg.redisAddr != "localhost:6379" || cfg.lockTTL != 10*time.Second || cfg.allowAfter != time.Minute || cfg.redisCodec != "json" || cfg.redisAtomic {
+		t.Fatalf("expected defaults for unset settings; got %+v", cfg)
+	}
+	if len(rest) != 2 || rest[0] != "get" || rest[1] != "a" {
+		t.Fatalf("expected the command and its arguments; got %v", rest)
+	}
+}
+
+func TestListTable(t *testing.T) {
+	c := newCLI(newPopulatedBackend(t))
+	if code := c.run(context.Background(), []string{"list", "tenant/"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(c.stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 circuits; got %q", c.stdout)
+	}
+	expected := [][]string{
+		{"NAME", "STATE", "GENERATION", "TOTAL", "FAILURES", "SUCCESSES", "CONSECUTIVE", "FAILURES", "EXPIRES", "AFTER"},
+		{"tenant/a", "open", "2", "5", "5", "0", "5", "2030-01-01T00:00:00Z"},
+		{"tenant/b", "closed", "1", "3", "0", "3", "0", "-"},
+	}
+	for i, line := range lines {
+		if got := strings.Join(strings.Fields(line), " "); got != strings.Join(expected[i], " ") {
+			t.Fatalf("expected line %d to be %q; got %q", i, strings.Join(expected[i], " "), got)
+		}
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	c := newCLI(newPopulatedBackend(t))
+	if code := c.run(context.Background(), []string{"-output", "json", "list"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+	}
+	var circuits []admin.Circuit
+	if err := json.Unmarshal([]byte(c.stdout.String()), &circuits); err != nil {
+		t.Fatalf("expected JSON output; got %v", err)
+	}
+	if len(circuits) != 3 || circuits[0].Name != "other" || circuits[1].State != "open" {
+		t.Fatalf("expected every circuit sorted by name; got %+v", circuits)
+	}
+}
+
+func TestListNotSupported(t *testing.T) {
+	c := newCLI(&struct{ circuitry.StorageBackender }{backends.NewInMemoryBackend()})
+	if code := c.run(context.Background(), []string{"list"}); code != exitError {
+		t.Fatalf("expected exit code %d; got %d", exitError, code)
+	}
+	if !strings.Contains(c.stderr.String(), circuitry.ErrListingNotSupported.Error()) {
+		t.Fatalf("expected %v on stderr; got %q", circuitry.ErrListingNotSupported, c.stderr)
+	}
+}
+
+func TestCircuitCommands(t *testing.T) {
+	testCases := map[string]struct {
+		args     []string
+		expected circuitry.CircuitInformation
+	}{
+		"get":   {[]string{"get", "tenant/b"}, circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 1, Total: 3, TotalSuccesses: 3, ConsecutiveSuccesses: 3}},
+		"reset": {[]string{"reset", "tenant/b"}, circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 1}},
+		"open":  {[]string{"open", "tenant/b"}, circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2}},
+		"close": {[]string{"close", "tenant/a"}, circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 3}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			backend := newPopulatedBackend(t)
+			c := newCLI(backend)
+			if code := c.run(context.Background(), append([]string{"-output", "json"}, tc.args...)); code != exitOK {
+				t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+			}
+			var circuit admin.Circuit
+			if err := json.Unmarshal([]byte(c.stdout.String()), &circuit); err != nil {
+				t.Fatalf("expected JSON output; got %v", err)
+			}
+			if circuit.Name != tc.args[1] || circuit.State != tc.expected.State.String() {
+				t.Fatalf("expected %s to be %s; got %+v", tc.args[1], tc.expected.State, circuit)
+			}
+			info := circuit.Information
+			if info.Generation != tc.expected.Generation || info.Total != tc.expected.Total || info.ConsecutiveSuccesses != tc.expected.ConsecutiveSuccesses {
+				t.Fatalf("expected %+v; got %+v", tc.expected, info)
+			}
+			stored, _ := backend.Retrieve(context.Background(), tc.args[1])
+			if stored.State != tc.expected.State || stored.Generation != tc.expected.Generation {
+				t.Fatalf("expected the backend to hold %+v; got %+v", tc.expected, stored)
+			}
+		})
+	}
+}
+
+func TestCloseStartsCyclicClear(t *testing.T) {
+	backend := newPopulatedBackend(t)
+	c := newCLI(backend)
+	before := time.Now()
+	if code := c.run(context.Background(), []string{"-cyclic-clear-after", "1h", "close", "tenant/a"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+	}
+	stored, _ := backend.Retrieve(context.Background(), "tenant/a")
+	if stored.State != circuitry.CircuitClosed || stored.ExpiresAfter.Before(before.Add(time.Hour)) {
+		t.Fatalf("expected the closed circuit to clear its counts in an hour; got %+v", stored)
+	}
+}
+
+func TestGetTable(t *testing.T) {
+	c := newCLI(newPopulatedBackend(t))
+	if code := c.run(context.Background(), []string{"get", "tenant/a"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+	}
+	if !strings.HasPrefix(c.stdout.String(), "NAME") || !strings.Contains(c.stdout.String(), "tenant/a") {
+		t.Fatalf("expected a table with tenant/a; got %q", c.stdout)
+	}
+}
+
+type failingBackend struct {
+	circuitry.StorageBackender
+	err error
+}
+
+func (b *failingBackend) Retrieve(context.Context, string) (circuitry.CircuitInformation, error) {
+	return circuitry.CircuitInformation{}, b.err
+}
+
+func (b *failingBackend) Lock(context.Context, string) (sync.Locker, error) {
+	return nil, b.err
+}
+
+func TestCircuitCommandErrors(t *testing.T) {
+	for _, command := range []string{"get", "reset", "open", "close"} {
+		t.Run(command, func(t *testing.T) {
+			c := newCLI(&failingBackend{backends.NewInMemoryBackend(), errors.New("backend unavailable")})
+			if code := c.run(context.Background(), []string{command, "tenant/a"}); code != exitError {
+				t.Fatalf("expected exit code %d; got %d", exitError, code)
+			}
+			if !strings.Contains(c.stderr.String(), "backend unavailable") {
+				t.Fatalf("expected the backend error on stderr; got %q", c.stderr)
+			}
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	exporter := newCLI(newPopulatedBackend(t))
+	if code := exporter.run(context.Background(), []string{"export"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, exporter.stderr)
+	}
+
+	backend := backends.NewInMemoryBackend()
+	importer := newCLI(backend)
+	importer.stdin.Reset(exporter.stdout.String())
+	if code := importer.run(context.Background(), []string{"import"}); code != exitOK {
+		t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, importer.stderr)
+	}
+	if !strings.Contains(importer.stderr.String(), "imported 3 circuits") {
+		t.Fatalf("expected a summary on stderr; got %q", importer.stderr)
+	}
+	info, _ := backend.Retrieve(context.Background(), "tenant/a")
+	if info.State != circuitry.CircuitOpen || info.Generation != 2 || info.ConsecutiveFailures != 5 {
+		t.Fatalf("expected tenant/a to be imported; got %+v", info)
+	}
+}
+
+func TestExportNotSupported(t *testing.T) {
+	c := newCLI(&struct{ circuitry.StorageBackender }{backends.NewInMemoryBackend()})
+	if code := c.run(context.Background(), []string{"export"}); code != exitError {
+		t.Fatalf("expected exit code %d; got %d", exitError, code)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	testCases := map[string]struct {
+		backend circuitry.StorageBackender
+		stdin   string
+		message string
+	}{
+		"invalid JSON": {backends.NewInMemoryBackend(), "{", "cannot decode circuits"},
+		"lock error":   {&failingBackend{backends.NewInMemoryBackend(), errors.New("lock held")}, `[{"name": "a"}]`, `cannot import "a": lock held`},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			c := newCLI(tc.backend)
+			c.stdin.Reset(tc.stdin)
+			if code := c.run(context.Background(), []string{"import"}); code != exitError {
+				t.Fatalf("expected exit code %d; got %d", exitError, code)
+			}
+			if !strings.Contains(c.stderr.String(), tc.message) {
+				t.Fatalf("expected stderr to contain %q; got %q", tc.message, c.stderr)
+			}
+		})
+	}
+}
+
+func TestWatchNotSupported(t *testing.T) {
+	c := newCLI(backends.NewInMemoryBackend())
+	if code := c.run(context.Background(), []string{"watch"}); code != exitError {
+		t.Fatalf("expected exit code %d; got %d", exitError, code)
+	}
+	if !strings.Contains(c.stderr.String(), circuitry.ErrWatchNotSupported.Error()) {
+		t.Fatalf("expected %v on stderr; got %q", circuitry.ErrWatchNotSupported, c.stderr)
+	}
+}
+
+func TestWatchRedis(t *testing.T) {
+	testCases := map[string]struct {
+		output   string
+		expected string
+	}{
+		"table": {"table", "2030-01-01T00:00:00Z\ttenant/a\tclosed -> open\tgeneration 2\n"},
+		"json":  {"json", `"name":"tenant/a","from":0,"to":1,"generation":2`},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			server := miniredis.RunT(t)
+			c := newCLI(nil)
+			c.newBackend = newBackend
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan int, 1)
+			go func() {
+				done <- c.run(ctx, []string{"-redis-addr", server.Addr(), "-redis-channel", "transitions", "-output", tc.output, "watch"})
+			}()
+
+			deadline := time.Now().Add(time.Second)
+			for server.PubSubNumSub("transitions")["transitions"] < 1 {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected watch to subscribe to transitions after 1s")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			publisher := &redisbackend.Backend{
+				Client:            redis.NewClient(&redis.Options{Addr: server.Addr()}),
+				TransitionChannel: "transitions",
+			}
+			event := circuitry.TransitionEvent{
+				Name:       "tenant/a",
+				From:       circuitry.CircuitClosed,
+				To:         circuitry.CircuitOpen,
+				Generation: 2,
+				Time:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			}
+			if err := publisher.PublishTransition(ctx, event); err != nil {
+				t.Fatalf("expected to publish the event; got %v", err)
+			}
+			for !strings.Contains(c.stdout.String(), tc.expected) {
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %q to be printed; got %q", tc.expected, c.stdout)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			cancel()
+			if code := <-done; code != exitOK {
+				t.Fatalf("expected exit code %d; got %d (stderr: %s)", exitOK, code, c.stderr)
+			}
+		})
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	ctx := context.Background()
+
+	backend, err := newBackend(ctx, config{
+		backend:             "redis",
+		redisAddr:           "localhost:6379",
+		redisChannel:        "transitions",
+		redisKeyPrefix:      "app:",
+		redisStateNamespace: "state:",
+		redisLockNamespace:  "locks:",
+		redisHashTags:       true,
+		redisCodec:          "binary",
+		retainFor:           time.Hour,
+		lockTTL:             time.Second,
+	})
+	if err != nil {
+		t.Fatalf("expected a redis backend; got %v", err)
+	}
+	rb, ok := backend.(*redisbackend.Backend)
+	if !ok || rb.TransitionChannel != "transitions" || rb.KeyPrefix != "app:" || rb.DefaultLockTTL != time.Second {
+		t.Fatalf("expected a configured redis backend; got %#v", backend)
+	}
+	if rb.StateNamespace != "state:" || rb.LockNamespace != "locks:" || !rb.HashTags || rb.Codec != codec.Binary || rb.Retention != circuitry.RetainFor(time.Hour) {
+		t.Fatalf("expected the redis backend's keys, codec, and retention to be configured; got %#v", rb)
+	}
+
+	backend, err = newBackend(ctx, config{backend: "redis", redisCodec: "json", redisAtomic: true, retentionGrace: time.Minute})
+	if err != nil {
+		t.Fatalf("expected a redis atomic backend; got %v", err)
+	}
+	if ab, ok := backend.(*redisbackend.AtomicBackend); !ok || ab.Codec != codec.JSON || ab.Retention != circuitry.RetainUntilExpiry(time.Minute) {
+		t.Fatalf("expected a configured redis atomic backend; got %#v", backend)
+	}
+
+	backend, err = newBackend(ctx, config{
+		backend:            "dynamodb",
+		dynamoTable:        "circuits",
+		dynamoLockTable:    "locks",
+		dynamoEndpoint:     "http://localhost:8000",
+		dynamoStreamARN:    "arn:aws:dynamodb:us-east-1:123456789012:table/circuits/stream/2030-01-01T00:00:00.000",
+		dynamoPollInterval: time.Second,
+		retainFor:          time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("expected a dynamodb backend; got %v", err)
+	}
+	db, ok := backend.(*ddbbackend.Backend)
+	if !ok || db.CircuitTableName != "circuits" || db.LockTableName != "locks" || db.StreamsClient == nil || db.StreamPollInterval != time.Second || db.Retention != circuitry.RetainFor(time.Hour) {
+		t.Fatalf("expected a configured dynamodb backend; got %#v", backend)
+	}
+
+	backend, err = newBackend(ctx, config{backend: "dynamodb", dynamoTable: "circuits", dynamoSingleTable: true})
+	if err != nil {
+		t.Fatalf("expected a single table dynamodb backend; got %v", err)
+	}
+	if db := backend.(*ddbbackend.Backend); !db.SingleTable || db.CircuitTableName != "circuits" || db.LockTableName != "circuits" {
+		t.Fatalf("expected a dynamodb backend using one table; got %#v", db)
+	}
+
+	backend, err = newBackend(ctx, config{backend: "dynamodb", dynamoTable: "circuits", dynamoLockTable: "locks"})
+	if err != nil {
+		t.Fatalf("expected a dynamodb backend; got %v", err)
+	}
+	if db := backend.(*ddbbackend.Backend); db.StreamsClient != nil {
+		t.Fatalf("expected no streams client without a stream ARN; got %#v", db.StreamsClient)
+	}
+}
+
+func TestNewBackendErrors(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_PROFILE", "circuitry-missing-profile")
+	testCases := map[string]struct {
+		cfg     config
+		message string
+	}{
+		"unknown backend":    {config{backend: "memcached"}, `unknown backend "memcached"`},
+		"unknown codec":      {config{backend: "redis", redisCodec: "xml"}, `unknown Redis codec "xml"`},
+		"missing table":      {config{backend: "dynamodb", dynamoLockTable: "locks"}, "requires -dynamodb-table"},
+		"invalid AWS config": {config{backend: "dynamodb", dynamoTable: "circuits", dynamoLockTable: "locks"}, "cannot load AWS configuration"},
+		"missing lock table": {config{backend: "dynamodb", dynamoTable: "circuits"}, "requires -dynamodb-table and -dynamodb-lock-table"},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			_, err := newBackend(context.Background(), tc.cfg)
+			if err == nil || !strings.Contains(err.Error(), tc.message) {
+				t.Fatalf("expected an error containing %q; got %v", tc.message, err)
+			}
+		})
+	}
+}
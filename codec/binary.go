@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+var (
+	errTruncated    = errors.New("codec: truncated binary circuit information")
+	errTrailingData = errors.New("codec: trailing data after binary circuit information")
+)
+
+// binaryCodec writes the version byte followed by the state and counts as
+// uvarints in the order CircuitInformation declares them and, when the
+// circuit expires, the seconds since the epoch as a varint and the
+// nanoseconds as a uvarint
+type binaryCodec struct{}
+
+func (binaryCodec) Version() byte { return VersionBinary }
+
+func (binaryCodec) Marshal(ci circuitry.CircuitInformation) ([]byte, error) {
+	b := make([]byte, 1, 1+9*binary.MaxVarintLen64)
+	b[0] = VersionBinary
+	for _, value := range []uint64{
+		uint64(ci.State),
+		ci.Generation,
+		ci.ConsecutiveFailures,
+		ci.ConsecutiveSuccesses,
+		ci.Total,
+		ci.TotalFailures,
+		ci.TotalSuccesses,
+	} {
+		b = binary.AppendUvarint(b, value)
+	}
+	if !ci.ExpiresAfter.IsZero() {
+		b = binary.AppendVarint(b, ci.ExpiresAfter.Unix())
+		b = binary.AppendUvarint(b, uint64(ci.ExpiresAfter.Nanosecond()))
+	}
+	return b, nil
+}
+
+func (binaryCodec) Unmarshal(data []byte) (circuitry.CircuitInformation, error) {
+	if len(data) == 0 || data[0] != VersionBinary {
+		return circuitry.CircuitInformation{}, versionError(data)
+	}
+	b := data[1:]
+	var values [7]uint64
+	for i := range values {
+		value, n := binary.Uvarint(b)
+		if n <= 0 {
+			return circuitry.CircuitInformation{}, errTruncated
+		}
+		values[i], b = value, b[n:]
+	}
+	if values[0] > math.MaxUint32 {
+		return circuitry.CircuitInformation{}, fmt.Errorf("%w %d", circuitry.ErrInvalidCircuitState, values[0])
+	}
+	ci := circuitry.CircuitInformation{
+		State:                circuitry.CircuitState(values[0]),
+		Generation:           values[1],
+		ConsecutiveFailures:  values[2],
+		ConsecutiveSuccesses: values[3],
+		Total:                values[4],
+		TotalFailures:        values[5],
+		TotalSuccesses:       values[6],
+	}
+	if len(b) == 0 {
+		return ci, nil
+	}
+	seconds, n := binary.Varint(b)
+	if n <= 0 {
+		return circuitry.CircuitInformation{}, errTruncated
+	}
+	b = b[n:]
+	nanos, n := binary.Uvarint(b)
+	if n <= 0 {
+		return circuitry.CircuitInformation{}, errTruncated
+	}
+	if n != len(b) {
+		return circuitry.CircuitInformation{}, errTrailingData
+	}
+	if nanos >= uint64(time.Second) {
+		return circuitry.CircuitInformation{}, fmt.Errorf("%w: %d nanoseconds", errInvalidTimestamp, nanos)
+	}
+	ci.ExpiresAfter = expiry(time.Unix(seconds, int64(nanos)))
+	return ci, nil
+}
@@ -0,0 +1,110 @@
+// Package codec serializes [github.com/sigmavirus24/circuitry.CircuitInformation]
+// for storage backends that store circuits as bytes.
+//
+// Everything a codec marshals starts with its version byte, and [Unmarshal]
+// uses that byte to pick the codec that reads it. Backends therefore read
+// circuits stored in any of this package's formats whichever codec they are
+// configured to write with, so a fleet can switch formats one process at a
+// time. JSON's version byte is the opening brace of the object, so circuits
+// stored as JSON before codecs were introduced are read as JSON.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// Version bytes of the codecs in this package
+const (
+	VersionJSON        byte = '{'
+	VersionMessagePack byte = 0x01
+	VersionProtobuf    byte = 0x02
+	VersionBinary      byte = 0x03
+)
+
+// ErrUnknownVersion is returned when data does not start with the version
+// byte of the codec reading it
+var ErrUnknownVersion = errors.New("codec: unknown version")
+
+// Codec marshals and unmarshals circuit information in one format
+type Codec interface {
+	// Version is the first byte of everything Marshal returns
+	Version() byte
+	// Marshal serializes the information, starting with the Version byte
+	Marshal(circuitry.CircuitInformation) ([]byte, error)
+	// Unmarshal deserializes information serialized by Marshal, returning
+	// ErrUnknownVersion if it does not start with the Version byte
+	Unmarshal([]byte) (circuitry.CircuitInformation, error)
+}
+
+// Codecs in this package
+var (
+	// JSON serializes circuits with encoding/json. It is the default.
+	JSON Codec = jsonCodec{}
+	// MessagePack serializes circuits as a MessagePack map with the same
+	// keys as JSON
+	MessagePack Codec = msgpackCodec{}
+	// Protobuf serializes circuits as the CircuitInformation message in
+	// circuit.proto
+	Protobuf Codec = protobufCodec{}
+	// Binary serializes circuits in a compact format of varints
+	Binary Codec = binaryCodec{}
+)
+
+var builtin = map[byte]Codec{
+	VersionJSON:        JSON,
+	VersionMessagePack: MessagePack,
+	VersionProtobuf:    Protobuf,
+	VersionBinary:      Binary,
+}
+
+// Unmarshal deserializes data with the codec whose version byte it starts
+// with, preferring the given codec, which may be nil, over this package's
+func Unmarshal(data []byte, preferred Codec) (circuitry.CircuitInformation, error) {
+	if len(data) > 0 && preferred != nil && preferred.Version() == data[0] {
+		return preferred.Unmarshal(data)
+	}
+	if len(data) > 0 && builtin[data[0]] != nil {
+		return builtin[data[0]].Unmarshal(data)
+	}
+	return circuitry.CircuitInformation{}, versionError(data)
+}
+
+func versionError(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: no data", ErrUnknownVersion)
+	}
+	return fmt.Errorf("%w %#02x", ErrUnknownVersion, data[0])
+}
+
+// expiry converts a time decoded from its seconds and nanoseconds since the
+// epoch, so that the zero time is decoded as time.Time{}
+func expiry(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Version() byte { return VersionJSON }
+
+func (jsonCodec) Marshal(ci circuitry.CircuitInformation) ([]byte, error) {
+	return json.Marshal(ci)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (circuitry.CircuitInformation, error) {
+	var ci circuitry.CircuitInformation
+	if len(data) == 0 || data[0] != VersionJSON {
+		return ci, versionError(data)
+	}
+	if err := json.Unmarshal(data, &ci); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	return ci, nil
+}
@@ -0,0 +1,269 @@
+package codec_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/codec"
+)
+
+var codecs = map[string]codec.Codec{
+	"json":        codec.JSON,
+	"messagepack": codec.MessagePack,
+	"protobuf":    codec.Protobuf,
+	"binary":      codec.Binary,
+}
+
+var examples = map[string]circuitry.CircuitInformation{
+	"zero value": {},
+	"closed": {
+		Generation:           7,
+		ConsecutiveSuccesses: 3,
+		Total:                10,
+		TotalFailures:        2,
+		TotalSuccesses:       8,
+		ExpiresAfter:         time.Date(2024, 12, 19, 1, 2, 3, 456789000, time.UTC),
+	},
+	"open": {
+		State:               circuitry.CircuitOpen,
+		Generation:          math.MaxUint64,
+		ConsecutiveFailures: math.MaxUint64,
+		Total:               math.MaxUint64,
+		TotalFailures:       math.MaxUint64,
+		ExpiresAfter:        time.Unix(1729296000, 1),
+	},
+	"half-open at the epoch": {State: circuitry.CircuitHalfOpen, ExpiresAfter: time.Unix(0, 0)},
+	"before the epoch":       {ExpiresAfter: time.Date(1900, 1, 1, 0, 0, 0, 999999999, time.UTC)},
+}
+
+func equal(a, b circuitry.CircuitInformation) bool {
+	if !a.ExpiresAfter.Equal(b.ExpiresAfter) || a.ExpiresAfter.IsZero() != b.ExpiresAfter.IsZero() {
+		return false
+	}
+	a.ExpiresAfter, b.ExpiresAfter = time.Time{}, time.Time{}
+	return a == b
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, testCase := range codecs {
+		c := testCase
+		t.Run(name, func(t *testing.T) {
+			for example, ci := range examples {
+				data, err := c.Marshal(ci)
+				if err != nil {
+					t.Fatalf("%s: expected to marshal; got err = %v", example, err)
+				}
+				if data[0] != c.Version() {
+					t.Fatalf("%s: expected the version byte %#02x; got %#02x", example, c.Version(), data[0])
+				}
+				for reader, decode := range map[string]func([]byte) (circuitry.CircuitInformation, error){
+					"codec":   c.Unmarshal,
+					"package": func(data []byte) (circuitry.CircuitInformation, error) { return codec.Unmarshal(data, nil) },
+				} {
+					actual, err := decode(data)
+					if err != nil {
+						t.Fatalf("%s: expected the %s to unmarshal; got err = %v", example, reader, err)
+					}
+					if !equal(actual, ci) {
+						t.Fatalf("%s: expected the %s to unmarshal %+v; got %+v", example, reader, ci, actual)
+					}
+					if ci.ExpiresAfter.IsZero() && actual.ExpiresAfter != (time.Time{}) {
+						t.Fatalf("%s: expected the zero time; got %#v", example, actual.ExpiresAfter)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCompactFormatsAreSmallerThanJSON(t *testing.T) {
+	ci := examples["closed"]
+	jsonData, _ := codec.JSON.Marshal(ci)
+	for _, name := range []string{"messagepack", "protobuf", "binary"} {
+		data, _ := codecs[name].Marshal(ci)
+		if len(data) >= len(jsonData) {
+			t.Fatalf("expected %s to be smaller than %d bytes of JSON; got %d bytes", name, len(jsonData), len(data))
+		}
+	}
+}
+
+func TestUnmarshalLegacyJSON(t *testing.T) {
+	data := []byte(`{"state":1,"generation":2,"consecutive_failures":3,"consecutive_successes":0,"total":5,"total_failures":4,"total_successes":1,"expires_after":"2024-12-19T00:00:00Z"}`)
+	expected := circuitry.CircuitInformation{
+		State:               circuitry.CircuitOpen,
+		Generation:          2,
+		ConsecutiveFailures: 3,
+		Total:               5,
+		TotalFailures:       4,
+		TotalSuccesses:      1,
+		ExpiresAfter:        time.Date(2024, 12, 19, 0, 0, 0, 0, time.UTC),
+	}
+	for name, c := range codecs {
+		actual, err := codec.Unmarshal(data, c)
+		if err != nil || !equal(actual, expected) {
+			t.Fatalf("expected a backend preferring %s to read JSON; got %+v, %v", name, actual, err)
+		}
+	}
+}
+
+// upperCodec is a custom codec whose version byte is taken by JSON
+type upperCodec struct{ codec.Codec }
+
+func (upperCodec) Unmarshal([]byte) (circuitry.CircuitInformation, error) {
+	return circuitry.CircuitInformation{Generation: 42}, nil
+}
+
+func TestUnmarshalPrefersCodec(t *testing.T) {
+	data, _ := codec.JSON.Marshal(circuitry.CircuitInformation{Generation: 1})
+	if actual, err := codec.Unmarshal(data, upperCodec{codec.JSON}); err != nil || actual.Generation != 42 {
+		t.Fatalf("expected the preferred codec to unmarshal; got %+v, %v", actual, err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	testCases := map[string]struct {
+		codec codec.Codec
+		data  []byte
+	}{
+		"empty":                        {nil, nil},
+		"unknown version":              {nil, []byte{0xff, 0x00}},
+		"json empty":                   {codec.JSON, nil},
+		"json wrong version":           {codec.JSON, []byte{codec.VersionBinary}},
+		"json invalid":                 {codec.JSON, []byte(`{"state":"open"}`)},
+		"messagepack wrong version":    {codec.MessagePack, []byte(`{}`)},
+		"messagepack invalid":          {codec.MessagePack, []byte{codec.VersionMessagePack, 0xc1}},
+		"protobuf wrong version":       {codec.Protobuf, []byte(`{}`)},
+		"protobuf truncated tag":       {codec.Protobuf, []byte{codec.VersionProtobuf, 0x80}},
+		"protobuf truncated varint":    {codec.Protobuf, []byte{codec.VersionProtobuf, 0x10, 0x80}},
+		"protobuf truncated bytes":     {codec.Protobuf, []byte{codec.VersionProtobuf, 0x42, 0x05, 0x08}},
+		"protobuf truncated unknown":   {codec.Protobuf, []byte{codec.VersionProtobuf, 0x4a, 0x05}},
+		"protobuf state overflow":      {codec.Protobuf, []byte{codec.VersionProtobuf, 0x08, 0x80, 0x80, 0x80, 0x80, 0x10}},
+		"protobuf invalid nanos":       {codec.Protobuf, []byte{codec.VersionProtobuf, 0x42, 0x05, 0x10, 0x80, 0x94, 0xeb, 0xdc, 0x03}},
+		"protobuf truncated seconds":   {codec.Protobuf, []byte{codec.VersionProtobuf, 0x42, 0x02, 0x08, 0x80}},
+		"protobuf truncated timestamp": {codec.Protobuf, []byte{codec.VersionProtobuf, 0x42, 0x01, 0x80}},
+		"binary wrong version":         {codec.Binary, []byte(`{}`)},
+		"binary truncated":             {codec.Binary, []byte{codec.VersionBinary, 0, 0, 0}},
+		"binary state overflow":        {codec.Binary, []byte{codec.VersionBinary, 0x80, 0x80, 0x80, 0x80, 0x10, 0, 0, 0, 0, 0, 0}},
+		"binary truncated seconds":     {codec.Binary, []byte{codec.VersionBinary, 0, 0, 0, 0, 0, 0, 0, 0x80}},
+		"binary truncated nanos":       {codec.Binary, []byte{codec.VersionBinary, 0, 0, 0, 0, 0, 0, 0, 0x02}},
+		"binary trailing data":         {codec.Binary, []byte{codec.VersionBinary, 0, 0, 0, 0, 0, 0, 0, 0x02, 0x00, 0x00}},
+		"binary invalid nanos":         {codec.Binary, []byte{codec.VersionBinary, 0, 0, 0, 0, 0, 0, 0, 0x02, 0x80, 0x94, 0xeb, 0xdc, 0x03}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			unmarshal := func(data []byte) (circuitry.CircuitInformation, error) { return codec.Unmarshal(data, nil) }
+			if tc.codec != nil {
+				unmarshal = tc.codec.Unmarshal
+			}
+			actual, err := unmarshal(tc.data)
+			if err == nil {
+				t.Fatalf("expected an error; got %+v", actual)
+			}
+			if actual != (circuitry.CircuitInformation{}) {
+				t.Fatalf("expected an empty CircuitInformation with the error; got %+v", actual)
+			}
+		})
+	}
+}
+
+func TestUnknownVersionErrors(t *testing.T) {
+	for name, c := range codecs {
+		if _, err := c.Unmarshal([]byte{0xff}); !errors.Is(err, codec.ErrUnknownVersion) {
+			t.Fatalf("expected %s to return ErrUnknownVersion; got %v", name, err)
+		}
+	}
+	if _, err := codec.Unmarshal(nil, codec.Binary); !errors.Is(err, codec.ErrUnknownVersion) {
+		t.Fatalf("expected ErrUnknownVersion; got %v", err)
+	}
+}
+
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+	data, _ := codec.Protobuf.Marshal(examples["closed"])
+	// Field 9 as a string and field 3 of the timestamp as a varint
+	data = append(data, 0x4a, 0x02, 'h', 'i')
+	data = append(data, 0x42, 0x02, 0x18, 0x01)
+	actual, err := codec.Protobuf.Unmarshal(data)
+	expected := examples["closed"]
+	expected.ExpiresAfter = time.Unix(0, 0)
+	if err != nil || !equal(actual, expected) {
+		t.Fatalf("expected unknown fields to be skipped; got %+v, %v", actual, err)
+	}
+}
+
+func TestJSONMarshalError(t *testing.T) {
+	ci := circuitry.CircuitInformation{ExpiresAfter: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if _, err := codec.JSON.Marshal(ci); err == nil {
+		t.Fatal("expected an error marshaling a time JSON cannot represent")
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, ci := range examples {
+		f.Add(uint32(ci.State), ci.Generation, ci.ConsecutiveFailures, ci.ConsecutiveSuccesses, ci.Total, ci.TotalFailures, ci.TotalSuccesses, ci.ExpiresAfter.Unix(), int64(ci.ExpiresAfter.Nanosecond()), ci.ExpiresAfter.IsZero())
+	}
+	f.Fuzz(func(t *testing.T, state uint32, generation, consecutiveFailures, consecutiveSuccesses, total, totalFailures, totalSuccesses uint64, seconds, nanos int64, zero bool) {
+		ci := circuitry.CircuitInformation{
+			State:                circuitry.CircuitState(state),
+			Generation:           generation,
+			ConsecutiveFailures:  consecutiveFailures,
+			ConsecutiveSuccesses: consecutiveSuccesses,
+			Total:                total,
+			TotalFailures:        totalFailures,
+			TotalSuccesses:       totalSuccesses,
+		}
+		if !zero {
+			// Keep to times time.Time can represent
+			ci.ExpiresAfter = time.Unix(seconds%(1<<40), nanos)
+		}
+		for name, c := range codecs {
+			data, err := c.Marshal(ci)
+			if err != nil {
+				if c == codec.JSON {
+					continue // Years JSON cannot represent
+				}
+				t.Fatalf("%s: expected to marshal %+v; got err = %v", name, ci, err)
+			}
+			actual, err := codec.Unmarshal(data, nil)
+			if err != nil || !equal(actual, ci) {
+				t.Fatalf("%s: expected %+v; got %+v, %v", name, ci, actual, err)
+			}
+		}
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, c := range codecs {
+		for _, ci := range examples {
+			data, _ := c.Marshal(ci)
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ci, err := codec.Unmarshal(data, nil)
+		if err != nil {
+			return
+		}
+		// Anything that unmarshals marshals again to the same information
+		c := codec.Binary
+		if data[0] == codec.VersionJSON {
+			c = codec.JSON
+		}
+		remarshaled, err := c.Marshal(ci)
+		if err != nil {
+			t.Fatalf("expected to marshal %+v; got err = %v", ci, err)
+		}
+		actual, err := c.Unmarshal(remarshaled)
+		if err != nil || !equal(actual, ci) {
+			t.Fatalf("expected %+v; got %+v, %v", ci, actual, err)
+		}
+		if again, _ := c.Marshal(actual); !bytes.Equal(again, remarshaled) {
+			t.Fatalf("expected marshaling to be deterministic; got %x and %x", remarshaled, again)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// msgpackInformation mirrors CircuitInformation with MessagePack keys
+type msgpackInformation struct {
+	State                uint32    `msgpack:"state"`
+	Generation           uint64    `msgpack:"generation"`
+	ConsecutiveFailures  uint64    `msgpack:"consecutive_failures"`
+	ConsecutiveSuccesses uint64    `msgpack:"consecutive_successes"`
+	Total                uint64    `msgpack:"total"`
+	TotalFailures        uint64    `msgpack:"total_failures"`
+	TotalSuccesses       uint64    `msgpack:"total_successes"`
+	ExpiresAfter         time.Time `msgpack:"expires_after"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Version() byte { return VersionMessagePack }
+
+func (msgpackCodec) Marshal(ci circuitry.CircuitInformation) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(VersionMessagePack)
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseCompactInts(true)
+	err := enc.Encode(msgpackInformation{
+		State:                uint32(ci.State),
+		Generation:           ci.Generation,
+		ConsecutiveFailures:  ci.ConsecutiveFailures,
+		ConsecutiveSuccesses: ci.ConsecutiveSuccesses,
+		Total:                ci.Total,
+		TotalFailures:        ci.TotalFailures,
+		TotalSuccesses:       ci.TotalSuccesses,
+		ExpiresAfter:         ci.ExpiresAfter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (circuitry.CircuitInformation, error) {
+	if len(data) == 0 || data[0] != VersionMessagePack {
+		return circuitry.CircuitInformation{}, versionError(data)
+	}
+	var m msgpackInformation
+	if err := msgpack.Unmarshal(data[1:], &m); err != nil {
+		return circuitry.CircuitInformation{}, err
+	}
+	return circuitry.CircuitInformation{
+		State:                circuitry.CircuitState(m.State),
+		Generation:           m.Generation,
+		ConsecutiveFailures:  m.ConsecutiveFailures,
+		ConsecutiveSuccesses: m.ConsecutiveSuccesses,
+		Total:                m.Total,
+		TotalFailures:        m.TotalFailures,
+		TotalSuccesses:       m.TotalSuccesses,
+		ExpiresAfter:         expiry(m.ExpiresAfter),
+	}, nil
+}
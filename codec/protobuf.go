@@ -0,0 +1,158 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// Field numbers of the CircuitInformation message in circuit.proto and of
+// google.protobuf.Timestamp
+const (
+	fieldState protowire.Number = iota + 1
+	fieldGeneration
+	fieldConsecutiveFailures
+	fieldConsecutiveSuccesses
+	fieldTotal
+	fieldTotalFailures
+	fieldTotalSuccesses
+	fieldExpiresAfter
+
+	fieldSeconds protowire.Number = 1
+	fieldNanos   protowire.Number = 2
+)
+
+var errInvalidTimestamp = errors.New("codec: invalid timestamp")
+
+type protobufCodec struct{}
+
+func (protobufCodec) Version() byte { return VersionProtobuf }
+
+func (protobufCodec) Marshal(ci circuitry.CircuitInformation) ([]byte, error) {
+	b := []byte{VersionProtobuf}
+	for _, field := range []struct {
+		number protowire.Number
+		value  uint64
+	}{
+		{fieldState, uint64(ci.State)},
+		{fieldGeneration, ci.Generation},
+		{fieldConsecutiveFailures, ci.ConsecutiveFailures},
+		{fieldConsecutiveSuccesses, ci.ConsecutiveSuccesses},
+		{fieldTotal, ci.Total},
+		{fieldTotalFailures, ci.TotalFailures},
+		{fieldTotalSuccesses, ci.TotalSuccesses},
+	} {
+		if field.value != 0 {
+			b = protowire.AppendTag(b, field.number, protowire.VarintType)
+			b = protowire.AppendVarint(b, field.value)
+		}
+	}
+	if !ci.ExpiresAfter.IsZero() {
+		var ts []byte
+		if seconds := ci.ExpiresAfter.Unix(); seconds != 0 {
+			ts = protowire.AppendTag(ts, fieldSeconds, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(seconds))
+		}
+		if nanos := ci.ExpiresAfter.Nanosecond(); nanos != 0 {
+			ts = protowire.AppendTag(ts, fieldNanos, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(nanos))
+		}
+		b = protowire.AppendTag(b, fieldExpiresAfter, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b, nil
+}
+
+func (protobufCodec) Unmarshal(data []byte) (circuitry.CircuitInformation, error) {
+	var ci circuitry.CircuitInformation
+	if len(data) == 0 || data[0] != VersionProtobuf {
+		return ci, versionError(data)
+	}
+	b := data[1:]
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return circuitry.CircuitInformation{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var err error
+		switch {
+		case number == fieldExpiresAfter && typ == protowire.BytesType:
+			var ts []byte
+			if ts, n = protowire.ConsumeBytes(b); n >= 0 {
+				ci.ExpiresAfter, err = unmarshalTimestamp(ts)
+			}
+		case number >= fieldState && number < fieldExpiresAfter && typ == protowire.VarintType:
+			var value uint64
+			if value, n = protowire.ConsumeVarint(b); n >= 0 {
+				err = setField(&ci, number, value)
+			}
+		default:
+			// Skip fields added by later versions of the message
+			n = protowire.ConsumeFieldValue(number, typ, b)
+		}
+		if n < 0 {
+			return circuitry.CircuitInformation{}, protowire.ParseError(n)
+		}
+		if err != nil {
+			return circuitry.CircuitInformation{}, err
+		}
+		b = b[n:]
+	}
+	return ci, nil
+}
+
+func setField(ci *circuitry.CircuitInformation, number protowire.Number, value uint64) error {
+	switch number {
+	case fieldState:
+		if value > math.MaxUint32 {
+			return fmt.Errorf("%w %d", circuitry.ErrInvalidCircuitState, value)
+		}
+		ci.State = circuitry.CircuitState(value)
+	case fieldGeneration:
+		ci.Generation = value
+	case fieldConsecutiveFailures:
+		ci.ConsecutiveFailures = value
+	case fieldConsecutiveSuccesses:
+		ci.ConsecutiveSuccesses = value
+	case fieldTotal:
+		ci.Total = value
+	case fieldTotalFailures:
+		ci.TotalFailures = value
+	case fieldTotalSuccesses:
+		ci.TotalSuccesses = value
+	}
+	return nil
+}
+
+func unmarshalTimestamp(b []byte) (time.Time, error) {
+	var seconds, nanos uint64
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case number == fieldSeconds && typ == protowire.VarintType:
+			seconds, n = protowire.ConsumeVarint(b)
+		case number == fieldNanos && typ == protowire.VarintType:
+			nanos, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(number, typ, b)
+		}
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if nanos >= uint64(time.Second) {
+		return time.Time{}, fmt.Errorf("%w: %d nanoseconds", errInvalidTimestamp, nanos)
+	}
+	return expiry(time.Unix(int64(seconds), int64(nanos))), nil
+}
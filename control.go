@@ -0,0 +1,115 @@
+package circuitry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+// Information returns the [CircuitInformation] for the named circuit. Like
+// the other methods for operating on circuits directly, the name is used as
+// it is stored and the NameFn is not applied.
+func (cbf *CircuitBreakerFactory) Information(ctx context.Context, name string) (CircuitInformation, error) {
+	return cbf.breakerNamed(name).Information(ctx)
+}
+
+// ForceState moves the named circuit to the given state as if it had
+// transitioned on its own, e.g., forcing a circuit open starts a new
+// generation and waits AllowAfter before allowing requests. The transition
+// is delivered to subscribers and the StateChangeCallback.
+func (cbf *CircuitBreakerFactory) ForceState(ctx context.Context, name string, state CircuitState) (CircuitInformation, error) {
+	if state > CircuitHalfOpen {
+		return CircuitInformation{}, fmt.Errorf("%w: %d", ErrInvalidCircuitState, state)
+	}
+	return cbf.breakerNamed(name).update(ctx, func(cb *circuitBreaker, now time.Time) {
+		cb.setState(ctx, state, now, nil)
+	})
+}
+
+// ResetCounts zeroes the counts of the named circuit without changing its
+// state, generation, or expiry
+func (cbf *CircuitBreakerFactory) ResetCounts(ctx context.Context, name string) (CircuitInformation, error) {
+	return cbf.breakerNamed(name).update(ctx, func(cb *circuitBreaker, _ time.Time) {
+		cb.counts.Reset()
+	})
+}
+
+// Reset closes the circuit and starts a new generation with zeroed counts.
+// Leaving a state other than closed is delivered to subscribers and the
+// StateChangeCallback like any other transition.
+func (cb *circuitBreaker) Reset(ctx context.Context) (err error) {
+	if cb.lock != nil {
+		return ErrCircuitBreakerAlreadyStarted
+	}
+	ctx, span := cb.startSpan(ctx, "circuitry.Reset")
+	defer func() { endSpan(span, err) }()
+	_, err = cb.update(ctx, func(cb *circuitBreaker, now time.Time) {
+		if cb.state == CircuitClosed {
+			cb.newGeneration(now)
+			return
+		}
+		cb.setState(ctx, CircuitClosed, now, nil)
+	})
+	return err
+}
+
+// Delete removes the circuit from the storage backend while holding its lock
+// so that the next use of the circuit starts from nothing
+func (cb *circuitBreaker) Delete(ctx context.Context) (err error) {
+	if cb.lock != nil {
+		return ErrCircuitBreakerAlreadyStarted
+	}
+	deleter, ok := cb.storage.(Deleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+	ctx, span := cb.startSpan(ctx, "circuitry.Delete")
+	defer func() { endSpan(span, err) }()
+	if err := cb.lockRemoteState(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := cb.unlockRemoteState(ctx); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+	}()
+	spanCtx, backendSpan := cb.startSpan(ctx, "circuitry.backend.Delete")
+	start := time.Now()
+	err = deleter.Delete(spanCtx, cb.name)
+	cb.metrics.BackendOperation(cb.name, metrics.OpDelete, time.Since(start), err)
+	endSpan(backendSpan, err)
+	if err != nil {
+		return err
+	}
+	cb.fromCircuitInformation(CircuitInformation{})
+	return nil
+}
+
+// update applies the change to the circuit while holding the backend lock
+// and stores the result
+func (cb *circuitBreaker) update(ctx context.Context, change func(*circuitBreaker, time.Time)) (info CircuitInformation, err error) {
+	if err := cb.lockRemoteState(ctx); err != nil {
+		return CircuitInformation{}, err
+	}
+	cb.pending = nil
+	var published []TransitionEvent
+	defer func() { cb.publishTransitions(ctx, published) }()
+	defer func() {
+		if releaseErr := cb.unlockRemoteState(ctx); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+	}()
+	if err := cb.refreshFromRemoteState(ctx); err != nil {
+		return CircuitInformation{}, err
+	}
+	now := time.Now()
+	change(cb, now)
+	if err := cb.updateRemoteState(ctx, now); err != nil {
+		return CircuitInformation{}, err
+	}
+	published = cb.pending
+	return cb.toCircuitInformation(), nil
+}
@@ -0,0 +1,248 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+)
+
+func TestForceState(t *testing.T) {
+	ctx := context.Background()
+	var transitions []string
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithAllowAfter(time.Hour),
+		circuitry.WithFailureCountThreshold(10),
+		circuitry.WithNameFunc(func(circuit string, _ map[string]any) string { return "prefix-" + circuit }),
+		circuitry.WithStateChangeCallback(func(_ string, _ map[string]any, from, to circuitry.CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	breaker := factory.BreakerFor("TestForceState", map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+
+	info, err := factory.ForceState(ctx, "prefix-TestForceState", circuitry.CircuitOpen)
+	if err != nil {
+		t.Fatalf("expected to force the circuit open; got %v", err)
+	}
+	if info.State != circuitry.CircuitOpen || info.Generation != 1 || info.TotalFailures != 0 {
+		t.Fatalf("expected an open circuit in a new generation; got %+v", info)
+	}
+	if !info.ExpiresAfter.After(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("expected the circuit to allow requests after AllowAfter; got %v", info.ExpiresAfter)
+	}
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to be open; got %v", err)
+	}
+	event := receiveEvent(t, sub)
+	if event.Name != "prefix-TestForceState" || event.To != circuitry.CircuitOpen || event.Information.TotalFailures != 1 {
+		t.Fatalf("expected a transition to open with the previous counts; got %+v", event)
+	}
+
+	info, err = factory.ForceState(ctx, "prefix-TestForceState", circuitry.CircuitClosed)
+	if err != nil {
+		t.Fatalf("expected to force the circuit closed; got %v", err)
+	}
+	if info.State != circuitry.CircuitClosed || info.Generation != 2 {
+		t.Fatalf("expected a closed circuit in a new generation; got %+v", info)
+	}
+	if len(transitions) != 2 || transitions[0] != "closed->open" || transitions[1] != "open->closed" {
+		t.Fatalf("expected the StateChangeCallback to be called; got %v", transitions)
+	}
+	stored, err := factory.Information(ctx, "prefix-TestForceState")
+	if err != nil || stored.State != circuitry.CircuitClosed {
+		t.Fatalf("expected the stored circuit to be closed; got %+v (err = %v)", stored, err)
+	}
+}
+
+func TestForceStateInvalid(t *testing.T) {
+	factory := newFactory(backends.WithInMemoryBackend())
+	if _, err := factory.ForceState(context.Background(), "invalid", circuitry.CircuitState(7)); !errors.Is(err, circuitry.ErrInvalidCircuitState) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrInvalidCircuitState, err)
+	}
+}
+
+func TestResetCounts(t *testing.T) {
+	ctx := context.Background()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	breaker := factory.BreakerFor("TestResetCounts", map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	before, err := factory.Information(ctx, "TestResetCounts")
+	if err != nil {
+		t.Fatalf("expected to get the circuit information; got %v", err)
+	}
+	info, err := factory.ResetCounts(ctx, "TestResetCounts")
+	if err != nil {
+		t.Fatalf("expected to reset counts; got %v", err)
+	}
+	expected := circuitry.CircuitInformation{State: before.State, Generation: before.Generation, ExpiresAfter: before.ExpiresAfter}
+	if info != expected {
+		t.Fatalf("expected %+v; got %+v", expected, info)
+	}
+}
+
+func TestControlBackendErrors(t *testing.T) {
+	backendErr := errors.New("backend error")
+	testCases := map[string]struct {
+		backend circuitrytest.ErroringInMemoryBackend
+	}{
+		"lock":     {circuitrytest.ErroringInMemoryBackend{LockError: backendErr}},
+		"retrieve": {circuitrytest.ErroringInMemoryBackend{RetrieveError: backendErr}},
+		"store":    {circuitrytest.ErroringInMemoryBackend{StoreError: backendErr}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			factory := newFactory(circuitry.WithStorageBackend(tc.backend))
+			if _, err := factory.ResetCounts(context.Background(), name); !errors.Is(err, backendErr) {
+				t.Fatalf("expected err = %v; got %v", backendErr, err)
+			}
+			if _, err := factory.ForceState(context.Background(), name, circuitry.CircuitOpen); !errors.Is(err, backendErr) {
+				t.Fatalf("expected err = %v; got %v", backendErr, err)
+			}
+		})
+	}
+}
+
+func TestBreakerReset(t *testing.T) {
+	testCases := map[string]struct {
+		state              circuitry.CircuitState
+		expectedTransition bool
+	}{
+		"closed":    {circuitry.CircuitClosed, false},
+		"open":      {circuitry.CircuitOpen, true},
+		"half-open": {circuitry.CircuitHalfOpen, true},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			backend := backends.NewInMemoryBackend()
+			stored := circuitry.CircuitInformation{State: tc.state, Generation: 3, Total: 4, TotalFailures: 4, ConsecutiveFailures: 4, ExpiresAfter: time.Now().Add(time.Hour)}
+			_ = backend.Store(ctx, "TestBreakerReset", stored)
+			factory := newFactory(circuitry.WithStorageBackend(backend))
+			sub := factory.Subscribe(ctx, nil)
+			breaker := factory.BreakerFor("TestBreakerReset", map[string]any{})
+
+			if err := breaker.Reset(ctx); err != nil {
+				t.Fatalf("expected to reset the breaker; got %v", err)
+			}
+			info, _ := backend.Retrieve(ctx, "TestBreakerReset")
+			expected := circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 4}
+			if info != expected {
+				t.Fatalf("expected %+v; got %+v", expected, info)
+			}
+			select {
+			case event := <-sub.C:
+				if !tc.expectedTransition || event.From != tc.state || event.To != circuitry.CircuitClosed {
+					t.Fatalf("expected no transition other than %s->closed; got %+v", tc.state, event)
+				}
+			default:
+				if tc.expectedTransition {
+					t.Fatalf("expected a transition from %s to closed", tc.state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerResetAfterRejectedStart(t *testing.T) {
+	ctx := context.Background()
+	backend := &broadcastBackend{StorageBackender: backends.NewInMemoryBackend()}
+	stored := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 1, Total: 1, TotalFailures: 1, ConsecutiveFailures: 1, ExpiresAfter: time.Now().Add(-time.Second)}
+	_ = backend.Store(ctx, "TestBreakerResetAfterRejectedStart", stored)
+	breaker := newFactory(circuitry.WithStorageBackend(backend), circuitry.WithCloseThreshold(1)).BreakerFor("TestBreakerResetAfterRejectedStart", map[string]any{})
+	if err := breaker.Start(ctx); !errors.Is(err, circuitry.ErrTooManyRequests) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrTooManyRequests, err)
+	}
+
+	if err := breaker.Reset(ctx); err != nil {
+		t.Fatalf("expected to reset the breaker; got %v", err)
+	}
+	if transitions := backend.transitions(); len(transitions) != 2 || transitions[0] != "open->half-open" || transitions[1] != "half-open->closed" {
+		t.Fatalf("expected only the transitions stored by Reset to be published; got %v", transitions)
+	}
+}
+
+func TestBreakerDelete(t *testing.T) {
+	ctx := context.Background()
+	backend := backends.NewInMemoryBackend()
+	factory := newFactory(circuitry.WithStorageBackend(backend), circuitry.WithFailureCountThreshold(0))
+	breaker := factory.BreakerFor("TestBreakerDelete", map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+
+	if err := breaker.Delete(ctx); err != nil {
+		t.Fatalf("expected to delete the breaker; got %v", err)
+	}
+	for entry := range backend.(circuitry.Lister).List(ctx, "") {
+		t.Fatalf("expected no circuits to be stored; got %+v", entry)
+	}
+	info, err := breaker.Information(ctx)
+	if err != nil || info != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected an empty circuit; got %+v (err = %v)", info, err)
+	}
+}
+
+type deletingBackend struct {
+	circuitrytest.ErroringInMemoryBackend
+	DeleteError error
+}
+
+func (b deletingBackend) Delete(context.Context, string) error {
+	return b.DeleteError
+}
+
+func TestBreakerResetAndDeleteErrors(t *testing.T) {
+	backendErr := errors.New("backend error")
+	testCases := map[string]struct {
+		backend       circuitry.StorageBackender
+		expectedReset error
+		expectedDel   error
+	}{
+		"lock":          {deletingBackend{ErroringInMemoryBackend: circuitrytest.ErroringInMemoryBackend{LockError: backendErr}}, backendErr, backendErr},
+		"delete":        {deletingBackend{DeleteError: backendErr}, nil, backendErr},
+		"not supported": {circuitrytest.ErroringInMemoryBackend{StoreError: backendErr}, backendErr, circuitry.ErrDeleteNotSupported},
+		"succeeds":      {deletingBackend{}, nil, nil},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			breaker := newFactory(circuitry.WithStorageBackend(tc.backend)).BreakerFor(name, map[string]any{})
+			if err := breaker.Reset(context.Background()); !errors.Is(err, tc.expectedReset) {
+				t.Fatalf("expected err = %v; got %v", tc.expectedReset, err)
+			}
+			if err := breaker.Delete(context.Background()); !errors.Is(err, tc.expectedDel) {
+				t.Fatalf("expected err = %v; got %v", tc.expectedDel, err)
+			}
+		})
+	}
+}
+
+func TestBreakerResetAndDeleteWhileStarted(t *testing.T) {
+	ctx := context.Background()
+	breaker := newFactory(backends.WithInMemoryBackend()).BreakerFor("TestBreakerResetAndDeleteWhileStarted", map[string]any{})
+	if err := breaker.Start(ctx); err != nil {
+		t.Fatalf("expected to start the breaker; got %v", err)
+	}
+	defer func() { _ = breaker.End(ctx, nil) }()
+	if err := breaker.Reset(ctx); !errors.Is(err, circuitry.ErrCircuitBreakerAlreadyStarted) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrCircuitBreakerAlreadyStarted, err)
+	}
+	if err := breaker.Delete(ctx); !errors.Is(err, circuitry.ErrCircuitBreakerAlreadyStarted) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrCircuitBreakerAlreadyStarted, err)
+	}
+}
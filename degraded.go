@@ -0,0 +1,328 @@
+package circuitry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+// DefaultBackendProbeInterval is how often an unavailable storage backend is
+// tried again when the BackendProbeInterval setting is not configured
+const DefaultBackendProbeInterval = 5 * time.Second
+
+// BackendFailurePolicy decides what [CircuitBreaker]s do when their storage
+// backend is unavailable
+type BackendFailurePolicy int
+
+const (
+	// FailClosed returns the storage backend's errors so no work is started
+	// while it is unavailable. This is the default.
+	FailClosed BackendFailurePolicy = iota
+	// FailOpen allows all work while the storage backend is unavailable
+	// without recording its outcome
+	FailOpen
+	// FallbackInMemory keeps the information about each circuit in this
+	// process while the storage backend is unavailable. Once the backend is
+	// available again, circuits that tripped in the meantime are stored as
+	// open and everything else kept in memory is discarded in favor of what
+	// the backend has.
+	FallbackInMemory
+)
+
+func (p BackendFailurePolicy) String() string {
+	switch p {
+	case FailClosed:
+		return "fail-closed"
+	case FailOpen:
+		return "fail-open"
+	case FallbackInMemory:
+		return "fallback-in-memory"
+	default:
+		return "unknown-policy"
+	}
+}
+
+type noLock struct{}
+
+func (noLock) Lock()   {}
+func (noLock) Unlock() {}
+
+// heldLock wraps a lock that has already been locked
+type heldLock struct {
+	sync.Locker
+}
+
+func (heldLock) Lock() {}
+
+// heldLease wraps a Lease that has already been locked
+type heldLease struct {
+	Lease
+}
+
+func (heldLease) Lock() {}
+
+// localCircuit is locked by CircuitBreakers while degraded. Its information
+// is guarded by the degradableBackend's mutex instead because a
+// CircuitBreaker that obtained the backend's lock before degraded mode began
+// does not hold it.
+type localCircuit struct {
+	sync.Mutex
+	information CircuitInformation
+}
+
+// degradableBackend wraps the configured StorageBackender and, when it
+// returns an error, stops relying on it according to the BackendFailurePolicy
+// until a probe succeeds. Only Lock probes the backend because it is the only
+// operation that can safely reconcile a circuit with it.
+type degradableBackend struct {
+	remote        StorageBackender
+	policy        BackendFailurePolicy
+	probeInterval time.Duration
+	logger        log.Logger
+	metrics       metrics.Sink
+
+	mu        sync.Mutex
+	degraded  bool
+	since     time.Time
+	nextProbe time.Time
+	probing   bool
+	local     map[string]*localCircuit
+}
+
+func newDegradableBackend(s *FactorySettings) *degradableBackend {
+	interval := s.BackendProbeInterval
+	if interval <= 0 {
+		interval = DefaultBackendProbeInterval
+	}
+	return &degradableBackend{
+		remote:        s.StorageBackend,
+		policy:        s.BackendFailurePolicy,
+		probeInterval: interval,
+		logger:        s.logger(),
+		metrics:       s.metricsSink(),
+		local:         make(map[string]*localCircuit),
+	}
+}
+
+func (b *degradableBackend) isDegraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.degraded
+}
+
+// status reports whether the backend is degraded and, if so, whether the
+// caller should probe it. Only one caller probes at a time.
+func (b *degradableBackend) status(now time.Time) (degraded, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.degraded {
+		return false, false
+	}
+	if b.probing || now.Before(b.nextProbe) {
+		return true, false
+	}
+	b.probing = true
+	b.nextProbe = now.Add(b.probeInterval)
+	return true, true
+}
+
+// degrade enters degraded mode unless the error was caused by the caller's
+// context ending and reports whether the caller should fall back
+func (b *degradableBackend) degrade(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	b.mu.Lock()
+	if b.degraded {
+		b.mu.Unlock()
+		return true
+	}
+	b.degraded = true
+	b.since = time.Now()
+	b.nextProbe = b.since.Add(b.probeInterval)
+	b.mu.Unlock()
+	b.logger.WithError(err).WithField("policy", b.policy.String()).Warn("storage backend unavailable, entering degraded mode")
+	b.metrics.BackendDegraded(true)
+	return true
+}
+
+func (b *degradableBackend) localCircuit(name string) *localCircuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit, ok := b.local[name]
+	if !ok {
+		circuit = &localCircuit{}
+		b.local[name] = circuit
+	}
+	return circuit
+}
+
+func (b *degradableBackend) fallbackLock(name string) sync.Locker {
+	if b.policy == FailOpen {
+		return noLock{}
+	}
+	return b.localCircuit(name)
+}
+
+// Lock obtains the lock from the storage backend. Failing to obtain a lock
+// may only mean another process holds it, so the backend is only considered
+// unavailable if it cannot retrieve the circuit either.
+func (b *degradableBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	degraded, probe := b.status(time.Now())
+	if degraded && !probe {
+		return b.fallbackLock(name), nil
+	}
+	if probe {
+		defer func() {
+			b.mu.Lock()
+			b.probing = false
+			b.mu.Unlock()
+		}()
+	}
+	lock, err := b.remote.Lock(ctx, name)
+	if err != nil {
+		if degraded {
+			return b.fallbackLock(name), nil
+		}
+		if _, retrieveErr := b.remote.Retrieve(ctx, name); retrieveErr == nil || !b.degrade(ctx, err) {
+			return nil, err
+		}
+		return b.fallbackLock(name), nil
+	}
+	if !degraded {
+		return lock, nil
+	}
+	// The lock is needed while reconciling so it is handed to the caller
+	// already locked
+	lock.Lock()
+	if err := b.recover(ctx, name); err != nil {
+		lock.Unlock()
+		return b.fallbackLock(name), nil
+	}
+	if lease, ok := lock.(Lease); ok {
+		return heldLease{lease}, nil
+	}
+	return heldLock{lock}, nil
+}
+
+// Retrieve returns the information from the storage backend or, while
+// degraded, from memory
+func (b *degradableBackend) Retrieve(ctx context.Context, name string) (CircuitInformation, error) {
+	if !b.isDegraded() {
+		info, err := b.remote.Retrieve(ctx, name)
+		if err == nil || !b.degrade(ctx, err) {
+			return info, err
+		}
+	}
+	if b.policy == FailOpen {
+		return CircuitInformation{}, nil
+	}
+	circuit := b.localCircuit(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return circuit.information, nil
+}
+
+// Store saves the information in the storage backend or, while degraded, in
+// memory
+func (b *degradableBackend) Store(ctx context.Context, name string, ci CircuitInformation) error {
+	if !b.isDegraded() {
+		err := b.remote.Store(ctx, name, ci)
+		if err == nil || !b.degrade(ctx, err) {
+			return err
+		}
+	}
+	if b.policy == FallbackInMemory {
+		circuit := b.localCircuit(name)
+		b.mu.Lock()
+		circuit.information = ci
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// StoreFenced saves the information with the storage backend's fencing if it
+// implements FencedStorer and otherwise behaves like Store
+func (b *degradableBackend) StoreFenced(ctx context.Context, name string, ci CircuitInformation, token uint64) error {
+	fenced, ok := b.remote.(FencedStorer)
+	if !ok || b.isDegraded() {
+		return b.Store(ctx, name, ci)
+	}
+	err := fenced.StoreFenced(ctx, name, ci, token)
+	if err == nil || errors.Is(err, ErrLockLost) || !b.degrade(ctx, err) {
+		return err
+	}
+	return b.Store(ctx, name, ci)
+}
+
+// Delete removes the circuit from memory and from the storage backend if it
+// implements Deleter
+func (b *degradableBackend) Delete(ctx context.Context, name string) error {
+	deleter, ok := b.remote.(Deleter)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+	b.mu.Lock()
+	delete(b.local, name)
+	b.mu.Unlock()
+	return deleter.Delete(ctx, name)
+}
+
+// recover reconciles the circuits kept in memory with the storage backend
+// and leaves degraded mode. The caller already holds the backend's lock for
+// the named circuit.
+func (b *degradableBackend) recover(ctx context.Context, locked string) error {
+	b.mu.Lock()
+	local := make(map[string]CircuitInformation, len(b.local))
+	for name, circuit := range b.local {
+		local[name] = circuit.information
+	}
+	b.mu.Unlock()
+	for name, info := range local {
+		if err := b.reconcile(ctx, name, info, name == locked); err != nil {
+			b.logger.WithError(err).WithField("circuit_name", name).Warn("could not reconcile circuit with storage backend, remaining in degraded mode")
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.degraded = false
+	degradedFor := time.Since(b.since)
+	b.local = make(map[string]*localCircuit)
+	b.mu.Unlock()
+	b.logger.WithField("degraded_for", degradedFor.String()).Info("storage backend available, leaving degraded mode")
+	b.metrics.BackendDegraded(false)
+	return nil
+}
+
+// reconcile stores a circuit that tripped while the storage backend was
+// unavailable unless the backend already has it open
+func (b *degradableBackend) reconcile(ctx context.Context, name string, info CircuitInformation, locked bool) error {
+	if info.State != CircuitOpen {
+		return nil
+	}
+	if !locked {
+		lock, err := b.remote.Lock(ctx, name)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	remote, err := b.remote.Retrieve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if remote.State == CircuitOpen {
+		return nil
+	}
+	info.Generation = remote.Generation + 1
+	return b.remote.Store(ctx, name, info)
+}
+
+var _ StorageBackender = (*degradableBackend)(nil)
+var _ Deleter = (*degradableBackend)(nil)
+var _ FencedStorer = (*degradableBackend)(nil)
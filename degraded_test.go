@@ -0,0 +1,383 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/log"
+)
+
+var errBackendDown = errors.New("backend down")
+
+// flakyBackend fails every operation while down and can refuse locks as if
+// another process held them
+type flakyBackend struct {
+	circuitry.StorageBackender
+	down         atomic.Bool
+	locked       atomic.Bool
+	held         sync.Map
+	storeDown    atomic.Bool
+	retrieveDown atomic.Bool
+	stores       atomic.Int64
+}
+
+func newFlakyBackend() *flakyBackend {
+	return &flakyBackend{StorageBackender: backends.NewInMemoryBackend()}
+}
+
+func (b *flakyBackend) Lock(ctx context.Context, name string) (sync.Locker, error) {
+	if b.down.Load() {
+		return nil, errBackendDown
+	}
+	if _, held := b.held.Load(name); held || b.locked.Load() {
+		return nil, errors.New("lock held")
+	}
+	return b.StorageBackender.Lock(ctx, name)
+}
+
+func (b *flakyBackend) Retrieve(ctx context.Context, name string) (circuitry.CircuitInformation, error) {
+	if b.down.Load() || b.retrieveDown.Load() {
+		return circuitry.CircuitInformation{}, errBackendDown
+	}
+	return b.StorageBackender.Retrieve(ctx, name)
+}
+
+func (b *flakyBackend) Store(ctx context.Context, name string, ci circuitry.CircuitInformation) error {
+	if b.down.Load() || b.storeDown.Load() {
+		return errBackendDown
+	}
+	b.stores.Add(1)
+	return b.StorageBackender.Store(ctx, name, ci)
+}
+
+func (b *flakyBackend) Delete(ctx context.Context, name string) error {
+	if b.down.Load() {
+		return errBackendDown
+	}
+	return b.StorageBackender.(circuitry.Deleter).Delete(ctx, name)
+}
+
+func newDegradableFactory(backend circuitry.StorageBackender, policy circuitry.BackendFailurePolicy, sink *recordingSink) *circuitry.CircuitBreakerFactory {
+	return newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithBackendFailurePolicy(policy),
+		circuitry.WithBackendProbeInterval(time.Millisecond),
+		circuitry.WithMetricsSink(sink),
+		circuitry.WithLogger(&log.NoOp{}),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+}
+
+func TestBackendFailurePolicyString(t *testing.T) {
+	testCases := map[string]struct {
+		policy   circuitry.BackendFailurePolicy
+		expected string
+	}{
+		"FailClosed":       {circuitry.FailClosed, "fail-closed"},
+		"FailOpen":         {circuitry.FailOpen, "fail-open"},
+		"FallbackInMemory": {circuitry.FallbackInMemory, "fallback-in-memory"},
+		"unknown":          {circuitry.FallbackInMemory + 1, "unknown-policy"},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if actual := tc.policy.String(); actual != tc.expected {
+				t.Fatalf("expected %q; got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFailClosedReturnsBackendErrors(t *testing.T) {
+	backend := newFlakyBackend()
+	backend.down.Store(true)
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FailClosed, sink)
+	breaker := factory.BreakerFor("TestFailClosedReturnsBackendErrors", map[string]any{})
+	if _, _, err := breaker.Execute(context.Background(), func() (any, error) { return nil, nil }); !errors.Is(err, errBackendDown) {
+		t.Fatalf("expected err = %v; got %v", errBackendDown, err)
+	}
+	if len(sink.degraded) != 0 {
+		t.Fatalf("expected degraded mode not to be entered; got %v", sink.degraded)
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FailOpen, sink)
+	breaker := factory.BreakerFor("TestFailOpen", map[string]any{})
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+
+	backend.down.Store(true)
+	for range 3 {
+		ran := false
+		_, _, err := breaker.Execute(ctx, func() (any, error) { ran = true; return nil, nil })
+		if err != nil || !ran {
+			t.Fatalf("expected the work to run while the backend is down; got err = %v", err)
+		}
+	}
+	if state, err := breaker.State(ctx); err != nil || state != circuitry.CircuitClosed {
+		t.Fatalf("expected the breaker to report closed; got %s (err = %v)", state, err)
+	}
+	if _, _, err := breaker.Execute(ctx, alwaysErrorFn); err != nil {
+		t.Fatalf("expected failures not to be recorded; got %v", err)
+	}
+	if backend.stores.Load() != 0 {
+		t.Fatalf("expected nothing to be stored; got %d stores", backend.stores.Load())
+	}
+
+	backend.down.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := breaker.Execute(ctx, alwaysErrorFn); err != nil {
+		t.Fatalf("expected the work to run once the backend is back; got %v", err)
+	}
+	if info, _ := backend.Retrieve(ctx, "TestFailOpen"); info.State != circuitry.CircuitOpen {
+		t.Fatalf("expected the failure to be stored once the backend is back; got %+v", info)
+	}
+	if len(sink.degraded) != 2 || !sink.degraded[0] || sink.degraded[1] {
+		t.Fatalf("expected to enter and leave degraded mode; got %v", sink.degraded)
+	}
+}
+
+func TestFallbackInMemory(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	_ = backend.Store(ctx, "closed-remotely", circuitry.CircuitInformation{State: circuitry.CircuitClosed, Generation: 4})
+	_ = backend.Store(ctx, "open-remotely", circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 7})
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	neverErrorFn := func() (any, error) { return nil, nil }
+
+	backend.down.Store(true)
+	for _, name := range []string{"closed-remotely", "open-remotely"} {
+		breaker := factory.BreakerFor(name, map[string]any{})
+		if _, _, err := breaker.Execute(ctx, alwaysErrorFn); err != nil {
+			t.Fatalf("expected %s to run in memory; got %v", name, err)
+		}
+		if _, _, err := breaker.Execute(ctx, neverErrorFn); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+			t.Fatalf("expected %s to trip in memory; got %v", name, err)
+		}
+	}
+	untripped := factory.BreakerFor("untripped", map[string]any{})
+	if _, _, err := untripped.Execute(ctx, neverErrorFn); err != nil {
+		t.Fatalf("expected untripped to run in memory; got %v", err)
+	}
+
+	backend.down.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	breaker := factory.BreakerFor("closed-remotely", map[string]any{})
+	if _, _, err := breaker.Execute(ctx, neverErrorFn); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the circuit tripped in memory to be open; got %v", err)
+	}
+	info, _ := backend.Retrieve(ctx, "closed-remotely")
+	if info.State != circuitry.CircuitOpen || info.Generation != 5 {
+		t.Fatalf("expected the trip to be stored in the next generation; got %+v", info)
+	}
+	info, _ = backend.Retrieve(ctx, "open-remotely")
+	if info.State != circuitry.CircuitOpen || info.Generation != 7 {
+		t.Fatalf("expected the circuit open in the backend to be kept; got %+v", info)
+	}
+	info, _ = backend.Retrieve(ctx, "untripped")
+	if info != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected counts kept in memory to be discarded; got %+v", info)
+	}
+	if len(sink.degraded) != 2 || !sink.degraded[0] || sink.degraded[1] {
+		t.Fatalf("expected to enter and leave degraded mode; got %v", sink.degraded)
+	}
+}
+
+func TestFallbackInMemoryStoreFailure(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	breaker := factory.BreakerFor("TestFallbackInMemoryStoreFailure", map[string]any{})
+
+	backend.storeDown.Store(true)
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") }); err != nil {
+		t.Fatalf("expected the outcome to be stored in memory; got %v", err)
+	}
+	if state, err := breaker.State(ctx); err != nil || state != circuitry.CircuitOpen {
+		t.Fatalf("expected the breaker to be open in memory; got %s (err = %v)", state, err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to remain open in memory; got %v", err)
+	}
+	if len(sink.degraded) != 1 {
+		t.Fatalf("expected to remain in degraded mode when reconciling fails; got %v", sink.degraded)
+	}
+
+	backend.storeDown.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to be open; got %v", err)
+	}
+	if info, _ := backend.Retrieve(ctx, "TestFallbackInMemoryStoreFailure"); info.State != circuitry.CircuitOpen {
+		t.Fatalf("expected the trip to be stored; got %+v", info)
+	}
+	if len(sink.degraded) != 2 || sink.degraded[1] {
+		t.Fatalf("expected to leave degraded mode; got %v", sink.degraded)
+	}
+}
+
+func TestFallbackInMemoryReconcileOtherCircuits(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	tripped := factory.BreakerFor("tripped", map[string]any{})
+	other := factory.BreakerFor("other", map[string]any{})
+
+	backend.down.Store(true)
+	_, _, _ = tripped.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	backend.down.Store(false)
+	backend.locked.Store(true)
+	time.Sleep(2 * time.Millisecond)
+	// Locks being held by another process does not end degraded mode
+	if _, _, err := other.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected other to run in memory; got %v", err)
+	}
+	if len(sink.degraded) != 1 {
+		t.Fatalf("expected to remain in degraded mode; got %v", sink.degraded)
+	}
+
+	backend.locked.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := other.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected other to run; got %v", err)
+	}
+	if info, _ := backend.Retrieve(ctx, "tripped"); info.State != circuitry.CircuitOpen {
+		t.Fatalf("expected tripped to be reconciled; got %+v", info)
+	}
+	if len(sink.degraded) != 2 || sink.degraded[1] {
+		t.Fatalf("expected to leave degraded mode; got %v", sink.degraded)
+	}
+}
+
+func TestFallbackInMemoryReconcileLockFailure(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	tripped := factory.BreakerFor("tripped", map[string]any{})
+	other := factory.BreakerFor("other", map[string]any{})
+
+	backend.down.Store(true)
+	_, _, _ = tripped.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	backend.down.Store(false)
+	backend.held.Store("tripped", true)
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := other.Execute(ctx, func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("expected other to run in memory; got %v", err)
+	}
+	if len(sink.degraded) != 1 {
+		t.Fatalf("expected to remain in degraded mode while tripped cannot be reconciled; got %v", sink.degraded)
+	}
+	if info, _ := backend.Retrieve(ctx, "other"); info != (circuitry.CircuitInformation{}) {
+		t.Fatalf("expected other to only be stored in memory; got %+v", info)
+	}
+}
+
+func TestFallbackInMemoryReconcileRetrieveFailure(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	breaker := factory.BreakerFor("TestFallbackInMemoryReconcileRetrieveFailure", map[string]any{})
+
+	// The lock is obtained but the circuit cannot be retrieved
+	backend.retrieveDown.Store(true)
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	time.Sleep(2 * time.Millisecond)
+	if _, _, err := breaker.Execute(ctx, func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected the breaker to be open in memory; got %v", err)
+	}
+	if len(sink.degraded) != 1 || backend.stores.Load() != 0 {
+		t.Fatalf("expected to remain in degraded mode without storing anything; got %v and %d stores", sink.degraded, backend.stores.Load())
+	}
+}
+
+func TestDefaultBackendProbeInterval(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	sink := newRecordingSink()
+	factory := newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithBackendFailurePolicy(circuitry.FailOpen),
+		circuitry.WithMetricsSink(sink),
+	)
+	breaker := factory.BreakerFor("TestDefaultBackendProbeInterval", map[string]any{})
+	backend.down.Store(true)
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, nil })
+	backend.down.Store(false)
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, nil })
+	if len(sink.degraded) != 1 || backend.stores.Load() != 0 {
+		t.Fatalf("expected the backend not to be probed before %v; got %v and %d stores", circuitry.DefaultBackendProbeInterval, sink.degraded, backend.stores.Load())
+	}
+}
+
+func TestLockContentionIsNotAnOutage(t *testing.T) {
+	backend := newFlakyBackend()
+	backend.locked.Store(true)
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, sink)
+	breaker := factory.BreakerFor("TestLockContentionIsNotAnOutage", map[string]any{})
+	if err := breaker.Start(context.Background()); err == nil || errors.Is(err, errBackendDown) {
+		t.Fatalf("expected the lock error; got %v", err)
+	}
+	if len(sink.degraded) != 0 {
+		t.Fatalf("expected degraded mode not to be entered; got %v", sink.degraded)
+	}
+}
+
+func TestCanceledContextIsNotAnOutage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backend := newFlakyBackend()
+	backend.down.Store(true)
+	sink := newRecordingSink()
+	factory := newDegradableFactory(backend, circuitry.FailOpen, sink)
+	breaker := factory.BreakerFor("TestCanceledContextIsNotAnOutage", map[string]any{})
+	if _, err := breaker.Information(ctx); !errors.Is(err, errBackendDown) {
+		t.Fatalf("expected err = %v; got %v", errBackendDown, err)
+	}
+	if err := breaker.Start(ctx); !errors.Is(err, errBackendDown) {
+		t.Fatalf("expected err = %v; got %v", errBackendDown, err)
+	}
+	if len(sink.degraded) != 0 {
+		t.Fatalf("expected degraded mode not to be entered; got %v", sink.degraded)
+	}
+}
+
+func TestDegradableBackendDelete(t *testing.T) {
+	ctx := context.Background()
+	backend := newFlakyBackend()
+	factory := newDegradableFactory(backend, circuitry.FallbackInMemory, newRecordingSink())
+	breaker := factory.BreakerFor("TestDegradableBackendDelete", map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	if err := breaker.Delete(ctx); err != nil {
+		t.Fatalf("expected to delete the circuit; got %v", err)
+	}
+	for entry := range backend.StorageBackender.(circuitry.Lister).List(ctx, "") {
+		t.Fatalf("expected no circuits to be stored; got %+v", entry)
+	}
+
+	factory = newDegradableFactory(&struct{ circuitry.StorageBackender }{newFlakyBackend()}, circuitry.FailOpen, newRecordingSink())
+	breaker = factory.BreakerFor("TestDegradableBackendDelete", map[string]any{})
+	if err := breaker.Delete(ctx); !errors.Is(err, circuitry.ErrDeleteNotSupported) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrDeleteNotSupported, err)
+	}
+}
@@ -20,7 +20,7 @@
 // example, circuitry provides interfaces for structured logging libraries as
 // well as implementations for logrus and slog. Likewise, it allows for
 // metrics to be gathered from it by providing interfaces for users to specify
-// their sink. Finally, it has a backend interface and provides
+// their sink as well as an implementation for Prometheus. Finally, it has a backend interface and provides
 // implementations in Redis and DynamoDB for production usage and for
 // reference.
 //
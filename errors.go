@@ -28,6 +28,22 @@ const (
 	// ErrProvisioningStorageBackend is returned when a StorageBackend
 	// encounters an issue during it's creation that is not a setting conflict
 	ErrProvisioningStorageBackend = constError("could not provision storage backend")
+	// ErrWatchNotSupported is returned when the StorageBackend does not
+	// implement TransitionWatcher
+	ErrWatchNotSupported = constError("storage backend does not support watching state transitions")
+	// ErrListingNotSupported is returned when the StorageBackend does not
+	// implement Lister
+	ErrListingNotSupported = constError("storage backend does not support listing circuits")
+	// ErrInvalidCircuitState is returned when a CircuitState is not one of
+	// CircuitClosed, CircuitOpen, or CircuitHalfOpen
+	ErrInvalidCircuitState = constError("invalid circuit state")
+	// ErrDeleteNotSupported is returned when the StorageBackend does not
+	// implement Deleter
+	ErrDeleteNotSupported = constError("storage backend does not support deleting circuits")
+	// ErrLockLost is returned when a CircuitBreaker's lease on the storage
+	// backend's lock expired or was taken over before its information could
+	// be stored
+	ErrLockLost = constError("lock on circuit was lost before its information could be stored")
 )
 
 // SettingsConflictError contains the FactorySettingsName in the error and
@@ -117,6 +133,12 @@ var (
 	// ErrLoggerAlreadySet is returned when the Logger
 	// setting has already been configured
 	ErrLoggerAlreadySet = newSettingsConflictError("Logger")
+	// ErrMetricsSinkAlreadySet is returned when the MetricsSink
+	// setting has already been configured
+	ErrMetricsSinkAlreadySet = newSettingsConflictError("MetricsSink")
+	// ErrTracerProviderAlreadySet is returned when the TracerProvider
+	// setting has already been configured
+	ErrTracerProviderAlreadySet = newSettingsConflictError("TracerProvider")
 )
 
 // IsExpectedErrorer defines an interface that one can use when defining their
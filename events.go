@@ -0,0 +1,240 @@
+package circuitry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSubscriptionBufferSize is the number of [TransitionEvent]s buffered
+// for each [Subscription] when the SubscriptionBufferSize setting is not
+// configured
+const DefaultSubscriptionBufferSize = 64
+
+// transitionDedupeWindow is how long a transition is remembered so that the
+// same transition observed through the storage backend is not delivered to
+// subscribers twice
+const transitionDedupeWindow = 10 * time.Second
+
+// maxRememberedTransitions bounds how many transitions are remembered before
+// expired ones are pruned
+const maxRememberedTransitions = 1024
+
+// TransitionEvent describes a [CircuitBreaker] changing from one
+// [CircuitState] to another
+type TransitionEvent struct {
+	// Name of the circuit that changed state
+	Name string
+	// CircuitContext provided when the [CircuitBreaker] was created
+	CircuitContext map[string]any
+	// From is the state the circuit was in before the transition
+	From CircuitState
+	// To is the state the circuit is in after the transition
+	To CircuitState
+	// Generation of the circuit after the transition
+	Generation uint64
+	// Information describes the circuit immediately before the transition,
+	// i.e., the counts that caused it
+	Information CircuitInformation
+	// Time the transition happened
+	Time time.Time
+	// Err is the error returned by the work function that triggered the
+	// transition, if any. Only the message survives being sent through a
+	// storage backend.
+	Err error
+	// Remote is true when the event was delivered by the storage backend
+	// rather than by a [CircuitBreaker] in this process
+	Remote bool
+}
+
+type transitionEventJSON struct {
+	Name           string             `json:"name"`
+	CircuitContext map[string]any     `json:"circuit_context,omitempty"`
+	From           CircuitState       `json:"from"`
+	To             CircuitState       `json:"to"`
+	Generation     uint64             `json:"generation"`
+	Information    CircuitInformation `json:"information"`
+	Time           time.Time          `json:"time"`
+	Err            string             `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the event so a storage backend can publish it. Err is
+// encoded as its message and Remote is omitted.
+func (e TransitionEvent) MarshalJSON() ([]byte, error) {
+	wire := transitionEventJSON{
+		Name:           e.Name,
+		CircuitContext: e.CircuitContext,
+		From:           e.From,
+		To:             e.To,
+		Generation:     e.Generation,
+		Information:    e.Information,
+		Time:           e.Time,
+	}
+	if e.Err != nil {
+		wire.Err = e.Err.Error()
+	}
+	return json.Marshal(wire)
+}
+
+// UnmarshalJSON decodes an event encoded with MarshalJSON
+func (e *TransitionEvent) UnmarshalJSON(data []byte) error {
+	var wire transitionEventJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*e = TransitionEvent{
+		Name:           wire.Name,
+		CircuitContext: wire.CircuitContext,
+		From:           wire.From,
+		To:             wire.To,
+		Generation:     wire.Generation,
+		Information:    wire.Information,
+		Time:           wire.Time,
+	}
+	if wire.Err != "" {
+		e.Err = errors.New(wire.Err)
+	}
+	return nil
+}
+
+// TransitionFilter decides whether a [Subscription] receives a given
+// [TransitionEvent]. A nil TransitionFilter receives every event.
+type TransitionFilter func(TransitionEvent) bool
+
+// Subscription delivers [TransitionEvent]s asynchronously. Events are
+// buffered and, if the buffer is full, dropped rather than blocking the
+// [CircuitBreaker] that published them.
+type Subscription struct {
+	// C delivers the events and is closed when the context passed to
+	// Subscribe is done
+	C <-chan TransitionEvent
+
+	events  chan TransitionEvent
+	filter  TransitionFilter
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of events that could not be delivered because
+// the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) deliver(event TransitionEvent) {
+	if s.filter != nil && !s.filter(event) {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+type transitionKey struct {
+	name       string
+	from, to   CircuitState
+	generation uint64
+}
+
+type transitionHub struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+
+	seenMu sync.Mutex
+	seen   map[transitionKey]time.Time
+}
+
+func newTransitionHub(bufferSize int) *transitionHub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultSubscriptionBufferSize
+	}
+	return &transitionHub{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+		seen:        make(map[transitionKey]time.Time),
+	}
+}
+
+func (h *transitionHub) subscribe(ctx context.Context, filter TransitionFilter) *Subscription {
+	events := make(chan TransitionEvent, h.bufferSize)
+	sub := &Subscription{C: events, events: events, filter: filter}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subscribers, sub)
+		close(sub.events)
+		h.mu.Unlock()
+	}()
+	return sub
+}
+
+// firstSighting records the transition and reports whether it has not been
+// seen within the dedupe window
+func (h *transitionHub) firstSighting(event TransitionEvent) bool {
+	key := transitionKey{event.Name, event.From, event.To, event.Generation}
+	now := time.Now()
+	h.seenMu.Lock()
+	defer h.seenMu.Unlock()
+	if seen, ok := h.seen[key]; ok && now.Sub(seen) < transitionDedupeWindow {
+		return false
+	}
+	h.seen[key] = now
+	if len(h.seen) > maxRememberedTransitions {
+		for k, seen := range h.seen {
+			if now.Sub(seen) >= transitionDedupeWindow {
+				delete(h.seen, k)
+			}
+		}
+	}
+	return true
+}
+
+func (h *transitionHub) publishRemote(event TransitionEvent) {
+	if !h.firstSighting(event) {
+		return
+	}
+	event.Remote = true
+	h.deliver(event)
+}
+
+func (h *transitionHub) publish(event TransitionEvent) {
+	h.firstSighting(event)
+	h.deliver(event)
+}
+
+func (h *transitionHub) deliver(event TransitionEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers {
+		sub.deliver(event)
+	}
+}
+
+// Subscribe returns a [Subscription] that receives a [TransitionEvent] each
+// time a [CircuitBreaker] created by this factory stores a change of state
+// and the filter, if not nil, returns true. The Subscription's channel is closed
+// once the context is done.
+func (cbf *CircuitBreakerFactory) Subscribe(ctx context.Context, filter TransitionFilter) *Subscription {
+	return cbf.transitions.subscribe(ctx, filter)
+}
+
+// Watch delivers transitions made by other processes sharing the storage
+// backend to this factory's subscribers until the context is done. It
+// returns [ErrWatchNotSupported] if the backend does not implement
+// [TransitionWatcher]. Transitions already delivered within the last few
+// seconds, such as those made by this process, are not delivered again.
+func (cbf *CircuitBreakerFactory) Watch(ctx context.Context) error {
+	watcher, ok := cbf.settings.StorageBackend.(TransitionWatcher)
+	if !ok {
+		return ErrWatchNotSupported
+	}
+	return watcher.WatchTransitions(ctx, cbf.transitions.publishRemote)
+}
@@ -0,0 +1,446 @@
+package circuitry_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+func receiveEvent(t *testing.T, sub *circuitry.Subscription) circuitry.TransitionEvent {
+	t.Helper()
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatalf("expected to receive an event; got closed channel")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatalf("expected to receive an event; got nothing after 1s")
+	}
+	return circuitry.TransitionEvent{}
+}
+
+func TestSubscribeReceivesTransitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(1),
+		circuitry.WithAllowAfter(time.Millisecond),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	circuitContext := map[string]any{"key": "value"}
+	breaker := factory.BreakerFor("TestSubscribeReceivesTransitions", circuitContext)
+	workErr := errors.New("test")
+	alwaysErrorFn := func() (any, error) { return nil, workErr }
+	neverErrorFn := func() (any, error) { return nil, nil }
+
+	for range 2 {
+		_, _, _ = breaker.Execute(ctx, alwaysErrorFn)
+	}
+	event := receiveEvent(t, sub)
+	if event.Name != "TestSubscribeReceivesTransitions" {
+		t.Fatalf("expected event.Name = TestSubscribeReceivesTransitions; got %s", event.Name)
+	}
+	if event.CircuitContext["key"] != "value" {
+		t.Fatalf("expected event.CircuitContext to be the breaker's context; got %v", event.CircuitContext)
+	}
+	if event.From != circuitry.CircuitClosed || event.To != circuitry.CircuitOpen {
+		t.Fatalf("expected transition %s -> %s; got %s -> %s", circuitry.CircuitClosed, circuitry.CircuitOpen, event.From, event.To)
+	}
+	if event.Generation != 1 {
+		t.Fatalf("expected event.Generation = 1; got %d", event.Generation)
+	}
+	if event.Information.ConsecutiveFailures != 2 {
+		t.Fatalf("expected event.Information.ConsecutiveFailures = 2; got %d", event.Information.ConsecutiveFailures)
+	}
+	if !errors.Is(event.Err, workErr) {
+		t.Fatalf("expected event.Err = %v; got %v", workErr, event.Err)
+	}
+	if event.Time.IsZero() {
+		t.Fatalf("expected event.Time to be set")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	_, _, _ = breaker.Execute(ctx, neverErrorFn)
+	event = receiveEvent(t, sub)
+	if event.From != circuitry.CircuitOpen || event.To != circuitry.CircuitHalfOpen {
+		t.Fatalf("expected transition %s -> %s; got %s -> %s", circuitry.CircuitOpen, circuitry.CircuitHalfOpen, event.From, event.To)
+	}
+	if event.Err != nil {
+		t.Fatalf("expected event.Err = nil; got %v", event.Err)
+	}
+	event = receiveEvent(t, sub)
+	if event.From != circuitry.CircuitHalfOpen || event.To != circuitry.CircuitClosed {
+		t.Fatalf("expected transition %s -> %s; got %s -> %s", circuitry.CircuitHalfOpen, circuitry.CircuitClosed, event.From, event.To)
+	}
+	if sub.Dropped() != 0 {
+		t.Fatalf("expected sub.Dropped() = 0; got %d", sub.Dropped())
+	}
+}
+
+func TestSubscribeFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	sub := factory.Subscribe(ctx, func(event circuitry.TransitionEvent) bool {
+		return event.Name == "wanted"
+	})
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	_, _, _ = factory.BreakerFor("unwanted", map[string]any{}).Execute(ctx, alwaysErrorFn)
+	_, _, _ = factory.BreakerFor("wanted", map[string]any{}).Execute(ctx, alwaysErrorFn)
+
+	event := receiveEvent(t, sub)
+	if event.Name != "wanted" {
+		t.Fatalf("expected event.Name = wanted; got %s", event.Name)
+	}
+	select {
+	case event := <-sub.C:
+		t.Fatalf("expected no further events; got %v", event)
+	default:
+	}
+}
+
+func TestSubscribeDropsWhenBufferFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+		circuitry.WithSubscriptionBufferSize(1),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range []string{"a", "b", "c"} {
+			_, _, _ = factory.BreakerFor(name, map[string]any{}).Execute(ctx, alwaysErrorFn)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a full subscription to not block the CircuitBreaker")
+	}
+	if dropped := sub.Dropped(); dropped != 2 {
+		t.Fatalf("expected sub.Dropped() = 2; got %d", dropped)
+	}
+	event := receiveEvent(t, sub)
+	if event.Name != "a" {
+		t.Fatalf("expected the buffered event to be for a; got %s", event.Name)
+	}
+}
+
+func TestSubscribeClosesOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	cancel()
+	select {
+	case _, ok := <-sub.C:
+		if ok {
+			t.Fatalf("expected the subscription channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the subscription channel to be closed after 1s")
+	}
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	_, _, _ = factory.BreakerFor("closed", map[string]any{}).Execute(context.Background(), alwaysErrorFn)
+	if sub.Dropped() != 0 {
+		t.Fatalf("expected a closed subscription to not receive events; got %d dropped", sub.Dropped())
+	}
+}
+
+// broadcastBackend shares transitions between every factory using it
+type broadcastBackend struct {
+	circuitry.StorageBackender
+	mu         sync.Mutex
+	published  []circuitry.TransitionEvent
+	watchers   []func(circuitry.TransitionEvent)
+	publishErr error
+}
+
+func (b *broadcastBackend) PublishTransition(_ context.Context, event circuitry.TransitionEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.publishErr != nil {
+		return b.publishErr
+	}
+	b.published = append(b.published, event)
+	for _, watcher := range b.watchers {
+		watcher(event)
+	}
+	return nil
+}
+
+func (b *broadcastBackend) WatchTransitions(ctx context.Context, emit func(circuitry.TransitionEvent)) error {
+	b.mu.Lock()
+	b.watchers = append(b.watchers, emit)
+	b.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *broadcastBackend) watching() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.watchers)
+}
+
+func (b *broadcastBackend) transitions() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	transitions := make([]string, 0, len(b.published))
+	for _, event := range b.published {
+		transitions = append(transitions, event.From.String()+"->"+event.To.String())
+	}
+	return transitions
+}
+
+var _ circuitry.TransitionPublisher = (*broadcastBackend)(nil)
+var _ circuitry.TransitionWatcher = (*broadcastBackend)(nil)
+
+func TestWatchNotSupported(t *testing.T) {
+	factory := newFactory(backends.WithInMemoryBackend())
+	if err := factory.Watch(context.Background()); !errors.Is(err, circuitry.ErrWatchNotSupported) {
+		t.Fatalf("expected err = %v; got %v", circuitry.ErrWatchNotSupported, err)
+	}
+}
+
+func TestWatchDeliversRemoteTransitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backend := &broadcastBackend{StorageBackender: backends.NewInMemoryBackend()}
+	newSharedFactory := func() *circuitry.CircuitBreakerFactory {
+		return newFactory(
+			circuitry.WithStorageBackend(backend),
+			circuitry.WithFailureCountThreshold(0),
+			circuitry.WithCloseThreshold(1),
+			circuitry.WithAllowAfter(time.Millisecond),
+		)
+	}
+	tripping, watching := newSharedFactory(), newSharedFactory()
+	trippingSub := tripping.Subscribe(ctx, nil)
+	watchingSub := watching.Subscribe(ctx, nil)
+	for _, factory := range []*circuitry.CircuitBreakerFactory{tripping, watching} {
+		go func() { _ = factory.Watch(ctx) }()
+	}
+	for backend.watching() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	breaker := tripping.BreakerFor("TestWatchDeliversRemoteTransitions", map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	event := receiveEvent(t, watchingSub)
+	if !event.Remote || event.To != circuitry.CircuitOpen {
+		t.Fatalf("expected a remote transition to %s; got %+v", circuitry.CircuitOpen, event)
+	}
+	event = receiveEvent(t, trippingSub)
+	if event.Remote {
+		t.Fatalf("expected the tripping factory to receive its local event; got %+v", event)
+	}
+	select {
+	case event := <-trippingSub.C:
+		t.Fatalf("expected the tripping factory to not receive its own transition twice; got %+v", event)
+	default:
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, nil })
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	actual := backend.transitions()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected published transitions %v; got %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected published transitions %v; got %v", expected, actual)
+		}
+	}
+}
+
+func TestTransitionsNotPublishedWhenStoreFails(t *testing.T) {
+	backend := &broadcastBackend{StorageBackender: circuitrytest.ErroringInMemoryBackend{StoreError: errors.New("cannot store")}}
+	factory := newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithFailureCountThreshold(0),
+	)
+	breaker := factory.BreakerFor("TestTransitionsNotPublishedWhenStoreFails", map[string]any{})
+	_, _, err := breaker.Execute(context.Background(), func() (any, error) { return nil, errors.New("test") })
+	if err == nil {
+		t.Fatal("expected the store error; got nil")
+	}
+	if transitions := backend.transitions(); len(transitions) != 0 {
+		t.Fatalf("expected no transitions to be published; got %v", transitions)
+	}
+}
+
+func TestReadingExpiredCircuitDoesNotPublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	changes := 0
+	backend := &broadcastBackend{StorageBackender: backends.NewInMemoryBackend()}
+	factory := newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithCloseThreshold(1),
+		circuitry.WithAllowAfter(time.Millisecond),
+		circuitry.WithStateChangeCallback(func(_ string, _ map[string]any, _, _ circuitry.CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes++
+		}),
+	)
+	name := "TestReadingExpiredCircuitDoesNotPublish"
+	breaker := factory.BreakerFor(name, map[string]any{})
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	time.Sleep(2 * time.Millisecond)
+	sub := factory.Subscribe(ctx, nil)
+
+	for range 3 {
+		state, err := breaker.State(ctx)
+		if err != nil {
+			t.Fatalf("expected no error; got %v", err)
+		}
+		if state != circuitry.CircuitHalfOpen {
+			t.Fatalf("expected state = %s; got %s", circuitry.CircuitHalfOpen, state)
+		}
+		info, err := factory.Information(ctx, name)
+		if err != nil {
+			t.Fatalf("expected no error; got %v", err)
+		}
+		if info.State != circuitry.CircuitHalfOpen {
+			t.Fatalf("expected info.State = %s; got %s", circuitry.CircuitHalfOpen, info.State)
+		}
+	}
+	mu.Lock()
+	if changes != 1 {
+		t.Fatalf("expected only the transition to open to be reported; got %d transitions", changes)
+	}
+	mu.Unlock()
+	if transitions := backend.transitions(); len(transitions) != 1 {
+		t.Fatalf("expected only the transition to open to be published; got %v", transitions)
+	}
+	select {
+	case event := <-sub.C:
+		t.Fatalf("expected no event; got %v", event)
+	default:
+	}
+
+	_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, nil })
+	event := receiveEvent(t, sub)
+	if event.From != circuitry.CircuitOpen || event.To != circuitry.CircuitHalfOpen {
+		t.Fatalf("expected transition %s -> %s; got %s -> %s", circuitry.CircuitOpen, circuitry.CircuitHalfOpen, event.From, event.To)
+	}
+}
+
+func TestTransitionNotDeliveredWhenStoreFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sink := newRecordingSink()
+	changes := 0
+	factory := newFactory(
+		circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{StoreError: errors.New("cannot store")}),
+		circuitry.WithFailureCountThreshold(0),
+		circuitry.WithMetricsSink(sink),
+		circuitry.WithStateChangeCallback(func(_ string, _ map[string]any, _, _ circuitry.CircuitState) {
+			changes++
+		}),
+	)
+	sub := factory.Subscribe(ctx, nil)
+	_, _, err := factory.BreakerFor("TestTransitionNotDeliveredWhenStoreFails", map[string]any{}).Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+	if err == nil {
+		t.Fatalf("expected an error storing the circuit")
+	}
+	if changes != 0 {
+		t.Fatalf("expected the StateChangeCallback not to be called; got %d calls", changes)
+	}
+	if len(sink.transitions) != 0 {
+		t.Fatalf("expected no transitions to be recorded; got %v", sink.transitions)
+	}
+	select {
+	case event := <-sub.C:
+		t.Fatalf("expected no event; got %+v", event)
+	default:
+	}
+}
+
+func TestTransitionPublishErrorDoesNotFailEnd(t *testing.T) {
+	sink := newRecordingSink()
+	backend := &broadcastBackend{StorageBackender: backends.NewInMemoryBackend(), publishErr: errors.New("cannot publish")}
+	factory := newFactory(
+		circuitry.WithStorageBackend(backend),
+		circuitry.WithMetricsSink(sink),
+		circuitry.WithFailureCountThreshold(0),
+	)
+	breaker := factory.BreakerFor("TestTransitionPublishErrorDoesNotFailEnd", map[string]any{})
+	_, _, err := breaker.Execute(context.Background(), func() (any, error) { return nil, errors.New("test") })
+	if err != nil {
+		t.Fatalf("expected a publish error to not be returned; got %v", err)
+	}
+	if sink.opErrors[metrics.OpPublish] != 1 {
+		t.Fatalf("expected 1 publish error to be reported; got %d", sink.opErrors[metrics.OpPublish])
+	}
+}
+
+func TestTransitionEventJSON(t *testing.T) {
+	event := circuitry.TransitionEvent{
+		Name:           "TestTransitionEventJSON",
+		CircuitContext: map[string]any{"tenant": "a"},
+		From:           circuitry.CircuitHalfOpen,
+		To:             circuitry.CircuitOpen,
+		Generation:     3,
+		Information:    circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 3, Total: 1},
+		Time:           time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Err:            errors.New("test"),
+		Remote:         true,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("expected to marshal the event; got %v", err)
+	}
+	var decoded circuitry.TransitionEvent
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("expected to unmarshal the event; got %v", err)
+	}
+	if decoded.Name != event.Name || decoded.From != event.From || decoded.To != event.To || decoded.Generation != event.Generation {
+		t.Fatalf("expected %+v; got %+v", event, decoded)
+	}
+	if decoded.Information != event.Information || !decoded.Time.Equal(event.Time) || decoded.CircuitContext["tenant"] != "a" {
+		t.Fatalf("expected %+v; got %+v", event, decoded)
+	}
+	if decoded.Err == nil || decoded.Err.Error() != "test" {
+		t.Fatalf("expected decoded.Err to have the message test; got %v", decoded.Err)
+	}
+	if decoded.Remote {
+		t.Fatal("expected Remote to not be encoded")
+	}
+
+	decoded = circuitry.TransitionEvent{}
+	if err := json.Unmarshal([]byte(`{"name":"no-error"}`), &decoded); err != nil || decoded.Err != nil {
+		t.Fatalf("expected no error to be decoded; got %v (err = %v)", decoded.Err, err)
+	}
+	if err := json.Unmarshal([]byte(`{"name":1}`), &decoded); err == nil {
+		t.Fatal("expected an error decoding an invalid event; got nil")
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import "time"
+
+// NoOp implements a no-op version of the interface as a
+// reasonable default for circuitry to use
+type NoOp struct{}
+
+// CallStarted will do nothing
+func (s *NoOp) CallStarted(_ string) {}
+
+// CallSucceeded will do nothing
+func (s *NoOp) CallSucceeded(_ string) {}
+
+// CallFailed will do nothing
+func (s *NoOp) CallFailed(_ string) {}
+
+// CallRejected will do nothing
+func (s *NoOp) CallRejected(_ string, _ RejectionReason) {}
+
+// StateTransition will do nothing
+func (s *NoOp) StateTransition(_ string, _, _ string) {}
+
+// BackendOperation will do nothing
+func (s *NoOp) BackendOperation(_ string, _ Operation, _ time.Duration, _ error) {}
+
+// BackendDegraded will do nothing
+func (s *NoOp) BackendDegraded(_ bool) {}
+
+var _ Sink = (*NoOp)(nil)
@@ -0,0 +1,20 @@
+package metrics_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+func TestNoOp(t *testing.T) {
+	s := &metrics.NoOp{}
+	s.CallStarted("name")
+	s.CallSucceeded("name")
+	s.CallFailed("name")
+	s.CallRejected("name", metrics.RejectedOpen)
+	s.StateTransition("name", "closed", "open")
+	s.BackendOperation("name", metrics.OpStore, time.Second, errors.New("test noop err"))
+	s.BackendDegraded(true)
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus implements circuitry's [Sink] interface using
+// [github.com/prometheus/client_golang/prometheus]. It is also a
+// [github.com/prometheus/client_golang/prometheus.Collector] and must be
+// registered with a Registerer for its metrics to be exported.
+type Prometheus struct {
+	calls             *prometheus.CounterVec
+	rejections        *prometheus.CounterVec
+	transitions       *prometheus.CounterVec
+	backendLatency    *prometheus.HistogramVec
+	backendErrors     *prometheus.CounterVec
+	backendOperations *prometheus.CounterVec
+	degraded          prometheus.Gauge
+	degradations      prometheus.Counter
+}
+
+// NewPrometheus creates a new Prometheus implementation of the Sink interface
+// with all metrics created in the given namespace
+func NewPrometheus(namespace string) *Prometheus {
+	return &Prometheus{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "calls_total",
+			Help:      "Number of calls through a circuit breaker by result.",
+		}, []string{"circuit", "result"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "rejections_total",
+			Help:      "Number of calls a circuit breaker refused to start by reason.",
+		}, []string{"circuit", "reason"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "state_transitions_total",
+			Help:      "Number of circuit breaker state transitions.",
+		}, []string{"circuit", "from", "to"}),
+		backendLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "backend_operation_duration_seconds",
+			Help:      "Latency of circuit breaker storage backend operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		backendOperations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "backend_operations_total",
+			Help:      "Number of circuit breaker storage backend operations.",
+		}, []string{"circuit", "operation"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "backend_errors_total",
+			Help:      "Number of circuit breaker storage backend operations that returned an error.",
+		}, []string{"circuit", "operation"}),
+		degraded: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "backend_degraded",
+			Help:      "Whether circuit breakers are running without their storage backend (1) or not (0).",
+		}),
+		degradations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit_breaker",
+			Name:      "backend_degradations_total",
+			Help:      "Number of times circuit breakers stopped relying on their unavailable storage backend.",
+		}),
+	}
+}
+
+// CallStarted increments the calls counter with result="started"
+func (p *Prometheus) CallStarted(name string) {
+	p.calls.WithLabelValues(name, "started").Inc()
+}
+
+// CallSucceeded increments the calls counter with result="succeeded"
+func (p *Prometheus) CallSucceeded(name string) {
+	p.calls.WithLabelValues(name, "succeeded").Inc()
+}
+
+// CallFailed increments the calls counter with result="failed"
+func (p *Prometheus) CallFailed(name string) {
+	p.calls.WithLabelValues(name, "failed").Inc()
+}
+
+// CallRejected increments the rejections counter for the reason
+func (p *Prometheus) CallRejected(name string, reason RejectionReason) {
+	p.rejections.WithLabelValues(name, string(reason)).Inc()
+}
+
+// StateTransition increments the state transitions counter
+func (p *Prometheus) StateTransition(name string, from, to string) {
+	p.transitions.WithLabelValues(name, from, to).Inc()
+}
+
+// BackendOperation observes the operation latency and counts the operation
+// and whether it returned an error
+func (p *Prometheus) BackendOperation(name string, op Operation, duration time.Duration, err error) {
+	p.backendLatency.WithLabelValues(string(op)).Observe(duration.Seconds())
+	p.backendOperations.WithLabelValues(name, string(op)).Inc()
+	if err != nil {
+		p.backendErrors.WithLabelValues(name, string(op)).Inc()
+	}
+}
+
+// BackendDegraded sets the degraded gauge and counts each time degraded mode
+// is entered
+func (p *Prometheus) BackendDegraded(degraded bool) {
+	if degraded {
+		p.degraded.Set(1)
+		p.degradations.Inc()
+		return
+	}
+	p.degraded.Set(0)
+}
+
+// Describe implements [github.com/prometheus/client_golang/prometheus.Collector]
+func (p *Prometheus) Describe(ch chan<- *prometheus.Desc) {
+	p.calls.Describe(ch)
+	p.rejections.Describe(ch)
+	p.transitions.Describe(ch)
+	p.backendLatency.Describe(ch)
+	p.backendOperations.Describe(ch)
+	p.backendErrors.Describe(ch)
+	p.degraded.Describe(ch)
+	p.degradations.Describe(ch)
+}
+
+// Collect implements [github.com/prometheus/client_golang/prometheus.Collector]
+func (p *Prometheus) Collect(ch chan<- prometheus.Metric) {
+	p.calls.Collect(ch)
+	p.rejections.Collect(ch)
+	p.transitions.Collect(ch)
+	p.backendLatency.Collect(ch)
+	p.backendOperations.Collect(ch)
+	p.backendErrors.Collect(ch)
+	p.degraded.Collect(ch)
+	p.degradations.Collect(ch)
+}
+
+var _ Sink = (*Prometheus)(nil)
+var _ prometheus.Collector = (*Prometheus)(nil)
@@ -0,0 +1,114 @@
+package metrics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+func TestPrometheusCalls(t *testing.T) {
+	p := metrics.NewPrometheus("test")
+	p.CallStarted("a")
+	p.CallStarted("a")
+	p.CallSucceeded("a")
+	p.CallFailed("a")
+	p.CallRejected("a", metrics.RejectedOpen)
+	p.CallRejected("b", metrics.RejectedTooManyRequests)
+	p.StateTransition("a", "closed", "open")
+
+	expected := `
+# HELP test_circuit_breaker_calls_total Number of calls through a circuit breaker by result.
+# TYPE test_circuit_breaker_calls_total counter
+test_circuit_breaker_calls_total{circuit="a",result="failed"} 1
+test_circuit_breaker_calls_total{circuit="a",result="started"} 2
+test_circuit_breaker_calls_total{circuit="a",result="succeeded"} 1
+# HELP test_circuit_breaker_rejections_total Number of calls a circuit breaker refused to start by reason.
+# TYPE test_circuit_breaker_rejections_total counter
+test_circuit_breaker_rejections_total{circuit="a",reason="open"} 1
+test_circuit_breaker_rejections_total{circuit="b",reason="too_many_requests"} 1
+# HELP test_circuit_breaker_state_transitions_total Number of circuit breaker state transitions.
+# TYPE test_circuit_breaker_state_transitions_total counter
+test_circuit_breaker_state_transitions_total{circuit="a",from="closed",to="open"} 1
+`
+	err := testutil.CollectAndCompare(
+		p,
+		strings.NewReader(expected),
+		"test_circuit_breaker_calls_total",
+		"test_circuit_breaker_rejections_total",
+		"test_circuit_breaker_state_transitions_total",
+	)
+	if err != nil {
+		t.Fatalf("unexpected collecting result: %v", err)
+	}
+}
+
+func TestPrometheusBackendOperations(t *testing.T) {
+	p := metrics.NewPrometheus("test")
+	p.BackendOperation("a", metrics.OpRetrieve, 2*time.Millisecond, nil)
+	p.BackendOperation("a", metrics.OpStore, 20*time.Millisecond, errors.New("cannot store"))
+
+	expected := `
+# HELP test_circuit_breaker_backend_errors_total Number of circuit breaker storage backend operations that returned an error.
+# TYPE test_circuit_breaker_backend_errors_total counter
+test_circuit_breaker_backend_errors_total{circuit="a",operation="store"} 1
+# HELP test_circuit_breaker_backend_operations_total Number of circuit breaker storage backend operations.
+# TYPE test_circuit_breaker_backend_operations_total counter
+test_circuit_breaker_backend_operations_total{circuit="a",operation="retrieve"} 1
+test_circuit_breaker_backend_operations_total{circuit="a",operation="store"} 1
+`
+	err := testutil.CollectAndCompare(
+		p,
+		strings.NewReader(expected),
+		"test_circuit_breaker_backend_errors_total",
+		"test_circuit_breaker_backend_operations_total",
+	)
+	if err != nil {
+		t.Fatalf("unexpected collecting result: %v", err)
+	}
+	if count := testutil.CollectAndCount(p, "test_circuit_breaker_backend_operation_duration_seconds"); count != 2 {
+		t.Fatalf("expected latency histograms for 2 operations; got %d", count)
+	}
+}
+
+func TestPrometheusBackendDegraded(t *testing.T) {
+	p := metrics.NewPrometheus("test")
+	p.BackendDegraded(true)
+	p.BackendDegraded(false)
+	p.BackendDegraded(true)
+
+	expected := `
+# HELP test_circuit_breaker_backend_degraded Whether circuit breakers are running without their storage backend (1) or not (0).
+# TYPE test_circuit_breaker_backend_degraded gauge
+test_circuit_breaker_backend_degraded 1
+# HELP test_circuit_breaker_backend_degradations_total Number of times circuit breakers stopped relying on their unavailable storage backend.
+# TYPE test_circuit_breaker_backend_degradations_total counter
+test_circuit_breaker_backend_degradations_total 2
+`
+	err := testutil.CollectAndCompare(
+		p,
+		strings.NewReader(expected),
+		"test_circuit_breaker_backend_degraded",
+		"test_circuit_breaker_backend_degradations_total",
+	)
+	if err != nil {
+		t.Fatalf("unexpected collecting result: %v", err)
+	}
+}
+
+func TestPrometheusRegister(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	p := metrics.NewPrometheus("test")
+	if err := registry.Register(p); err != nil {
+		t.Fatalf("expected to register Prometheus sink; got %v", err)
+	}
+	p.CallStarted("a")
+	if problems, err := testutil.GatherAndLint(registry); err != nil || len(problems) != 0 {
+		t.Fatalf("expected metrics to pass linting; got problems = %+v, err = %v", problems, err)
+	}
+}
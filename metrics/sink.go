@@ -0,0 +1,65 @@
+// Package metrics provides the interface circuitry uses to report metrics
+// about CircuitBreakers as well as implementations of it.
+package metrics
+
+import "time"
+
+// RejectionReason describes why a CircuitBreaker refused to start work
+type RejectionReason string
+
+const (
+	// RejectedOpen is used when the CircuitBreaker is open
+	RejectedOpen RejectionReason = "open"
+	// RejectedTooManyRequests is used when the CircuitBreaker is half-open
+	// and already allowing as many requests as it will
+	RejectedTooManyRequests RejectionReason = "too_many_requests"
+	// RejectedBackendError is used when the CircuitBreaker could not lock or
+	// retrieve its state from the storage backend
+	RejectedBackendError RejectionReason = "backend_error"
+)
+
+// Operation describes an operation a CircuitBreaker performs against its
+// storage backend
+type Operation string
+
+const (
+	// OpRetrieve represents retrieving circuit information
+	OpRetrieve Operation = "retrieve"
+	// OpStore represents storing circuit information
+	OpStore Operation = "store"
+	// OpLock represents locking the circuit in the backend
+	OpLock Operation = "lock"
+	// OpPublish represents publishing a state transition through the backend
+	OpPublish Operation = "publish"
+	// OpDelete represents deleting circuit information
+	OpDelete Operation = "delete"
+	// OpAdmit represents admitting a request with a backend that records
+	// outcomes atomically
+	OpAdmit Operation = "admit"
+	// OpRecord represents recording an outcome with a backend that records
+	// outcomes atomically
+	OpRecord Operation = "record"
+)
+
+// Sink provides an interface to be used so that metrics can be reported by
+// the circuitry package
+type Sink interface {
+	// CallStarted is called when a CircuitBreaker allows work to start
+	CallStarted(name string)
+	// CallSucceeded is called when the work ends and is not considered a
+	// failure
+	CallSucceeded(name string)
+	// CallFailed is called when the work ends and is considered a failure
+	CallFailed(name string)
+	// CallRejected is called when a CircuitBreaker refuses to start work
+	CallRejected(name string, reason RejectionReason)
+	// StateTransition is called when a CircuitBreaker changes state
+	StateTransition(name string, from, to string)
+	// BackendOperation is called after each operation against the storage
+	// backend with how long it took and the error it returned, if any
+	BackendOperation(name string, op Operation, duration time.Duration, err error)
+	// BackendDegraded is called when CircuitBreakers stop relying on the
+	// storage backend because it is unavailable, with degraded set to true,
+	// and when they start relying on it again, with degraded set to false
+	BackendDegraded(degraded bool)
+}
@@ -0,0 +1,130 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/metrics"
+)
+
+type recordingSink struct {
+	mu          sync.Mutex
+	started     int
+	succeeded   int
+	failed      int
+	rejected    map[metrics.RejectionReason]int
+	transitions []string
+	operations  map[metrics.Operation]int
+	opErrors    map[metrics.Operation]int
+	degraded    []bool
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{
+		rejected:   make(map[metrics.RejectionReason]int),
+		operations: make(map[metrics.Operation]int),
+		opErrors:   make(map[metrics.Operation]int),
+	}
+}
+
+func (s *recordingSink) CallStarted(_ string)   { s.mu.Lock(); s.started++; s.mu.Unlock() }
+func (s *recordingSink) CallSucceeded(_ string) { s.mu.Lock(); s.succeeded++; s.mu.Unlock() }
+func (s *recordingSink) CallFailed(_ string)    { s.mu.Lock(); s.failed++; s.mu.Unlock() }
+func (s *recordingSink) CallRejected(_ string, reason metrics.RejectionReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[reason]++
+}
+func (s *recordingSink) StateTransition(_ string, from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transitions = append(s.transitions, from+"->"+to)
+}
+func (s *recordingSink) BackendOperation(_ string, op metrics.Operation, _ time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operations[op]++
+	if err != nil {
+		s.opErrors[op]++
+	}
+}
+
+func (s *recordingSink) BackendDegraded(degraded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.degraded = append(s.degraded, degraded)
+}
+
+var _ metrics.Sink = (*recordingSink)(nil)
+
+func TestMetricsSinkReceivesCalls(t *testing.T) {
+	sink := newRecordingSink()
+	factory := newFactory(
+		backends.WithInMemoryBackend(),
+		circuitry.WithMetricsSink(sink),
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(1),
+		circuitry.WithAllowAfter(time.Hour),
+	)
+	breaker := factory.BreakerFor("TestMetricsSinkReceivesCalls", map[string]any{})
+	alwaysErrorFn := func() (any, error) { return nil, errors.New("test") }
+	neverErrorFn := func() (any, error) { return nil, nil }
+
+	if _, _, err := breaker.Execute(context.TODO(), neverErrorFn); err != nil {
+		t.Fatalf("couldn't execute work function; got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := breaker.Execute(context.TODO(), alwaysErrorFn); err != nil {
+			t.Fatalf("couldn't execute work function; got %v", err)
+		}
+	}
+	if _, _, err := breaker.Execute(context.TODO(), neverErrorFn); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected breaker to be open; got %v", err)
+	}
+
+	if sink.started != 3 || sink.succeeded != 1 || sink.failed != 2 {
+		t.Fatalf("expected 3 started, 1 succeeded, 2 failed; got %d, %d, %d", sink.started, sink.succeeded, sink.failed)
+	}
+	if sink.rejected[metrics.RejectedOpen] != 1 {
+		t.Fatalf("expected 1 rejection due to the circuit being open; got %+v", sink.rejected)
+	}
+	if len(sink.transitions) != 1 || sink.transitions[0] != "closed->open" {
+		t.Fatalf("expected a single closed->open transition; got %v", sink.transitions)
+	}
+	if sink.operations[metrics.OpLock] != 4 || sink.operations[metrics.OpRetrieve] != 4 || sink.operations[metrics.OpStore] != 3 {
+		t.Fatalf("expected 4 locks, 4 retrieves, and 3 stores; got %+v", sink.operations)
+	}
+}
+
+func TestMetricsSinkRejectionReasons(t *testing.T) {
+	testCases := map[string]struct {
+		backend  circuitry.StorageBackender
+		expected metrics.RejectionReason
+		op       metrics.Operation
+	}{
+		"can't lock":     {circuitrytest.ErroringInMemoryBackend{LockError: errors.New("cannot lock")}, metrics.RejectedBackendError, metrics.OpLock},
+		"can't retrieve": {circuitrytest.ErroringInMemoryBackend{RetrieveError: errors.New("cannot retrieve")}, metrics.RejectedBackendError, metrics.OpRetrieve},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			sink := newRecordingSink()
+			factory := newFactory(circuitry.WithStorageBackend(tc.backend), circuitry.WithMetricsSink(sink))
+			if err := factory.BreakerFor("name", map[string]any{}).Start(context.TODO()); err == nil {
+				t.Fatal("expected breaker.Start() to fail")
+			}
+			if sink.rejected[tc.expected] != 1 {
+				t.Fatalf("expected rejection with reason %s; got %+v", tc.expected, sink.rejected)
+			}
+			if sink.opErrors[tc.op] != 1 {
+				t.Fatalf("expected backend error for %s; got %+v", tc.op, sink.opErrors)
+			}
+		})
+	}
+}
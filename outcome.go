@@ -0,0 +1,96 @@
+package circuitry
+
+import "time"
+
+// Admit applies the policy to the circuit as an [OutcomeRecorder]'s Admit
+// would, for backends that read the circuit and write it back on the
+// condition that it has not changed in between. It returns the circuit
+// before any change of state and after counting the request, along with
+// ErrCircuitBreakerOpen or ErrTooManyRequests if the request is not allowed.
+func (p OutcomePolicy) Admit(ci CircuitInformation, now time.Time) (before, after CircuitInformation, err error) {
+	after = ci
+	if after.ExpiresAfter.IsZero() && after.Generation == 0 && after.Total == 0 && p.CyclicClearAfter != 0 {
+		after.ExpiresAfter = now.Add(p.CyclicClearAfter)
+	}
+	before = after
+	switch after.State {
+	case CircuitClosed:
+		if !after.ExpiresAfter.IsZero() && after.ExpiresAfter.Before(now) {
+			p.newGeneration(&after, now)
+		}
+	case CircuitOpen:
+		if after.ExpiresAfter.Before(now) {
+			p.setState(&after, CircuitHalfOpen, now)
+		}
+	}
+	switch {
+	case after.State == CircuitOpen:
+		return before, after, ErrCircuitBreakerOpen
+	case after.State == CircuitHalfOpen && after.Total >= p.CloseThreshold:
+		return before, after, ErrTooManyRequests
+	}
+	after.Total++
+	return before, after, nil
+}
+
+// Record applies the policy to the circuit as an [OutcomeRecorder]'s Record
+// would. It returns the circuit after counting the outcome but before any
+// change of state, and the circuit afterwards. Outcomes from other
+// generations leave the circuit unchanged.
+func (p OutcomePolicy) Record(ci CircuitInformation, generation uint64, status ExecutionStatus, now time.Time) (before, after CircuitInformation) {
+	after = ci
+	if after.Generation != generation {
+		return after, after
+	}
+	counts := fromCircuitInformation(after)
+	switch {
+	case status == ExecutionSucceeded && after.State != CircuitOpen:
+		counts.AddSuccess()
+		after = counts.ToCircuitInformation(after.Generation, after.State, after.ExpiresAfter)
+		before = after
+		if after.State == CircuitHalfOpen && after.ConsecutiveSuccesses >= p.CloseThreshold {
+			p.setState(&after, CircuitClosed, now)
+		}
+	case status != ExecutionSucceeded && after.State == CircuitClosed:
+		counts.AddFailure()
+		after = counts.ToCircuitInformation(after.Generation, after.State, after.ExpiresAfter)
+		before = after
+		if after.ConsecutiveFailures > p.FailureCountThreshold {
+			p.setState(&after, CircuitOpen, now)
+		}
+	case status != ExecutionSucceeded && after.State == CircuitHalfOpen:
+		before = after
+		p.setState(&after, CircuitOpen, now)
+	default:
+		before = after
+	}
+	return before, after
+}
+
+func (p OutcomePolicy) updateExpiry(ci *CircuitInformation, now time.Time) {
+	switch {
+	case ci.State == CircuitClosed && p.CyclicClearAfter != 0:
+		ci.ExpiresAfter = now.Add(p.CyclicClearAfter)
+	case ci.State == CircuitOpen:
+		ci.ExpiresAfter = now.Add(p.AllowAfter)
+	default:
+		ci.ExpiresAfter = time.Time{}
+	}
+}
+
+func (p OutcomePolicy) newGeneration(ci *CircuitInformation, now time.Time) {
+	*ci = circuitCounts{}.ToCircuitInformation(ci.Generation+1, ci.State, ci.ExpiresAfter)
+	p.updateExpiry(ci, now)
+}
+
+// setState keeps the generation when a half-open circuit opens again or an
+// open circuit becomes half-open, as a CircuitBreaker does
+func (p OutcomePolicy) setState(ci *CircuitInformation, state CircuitState, now time.Time) {
+	prev := ci.State
+	ci.State = state
+	if (prev == CircuitHalfOpen && state != CircuitClosed) || state == CircuitHalfOpen {
+		p.updateExpiry(ci, now)
+		return
+	}
+	p.newGeneration(ci, now)
+}
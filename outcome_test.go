@@ -0,0 +1,233 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+)
+
+var testPolicy = circuitry.OutcomePolicy{
+	FailureCountThreshold: 1,
+	CloseThreshold:        2,
+	AllowAfter:            time.Minute,
+	CyclicClearAfter:      time.Hour,
+}
+
+func TestOutcomePolicyAdmit(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Second)
+	testCases := map[string]struct {
+		policy   circuitry.OutcomePolicy
+		ci       circuitry.CircuitInformation
+		before   circuitry.CircuitInformation
+		after    circuitry.CircuitInformation
+		expected error
+	}{
+		"new circuit": {
+			policy: testPolicy,
+			before: circuitry.CircuitInformation{ExpiresAfter: now.Add(time.Hour)},
+			after:  circuitry.CircuitInformation{Total: 1, ExpiresAfter: now.Add(time.Hour)},
+		},
+		"new circuit without a reset cycle": {
+			after: circuitry.CircuitInformation{Total: 1},
+		},
+		"closed": {
+			policy: testPolicy,
+			ci:     circuitry.CircuitInformation{Generation: 2, Total: 3, TotalSuccesses: 3, ExpiresAfter: future},
+			before: circuitry.CircuitInformation{Generation: 2, Total: 3, TotalSuccesses: 3, ExpiresAfter: future},
+			after:  circuitry.CircuitInformation{Generation: 2, Total: 4, TotalSuccesses: 3, ExpiresAfter: future},
+		},
+		"closed reset cycle expired": {
+			policy: testPolicy,
+			ci:     circuitry.CircuitInformation{Generation: 2, Total: 3, TotalSuccesses: 3, ExpiresAfter: past},
+			before: circuitry.CircuitInformation{Generation: 2, Total: 3, TotalSuccesses: 3, ExpiresAfter: past},
+			after:  circuitry.CircuitInformation{Generation: 3, Total: 1, ExpiresAfter: now.Add(time.Hour)},
+		},
+		"open": {
+			policy:   testPolicy,
+			ci:       circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: future},
+			before:   circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: future},
+			after:    circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: future},
+			expected: circuitry.ErrCircuitBreakerOpen,
+		},
+		"open expired": {
+			policy: testPolicy,
+			ci:     circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: past},
+			before: circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: past},
+			after:  circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 1},
+		},
+		"half-open": {
+			policy: testPolicy,
+			ci:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 1},
+			before: circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 1},
+			after:  circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 2},
+		},
+		"half-open allowing as many requests as it will": {
+			policy:   testPolicy,
+			ci:       circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 2},
+			before:   circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 2},
+			after:    circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 2},
+			expected: circuitry.ErrTooManyRequests,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			before, after, err := tc.policy.Admit(tc.ci, now)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected err = %v; got %v", tc.expected, err)
+			}
+			if before != tc.before {
+				t.Fatalf("expected before = %+v; got %+v", tc.before, before)
+			}
+			if after != tc.after {
+				t.Fatalf("expected after = %+v; got %+v", tc.after, after)
+			}
+		})
+	}
+}
+
+func TestOutcomePolicyRecord(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Second)
+	closed := circuitry.CircuitInformation{Generation: 2, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1, ExpiresAfter: future}
+	halfOpen := circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, Total: 2}
+	open := circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, ExpiresAfter: future}
+	testCases := map[string]struct {
+		ci         circuitry.CircuitInformation
+		generation uint64
+		status     circuitry.ExecutionStatus
+		before     circuitry.CircuitInformation
+		after      circuitry.CircuitInformation
+	}{
+		"closed success": {
+			ci:         closed,
+			generation: 2,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{Generation: 2, ConsecutiveSuccesses: 2, Total: 2, TotalSuccesses: 2, ExpiresAfter: future},
+			after:      circuitry.CircuitInformation{Generation: 2, ConsecutiveSuccesses: 2, Total: 2, TotalSuccesses: 2, ExpiresAfter: future},
+		},
+		"closed failure": {
+			ci:         closed,
+			generation: 2,
+			status:     circuitry.ExecutionFailed,
+			before:     circuitry.CircuitInformation{Generation: 2, ConsecutiveFailures: 1, Total: 2, TotalFailures: 1, TotalSuccesses: 1, ExpiresAfter: future},
+			after:      circuitry.CircuitInformation{Generation: 2, ConsecutiveFailures: 1, Total: 2, TotalFailures: 1, TotalSuccesses: 1, ExpiresAfter: future},
+		},
+		"closed failure trips": {
+			ci:         circuitry.CircuitInformation{Generation: 2, ConsecutiveFailures: 1, Total: 2, TotalFailures: 1, ExpiresAfter: future},
+			generation: 2,
+			status:     circuitry.ExecutionFailed,
+			before:     circuitry.CircuitInformation{Generation: 2, ConsecutiveFailures: 2, Total: 2, TotalFailures: 2, ExpiresAfter: future},
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 3, ExpiresAfter: now.Add(time.Minute)},
+		},
+		"half-open success": {
+			ci:         halfOpen,
+			generation: 2,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+		},
+		"half-open success closes": {
+			ci:         circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, ConsecutiveSuccesses: 1, Total: 2, TotalSuccesses: 1},
+			generation: 2,
+			status:     circuitry.ExecutionSucceeded,
+			before:     circuitry.CircuitInformation{State: circuitry.CircuitHalfOpen, Generation: 2, ConsecutiveSuccesses: 2, Total: 2, TotalSuccesses: 2},
+			after:      circuitry.CircuitInformation{Generation: 3, ExpiresAfter: now.Add(time.Hour)},
+		},
+		"half-open failure opens in the same generation": {
+			ci:         halfOpen,
+			generation: 2,
+			status:     circuitry.ExecutionFailed,
+			before:     halfOpen,
+			after:      circuitry.CircuitInformation{State: circuitry.CircuitOpen, Generation: 2, Total: 2, ExpiresAfter: now.Add(time.Minute)},
+		},
+		"open": {
+			ci:         open,
+			generation: 2,
+			status:     circuitry.ExecutionSucceeded,
+			before:     open,
+			after:      open,
+		},
+		"other generation": {
+			ci:         closed,
+			generation: 1,
+			status:     circuitry.ExecutionFailed,
+			before:     closed,
+			after:      closed,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			before, after := testPolicy.Record(tc.ci, tc.generation, tc.status, now)
+			if before != tc.before {
+				t.Fatalf("expected before = %+v; got %+v", tc.before, before)
+			}
+			if after != tc.after {
+				t.Fatalf("expected after = %+v; got %+v", tc.after, after)
+			}
+		})
+	}
+}
+
+// policyBackend records outcomes by applying the OutcomePolicy to the
+// in-memory backend's information
+type policyBackend struct {
+	*backends.InMemoryBackend
+}
+
+func (b policyBackend) Admit(ctx context.Context, name string, policy circuitry.OutcomePolicy, now time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	ci, _ := b.Retrieve(ctx, name)
+	before, after, err := policy.Admit(ci, now)
+	_ = b.Store(ctx, name, after)
+	return before, after, err
+}
+
+func (b policyBackend) Record(ctx context.Context, name string, policy circuitry.OutcomePolicy, generation uint64, status circuitry.ExecutionStatus, now time.Time) (circuitry.CircuitInformation, circuitry.CircuitInformation, error) {
+	ci, _ := b.Retrieve(ctx, name)
+	before, after := policy.Record(ci, generation, status, now)
+	_ = b.Store(ctx, name, after)
+	return before, after, nil
+}
+
+func TestOutcomePolicyMatchesCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	opts := []circuitry.SettingsOption{
+		circuitry.WithFailureCountThreshold(1),
+		circuitry.WithCloseThreshold(2),
+		circuitry.WithAllowAfter(time.Millisecond),
+		circuitry.WithCyclicClearAfter(time.Hour),
+	}
+	locked := backends.NewInMemoryBackend()
+	recorded := policyBackend{backends.NewInMemoryBackend().(*backends.InMemoryBackend)}
+	lockedBreaker := newFactory(append(opts, circuitry.WithStorageBackend(locked))...).BreakerFor("parity", map[string]any{})
+	recordedBreaker := newFactory(append(opts, circuitry.WithStorageBackend(recorded))...).BreakerFor("parity", map[string]any{})
+	workErr := errors.New("test")
+	outcomes := []error{nil, workErr, workErr, workErr, nil, nil, nil, workErr, nil, workErr, workErr, nil}
+
+	for i, outcome := range outcomes {
+		// Let open circuits become half-open
+		time.Sleep(2 * time.Millisecond)
+		_, _, lockedErr := lockedBreaker.Execute(ctx, func() (any, error) { return nil, outcome })
+		_, _, recordedErr := recordedBreaker.Execute(ctx, func() (any, error) { return nil, outcome })
+		if !errors.Is(recordedErr, lockedErr) {
+			t.Fatalf("expected step %d to end with %v; got %v", i, lockedErr, recordedErr)
+		}
+		expected, _ := locked.Retrieve(ctx, "parity")
+		actual, _ := recorded.Retrieve(ctx, "parity")
+		expected.ExpiresAfter, actual.ExpiresAfter = time.Time{}, time.Time{}
+		if actual != expected {
+			t.Fatalf("expected step %d to store %+v; got %+v", i, expected, actual)
+		}
+	}
+}
+
+var _ circuitry.OutcomeRecorder = policyBackend{}
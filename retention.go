@@ -0,0 +1,43 @@
+package circuitry
+
+import "time"
+
+// Retention decides how long a storage backend keeps the data it stores for
+// a circuit, such as the Redis backend's keys or the DynamoDB backend's
+// items, before it may be removed. The zero value keeps it until the circuit
+// is deleted. Data should be kept for longer than the AllowAfter and
+// CyclicClearAfter settings, as a circuit whose data was removed starts over
+// closed.
+type Retention struct {
+	// KeepFor keeps data for this long after the circuit is last stored
+	KeepFor time.Duration
+	// Grace keeps data, when KeepFor is zero, until this long after the
+	// circuit's ExpiresAfter and for at least this long. Data of circuits
+	// that do not expire, such as closed circuits without a
+	// CyclicClearAfter, is kept until they are deleted.
+	Grace time.Duration
+}
+
+// RetainFor keeps data for the duration after the circuit is last stored
+func RetainFor(d time.Duration) Retention {
+	return Retention{KeepFor: d}
+}
+
+// RetainUntilExpiry keeps data until the grace period after the circuit's
+// ExpiresAfter
+func RetainUntilExpiry(grace time.Duration) Retention {
+	return Retention{Grace: grace}
+}
+
+// TTL returns how long to keep the data of a circuit stored at now, or 0 if
+// it is kept until it is deleted
+func (r Retention) TTL(ci CircuitInformation, now time.Time) time.Duration {
+	switch {
+	case r.KeepFor > 0:
+		return r.KeepFor
+	case r.Grace > 0 && !ci.ExpiresAfter.IsZero():
+		return max(ci.ExpiresAfter.Sub(now)+r.Grace, r.Grace)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,35 @@
+package circuitry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+func TestRetentionTTL(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		retention    circuitry.Retention
+		expiresAfter time.Time
+		expected     time.Duration
+	}{
+		"zero value":                      {circuitry.Retention{}, now.Add(time.Hour), 0},
+		"keep for":                        {circuitry.RetainFor(time.Hour), time.Time{}, time.Hour},
+		"keep for ignores expiry":         {circuitry.RetainFor(time.Hour), now.Add(time.Minute), time.Hour},
+		"keep for takes precedence":       {circuitry.Retention{KeepFor: time.Hour, Grace: time.Minute}, now.Add(time.Minute), time.Hour},
+		"until expiry":                    {circuitry.RetainUntilExpiry(time.Minute), now.Add(time.Hour), time.Hour + time.Minute},
+		"until expiry of expired circuit": {circuitry.RetainUntilExpiry(time.Minute), now.Add(-time.Hour), time.Minute},
+		"until expiry without expiry":     {circuitry.RetainUntilExpiry(time.Minute), time.Time{}, 0},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			ci := circuitry.CircuitInformation{ExpiresAfter: tc.expiresAfter}
+			if actual := tc.retention.TTL(ci, now); actual != tc.expected {
+				t.Fatalf("expected %v; got %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Package retry runs work through a
+// [github.com/sigmavirus24/circuitry.CircuitBreaker], retrying failed
+// attempts with exponential backoff and jitter.
+//
+// Retrying stops as soon as the circuit breaker refuses an attempt, rather
+// than waiting on a circuit that will not allow it, and the returned
+// [*OpenCircuitError] says when the circuit will allow requests again.
+// Errors that are not retryable, which by default are the errors the
+// circuit breaker considers expected, are returned immediately.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+const (
+	// DefaultInitialInterval is how long to wait before the first retry
+	DefaultInitialInterval = 100 * time.Millisecond
+	// DefaultMaxInterval is the longest to wait between attempts
+	DefaultMaxInterval = 10 * time.Second
+	// DefaultMultiplier is how much the wait grows after each attempt
+	DefaultMultiplier = 2.0
+	// DefaultJitter is the fraction each wait is randomly adjusted by
+	DefaultJitter = 0.2
+	// DefaultMaxAttempts is how many attempts are made, including the first
+	DefaultMaxAttempts = 3
+)
+
+// OpenCircuitError is returned when the circuit breaker refuses to start an
+// attempt
+type OpenCircuitError struct {
+	Name string
+	// Err is [github.com/sigmavirus24/circuitry.ErrCircuitBreakerOpen] or
+	// [github.com/sigmavirus24/circuitry.ErrTooManyRequests]
+	Err error
+	// RetryAfter is when an open circuit will allow requests again. It is
+	// zero if the circuit is half-open or its information is unavailable.
+	RetryAfter time.Time
+	// LastErr is the error returned by the previous attempt, if any
+	LastErr error
+}
+
+func (e *OpenCircuitError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("circuit %q rejected attempt: %s", e.Name, e.Err)
+	}
+	return fmt.Sprintf("circuit %q rejected attempt: %s; retry after %s", e.Name, e.Err, e.RetryAfter.Format(time.RFC3339))
+}
+
+func (e *OpenCircuitError) Unwrap() []error {
+	if e.LastErr == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.LastErr}
+}
+
+var _ error = (*OpenCircuitError)(nil)
+
+// ExhaustedError is returned when every attempt allowed failed. It wraps the
+// error from the last attempt.
+type ExhaustedError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *ExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+var _ error = (*ExhaustedError)(nil)
+
+type options struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	multiplier      float64
+	jitter          float64
+	maxAttempts     int
+	maxElapsed      time.Duration
+	retryable       func(error) bool
+	random          func() float64
+}
+
+// Option configures how work is retried
+type Option func(*options)
+
+// WithInitialInterval overrides [DefaultInitialInterval]
+func WithInitialInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.initialInterval = interval
+	}
+}
+
+// WithMaxInterval overrides [DefaultMaxInterval]
+func WithMaxInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.maxInterval = interval
+	}
+}
+
+// WithMultiplier overrides [DefaultMultiplier]
+func WithMultiplier(multiplier float64) Option {
+	return func(o *options) {
+		o.multiplier = multiplier
+	}
+}
+
+// WithJitter overrides [DefaultJitter]. Each wait is adjusted by a random
+// amount up to this fraction of it in either direction, so 0 disables jitter.
+func WithJitter(jitter float64) Option {
+	return func(o *options) {
+		o.jitter = jitter
+	}
+}
+
+// WithMaxAttempts overrides [DefaultMaxAttempts]. Zero or less means attempts
+// are only limited by WithMaxElapsed and the context.
+func WithMaxAttempts(attempts int) Option {
+	return func(o *options) {
+		o.maxAttempts = attempts
+	}
+}
+
+// WithMaxElapsed stops retrying once waiting for the next attempt would take
+// longer than the duration since the first attempt started
+func WithMaxElapsed(elapsed time.Duration) Option {
+	return func(o *options) {
+		o.maxElapsed = elapsed
+	}
+}
+
+// WithRetryable decides which errors returned by the work are retried. By
+// default, errors that [github.com/sigmavirus24/circuitry.DefaultErrorMatcher]
+// considers failures are retried. Configure this to match the circuit
+// breaker's error matcher if it has a custom one.
+func WithRetryable(retryable func(error) bool) Option {
+	return func(o *options) {
+		o.retryable = retryable
+	}
+}
+
+func defaultRetryable(err error) bool {
+	return circuitry.DefaultErrorMatcher(err) == circuitry.ExecutionFailed
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		initialInterval: DefaultInitialInterval,
+		maxInterval:     DefaultMaxInterval,
+		multiplier:      DefaultMultiplier,
+		jitter:          DefaultJitter,
+		maxAttempts:     DefaultMaxAttempts,
+		retryable:       defaultRetryable,
+		random:          rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// backoff returns how long to wait after the given number of attempts
+func (o *options) backoff(attempts int) time.Duration {
+	wait := float64(o.initialInterval)
+	for range attempts - 1 {
+		wait *= o.multiplier
+		if wait >= float64(o.maxInterval) {
+			break
+		}
+	}
+	wait += wait * o.jitter * (2*o.random() - 1)
+	return time.Duration(min(wait, float64(o.maxInterval)))
+}
+
+// Do runs the work through the circuit breaker's Execute until it succeeds,
+// returns an error that is not retryable, or runs out of attempts or time.
+// The breaker must not be used concurrently while Do is running. Errors from
+// the breaker itself, such as being unable to reach the storage backend, are
+// returned immediately along with the result and error of the work if it
+// ran.
+func Do(ctx context.Context, breaker circuitry.CircuitBreaker, work circuitry.WorkFn, opts ...Option) (any, error) {
+	o := newOptions(opts)
+	start := time.Now()
+	var lastErr error
+	for attempts := 1; ; attempts++ {
+		result, workErr, circuitErr := breaker.Execute(ctx, work)
+		if circuitErr != nil {
+			if workErr != nil {
+				// The work ran but its outcome could not be recorded
+				circuitErr = errors.Join(workErr, circuitErr)
+			}
+			return result, rejected(ctx, breaker, circuitErr, lastErr)
+		}
+		if workErr == nil || !o.retryable(workErr) {
+			return result, workErr
+		}
+		lastErr = workErr
+		// Waiting on a circuit this attempt tripped would be pointless
+		if info, err := breaker.Information(ctx); err == nil && info.State == circuitry.CircuitOpen {
+			return nil, &OpenCircuitError{Name: breaker.Name(), Err: circuitry.ErrCircuitBreakerOpen, RetryAfter: info.ExpiresAfter, LastErr: workErr}
+		}
+		if o.maxAttempts > 0 && attempts >= o.maxAttempts {
+			return nil, &ExhaustedError{Attempts: attempts, Err: workErr}
+		}
+		wait := o.backoff(attempts)
+		if o.maxElapsed > 0 && time.Since(start)+wait > o.maxElapsed {
+			return nil, &ExhaustedError{Attempts: attempts, Err: workErr}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(ctx.Err(), workErr)
+		case <-timer.C:
+		}
+	}
+}
+
+// rejected converts the circuit breaker refusing an attempt into an
+// OpenCircuitError with the time an open circuit allows requests again
+func rejected(ctx context.Context, breaker circuitry.CircuitBreaker, err, lastErr error) error {
+	switch {
+	case errors.Is(err, circuitry.ErrCircuitBreakerOpen):
+		openErr := &OpenCircuitError{Name: breaker.Name(), Err: err, LastErr: lastErr}
+		if info, infoErr := breaker.Information(ctx); infoErr == nil && info.State == circuitry.CircuitOpen {
+			openErr.RetryAfter = info.ExpiresAfter
+		}
+		return openErr
+	case errors.Is(err, circuitry.ErrTooManyRequests):
+		return &OpenCircuitError{Name: breaker.Name(), Err: err, LastErr: lastErr}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,33 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	testCases := map[string]struct {
+		random   float64
+		attempts int
+		expected time.Duration
+	}{
+		"first retry":            {0.5, 1, 100 * time.Millisecond},
+		"grows":                  {0.5, 3, 400 * time.Millisecond},
+		"capped":                 {0.5, 10, time.Second},
+		"jitter down":            {0, 2, 160 * time.Millisecond},
+		"jitter up":              {1, 2, 240 * time.Millisecond},
+		"jitter capped":          {1, 5, time.Second},
+		"capped before overflow": {0.5, 10_000, time.Second},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			o := newOptions([]Option{WithMaxInterval(time.Second)})
+			o.random = func() float64 { return tc.random }
+			if actual := o.backoff(tc.attempts); actual != tc.expected {
+				t.Fatalf("expected %v; got %v", tc.expected, actual)
+			}
+		})
+	}
+}
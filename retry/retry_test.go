@@ -0,0 +1,212 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/retry"
+)
+
+func newFactory(opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	settings, _ := circuitry.NewFactorySettings(opts...)
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+func newBreaker(name string, opts ...circuitry.SettingsOption) circuitry.CircuitBreaker {
+	opts = append([]circuitry.SettingsOption{backends.WithInMemoryBackend()}, opts...)
+	return newFactory(opts...).BreakerFor(name, map[string]any{})
+}
+
+// failing returns work that fails the given number of times before succeeding
+func failing(times int, calls *int) circuitry.WorkFn {
+	return func() (any, error) {
+		*calls++
+		if *calls <= times {
+			return nil, errors.New("test")
+		}
+		return "ok", nil
+	}
+}
+
+func TestDoRetriesFailures(t *testing.T) {
+	breaker := newBreaker("TestDoRetriesFailures", circuitry.WithFailureCountThreshold(10))
+	calls := 0
+	result, err := retry.Do(context.Background(), breaker, failing(2, &calls), retry.WithInitialInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected the work to succeed on the last attempt; got %v", err)
+	}
+	if result != "ok" || calls != 3 {
+		t.Fatalf("expected the result after 3 calls; got %v after %d calls", result, calls)
+	}
+}
+
+func TestDoReturnsUnretryableErrors(t *testing.T) {
+	expected := circuitry.WrapExpectedConditionError(errors.New("not found"))
+	other := errors.New("other")
+	testCases := map[string]struct {
+		err  error
+		opts []retry.Option
+	}{
+		"expected error":   {expected, nil},
+		"custom retryable": {other, []retry.Option{retry.WithRetryable(func(err error) bool { return err != other })}},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			breaker := newBreaker(name, circuitry.WithFailureCountThreshold(10))
+			calls := 0
+			_, err := retry.Do(context.Background(), breaker, func() (any, error) {
+				calls++
+				return nil, tc.err
+			}, tc.opts...)
+			if err != tc.err || calls != 1 {
+				t.Fatalf("expected %v after 1 call; got %v after %d calls", tc.err, err, calls)
+			}
+		})
+	}
+}
+
+func TestDoGivesUp(t *testing.T) {
+	testCases := map[string]struct {
+		opts     []retry.Option
+		attempts int
+	}{
+		"max attempts": {
+			[]retry.Option{retry.WithMaxAttempts(4), retry.WithInitialInterval(time.Millisecond)},
+			4,
+		},
+		"max elapsed": {
+			[]retry.Option{retry.WithMaxAttempts(0), retry.WithInitialInterval(20 * time.Millisecond), retry.WithMultiplier(1), retry.WithJitter(0), retry.WithMaxElapsed(50 * time.Millisecond)},
+			3,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			breaker := newBreaker(name, circuitry.WithFailureCountThreshold(10))
+			calls := 0
+			_, err := retry.Do(context.Background(), breaker, failing(10, &calls), tc.opts...)
+			var exhausted *retry.ExhaustedError
+			if !errors.As(err, &exhausted) {
+				t.Fatalf("expected an ExhaustedError; got %v", err)
+			}
+			if exhausted.Attempts != tc.attempts || calls != tc.attempts {
+				t.Fatalf("expected to give up after %d attempts; got %d after %d calls", tc.attempts, exhausted.Attempts, calls)
+			}
+			if exhausted.Err == nil || !strings.Contains(err.Error(), "giving up after") {
+				t.Fatalf("expected the error to include the last failure; got %q", err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenCircuitTrips(t *testing.T) {
+	breaker := newBreaker("TestDoStopsWhenCircuitTrips", circuitry.WithFailureCountThreshold(1), circuitry.WithAllowAfter(time.Hour))
+	calls := 0
+	_, err := retry.Do(context.Background(), breaker, failing(10, &calls), retry.WithMaxAttempts(5), retry.WithInitialInterval(time.Millisecond))
+	var openErr *retry.OpenCircuitError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("expected an OpenCircuitError; got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected to stop once the circuit tripped; got %d calls", calls)
+	}
+	if !errors.Is(err, circuitry.ErrCircuitBreakerOpen) || openErr.LastErr == nil {
+		t.Fatalf("expected the error to wrap the open circuit and the last failure; got %v", err)
+	}
+	if !openErr.RetryAfter.After(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("expected to be able to retry after AllowAfter; got %v", openErr.RetryAfter)
+	}
+	if !strings.Contains(err.Error(), "retry after") {
+		t.Fatalf("expected the error to say when to retry; got %q", err)
+	}
+}
+
+func TestDoRejected(t *testing.T) {
+	ctx := context.Background()
+	t.Run("open", func(t *testing.T) {
+		breaker := newBreaker("open", circuitry.WithAllowAfter(time.Hour))
+		_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+		calls := 0
+		_, err := retry.Do(ctx, breaker, failing(0, &calls))
+		var openErr *retry.OpenCircuitError
+		if !errors.As(err, &openErr) || calls != 0 {
+			t.Fatalf("expected an OpenCircuitError without calling the work; got %v after %d calls", err, calls)
+		}
+		if openErr.Name != "open" || openErr.RetryAfter.IsZero() || openErr.LastErr != nil {
+			t.Fatalf("expected the circuit and when to retry; got %+v", openErr)
+		}
+	})
+	t.Run("too many requests", func(t *testing.T) {
+		breaker := newBreaker("half-open", circuitry.WithAllowAfter(time.Millisecond))
+		_, _, _ = breaker.Execute(ctx, func() (any, error) { return nil, errors.New("test") })
+		time.Sleep(5 * time.Millisecond)
+		calls := 0
+		_, err := retry.Do(ctx, breaker, failing(0, &calls))
+		var openErr *retry.OpenCircuitError
+		if !errors.As(err, &openErr) || !errors.Is(err, circuitry.ErrTooManyRequests) {
+			t.Fatalf("expected an OpenCircuitError for too many requests; got %v", err)
+		}
+		if !openErr.RetryAfter.IsZero() || strings.Contains(err.Error(), "retry after") {
+			t.Fatalf("expected no time to retry after for a half-open circuit; got %q", err)
+		}
+	})
+}
+
+func TestDoReturnsBackendErrors(t *testing.T) {
+	backendErr := errors.New("backend unavailable")
+	workErr := errors.New("test")
+	testCases := map[string]struct {
+		backend circuitrytest.ErroringInMemoryBackend
+		work    circuitry.WorkFn
+		calls   int
+	}{
+		"before the work": {
+			circuitrytest.ErroringInMemoryBackend{RetrieveError: backendErr},
+			func() (any, error) { return nil, nil },
+			0,
+		},
+		"after the work": {
+			circuitrytest.ErroringInMemoryBackend{StoreError: backendErr},
+			func() (any, error) { return nil, workErr },
+			1,
+		},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			breaker := newFactory(circuitry.WithStorageBackend(tc.backend)).BreakerFor(name, map[string]any{})
+			calls := 0
+			_, err := retry.Do(context.Background(), breaker, func() (any, error) {
+				calls++
+				return tc.work()
+			})
+			if !errors.Is(err, backendErr) || calls != tc.calls {
+				t.Fatalf("expected the backend error after %d calls; got %v after %d calls", tc.calls, err, calls)
+			}
+			if tc.calls > 0 && !errors.Is(err, workErr) {
+				t.Fatalf("expected the error to include the work's error; got %v", err)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenContextEnds(t *testing.T) {
+	breaker := newBreaker("TestDoStopsWhenContextEnds", circuitry.WithFailureCountThreshold(10))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := 0
+	_, err := retry.Do(ctx, breaker, failing(10, &calls), retry.WithInitialInterval(time.Hour))
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Fatalf("expected the context's error after 1 call; got %v after %d calls", err, calls)
+	}
+}
@@ -1,9 +1,14 @@
 package circuitry
 
 import (
+	"reflect"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
 	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
 )
 
 // NameFunc defines the signature of the function used to generate names for
@@ -23,7 +28,8 @@ type ExpectedErrorMatcherFunc func(err error) ExecutionStatus
 type WillTripFunc func(name string, configuredThreshold uint64, information CircuitInformation) bool
 
 // StateChangeFunc defines the signature of a function that allows the user to
-// define custom logic around the changing of [CircuitBreaker] state.
+// define custom logic around the changing of [CircuitBreaker] state. It is
+// called once the new state has been stored.
 type StateChangeFunc func(name string, circuitContext map[string]any, from, to CircuitState)
 
 // DefaultNameFunc is a default name implementation that simply uses the name of
@@ -59,6 +65,12 @@ func DefaultTripFunc(_ string, configuredThreshold uint64, information CircuitIn
 	return information.ConsecutiveFailures > configuredThreshold
 }
 
+// isDefaultTripFunc reports whether the function is DefaultTripFunc, whose
+// decision an [OutcomeRecorder] can make on its own
+func isDefaultTripFunc(tf WillTripFunc) bool {
+	return reflect.ValueOf(tf).Pointer() == reflect.ValueOf(DefaultTripFunc).Pointer()
+}
+
 // FactorySettings contains information for configuring a CircuitBreakerFactory and
 // any CircuitBreaker it creates.
 type FactorySettings struct {
@@ -73,6 +85,11 @@ type FactorySettings struct {
 	StateChangeCallback         StateChangeFunc                     // StateChangeCallback stores a callback for users to learn when the [CircuitBreaker] state is changing.
 	WillTripCircuit             WillTripFunc                        // WillTripCircuit provides a way to customize whether the [WillTripCircuit] will trip in conjunction with the [FailureCountThreshold].
 	Logger                      log.Logger                          // Logger allows the caller to specify a given logger to use for all [CircuitBreaker]s.
+	MetricsSink                 metrics.Sink                        // MetricsSink allows the caller to specify where metrics for all [CircuitBreaker]s are reported.
+	TracerProvider              trace.TracerProvider                // TracerProvider allows the caller to have spans created around [CircuitBreaker] executions and backend operations.
+	SubscriptionBufferSize      int                                 // SubscriptionBufferSize defines how many [TransitionEvent]s are buffered for each [Subscription]. If not specified, the default is [DefaultSubscriptionBufferSize].
+	BackendFailurePolicy        BackendFailurePolicy                // BackendFailurePolicy defines what [CircuitBreaker]s do when the StorageBackend is unavailable. If not specified, the default is [FailClosed].
+	BackendProbeInterval        time.Duration                       // BackendProbeInterval defines how often an unavailable StorageBackend is tried again when the BackendFailurePolicy is not [FailClosed]. If not specified, the default is [DefaultBackendProbeInterval].
 }
 
 // GenerateName builds a name for a [CircuitBreaker]
@@ -85,8 +102,13 @@ func (s *FactorySettings) GenerateName(circuit string, circuitContext map[string
 
 // circuitBreakerFor builds a [CircuitBreaker] from the settings configured
 // globally
-func (s *FactorySettings) circuitBreakerFor(circuit string, circuitContext map[string]any) CircuitBreaker {
-	name := s.GenerateName(circuit, circuitContext)
+func (s *FactorySettings) circuitBreakerFor(circuit string, circuitContext map[string]any) *circuitBreaker {
+	return s.namedCircuitBreaker(s.GenerateName(circuit, circuitContext), circuit, circuitContext)
+}
+
+// namedCircuitBreaker builds a [CircuitBreaker] for the circuit using the
+// name as is
+func (s *FactorySettings) namedCircuitBreaker(name, circuit string, circuitContext map[string]any) *circuitBreaker {
 	matcher, ok := s.CircuitSpecificErrorMatcher[circuit]
 	if !ok {
 		if s.FallbackErrorMatcher != nil {
@@ -99,10 +121,11 @@ func (s *FactorySettings) circuitBreakerFor(circuit string, circuitContext map[s
 	if tripper == nil {
 		tripper = DefaultTripFunc
 	}
-	logger := s.Logger
-	if logger == nil {
-		logger = &log.NoOp{}
+	tracerProvider := s.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
 	}
+	publisher, _ := s.StorageBackend.(TransitionPublisher)
 	return &circuitBreaker{
 		name:                  name,
 		storage:               s.StorageBackend,
@@ -115,10 +138,27 @@ func (s *FactorySettings) circuitBreakerFor(circuit string, circuitContext map[s
 		circuitContext:        circuitContext,
 		tripperFn:             tripper,
 		stateChangeFn:         s.StateChangeCallback,
-		logger:                logger,
+		logger:                s.logger(),
+		metrics:               s.metricsSink(),
+		tracer:                tracerProvider.Tracer(TracerName),
+		publisher:             publisher,
 	}
 }
 
+func (s *FactorySettings) logger() log.Logger {
+	if s.Logger == nil {
+		return &log.NoOp{}
+	}
+	return s.Logger
+}
+
+func (s *FactorySettings) metricsSink() metrics.Sink {
+	if s.MetricsSink == nil {
+		return &metrics.NoOp{}
+	}
+	return s.MetricsSink
+}
+
 // NewFactorySettings constructs a [FactorySettings] struct with the provided options
 func NewFactorySettings(opts ...SettingsOption) (*FactorySettings, error) {
 	s := &FactorySettings{CircuitSpecificErrorMatcher: make(map[string]ExpectedErrorMatcherFunc), AllowAfter: 0 * time.Second}
@@ -263,3 +303,55 @@ func WithLogger(l log.Logger) SettingsOption {
 		return nil
 	}
 }
+
+// WithMetricsSink configures the MetricsSink setting to report metrics to
+// the given sink as long as it implements the interface we expect
+func WithMetricsSink(sink metrics.Sink) SettingsOption {
+	return func(s *FactorySettings) error {
+		if s.MetricsSink != nil {
+			return ErrMetricsSinkAlreadySet
+		}
+		s.MetricsSink = sink
+		return nil
+	}
+}
+
+// WithTracerProvider configures the TracerProvider setting so that spans are
+// created around Start, the work function, End, and storage backend
+// operations
+func WithTracerProvider(tp trace.TracerProvider) SettingsOption {
+	return func(s *FactorySettings) error {
+		if s.TracerProvider != nil {
+			return ErrTracerProviderAlreadySet
+		}
+		s.TracerProvider = tp
+		return nil
+	}
+}
+
+// WithSubscriptionBufferSize configures the SubscriptionBufferSize setting
+// and always overrides it.
+func WithSubscriptionBufferSize(size int) SettingsOption {
+	return func(s *FactorySettings) error {
+		s.SubscriptionBufferSize = size
+		return nil
+	}
+}
+
+// WithBackendFailurePolicy configures the BackendFailurePolicy setting and
+// always overrides it.
+func WithBackendFailurePolicy(policy BackendFailurePolicy) SettingsOption {
+	return func(s *FactorySettings) error {
+		s.BackendFailurePolicy = policy
+		return nil
+	}
+}
+
+// WithBackendProbeInterval configures the BackendProbeInterval setting and
+// always overrides it.
+func WithBackendProbeInterval(interval time.Duration) SettingsOption {
+	return func(s *FactorySettings) error {
+		s.BackendProbeInterval = interval
+		return nil
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"testing"
 	"time"
 
+	"go.opentelemetry.io/otel/trace/noop"
+
 	"github.com/sigmavirus24/circuitry"
 	"github.com/sigmavirus24/circuitry/backends"
 	"github.com/sigmavirus24/circuitry/log"
+	"github.com/sigmavirus24/circuitry/metrics"
 )
 
 func TestDefaultNameFunc(t *testing.T) {
@@ -144,6 +147,16 @@ func TestBuildFactorySettingsWithConflictingOptions(t *testing.T) {
 			circuitry.ErrLoggerAlreadySet,
 			"ErrLoggerAlreadySet",
 		},
+		"metrics sink": {
+			circuitry.WithMetricsSink(&metrics.NoOp{}),
+			circuitry.ErrMetricsSinkAlreadySet,
+			"ErrMetricsSinkAlreadySet",
+		},
+		"tracer provider": {
+			circuitry.WithTracerProvider(noop.NewTracerProvider()),
+			circuitry.ErrTracerProviderAlreadySet,
+			"ErrTracerProviderAlreadySet",
+		},
 	}
 
 	for name, testCase := range testCases {
@@ -193,6 +206,34 @@ func TestWithCyclicClearAfter(t *testing.T) {
 	}
 }
 
+func TestWithSubscriptionBufferSize(t *testing.T) {
+	expected := 8
+	s, err := circuitry.NewFactorySettings(circuitry.WithSubscriptionBufferSize(expected))
+	if err != nil {
+		t.Fatalf("expected to not receive an error but got %v", err)
+	}
+	if s.SubscriptionBufferSize != expected {
+		t.Errorf("expected s.SubscriptionBufferSize == %d; got %d", expected, s.SubscriptionBufferSize)
+	}
+}
+
+func TestWithBackendFailurePolicy(t *testing.T) {
+	s, err := circuitry.NewFactorySettings(
+		circuitry.WithBackendFailurePolicy(circuitry.FailOpen),
+		circuitry.WithBackendFailurePolicy(circuitry.FallbackInMemory),
+		circuitry.WithBackendProbeInterval(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("expected to not receive an error but got %v", err)
+	}
+	if s.BackendFailurePolicy != circuitry.FallbackInMemory {
+		t.Errorf("expected s.BackendFailurePolicy == %s; got %s", circuitry.FallbackInMemory, s.BackendFailurePolicy)
+	}
+	if s.BackendProbeInterval != time.Second {
+		t.Errorf("expected s.BackendProbeInterval == %v; got %v", time.Second, s.BackendProbeInterval)
+	}
+}
+
 func TestWithCircuitSpecificErrorMatcher(t *testing.T) {
 	s, err := circuitry.NewFactorySettings(circuitry.WithCircuitSpecificErrorMatcher("circuit-a", circuitry.DefaultErrorMatcher), circuitry.WithCircuitSpecificErrorMatcher("circuit-b", func(err error) circuitry.ExecutionStatus {
 		return circuitry.ExecutionSucceeded
@@ -0,0 +1,231 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+)
+
+// errIsolationUnsupported mirrors the error database/sql returns when a
+// driver without BeginTx support is asked for non-default options
+var errIsolationUnsupported = errors.New("sqldriver: wrapped driver does not support non-default transaction options")
+
+type wrappedConn struct {
+	conn  driver.Conn
+	guard *guard
+}
+
+func (c *wrappedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if pc, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedStmt{stmt: stmt, guard: c.guard}, nil
+}
+
+func (c *wrappedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *wrappedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if bt, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = bt.BeginTx(ctx, opts)
+	} else {
+		if opts.Isolation != driver.IsolationLevel(0) || opts.ReadOnly {
+			return nil, errIsolationUnsupported
+		}
+		tx, err = c.conn.Begin() //nolint:staticcheck // Fallback for drivers without BeginTx
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedTx{ctx: context.WithoutCancel(ctx), tx: tx, guard: c.guard}, nil
+}
+
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var result driver.Result
+	err := c.guard.run(ctx, func() error {
+		var err error
+		result, err = execer.ExecContext(ctx, query, args)
+		return err
+	})
+	return result, err
+}
+
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var rows driver.Rows
+	err := c.guard.run(ctx, func() error {
+		var err error
+		rows, err = queryer.QueryContext(ctx, query, args)
+		return err
+	})
+	return rows, err
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+var _ driver.Conn = (*wrappedConn)(nil)
+var _ driver.ConnPrepareContext = (*wrappedConn)(nil)
+var _ driver.ConnBeginTx = (*wrappedConn)(nil)
+var _ driver.ExecerContext = (*wrappedConn)(nil)
+var _ driver.QueryerContext = (*wrappedConn)(nil)
+var _ driver.Pinger = (*wrappedConn)(nil)
+var _ driver.SessionResetter = (*wrappedConn)(nil)
+var _ driver.Validator = (*wrappedConn)(nil)
+var _ driver.NamedValueChecker = (*wrappedConn)(nil)
+
+type wrappedStmt struct {
+	stmt  driver.Stmt
+	guard *guard
+}
+
+func (s *wrappedStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *wrappedStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *wrappedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	var result driver.Result
+	err := s.guard.run(context.Background(), func() error {
+		var err error
+		result, err = s.stmt.Exec(args) //nolint:staticcheck // Required by driver.Stmt
+		return err
+	})
+	return result, err
+}
+
+func (s *wrappedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var rows driver.Rows
+	err := s.guard.run(context.Background(), func() error {
+		var err error
+		rows, err = s.stmt.Query(args) //nolint:staticcheck // Required by driver.Stmt
+		return err
+	})
+	return rows, err
+}
+
+func (s *wrappedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.stmt.(driver.StmtExecContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Exec(values)
+	}
+	var result driver.Result
+	err := s.guard.run(ctx, func() error {
+		var err error
+		result, err = execer.ExecContext(ctx, args)
+		return err
+	})
+	return result, err
+}
+
+func (s *wrappedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.stmt.(driver.StmtQueryContext)
+	if !ok {
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Query(values)
+	}
+	var rows driver.Rows
+	err := s.guard.run(ctx, func() error {
+		var err error
+		rows, err = queryer.QueryContext(ctx, args)
+		return err
+	})
+	return rows, err
+}
+
+var _ driver.Stmt = (*wrappedStmt)(nil)
+var _ driver.StmtExecContext = (*wrappedStmt)(nil)
+var _ driver.StmtQueryContext = (*wrappedStmt)(nil)
+
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sqldriver: wrapped driver does not support the use of Named Parameters")
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
+
+type wrappedTx struct {
+	ctx   context.Context
+	tx    driver.Tx
+	guard *guard
+}
+
+// Commit runs the commit through the circuit breaker using the values from the
+// context the transaction was started with as driver.Tx does not accept one
+func (t *wrappedTx) Commit() error {
+	return t.guard.run(t.ctx, t.tx.Commit)
+}
+
+func (t *wrappedTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+var _ driver.Tx = (*wrappedTx)(nil)
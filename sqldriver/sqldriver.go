@@ -0,0 +1,248 @@
+// Package sqldriver wraps [database/sql/driver] implementations so that
+// database calls made through [database/sql] are protected by a named
+// [github.com/sigmavirus24/circuitry.CircuitBreaker] without needing to change
+// every call site.
+//
+// Connection acquisition, Exec, Query, and transaction commits are run
+// through the circuit breaker. When the circuit is open, callers receive an
+// [*OpenCircuitError] which wraps
+// [github.com/sigmavirus24/circuitry.ErrCircuitBreakerOpen] (or
+// [github.com/sigmavirus24/circuitry.ErrTooManyRequests] when half-open).
+package sqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/sigmavirus24/circuitry"
+)
+
+// ClassifierFunc determines whether an error returned by the wrapped driver
+// should count as a failure for the circuit breaker
+type ClassifierFunc func(err error) circuitry.ExecutionStatus
+
+type timeouter interface {
+	Timeout() bool
+}
+
+// DefaultClassifier treats [database/sql/driver.ErrBadConn], timeouts, and
+// network errors as failures. Any other error, such as a constraint
+// violation or a syntax error, is the caller's and is treated as expected so
+// that a buggy query cannot trip the circuit.
+func DefaultClassifier(err error) circuitry.ExecutionStatus {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return circuitry.ExecutionSucceeded
+	case errors.Is(err, driver.ErrBadConn), IsTimeout(err), errors.As(err, &netErr):
+		return circuitry.ExecutionFailed
+	default:
+		return circuitry.ExecutionSucceeded
+	}
+}
+
+// IsTimeout reports whether the error represents a timeout
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var t timeouter
+	return errors.As(err, &t) && t.Timeout()
+}
+
+// OpenCircuitError is returned to [database/sql] callers when the circuit
+// breaker refuses to allow the call to the database
+type OpenCircuitError struct {
+	Name string
+	Err  error
+}
+
+func (e *OpenCircuitError) Error() string {
+	return fmt.Sprintf("database circuit %q rejected call: %s", e.Name, e.Err)
+}
+
+func (e *OpenCircuitError) Unwrap() error {
+	return e.Err
+}
+
+var _ error = (*OpenCircuitError)(nil)
+
+type options struct {
+	circuitContext map[string]any
+	classifier     ClassifierFunc
+	onBreakerError func(error)
+}
+
+// Option configures how the driver is wrapped
+type Option func(*options)
+
+// WithCircuitContext provides the circuit context passed to
+// [github.com/sigmavirus24/circuitry.CircuitBreakerFactory].BreakerFor
+func WithCircuitContext(circuitContext map[string]any) Option {
+	return func(o *options) {
+		o.circuitContext = circuitContext
+	}
+}
+
+// WithClassifier overrides the [DefaultClassifier]
+func WithClassifier(classifier ClassifierFunc) Option {
+	return func(o *options) {
+		o.classifier = classifier
+	}
+}
+
+// WithBreakerErrorHandler configures a function to be called when the
+// circuit breaker cannot record the outcome of a call (e.g., the storage
+// backend is unavailable). The database call itself has already completed at
+// that point so the error is not returned to the caller.
+func WithBreakerErrorHandler(handler func(error)) Option {
+	return func(o *options) {
+		o.onBreakerError = handler
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		circuitContext: map[string]any{},
+		classifier:     DefaultClassifier,
+		onBreakerError: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// guard runs calls through a freshly created CircuitBreaker. A new breaker is
+// created for every call as CircuitBreakers may not be started concurrently.
+type guard struct {
+	factory *circuitry.CircuitBreakerFactory
+	name    string
+	opts    *options
+}
+
+func (g *guard) run(ctx context.Context, fn func() error) error {
+	breaker := g.factory.BreakerFor(g.name, g.opts.circuitContext)
+	if err := breaker.Start(ctx); err != nil {
+		if errors.Is(err, circuitry.ErrCircuitBreakerOpen) || errors.Is(err, circuitry.ErrTooManyRequests) {
+			return &OpenCircuitError{Name: breaker.Name(), Err: err}
+		}
+		return err
+	}
+	workErr := fn()
+	reported := workErr
+	if workErr != nil && g.opts.classifier(workErr) == circuitry.ExecutionSucceeded {
+		reported = circuitry.WrapExpectedConditionError(workErr)
+	}
+	if err := breaker.End(ctx, reported); err != nil {
+		g.opts.onBreakerError(err)
+	}
+	return workErr
+}
+
+// Connector wraps a [database/sql/driver.Connector] so that connections are
+// acquired through a circuit breaker
+type Connector struct {
+	connector driver.Connector
+	driver    *Driver
+	guard     *guard
+}
+
+// NewConnector wraps the connector so that all calls are protected by the
+// circuit breaker with the given name built from the factory. The result can
+// be passed to [database/sql.OpenDB].
+func NewConnector(connector driver.Connector, factory *circuitry.CircuitBreakerFactory, name string, opts ...Option) *Connector {
+	g := &guard{factory: factory, name: name, opts: newOptions(opts)}
+	return &Connector{
+		connector: connector,
+		driver:    &Driver{driver: connector.Driver(), guard: g},
+		guard:     g,
+	}
+}
+
+// Connect acquires a new connection through the circuit breaker
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	err := c.guard.run(ctx, func() error {
+		var err error
+		conn, err = c.connector.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn: conn, guard: c.guard}, nil
+}
+
+// Driver returns the wrapped [database/sql/driver.Driver]
+func (c *Connector) Driver() driver.Driver {
+	return c.driver
+}
+
+var _ driver.Connector = (*Connector)(nil)
+
+// Driver wraps a [database/sql/driver.Driver] so that connections are opened
+// through a circuit breaker
+type Driver struct {
+	driver driver.Driver
+	guard  *guard
+}
+
+// Wrap wraps the driver so that all calls are protected by the circuit
+// breaker with the given name built from the factory. The result can be
+// registered with [database/sql.Register].
+func Wrap(d driver.Driver, factory *circuitry.CircuitBreakerFactory, name string, opts ...Option) *Driver {
+	return &Driver{
+		driver: d,
+		guard:  &guard{factory: factory, name: name, opts: newOptions(opts)},
+	}
+}
+
+// Open opens a new connection through the circuit breaker
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	var conn driver.Conn
+	err := d.guard.run(context.Background(), func() error {
+		var err error
+		conn, err = d.driver.Open(dsn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedConn{conn: conn, guard: d.guard}, nil
+}
+
+// OpenConnector implements [database/sql/driver.DriverContext]. If the
+// wrapped driver does not implement it, the returned Connector opens
+// connections using Open.
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	var connector driver.Connector = dsnConnector{dsn: dsn, driver: d.driver}
+	if dc, ok := d.driver.(driver.DriverContext); ok {
+		var err error
+		connector, err = dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Connector{connector: connector, driver: d, guard: d.guard}, nil
+}
+
+var _ driver.Driver = (*Driver)(nil)
+var _ driver.DriverContext = (*Driver)(nil)
+
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,562 @@
+package sqldriver_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+	"github.com/sigmavirus24/circuitry/sqldriver"
+)
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 1, nil }
+
+type fakeRows struct{ remaining int }
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.remaining == 0 {
+		return io.EOF
+	}
+	r.remaining--
+	dest[0] = int64(1)
+	return nil
+}
+
+type fakeTx struct{ commitErr error }
+
+func (t *fakeTx) Commit() error   { return t.commitErr }
+func (t *fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct{ err error }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(_ []driver.Value) (driver.Result, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return fakeResult{}, nil
+}
+func (s *fakeStmt) Query(_ []driver.Value) (driver.Rows, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &fakeRows{remaining: 1}, nil
+}
+
+// ctxStmt implements the optional context interfaces for statements
+type ctxStmt struct{ fakeStmt }
+
+func (s *ctxStmt) ExecContext(_ context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Exec(nil)
+}
+
+func (s *ctxStmt) QueryContext(_ context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.Query(nil)
+}
+
+// basicConn only implements driver.Conn forcing database/sql to use
+// prepared statements and Begin
+type basicConn struct {
+	err       error
+	commitErr error
+}
+
+func (c *basicConn) Prepare(_ string) (driver.Stmt, error) { return &fakeStmt{err: c.err}, nil }
+func (c *basicConn) Close() error                          { return nil }
+func (c *basicConn) Begin() (driver.Tx, error)             { return &fakeTx{commitErr: c.commitErr}, nil }
+
+// fullConn implements the optional context interfaces
+type fullConn struct {
+	basicConn
+	execErr  error
+	queryErr error
+	execs    atomic.Int64
+}
+
+func (c *fullConn) ExecContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	c.execs.Add(1)
+	if c.execErr != nil {
+		return nil, c.execErr
+	}
+	return fakeResult{}, nil
+}
+
+func (c *fullConn) QueryContext(_ context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	if c.queryErr != nil {
+		return nil, c.queryErr
+	}
+	return &fakeRows{remaining: 1}, nil
+}
+
+func (c *fullConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	return &fakeTx{commitErr: c.commitErr}, nil
+}
+
+func (c *fullConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	if query == "" {
+		return nil, errors.New("empty query")
+	}
+	return &ctxStmt{fakeStmt{err: c.err}}, nil
+}
+
+type fakeDriver struct {
+	conn    driver.Conn
+	openErr error
+}
+
+func (d *fakeDriver) Open(_ string) (driver.Conn, error) {
+	if d.openErr != nil {
+		return nil, d.openErr
+	}
+	return d.conn, nil
+}
+
+type fakeConnector struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConnector) Connect(_ context.Context) (driver.Conn, error) { return c.driver.Open("") }
+func (c *fakeConnector) Driver() driver.Driver                          { return c.driver }
+
+type fakeDriverContext struct {
+	fakeDriver
+	openConnectorErr error
+}
+
+func (d *fakeDriverContext) OpenConnector(_ string) (driver.Connector, error) {
+	if d.openConnectorErr != nil {
+		return nil, d.openConnectorErr
+	}
+	return &fakeConnector{&d.fakeDriver}, nil
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string { return "i/o timeout" }
+func (timeoutErr) Timeout() bool { return true }
+
+func newFactory(t *testing.T, opts ...circuitry.SettingsOption) *circuitry.CircuitBreakerFactory {
+	t.Helper()
+	opts = append(opts, circuitry.WithAllowAfter(time.Hour))
+	settings, err := circuitry.NewFactorySettings(opts...)
+	if err != nil {
+		t.Fatalf("expected NewFactorySettings to succeed; got %v", err)
+	}
+	return circuitry.NewCircuitBreakerFactory(settings)
+}
+
+func information(t *testing.T, factory *circuitry.CircuitBreakerFactory, name string) circuitry.CircuitInformation {
+	t.Helper()
+	ci, err := factory.BreakerFor(name, map[string]any{}).Information(context.TODO())
+	if err != nil {
+		t.Fatalf("expected to retrieve circuit information; got %v", err)
+	}
+	return ci
+}
+
+func TestDefaultClassifier(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected circuitry.ExecutionStatus
+	}{
+		"nil":                  {nil, circuitry.ExecutionSucceeded},
+		"skip":                 {driver.ErrSkip, circuitry.ExecutionSucceeded},
+		"bad conn":             {driver.ErrBadConn, circuitry.ExecutionFailed},
+		"wrapped bad conn":     {fmt.Errorf("exec: %w", driver.ErrBadConn), circuitry.ExecutionFailed},
+		"deadline exceeded":    {context.DeadlineExceeded, circuitry.ExecutionFailed},
+		"os deadline":          {os.ErrDeadlineExceeded, circuitry.ExecutionFailed},
+		"net timeout":          {timeoutErr{}, circuitry.ExecutionFailed},
+		"connection refused":   {&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, circuitry.ExecutionFailed},
+		"constraint violation": {errors.New("duplicate key value violates unique constraint"), circuitry.ExecutionSucceeded},
+		"syntax error":         {errors.New("syntax error at or near \"SELEC\""), circuitry.ExecutionSucceeded},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			if status := sqldriver.DefaultClassifier(tc.err); status != tc.expected {
+				t.Fatalf("expected DefaultClassifier(%v) = %s; got %s", tc.err, tc.expected, status)
+			}
+		})
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	if sqldriver.IsTimeout(errors.New("not a timeout")) {
+		t.Fatal("expected plain error not to be a timeout")
+	}
+	if !sqldriver.IsTimeout(fmt.Errorf("wrapped: %w", timeoutErr{})) {
+		t.Fatal("expected wrapped timeout error to be a timeout")
+	}
+}
+
+func TestConnectorTripsOnBadConn(t *testing.T) {
+	conn := &fullConn{execErr: driver.ErrBadConn}
+	// database/sql reconnects after driver.ErrBadConn so consecutive failures
+	// are interleaved with successful connections
+	tripper := func(_ string, threshold uint64, ci circuitry.CircuitInformation) bool {
+		return ci.TotalFailures >= threshold
+	}
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(2), circuitry.WithTripFunc(tripper))
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: conn}}, factory, "db"))
+	defer db.Close()
+
+	var openErr *sqldriver.OpenCircuitError
+	for i := 0; i < 5 && openErr == nil; i++ {
+		_, err := db.ExecContext(context.TODO(), "UPDATE things SET a = 1")
+		if err == nil {
+			t.Fatal("expected ExecContext to fail")
+		}
+		errors.As(err, &openErr)
+	}
+	if openErr == nil {
+		t.Fatal("expected circuit to open after repeated driver.ErrBadConn")
+	}
+	if !errors.Is(openErr, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected OpenCircuitError to wrap ErrCircuitBreakerOpen; got %v", openErr.Err)
+	}
+	if openErr.Name != "db" {
+		t.Fatalf("expected OpenCircuitError.Name = db; got %q", openErr.Name)
+	}
+	if openErr.Error() == "" {
+		t.Fatal("expected OpenCircuitError to have a message")
+	}
+	execs := conn.execs.Load()
+	if _, err := db.ExecContext(context.TODO(), "UPDATE things SET a = 1"); !errors.As(err, &openErr) {
+		t.Fatalf("expected OpenCircuitError; got %v", err)
+	}
+	if conn.execs.Load() != execs {
+		t.Fatal("expected no calls to reach the driver while the circuit is open")
+	}
+}
+
+func TestConnectorExpectedErrors(t *testing.T) {
+	constraintErr := errors.New("duplicate key value violates unique constraint")
+	conn := &fullConn{execErr: constraintErr}
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(1))
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: conn}}, factory, "db"))
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.ExecContext(context.TODO(), "INSERT INTO things VALUES (1)"); !errors.Is(err, constraintErr) {
+			t.Fatalf("expected the constraint violation to be returned to the caller; got %v", err)
+		}
+	}
+	ci := information(t, factory, "db")
+	if ci.State != circuitry.CircuitClosed || ci.TotalFailures != 0 {
+		t.Fatalf("expected a constraint violation not to count as a failure; got %+v", ci)
+	}
+}
+
+func TestConnectorCustomClassifier(t *testing.T) {
+	conn := &fullConn{queryErr: errors.New("unique constraint violated")}
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(1))
+	classifier := func(err error) circuitry.ExecutionStatus {
+		if err != nil {
+			return circuitry.ExecutionFailed
+		}
+		return circuitry.ExecutionSucceeded
+	}
+	db := sql.OpenDB(sqldriver.NewConnector(
+		&fakeConnector{&fakeDriver{conn: conn}},
+		factory,
+		"db",
+		sqldriver.WithClassifier(classifier),
+		sqldriver.WithCircuitContext(map[string]any{"tenant": "a"}),
+	))
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.QueryContext(context.TODO(), "SELECT 1"); err == nil {
+			t.Fatal("expected QueryContext to return the driver error")
+		}
+	}
+	// the failed queries trip the circuit
+	if ci := information(t, factory, "db"); ci.State != circuitry.CircuitOpen {
+		t.Fatalf("expected custom classifier to count the constraint violation as a failure; got %+v", ci)
+	}
+}
+
+func TestConnectorQueryAndCommit(t *testing.T) {
+	conn := &fullConn{}
+	factory := newFactory(t, backends.WithInMemoryBackend())
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: conn}}, factory, "db"))
+	defer db.Close()
+
+	var value int64
+	if err := db.QueryRowContext(context.TODO(), "SELECT 1").Scan(&value); err != nil || value != 1 {
+		t.Fatalf("expected to query a single row; got value = %d, err = %v", value, err)
+	}
+	tx, err := db.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("expected to begin transaction; got %v", err)
+	}
+	if _, err := tx.ExecContext(context.TODO(), "INSERT INTO things VALUES (1)"); err != nil {
+		t.Fatalf("expected ExecContext in transaction to succeed; got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected Commit to succeed; got %v", err)
+	}
+	if err := db.PingContext(context.TODO()); err != nil {
+		t.Fatalf("expected Ping to succeed; got %v", err)
+	}
+	// connect, query, exec, commit
+	if ci := information(t, factory, "db"); ci.TotalSuccesses != 4 {
+		t.Fatalf("expected 4 successful calls through the circuit breaker; got %+v", ci)
+	}
+}
+
+func TestConnectorFailedCommit(t *testing.T) {
+	conn := &fullConn{basicConn: basicConn{commitErr: driver.ErrBadConn}}
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(10))
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: conn}}, factory, "db"))
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("expected to begin transaction; got %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected Commit to fail with driver.ErrBadConn; got %v", err)
+	}
+	if ci := information(t, factory, "db"); ci.TotalFailures != 1 {
+		t.Fatalf("expected failed commit to be recorded; got %+v", ci)
+	}
+}
+
+func TestConnectorConnectFailure(t *testing.T) {
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(1))
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{openErr: timeoutErr{}}}, factory, "db"))
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := db.PingContext(context.TODO()); !errors.Is(err, timeoutErr{}) {
+			t.Fatalf("expected connection timeout; got %v", err)
+		}
+	}
+	var openErr *sqldriver.OpenCircuitError
+	if err := db.PingContext(context.TODO()); !errors.As(err, &openErr) {
+		t.Fatalf("expected OpenCircuitError when connecting to an open circuit; got %v", err)
+	}
+}
+
+func TestConnectorBackendErrors(t *testing.T) {
+	retrieveErr := errors.New("backend unavailable")
+	factory := newFactory(t, circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{RetrieveError: retrieveErr}))
+	db := sql.OpenDB(sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: &fullConn{}}}, factory, "db"))
+	defer db.Close()
+	if err := db.PingContext(context.TODO()); !errors.Is(err, retrieveErr) {
+		t.Fatalf("expected backend error to be returned; got %v", err)
+	}
+
+	storeErr := errors.New("cannot store")
+	var handled error
+	factory = newFactory(t, circuitry.WithStorageBackend(circuitrytest.ErroringInMemoryBackend{StoreError: storeErr}))
+	db = sql.OpenDB(sqldriver.NewConnector(
+		&fakeConnector{&fakeDriver{conn: &fullConn{}}},
+		factory,
+		"db",
+		sqldriver.WithBreakerErrorHandler(func(err error) { handled = err }),
+	))
+	defer db.Close()
+	if err := db.PingContext(context.TODO()); err != nil {
+		t.Fatalf("expected store errors not to be returned to the caller; got %v", err)
+	}
+	if !errors.Is(handled, storeErr) {
+		t.Fatalf("expected store error to be passed to the handler; got %v", handled)
+	}
+}
+
+func TestWrapDriverPreparedStatements(t *testing.T) {
+	factory := newFactory(t, backends.WithInMemoryBackend())
+	drv := sqldriver.Wrap(&fakeDriver{conn: &basicConn{}}, factory, "wrapped")
+	sql.Register("circuitry-basic", drv)
+	db, err := sql.Open("circuitry-basic", "dsn")
+	if err != nil {
+		t.Fatalf("expected sql.Open to succeed; got %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(context.TODO(), "UPDATE things SET a = ?", 1); err != nil {
+		t.Fatalf("expected prepared ExecContext to succeed; got %v", err)
+	}
+	rows, err := db.QueryContext(context.TODO(), "SELECT a FROM things WHERE b = ?", 2)
+	if err != nil {
+		t.Fatalf("expected prepared QueryContext to succeed; got %v", err)
+	}
+	_ = rows.Close()
+	if _, err := db.ExecContext(context.TODO(), "UPDATE things SET a = :a", sql.Named("a", 1)); err == nil {
+		t.Fatal("expected named parameters to be rejected")
+	}
+	tx, err := db.BeginTx(context.TODO(), &sql.TxOptions{ReadOnly: true})
+	if err == nil {
+		_ = tx.Rollback()
+		t.Fatal("expected read-only transaction to be rejected by a driver without BeginTx")
+	}
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("expected Begin to succeed; got %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("expected Commit to succeed; got %v", err)
+	}
+	// open, exec, query, commit
+	if ci := information(t, factory, "wrapped"); ci.TotalSuccesses != 4 {
+		t.Fatalf("expected 4 successful calls through the circuit breaker; got %+v", ci)
+	}
+}
+
+func TestWrapDriverStatementFailures(t *testing.T) {
+	factory := newFactory(t, backends.WithInMemoryBackend(), circuitry.WithFailureCountThreshold(10))
+	conn := &fullConn{basicConn: basicConn{err: timeoutErr{}}}
+	drv := sqldriver.Wrap(&fakeDriver{conn: conn}, factory, "stmt")
+	connector, err := drv.OpenConnector("dsn")
+	if err != nil {
+		t.Fatalf("expected OpenConnector to succeed; got %v", err)
+	}
+	if connector.Driver() != drv {
+		t.Fatal("expected Connector.Driver() to return the wrapping driver")
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	stmt, err := db.PrepareContext(context.TODO(), "SELECT a FROM things WHERE b = ?")
+	if err != nil {
+		t.Fatalf("expected PrepareContext to succeed; got %v", err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.ExecContext(context.TODO(), 1); !errors.Is(err, timeoutErr{}) {
+		t.Fatalf("expected statement Exec to time out; got %v", err)
+	}
+	if _, err := stmt.QueryContext(context.TODO(), 1); !errors.Is(err, timeoutErr{}) {
+		t.Fatalf("expected statement Query to time out; got %v", err)
+	}
+	if _, err := db.PrepareContext(context.TODO(), ""); err == nil {
+		t.Fatal("expected PrepareContext error to be returned")
+	}
+	if ci := information(t, factory, "stmt"); ci.TotalFailures != 2 {
+		t.Fatalf("expected 2 failed calls through the circuit breaker; got %+v", ci)
+	}
+}
+
+func TestWrapDriverContext(t *testing.T) {
+	factory := newFactory(t, backends.WithInMemoryBackend())
+	openErr := errors.New("invalid dsn")
+	drv := sqldriver.Wrap(&fakeDriverContext{openConnectorErr: openErr}, factory, "ctx")
+	if _, err := drv.OpenConnector("dsn"); !errors.Is(err, openErr) {
+		t.Fatalf("expected OpenConnector error to be returned; got %v", err)
+	}
+
+	drv = sqldriver.Wrap(&fakeDriverContext{fakeDriver: fakeDriver{conn: &fullConn{}}}, factory, "ctx")
+	connector, err := drv.OpenConnector("dsn")
+	if err != nil {
+		t.Fatalf("expected OpenConnector to succeed; got %v", err)
+	}
+	conn, err := connector.Connect(context.TODO())
+	if err != nil {
+		t.Fatalf("expected Connect to succeed; got %v", err)
+	}
+	defer conn.Close()
+	if p, ok := conn.(driver.Pinger); !ok || p.Ping(context.TODO()) != nil {
+		t.Fatal("expected wrapped connection to support Ping")
+	}
+	if r, ok := conn.(driver.SessionResetter); !ok || r.ResetSession(context.TODO()) != nil {
+		t.Fatal("expected wrapped connection to support ResetSession")
+	}
+	if v, ok := conn.(driver.Validator); !ok || !v.IsValid() {
+		t.Fatal("expected wrapped connection to be valid")
+	}
+	if c, ok := conn.(driver.NamedValueChecker); !ok || !errors.Is(c.CheckNamedValue(&driver.NamedValue{}), driver.ErrSkip) {
+		t.Fatal("expected wrapped connection to defer named value checks")
+	}
+
+	if _, err := drv.Open("dsn"); err != nil {
+		t.Fatalf("expected Open to succeed; got %v", err)
+	}
+	drv = sqldriver.Wrap(&fakeDriver{openErr: driver.ErrBadConn}, factory, "ctx")
+	if _, err := drv.Open("dsn"); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected Open to fail with driver.ErrBadConn; got %v", err)
+	}
+}
+
+type passthroughConn struct {
+	fullConn
+	pingErr  error
+	resetErr error
+	valid    bool
+	checkErr error
+}
+
+func (c *passthroughConn) Ping(_ context.Context) error               { return c.pingErr }
+func (c *passthroughConn) ResetSession(_ context.Context) error       { return c.resetErr }
+func (c *passthroughConn) IsValid() bool                              { return c.valid }
+func (c *passthroughConn) CheckNamedValue(_ *driver.NamedValue) error { return c.checkErr }
+
+func TestConnPassthrough(t *testing.T) {
+	pingErr := errors.New("ping")
+	resetErr := errors.New("reset")
+	checkErr := errors.New("check")
+	factory := newFactory(t, backends.WithInMemoryBackend())
+	connector := sqldriver.NewConnector(&fakeConnector{&fakeDriver{conn: &passthroughConn{
+		pingErr:  pingErr,
+		resetErr: resetErr,
+		checkErr: checkErr,
+	}}}, factory, "passthrough")
+	conn, err := connector.Connect(context.TODO())
+	if err != nil {
+		t.Fatalf("expected Connect to succeed; got %v", err)
+	}
+	if err := conn.(driver.Pinger).Ping(context.TODO()); !errors.Is(err, pingErr) {
+		t.Fatalf("expected Ping error to pass through; got %v", err)
+	}
+	if err := conn.(driver.SessionResetter).ResetSession(context.TODO()); !errors.Is(err, resetErr) {
+		t.Fatalf("expected ResetSession error to pass through; got %v", err)
+	}
+	if conn.(driver.Validator).IsValid() {
+		t.Fatal("expected IsValid to pass through")
+	}
+	if err := conn.(driver.NamedValueChecker).CheckNamedValue(&driver.NamedValue{}); !errors.Is(err, checkErr) {
+		t.Fatalf("expected CheckNamedValue error to pass through; got %v", err)
+	}
+	stmt, err := conn.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatalf("expected Prepare to succeed; got %v", err)
+	}
+	if stmt.NumInput() != -1 {
+		t.Fatalf("expected NumInput to pass through; got %d", stmt.NumInput())
+	}
+	if _, err := stmt.Exec(nil); err != nil { //nolint:staticcheck // Testing the deprecated interface
+		t.Fatalf("expected Exec to succeed; got %v", err)
+	}
+	if _, err := stmt.Query(nil); err != nil { //nolint:staticcheck // Testing the deprecated interface
+		t.Fatalf("expected Query to succeed; got %v", err)
+	}
+	if err := stmt.Close(); err != nil {
+		t.Fatalf("expected Close to succeed; got %v", err)
+	}
+	tx, err := conn.Begin() //nolint:staticcheck // Testing the deprecated interface
+	if err != nil {
+		t.Fatalf("expected Begin to succeed; got %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("expected Rollback to succeed; got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("expected Close to succeed; got %v", err)
+	}
+}
@@ -30,6 +30,17 @@ func (cs CircuitState) String() string {
 	}
 }
 
+// ParseCircuitState returns the [CircuitState] whose String method returns s
+// or [ErrInvalidCircuitState]
+func ParseCircuitState(s string) (CircuitState, error) {
+	for _, state := range []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+	return CircuitClosed, fmt.Errorf("%w: %q", ErrInvalidCircuitState, s)
+}
+
 // ExecutionStatus describes the status of a given execution
 type ExecutionStatus uint32
 
@@ -2,6 +2,7 @@ package circuitry_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -32,6 +33,34 @@ func TestCircuitStateStringer(t *testing.T) {
 	}
 }
 
+func TestParseCircuitState(t *testing.T) {
+	testCases := map[string]struct {
+		expected circuitry.CircuitState
+		valid    bool
+	}{
+		"closed":    {circuitry.CircuitClosed, true},
+		"open":      {circuitry.CircuitOpen, true},
+		"half-open": {circuitry.CircuitHalfOpen, true},
+		"invalid":   {circuitry.CircuitClosed, false},
+	}
+
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			actual, err := circuitry.ParseCircuitState(name)
+			if tc.valid && err != nil {
+				t.Fatalf("expected to parse %q; got %v", name, err)
+			}
+			if !tc.valid && !errors.Is(err, circuitry.ErrInvalidCircuitState) {
+				t.Fatalf("expected err = %v; got %v", circuitry.ErrInvalidCircuitState, err)
+			}
+			if actual != tc.expected {
+				t.Fatalf("expected %s; got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func TestExecutionStatusStringer(t *testing.T) {
 	var _ fmt.Stringer = (*circuitry.ExecutionStatus)(nil)
 	testCases := []struct {
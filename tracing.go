@@ -0,0 +1,47 @@
+package circuitry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the [go.opentelemetry.io/otel/trace.Tracer]
+// circuitry requests from the configured TracerProvider
+const TracerName = "github.com/sigmavirus24/circuitry"
+
+// Attribute keys used to annotate spans created by a [CircuitBreaker]
+const (
+	AttributeCircuitName     = attribute.Key("circuitry.circuit.name")
+	AttributeStateBefore     = attribute.Key("circuitry.state.before")
+	AttributeStateAfter      = attribute.Key("circuitry.state.after")
+	AttributeGeneration      = attribute.Key("circuitry.generation")
+	AttributeRejectionReason = attribute.Key("circuitry.rejection.reason")
+	AttributeExecutionStatus = attribute.Key("circuitry.execution.status")
+)
+
+// StateTransitionEventName is the name of the span event recorded when a
+// [CircuitBreaker] changes state
+const StateTransitionEventName = "circuitry.state_transition"
+
+func (cb *circuitBreaker) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return cb.tracer.Start(ctx, name, trace.WithAttributes(AttributeCircuitName.String(cb.name)))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func recordTransition(ctx context.Context, from, to CircuitState, generation uint64) {
+	trace.SpanFromContext(ctx).AddEvent(StateTransitionEventName, trace.WithAttributes(
+		AttributeStateBefore.String(from.String()),
+		AttributeStateAfter.String(to.String()),
+		AttributeGeneration.Int64(int64(generation)), //nolint:gosec // Generations will not overflow an int64
+	))
+}
@@ -0,0 +1,158 @@
+package circuitry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/sigmavirus24/circuitry"
+	"github.com/sigmavirus24/circuitry/backends"
+	"github.com/sigmavirus24/circuitry/circuitrytest"
+)
+
+func newTracedFactory(opts ...circuitry.SettingsOption) (*tracetest.InMemoryExporter, *circuitry.CircuitBreakerFactory) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return exporter, newFactory(append(opts, circuitry.WithTracerProvider(tp))...)
+}
+
+func spansByName(spans tracetest.SpanStubs) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub, len(spans))
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	return byName
+}
+
+func attributeValue(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingExecute(t *testing.T) {
+	exporter, factory := newTracedFactory(backends.WithInMemoryBackend())
+	breaker := factory.BreakerFor("TestTracingExecute", map[string]any{})
+	if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("couldn't execute work function; got %v", err)
+	}
+
+	spans := spansByName(exporter.GetSpans())
+	expectedParents := map[string]string{
+		"circuitry.Start":            "circuitry.Execute",
+		"circuitry.Work":             "circuitry.Execute",
+		"circuitry.End":              "circuitry.Execute",
+		"circuitry.backend.Lock":     "circuitry.Start",
+		"circuitry.backend.Retrieve": "circuitry.Start",
+		"circuitry.backend.Store":    "circuitry.End",
+	}
+	if _, ok := spans["circuitry.Execute"]; !ok {
+		t.Fatalf("expected a circuitry.Execute span; got %v", exporter.GetSpans().Snapshots())
+	}
+	for name, parent := range expectedParents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %s span but it was not recorded", name)
+		}
+		if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Fatalf("expected %s to be a child of %s", name, parent)
+		}
+		if value, _ := attributeValue(span.Attributes, circuitry.AttributeCircuitName); value.AsString() != "TestTracingExecute" {
+			t.Fatalf("expected %s to have the circuit name attribute; got %v", name, span.Attributes)
+		}
+	}
+	end := spans["circuitry.End"]
+	if value, _ := attributeValue(end.Attributes, circuitry.AttributeExecutionStatus); value.AsString() != circuitry.ExecutionSucceeded.String() {
+		t.Fatalf("expected End span to record the execution status; got %v", end.Attributes)
+	}
+	if value, _ := attributeValue(end.Attributes, circuitry.AttributeStateAfter); value.AsString() != circuitry.CircuitClosed.String() {
+		t.Fatalf("expected End span to record the state after; got %v", end.Attributes)
+	}
+}
+
+func TestTracingStateTransition(t *testing.T) {
+	exporter, factory := newTracedFactory(backends.WithInMemoryBackend(), circuitry.WithAllowAfter(time.Hour))
+	breaker := factory.BreakerFor("TestTracingStateTransition", map[string]any{})
+	workErr := errors.New("test")
+	if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, workErr }); err != nil {
+		t.Fatalf("couldn't execute work function; got %v", err)
+	}
+
+	spans := spansByName(exporter.GetSpans())
+	work := spans["circuitry.Work"]
+	if work.Status.Code != codes.Error || len(work.Events) != 1 {
+		t.Fatalf("expected Work span to record the work error; got status = %+v, events = %+v", work.Status, work.Events)
+	}
+	end := spans["circuitry.End"]
+	if value, _ := attributeValue(end.Attributes, circuitry.AttributeStateBefore); value.AsString() != circuitry.CircuitClosed.String() {
+		t.Fatalf("expected End span state before = closed; got %v", end.Attributes)
+	}
+	if value, _ := attributeValue(end.Attributes, circuitry.AttributeStateAfter); value.AsString() != circuitry.CircuitOpen.String() {
+		t.Fatalf("expected End span state after = open; got %v", end.Attributes)
+	}
+	if len(end.Events) != 1 || end.Events[0].Name != circuitry.StateTransitionEventName {
+		t.Fatalf("expected End span to have a state transition event; got %+v", end.Events)
+	}
+	if value, _ := attributeValue(end.Events[0].Attributes, circuitry.AttributeStateAfter); value.AsString() != circuitry.CircuitOpen.String() {
+		t.Fatalf("expected transition event to record the new state; got %v", end.Events[0].Attributes)
+	}
+	if value, _ := attributeValue(end.Events[0].Attributes, circuitry.AttributeGeneration); value.AsInt64() != 1 {
+		t.Fatalf("expected transition event to record the generation; got %v", end.Events[0].Attributes)
+	}
+
+	exporter.Reset()
+	if _, _, err := breaker.Execute(context.TODO(), func() (any, error) { return nil, nil }); !errors.Is(err, circuitry.ErrCircuitBreakerOpen) {
+		t.Fatalf("expected breaker to be open; got %v", err)
+	}
+	spans = spansByName(exporter.GetSpans())
+	start := spans["circuitry.Start"]
+	if value, _ := attributeValue(start.Attributes, circuitry.AttributeRejectionReason); value.AsString() != "open" {
+		t.Fatalf("expected Start span to record the rejection reason; got %v", start.Attributes)
+	}
+	if start.Status.Code != codes.Error {
+		t.Fatalf("expected Start span to have an error status; got %+v", start.Status)
+	}
+	if _, ok := spans["circuitry.Work"]; ok {
+		t.Fatal("expected no Work span when the circuit is open")
+	}
+	if spans["circuitry.Execute"].Status.Code != codes.Error {
+		t.Fatalf("expected Execute span to have an error status; got %+v", spans["circuitry.Execute"].Status)
+	}
+}
+
+func TestTracingBackendErrors(t *testing.T) {
+	testCases := map[string]struct {
+		backend circuitry.StorageBackender
+		span    string
+	}{
+		"can't lock":     {circuitrytest.ErroringInMemoryBackend{LockError: errors.New("cannot lock")}, "circuitry.backend.Lock"},
+		"can't retrieve": {circuitrytest.ErroringInMemoryBackend{RetrieveError: errors.New("cannot retrieve")}, "circuitry.backend.Retrieve"},
+		"can't store":    {circuitrytest.ErroringInMemoryBackend{StoreError: errors.New("cannot store")}, "circuitry.backend.Store"},
+	}
+	for name, testCase := range testCases {
+		tc := testCase
+		t.Run(name, func(t *testing.T) {
+			exporter, factory := newTracedFactory(circuitry.WithStorageBackend(tc.backend))
+			_, _, err := factory.BreakerFor("name", map[string]any{}).Execute(context.TODO(), func() (any, error) { return nil, nil })
+			if err == nil {
+				t.Fatal("expected breaker.Execute() to fail")
+			}
+			spans := spansByName(exporter.GetSpans())
+			if spans[tc.span].Status.Code != codes.Error {
+				t.Fatalf("expected %s span to have an error status; got %+v", tc.span, spans[tc.span].Status)
+			}
+			if spans["circuitry.Execute"].Status.Code != codes.Error {
+				t.Fatalf("expected Execute span to have an error status; got %+v", spans["circuitry.Execute"].Status)
+			}
+		})
+	}
+}